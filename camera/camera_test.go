@@ -40,7 +40,7 @@ func TestCamera(t *testing.T) {
 				t.Error(err)
 			}
 
-			err = image.NewEncoder(io.Discard, 75).Encode(img)
+			err = image.NewEncoder(io.Discard, image.DefaultEncoderOptions).Encode(img)
 			if err != nil {
 				t.Error(err)
 			}
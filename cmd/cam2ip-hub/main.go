@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go.senan.xyz/flagconf"
+
+	"github.com/gen2brain/cam2ip/handlers"
+	"github.com/gen2brain/cam2ip/hub"
+)
+
+const (
+	name    = "cam2ip-hub"
+	version = "1.6"
+)
+
+func main() {
+	var bind, certFile, keyFile string
+	var interval time.Duration
+
+	flag.StringVar(&bind, "bind-addr", ":56200", "Bind address [CAM2IP_HUB_BIND_ADDR]")
+	flag.DurationVar(&interval, "check-interval", 10*time.Second, "Interval of node health checks [CAM2IP_HUB_CHECK_INTERVAL]")
+	flag.StringVar(&certFile, "tls-cert", "", "Path to TLS certificate, if empty hub listens on plain HTTP [CAM2IP_HUB_TLS_CERT]")
+	flag.StringVar(&keyFile, "tls-key", "", "Path to TLS private key [CAM2IP_HUB_TLS_KEY]")
+
+	flag.Usage = func() {
+		stderr("Usage: %s [<flags>]\n", name)
+		order := []string{"bind-addr", "check-interval", "tls-cert", "tls-key"}
+
+		for _, name := range order {
+			f := flag.Lookup(name)
+			if f != nil {
+				stderr("  --%s\n    \t%v (default %q)\n", f.Name, f.Usage, f.DefValue)
+			}
+		}
+	}
+
+	flag.Parse()
+	_ = flagconf.ParseEnv()
+
+	if interval < time.Second {
+		stderr("check interval must be at least 1s\n")
+		os.Exit(1)
+	}
+
+	// Пользователи и узлы hub хранятся в его собственной базе данных
+	if err := handlers.InitDatabase(); err != nil {
+		stderr("failed to initialize database: %v\n", err)
+		os.Exit(1)
+	}
+	defer handlers.GetDatabase().Close()
+
+	if err := handlers.InitLogger(); err != nil {
+		stderr("failed to initialize logger: %v\n", err)
+		os.Exit(1)
+	}
+
+	h := hub.New(handlers.GetDatabase(), interval)
+	h.Start()
+	defer h.Close()
+
+	dashboard := handlers.AuthMiddleware(handlers.NewHubDashboard(handlers.GetDatabase(), h))
+
+	http.Handle("/", handlers.NewAuth())
+	http.Handle("/logout", handlers.NewLogout())
+	http.Handle("/dashboard", dashboard)
+	http.Handle("/hub/", dashboard)
+	http.Handle(hub.NodePrefix, handlers.AuthMiddleware(h))
+
+	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Потоки узлов живут долго, поэтому ограничено только чтение заголовков
+	srv := &http.Server{
+		Addr:              bind,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	stderr("Listening on %s\n", bind)
+
+	var err error
+	if certFile != "" {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+
+	if err != nil {
+		stderr("%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func stderr(format string, a ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, format, a...)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go.senan.xyz/flagconf"
+
+	"github.com/gen2brain/cam2ip/handlers"
+	"github.com/gen2brain/cam2ip/relay"
+)
+
+const (
+	name    = "cam2ip-relay"
+	version = "1.6"
+)
+
+func main() {
+	var bind, devices, certFile, keyFile, addDevice string
+
+	flag.StringVar(&bind, "bind-addr", ":56100", "Bind address [CAM2IP_RELAY_BIND_ADDR]")
+	flag.StringVar(&devices, "devices-file", "devices.txt", "Path to file with device ids and key hashes [CAM2IP_RELAY_DEVICES_FILE]")
+	flag.StringVar(&certFile, "tls-cert", "", "Path to TLS certificate, if empty relay listens on plain HTTP [CAM2IP_RELAY_TLS_CERT]")
+	flag.StringVar(&keyFile, "tls-key", "", "Path to TLS private key [CAM2IP_RELAY_TLS_KEY]")
+	flag.StringVar(&addDevice, "add-device", "", "Add device with given id to devices file, print its key and exit")
+
+	flag.Usage = func() {
+		stderr("Usage: %s [<flags>]\n", name)
+		order := []string{"bind-addr", "devices-file", "tls-cert", "tls-key", "add-device"}
+
+		for _, name := range order {
+			f := flag.Lookup(name)
+			if f != nil {
+				stderr("  --%s\n    \t%v (default %q)\n", f.Name, f.Usage, f.DefValue)
+			}
+		}
+	}
+
+	flag.Parse()
+	_ = flagconf.ParseEnv()
+
+	if addDevice != "" {
+		if err := add(devices, addDevice); err != nil {
+			stderr("%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	keys, err := relay.LoadKeys(devices)
+	if err != nil {
+		stderr("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	// Пользователи relay хранятся в его собственной базе данных
+	if err := handlers.InitDatabase(); err != nil {
+		stderr("failed to initialize database: %v\n", err)
+		os.Exit(1)
+	}
+	defer handlers.GetDatabase().Close()
+
+	if err := handlers.InitLogger(); err != nil {
+		stderr("failed to initialize logger: %v\n", err)
+		os.Exit(1)
+	}
+
+	rs := relay.NewServer(keys)
+	defer rs.Close()
+
+	http.Handle("/", handlers.NewAuth())
+	http.Handle("/logout", handlers.NewLogout())
+	http.Handle("/dashboard", handlers.AuthMiddleware(handlers.NewRelayDashboard(rs.Devices)))
+	http.HandleFunc(relay.TunnelPath, rs.ServeTunnel)
+	http.Handle(relay.CameraPrefix, handlers.AuthMiddleware(rs))
+
+	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Туннели и потоки живут долго, поэтому ограничено только чтение заголовков
+	srv := &http.Server{
+		Addr:              bind,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	stderr("Listening on %s, %d devices\n", bind, len(keys))
+
+	if certFile != "" {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+
+	if err != nil {
+		stderr("%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+// add appends device with new key to devices file and prints the key.
+func add(devices, id string) error {
+	if !relay.ValidID(id) {
+		return fmt.Errorf("invalid device id %q, use letters, digits, dot, dash and underscore", id)
+	}
+
+	if keys, err := relay.LoadKeys(devices); err == nil {
+		if _, ok := keys[id]; ok {
+			return fmt.Errorf("device %s already exists in %s", id, devices)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.OpenFile(devices, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	key := relay.NewKey()
+
+	if _, err := fmt.Fprintf(f, "%s:%s\n", id, relay.HashKey(key)); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Device %s added to %s, run camera with:\n\n", id, devices)
+	fmt.Printf("    cam2ip --relay=https://<relay> --relay-id=%s --relay-key=%s\n", id, key)
+
+	return nil
+}
+
+func stderr(format string, a ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, format, a...)
+}
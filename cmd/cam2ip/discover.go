@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gen2brain/cam2ip/mdns"
+)
+
+// discover lists cam2ip instances advertised over mDNS and returns exit code.
+func discover(args []string) int {
+	fs := flag.NewFlagSet(name+" discover", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 3*time.Second, "How long to wait for answers")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	entries, err := mdns.Browse("_cam2ip._tcp", *timeout)
+	if err != nil {
+		stderr("%s\n", err.Error())
+		return 1
+	}
+
+	if len(entries) == 0 {
+		stderr("No cameras found\n")
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tURL\tVERSION\tSTREAMS")
+
+	for _, e := range entries {
+		host := strings.TrimSuffix(e.Host, ".")
+		if len(e.Addrs) > 0 {
+			host = e.Addrs[0].String()
+		}
+
+		// Разрешение каждого потока хранится в TXT записи с именем потока
+		var streams []string
+		for _, s := range strings.Split(e.Text["streams"], ",") {
+			if s != "" {
+				streams = append(streams, s+" "+e.Text[s])
+			}
+		}
+
+		url := "http://" + net.JoinHostPort(host, strconv.Itoa(e.Port)) + e.Text["mjpeg"]
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Instance, url, e.Text["version"], strings.Join(streams, ", "))
+	}
+
+	_ = w.Flush()
+
+	return 0
+}
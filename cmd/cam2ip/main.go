@@ -4,11 +4,14 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"go.senan.xyz/flagconf"
 
 	"github.com/gen2brain/cam2ip/camera"
+	"github.com/gen2brain/cam2ip/image"
 	"github.com/gen2brain/cam2ip/server"
+	"github.com/gen2brain/cam2ip/stream"
 )
 
 const (
@@ -17,6 +20,11 @@ const (
 )
 
 func main() {
+	// Подкоманда discover ищет камеры в локальной сети
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		os.Exit(discover(os.Args[2:]))
+	}
+
 	srv := server.NewServer()
 
 	flag.IntVar(&srv.Index, "index", 0, "Camera index [CAM2IP_INDEX]")
@@ -24,18 +32,83 @@ func main() {
 	flag.Float64Var(&srv.Width, "width", 640, "Frame width [CAM2IP_WIDTH]")
 	flag.Float64Var(&srv.Height, "height", 480, "Frame height [CAM2IP_HEIGHT]")
 	flag.IntVar(&srv.Quality, "quality", 75, "Image quality [CAM2IP_QUALITY]")
+	flag.StringVar(&srv.Codec, "codec", image.DefaultCodec, "JPEG codec, valid values are "+codecNames()+" [CAM2IP_CODEC]")
+	flag.StringVar(&srv.Subsampling, "subsampling", "420", "Chroma subsampling, valid values are 444, 440, 422 and 420 [CAM2IP_SUBSAMPLING]")
+	flag.BoolVar(&srv.Progressive, "progressive", false, "Use progressive JPEG encoding [CAM2IP_PROGRESSIVE]")
+	flag.BoolVar(&srv.OptimizeCoding, "optimize-coding", false, "Use optimized Huffman tables [CAM2IP_OPTIMIZE_CODING]")
+	flag.BoolVar(&srv.AdaptiveQuantization, "adaptive-quantization", false, "Use adaptive quantization [CAM2IP_ADAPTIVE_QUANTIZATION]")
+	flag.StringVar(&srv.DCTMethod, "dct-method", "ifast", "DCT method, valid values are islow, ifast and float [CAM2IP_DCT_METHOD]")
 	flag.IntVar(&srv.Rotate, "rotate", 0, "Rotate image, valid values are 90, 180, 270 [CAM2IP_ROTATE]")
 	flag.StringVar(&srv.Flip, "flip", "", "Flip image, valid values are horizontal and vertical [CAM2IP_FLIP]")
 	flag.BoolVar(&srv.NoWebGL, "no-webgl", false, "Disable WebGL drawing of image (html handler) [CAM2IP_NO_WEBGL]")
+	flag.BoolVar(&srv.ABR, "abr", false, "Adapt quality and resolution to each client bandwidth (mjpeg and html handlers) [CAM2IP_ABR]")
+	flag.IntVar(&srv.ABRBitrate, "abr-bitrate", 0, "Target bitrate per client in kbit/s, 0 is unlimited [CAM2IP_ABR_BITRATE]")
+	flag.IntVar(&srv.ABRLatency, "abr-latency", 200, "Target frame write latency in milliseconds [CAM2IP_ABR_LATENCY]")
+	flag.IntVar(&srv.ABRMinQuality, "abr-min-quality", 30, "Minimum image quality [CAM2IP_ABR_MIN_QUALITY]")
 	flag.BoolVar(&srv.Timestamp, "timestamp", false, "Draws timestamp on image [CAM2IP_TIMESTAMP]")
 	flag.StringVar(&srv.TimeFormat, "time-format", "2006-01-02 15:04:05", "Time format [CAM2IP_TIME_FORMAT]")
+	flag.IntVar(&srv.History, "history", 60, "Length of in-memory frame history, in seconds [CAM2IP_HISTORY]")
+	flag.Float64Var(&srv.HistoryFPS, "history-fps", 2, "Frames per second kept in history [CAM2IP_HISTORY_FPS]")
+	flag.BoolVar(&srv.Motion, "motion", false, "Enable motion detection [CAM2IP_MOTION]")
+	flag.Float64Var(&srv.MotionThreshold, "motion-threshold", 1.5, "Percentage of changed pixels that is motion [CAM2IP_MOTION_THRESHOLD]")
+	flag.IntVar(&srv.SubWidth, "sub-width", 320, "Substream width, 0 disables substream [CAM2IP_SUB_WIDTH]")
+	flag.IntVar(&srv.SubHeight, "sub-height", 0, "Substream height, 0 keeps aspect ratio [CAM2IP_SUB_HEIGHT]")
+	flag.Float64Var(&srv.SubFPS, "sub-fps", 5, "Substream frames per second [CAM2IP_SUB_FPS]")
+	flag.IntVar(&srv.SubQuality, "sub-quality", 50, "Substream image quality [CAM2IP_SUB_QUALITY]")
 	flag.StringVar(&srv.Bind, "bind-addr", ":56000", "Bind address [CAM2IP_BIND_ADDR]")
+	flag.StringVar(&srv.RTSPBind, "rtsp-bind-addr", "", "RTSP bind address, e.g. :8554, if empty RTSP is disabled [CAM2IP_RTSP_BIND_ADDR]")
+	flag.BoolVar(&srv.ONVIF, "onvif", false, "Enable ONVIF device and media services and WS-Discovery, only PasswordText authentication is supported [CAM2IP_ONVIF]")
+	flag.BoolVar(&srv.MDNS, "mdns", false, "Advertise _http._tcp and _cam2ip._tcp services over mDNS [CAM2IP_MDNS]")
+	flag.StringVar(&srv.MDNSName, "mdns-name", "", "Camera name advertised over mDNS, if empty it is cam2ip on <hostname> [CAM2IP_MDNS_NAME]")
+	flag.StringVar(&srv.MQTTBroker, "mqtt-broker", "", "MQTT broker URL, e.g. tcp://localhost:1883, if empty MQTT is disabled [CAM2IP_MQTT_BROKER]")
+	flag.StringVar(&srv.MQTTUsername, "mqtt-username", "", "MQTT username [CAM2IP_MQTT_USERNAME]")
+	flag.StringVar(&srv.MQTTPassword, "mqtt-password", "", "MQTT password [CAM2IP_MQTT_PASSWORD]")
+	flag.StringVar(&srv.MQTTTopic, "mqtt-topic", "cam2ip", "MQTT base topic [CAM2IP_MQTT_TOPIC]")
+	flag.IntVar(&srv.MQTTSnapshotInterval, "mqtt-snapshot-interval", 0, "Interval of MQTT snapshots, in seconds, 0 disables snapshots [CAM2IP_MQTT_SNAPSHOT_INTERVAL]")
+	flag.BoolVar(&srv.MQTTDiscovery, "mqtt-discovery", false, "Publish Home Assistant MQTT discovery configs [CAM2IP_MQTT_DISCOVERY]")
+	flag.BoolVar(&srv.NotifyAllowLocal, "notify-allow-local", false, "Allow notification webhooks to loopback and link-local addresses [CAM2IP_NOTIFY_ALLOW_LOCAL]")
+	flag.StringVar(&srv.S3Endpoint, "s3-endpoint", "", "S3-compatible storage URL, e.g. http://localhost:9000, if empty uploads are disabled [CAM2IP_S3_ENDPOINT]")
+	flag.StringVar(&srv.S3Region, "s3-region", "us-east-1", "S3 region [CAM2IP_S3_REGION]")
+	flag.StringVar(&srv.S3Bucket, "s3-bucket", "cam2ip", "S3 bucket [CAM2IP_S3_BUCKET]")
+	flag.StringVar(&srv.S3AccessKey, "s3-access-key", "", "S3 access key [CAM2IP_S3_ACCESS_KEY]")
+	flag.StringVar(&srv.S3SecretKey, "s3-secret-key", "", "S3 secret key [CAM2IP_S3_SECRET_KEY]")
+	flag.StringVar(&srv.S3Prefix, "s3-prefix", "", "S3 key prefix, e.g. camera1/ [CAM2IP_S3_PREFIX]")
+	flag.IntVar(&srv.S3Retention, "s3-retention", 0, "Days after which uploaded objects expire, set as bucket lifecycle rule, 0 keeps them [CAM2IP_S3_RETENTION]")
+	flag.BoolVar(&srv.Record, "record", false, "Record continuously to segmented MJPEG AVI files [CAM2IP_RECORD]")
+	flag.StringVar(&srv.RecordDir, "record-dir", server.RecordingsDir, "Directory of recordings [CAM2IP_RECORD_DIR]")
+	flag.IntVar(&srv.RecordSegment, "record-segment", 10, "Length of recording segment, in minutes [CAM2IP_RECORD_SEGMENT]")
+	flag.Float64Var(&srv.RecordFPS, "record-fps", 0, "Recording frame rate, 0 is the stream rate [CAM2IP_RECORD_FPS]")
+	flag.StringVar(&srv.RecordStream, "record-stream", stream.MainStream, "Recorded stream, valid values are main and sub [CAM2IP_RECORD_STREAM]")
+	flag.IntVar(&srv.RecordMaxAge, "record-max-age", 0, "Days after which segments are deleted, 0 keeps them [CAM2IP_RECORD_MAX_AGE]")
+	flag.IntVar(&srv.RecordClipMaxAge, "record-clip-max-age", 0, "Days after which event clips are deleted, 0 keeps them [CAM2IP_RECORD_CLIP_MAX_AGE]")
+	flag.IntVar(&srv.RecordMaxSize, "record-max-size", 0, "Maximum total size of recordings, in MB, the oldest segments and then clips are deleted above it, 0 is unlimited [CAM2IP_RECORD_MAX_SIZE]")
+	flag.IntVar(&srv.RecordMinFree, "record-min-free", 0, "Free disk space kept, in MB, recordings are deleted as with --record-max-size below it [CAM2IP_RECORD_MIN_FREE]")
+	flag.BoolVar(&srv.EventRecord, "event-record", false, "Record clips on motion, tamper and API trigger events to clips in recordings directory [CAM2IP_EVENT_RECORD]")
+	flag.IntVar(&srv.EventPreRoll, "event-pre-roll", 5, "Time recorded before event, in seconds [CAM2IP_EVENT_PRE_ROLL]")
+	flag.IntVar(&srv.EventPostRoll, "event-post-roll", 10, "Time recorded after event ends, in seconds [CAM2IP_EVENT_POST_ROLL]")
+	flag.StringVar(&srv.RelayURL, "relay", "", "Relay URL, e.g. https://relay.example.com, camera connects to relay and serves requests through it [CAM2IP_RELAY]")
+	flag.StringVar(&srv.RelayID, "relay-id", "", "Device id registered on relay [CAM2IP_RELAY_ID]")
+	flag.StringVar(&srv.RelayKey, "relay-key", "", "Device key registered on relay [CAM2IP_RELAY_KEY]")
+	flag.StringVar(&srv.MetricsToken, "metrics-token", "", "Bearer token for /metrics [CAM2IP_METRICS_TOKEN]")
+	srv.MetricsAllow = "127.0.0.1/8,::1"
+	flag.Var(&listValue{s: &srv.MetricsAllow}, "metrics-allow", "Comma separated addresses and networks allowed to read /metrics without token [CAM2IP_METRICS_ALLOW]")
 	flag.StringVar(&srv.Htpasswd, "htpasswd-file", "", "Path to htpasswd file, if empty auth is disabled [CAM2IP_HTPASSWD_FILE]")
+	flag.BoolVar(&srv.Debug, "debug", false, "Enable /debug/codec-bench handler [CAM2IP_DEBUG]")
 
 	flag.Usage = func() {
-		stderr("Usage: %s [<flags>]\n", name)
-		order := []string{"index", "delay", "width", "height", "quality", "rotate", "flip", "no-webgl",
-			"timestamp", "time-format", "bind-addr", "htpasswd-file"}
+		stderr("Usage: %s [<flags>]\n       %s discover [--timeout=<duration>]\n", name, name)
+		order := []string{"index", "delay", "width", "height", "quality", "codec", "subsampling", "progressive",
+			"optimize-coding", "adaptive-quantization", "dct-method", "rotate", "flip", "no-webgl",
+			"abr", "abr-bitrate", "abr-latency", "abr-min-quality",
+			"timestamp", "time-format", "history", "history-fps", "motion", "motion-threshold",
+			"sub-width", "sub-height", "sub-fps", "sub-quality", "bind-addr", "rtsp-bind-addr", "onvif", "mdns", "mdns-name",
+			"mqtt-broker", "mqtt-username", "mqtt-password", "mqtt-topic", "mqtt-snapshot-interval", "mqtt-discovery",
+			"notify-allow-local",
+			"s3-endpoint", "s3-region", "s3-bucket", "s3-access-key", "s3-secret-key", "s3-prefix", "s3-retention",
+			"record", "record-dir", "record-segment", "record-fps", "record-stream",
+			"record-max-age", "record-clip-max-age", "record-max-size", "record-min-free",
+			"event-record", "event-pre-roll", "event-post-roll",
+			"relay", "relay-id", "relay-key", "metrics-token", "metrics-allow", "htpasswd-file", "debug"}
 
 		for _, name := range order {
 			f := flag.Lookup(name)
@@ -47,6 +120,8 @@ func main() {
 
 	flag.Parse()
 	_ = flagconf.ParseEnv()
+	setRawEnv("time-format", "mdns-name", "mqtt-username", "mqtt-password", "mqtt-topic",
+		"s3-access-key", "s3-secret-key", "s3-prefix", "relay-key", "metrics-token")
 
 	srv.Name = name
 	srv.Version = version
@@ -58,6 +133,16 @@ func main() {
 		}
 	}
 
+	if err := image.SetCodec(srv.Codec); err != nil {
+		stderr("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if _, err := srv.EncoderOptions(); err != nil {
+		stderr("%s\n", err.Error())
+		os.Exit(1)
+	}
+
 	cam, err := camera.New(camera.Options{
 		Index:      srv.Index,
 		Rotate:     srv.Rotate,
@@ -85,6 +170,60 @@ func main() {
 	}
 }
 
+func codecNames() string {
+	names := make([]string, 0)
+	for _, c := range image.Codecs() {
+		names = append(names, c.Name())
+	}
+
+	return strings.Join(names, ", ")
+}
+
 func stderr(format string, a ...any) {
 	_, _ = fmt.Fprintf(os.Stderr, format, a...)
 }
+
+// setRawEnv sets flags from environment variables as is, flagconf splits values on comma and expands $ in them,
+// which corrupts passwords and keys. Flags given on command line are kept.
+func setRawEnv(names ...string) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for _, n := range names {
+		if set[n] {
+			continue
+		}
+
+		if v, ok := os.LookupEnv("CAM2IP_" + strings.ToUpper(strings.ReplaceAll(n, "-", "_"))); ok {
+			_ = flag.Set(n, v)
+		}
+	}
+}
+
+// listValue is comma separated list flag, environment variables are split on comma and set one by one.
+type listValue struct {
+	s   *string
+	set bool
+}
+
+func (l *listValue) String() string {
+	if l.s == nil {
+		return ""
+	}
+
+	return *l.s
+}
+
+func (l *listValue) Set(v string) error {
+	if l.set {
+		*l.s += "," + v
+	} else {
+		*l.s = v
+	}
+
+	l.set = true
+
+	return nil
+}
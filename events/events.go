@@ -0,0 +1,216 @@
+// Package events implements publish/subscribe bus of camera and server events.
+package events
+
+import (
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Type is event type.
+type Type string
+
+// Event types.
+const (
+	MotionStart        Type = "motion_start"
+	MotionEnd          Type = "motion_end"
+	CameraOffline      Type = "camera_offline"
+	CameraOnline       Type = "camera_online"
+	ViewerConnected    Type = "viewer_connected"
+	ViewerDisconnected Type = "viewer_disconnected"
+	RecordingStarted   Type = "recording_started"
+	AuthFailed         Type = "auth_failed"
+	Tamper             Type = "tamper"
+	PrivacyOn          Type = "privacy_on"
+	PrivacyOff         Type = "privacy_off"
+	Trigger            Type = "trigger"
+)
+
+// recentSize is the number of events kept for subscribers that reconnect.
+const recentSize = 256
+
+// Event is a published event.
+type Event struct {
+	ID   uint64         `json:"id"`
+	Type Type           `json:"type"`
+	Time time.Time      `json:"time"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+// Status is the current state derived from published events.
+type Status struct {
+	Camera  string `json:"camera"`
+	Motion  bool   `json:"motion"`
+	Viewers int    `json:"viewers"`
+	Privacy bool   `json:"privacy"`
+}
+
+// Bus delivers published events to subscribers.
+type Bus struct {
+	mu     sync.Mutex
+	seq    uint64
+	subs   map[chan Event]struct{}
+	recent []Event
+	status Status
+
+	// viewers are connected events of viewers that did not disconnect yet, by id.
+	viewers map[uint64]Event
+}
+
+// NewBus returns new Bus.
+func NewBus() *Bus {
+	return &Bus{
+		subs:    make(map[chan Event]struct{}),
+		status:  Status{Camera: "online"},
+		viewers: make(map[uint64]Event),
+	}
+}
+
+// Default is the bus used by package level functions.
+var Default = NewBus()
+
+// Publish publishes event to the default bus.
+func Publish(typ Type, data map[string]any) Event {
+	return Default.Publish(typ, data)
+}
+
+// Connect publishes viewer connected event to the default bus.
+func Connect(data map[string]any) func() {
+	return Default.Connect(data)
+}
+
+// Subscribe subscribes to the default bus.
+func Subscribe(size int) (<-chan Event, func()) {
+	return Default.Subscribe(size)
+}
+
+// Publish sends event to all subscribers, subscribers that are not keeping up miss the event.
+func (b *Bus) Publish(typ Type, data map[string]any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+
+	// Viewer gets id of its connected event, disconnected event carries the same id
+	if typ == ViewerConnected {
+		data = maps.Clone(data)
+		if data == nil {
+			data = make(map[string]any)
+		}
+
+		data["id"] = b.seq
+	}
+
+	e := Event{ID: b.seq, Type: typ, Time: time.Now(), Data: data}
+
+	switch typ {
+	case CameraOffline:
+		b.status.Camera = "offline"
+	case CameraOnline:
+		b.status.Camera = "online"
+	case MotionStart:
+		b.status.Motion = true
+	case MotionEnd:
+		b.status.Motion = false
+	case ViewerConnected:
+		b.viewers[e.ID] = e
+		b.status.Viewers = len(b.viewers)
+	case ViewerDisconnected:
+		if id, ok := data["id"].(uint64); ok {
+			delete(b.viewers, id)
+		}
+
+		b.status.Viewers = len(b.viewers)
+	case PrivacyOn:
+		b.status.Privacy = true
+	case PrivacyOff:
+		b.status.Privacy = false
+	}
+
+	b.recent = append(b.recent, e)
+	if len(b.recent) > recentSize {
+		b.recent = append(b.recent[:0], b.recent[len(b.recent)-recentSize:]...)
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+
+	return e
+}
+
+// Connect publishes viewer connected event and returns function that publishes viewer disconnected event.
+func (b *Bus) Connect(data map[string]any) func() {
+	e := b.Publish(ViewerConnected, data)
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			b.Publish(ViewerDisconnected, e.Data)
+		})
+	}
+}
+
+// Viewers returns connected events of viewers that are still connected, oldest first.
+func (b *Bus) Viewers() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ret := make([]Event, 0, len(b.viewers))
+	for _, e := range b.viewers {
+		ret = append(ret, e)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+
+	return ret
+}
+
+// Subscribe returns channel buffered for size events and a function that cancels subscription.
+func (b *Bus) Subscribe(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+
+			close(ch)
+		})
+	}
+}
+
+// Since returns kept events published after event with given id, oldest first.
+func (b *Bus) Since(id uint64) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ret := make([]Event, 0)
+	for _, e := range b.recent {
+		if e.ID > id {
+			ret = append(ret, e)
+		}
+	}
+
+	return ret
+}
+
+// Status returns current status.
+func (b *Bus) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.status
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+
+	ch, cancel := b.Subscribe(4)
+
+	b.Publish(CameraOffline, nil)
+	b.Publish(ViewerConnected, map[string]any{"stream": "main"})
+
+	e := <-ch
+	if e.Type != CameraOffline || e.ID != 1 {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	e = <-ch
+	if e.Type != ViewerConnected || e.Data["stream"] != "main" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	s := b.Status()
+	if s.Camera != "offline" || s.Viewers != 1 || s.Motion {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	if since := b.Since(1); len(since) != 1 || since[0].ID != 2 {
+		t.Errorf("unexpected events since 1: %+v", since)
+	}
+
+	cancel()
+	cancel()
+
+	if _, ok := <-ch; ok {
+		t.Error("channel not closed")
+	}
+
+	// Slow subscriber misses events instead of blocking publisher.
+	slow, cancel := b.Subscribe(1)
+	defer cancel()
+
+	for i := 0; i < 10; i++ {
+		b.Publish(MotionStart, nil)
+	}
+
+	if len(slow) != 1 {
+		t.Errorf("slow subscriber has %d events", len(slow))
+	}
+}
+
+func TestViewers(t *testing.T) {
+	b := NewBus()
+
+	data := map[string]any{"handler": "mjpeg"}
+
+	disconnect := b.Connect(data)
+	b.Connect(map[string]any{"handler": "rtsp"})
+
+	if _, ok := data["id"]; ok {
+		t.Error("caller data modified")
+	}
+
+	viewers := b.Viewers()
+	if len(viewers) != 2 || viewers[0].Data["handler"] != "mjpeg" || viewers[0].Data["id"] != viewers[0].ID {
+		t.Fatalf("unexpected viewers %+v", viewers)
+	}
+
+	disconnect()
+	disconnect()
+
+	viewers = b.Viewers()
+	if len(viewers) != 1 || viewers[0].Data["handler"] != "rtsp" || b.Status().Viewers != 1 {
+		t.Errorf("unexpected viewers %+v, status %+v", viewers, b.Status())
+	}
+}
@@ -4,22 +4,26 @@ require (
 	github.com/abbot/go-http-auth v0.4.0
 	github.com/anthonynsimon/bild v0.14.0
 	github.com/coder/websocket v1.8.13
+	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/gen2brain/base64 v0.0.0-20221015184129-317a5c93030c
 	github.com/gen2brain/jpegli v0.3.4
+	github.com/hashicorp/yamux v0.1.2
 	github.com/korandiz/v4l v1.1.0
+	github.com/mattn/go-sqlite3 v1.14.32
 	github.com/pbnjay/pixfont v0.0.0-20200714042608-33b744692567
 	github.com/pixiv/go-libjpeg v0.0.0-20190822045933-3da21a74767d
 	go.senan.xyz/flagconf v0.1.9
 	gocv.io/x/gocv v0.35.0
+	golang.org/x/net v0.44.0
+	golang.org/x/term v0.36.0
 )
 
 require (
-	github.com/mattn/go-sqlite3 v1.14.32 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/tetratelabs/wazero v1.9.0 // indirect
-	golang.org/x/crypto v0.18.0 // indirect
-	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/crypto v0.42.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
 	golang.org/x/sys v0.37.0 // indirect
-	golang.org/x/term v0.36.0 // indirect
 )
 
 go 1.24.0
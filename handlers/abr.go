@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+const (
+	abrQualityStep = 5
+	abrScaleStep   = 0.75
+	abrMinScale    = 0.25
+	abrSmoothing   = 0.2
+	abrHoldFrames  = 5
+)
+
+// ABROptions are adaptive bitrate options.
+type ABROptions struct {
+	// Enabled turns on per-client adaptation.
+	Enabled bool
+	// TargetBitrate is the wanted bandwidth in kbit/s, zero means unlimited.
+	TargetBitrate int
+	// TargetLatency is the maximum acceptable frame write time.
+	TargetLatency time.Duration
+	// MinQuality is the lowest JPEG quality controller can choose.
+	MinQuality int
+}
+
+// ABRStats are current values of ABR controller.
+type ABRStats struct {
+	Quality int     `json:"quality"`
+	Scale   float64 `json:"scale"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Bitrate int     `json:"bitrate"`
+	Latency float64 `json:"latency"`
+}
+
+// ABR is a per-client controller that adjusts JPEG quality and resolution
+// from the measured frame write latency and throughput.
+type ABR struct {
+	opts ABROptions
+
+	mu         sync.Mutex
+	maxQuality int
+	quality    int
+	scale      float64
+	bitrate    float64
+	latency    float64
+	last       time.Time
+	hold       int
+	width      int
+	height     int
+}
+
+// NewABR returns new ABR controller starting at the given quality.
+func NewABR(opts ABROptions, quality int) *ABR {
+	if opts.MinQuality <= 0 || opts.MinQuality > quality {
+		opts.MinQuality = min(30, quality)
+	}
+
+	return &ABR{
+		opts:       opts,
+		maxQuality: quality,
+		quality:    quality,
+		scale:      1,
+	}
+}
+
+// Quality returns JPEG quality for the next frame.
+func (a *ABR) Quality() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.quality
+}
+
+// Scale returns resolution scale for the next frame.
+func (a *ABR) Scale() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.scale
+}
+
+// SetQuality sets the highest quality controller can choose and starts from it.
+func (a *ABR) SetQuality(quality int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.maxQuality = quality
+	a.quality = quality
+	a.opts.MinQuality = min(a.opts.MinQuality, quality)
+	a.hold = 0
+}
+
+// Update records a frame of given size and dimensions that took d to write, and adjusts quality and scale.
+func (a *ABR) Update(size, width, height int, d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.width, a.height = width, height
+
+	latency := float64(d) / float64(time.Millisecond)
+	a.latency = ewma(a.latency, latency)
+
+	if !a.last.IsZero() {
+		elapsed := now.Sub(a.last).Seconds()
+		if elapsed > 0 {
+			a.bitrate = ewma(a.bitrate, float64(size*8)/elapsed/1000)
+		}
+	}
+
+	a.last = now
+
+	if !a.opts.Enabled {
+		return
+	}
+
+	if a.hold > 0 {
+		a.hold--
+
+		return
+	}
+
+	over := a.opts.TargetLatency > 0 && a.latency > float64(a.opts.TargetLatency)/float64(time.Millisecond)
+	if a.opts.TargetBitrate > 0 && a.bitrate > float64(a.opts.TargetBitrate)*1.1 {
+		over = true
+	}
+
+	under := a.opts.TargetLatency <= 0 || a.latency < float64(a.opts.TargetLatency)/float64(time.Millisecond)/2
+	if a.opts.TargetBitrate > 0 && a.bitrate > float64(a.opts.TargetBitrate)*0.8 {
+		under = false
+	}
+
+	switch {
+	case over:
+		a.decrease()
+	case under:
+		a.increase()
+	}
+}
+
+// Stats returns current controller values.
+func (a *ABR) Stats() ABRStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return ABRStats{
+		Quality: a.quality,
+		Scale:   a.scale,
+		Width:   a.width,
+		Height:  a.height,
+		Bitrate: int(a.bitrate),
+		Latency: a.latency,
+	}
+}
+
+// decrease lowers quality first, and resolution when quality is already at minimum.
+func (a *ABR) decrease() {
+	switch {
+	case a.quality > a.opts.MinQuality:
+		a.quality = max(a.quality-abrQualityStep, a.opts.MinQuality)
+	case a.scale > abrMinScale:
+		a.scale = max(a.scale*abrScaleStep, abrMinScale)
+	default:
+		return
+	}
+
+	a.hold = abrHoldFrames
+}
+
+// increase restores resolution first, and quality when resolution is already full.
+func (a *ABR) increase() {
+	switch {
+	case a.scale < 1:
+		a.scale = min(a.scale/abrScaleStep, 1)
+	case a.quality < a.maxQuality:
+		a.quality = min(a.quality+abrQualityStep, a.maxQuality)
+	default:
+		return
+	}
+
+	a.hold = abrHoldFrames * 2
+}
+
+func ewma(prev, v float64) float64 {
+	if prev == 0 {
+		return v
+	}
+
+	return prev + abrSmoothing*(v-prev)
+}
+
+// encodeFrame returns frame as JPEG at ABR quality and scale.
+// Frame data shared by all clients is used when ABR does not change stream quality and size.
+func encodeFrame(f *stream.Frame, opts image.EncoderOptions, abr *ABR, buf *bytes.Buffer) (data []byte, width, height, quality int, err error) {
+	img, err := f.Image()
+	if err != nil {
+		return
+	}
+
+	scale := abr.Scale()
+	quality = abr.Quality()
+
+	if scale >= 1 && quality == opts.Quality {
+		data, err = f.JPEG()
+		if err != nil {
+			return
+		}
+	} else {
+		img = image.Resize(img, scale)
+		opts.Quality = quality
+
+		buf.Reset()
+
+		err = image.NewEncoder(buf, opts).Encode(img)
+		if err != nil {
+			return
+		}
+
+		data = buf.Bytes()
+	}
+
+	b := img.Bounds()
+	width, height = b.Dx(), b.Dy()
+
+	return
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestABRUpdate(t *testing.T) {
+	latency := ABROptions{Enabled: true, TargetLatency: 200 * time.Millisecond, MinQuality: 30}
+	bitrate := ABROptions{Enabled: true, TargetBitrate: 1000, MinQuality: 30}
+
+	tests := []struct {
+		name    string
+		opts    ABROptions
+		quality int
+		scale   float64
+		hold    int
+		latency float64
+		bitrate float64
+
+		wantQuality int
+		wantScale   float64
+		wantHold    int
+	}{
+		{"latency over target lowers quality", latency, 75, 1, 0, 250, 0, 70, 1, abrHoldFrames},
+		{"quality is not lowered below minimum", latency, 32, 1, 0, 250, 0, 30, 1, abrHoldFrames},
+		{"minimum quality lowers scale", latency, 30, 1, 0, 250, 0, 30, 0.75, abrHoldFrames},
+		{"scale is not lowered below minimum", latency, 30, 0.3, 0, 250, 0, 30, abrMinScale, abrHoldFrames},
+		{"nothing to lower", latency, 30, abrMinScale, 0, 250, 0, 30, abrMinScale, 0},
+		{"latency between half and target keeps values", latency, 50, 0.75, 0, 150, 0, 50, 0.75, 0},
+		{"latency exactly at target keeps values", latency, 50, 0.75, 0, 200, 0, 50, 0.75, 0},
+		{"latency below half restores scale first", latency, 50, 0.75, 0, 50, 0, 50, 1, abrHoldFrames * 2},
+		{"full scale raises quality", latency, 50, 1, 0, 50, 0, 55, 1, abrHoldFrames * 2},
+		{"quality is not raised above maximum", latency, 73, 1, 0, 50, 0, 75, 1, abrHoldFrames * 2},
+		{"nothing to raise", latency, 75, 1, 0, 50, 0, 75, 1, 0},
+		{"hold skips adjustment", latency, 75, 1, 3, 250, 0, 75, 1, 2},
+		{"bitrate over target lowers quality", bitrate, 75, 1, 0, 10, 1200, 70, 1, abrHoldFrames},
+		{"bitrate within hysteresis keeps values", bitrate, 50, 1, 0, 10, 900, 50, 1, 0},
+		{"bitrate at upper bound keeps values", bitrate, 50, 1, 0, 10, 1100, 50, 1, 0},
+		{"bitrate below hysteresis raises quality", bitrate, 50, 1, 0, 10, 700, 55, 1, abrHoldFrames * 2},
+		{"disabled keeps values", ABROptions{TargetLatency: 200 * time.Millisecond}, 75, 1, 0, 250, 0, 75, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewABR(tt.opts, 75)
+			a.quality, a.scale, a.hold = tt.quality, tt.scale, tt.hold
+
+			// Smoothed values equal to measured ones stay constant, bitrate is measured from the second frame
+			a.latency, a.bitrate = tt.latency, tt.bitrate
+
+			a.Update(1000, 640, 480, time.Duration(tt.latency*float64(time.Millisecond)))
+
+			if a.quality != tt.wantQuality || a.scale != tt.wantScale || a.hold != tt.wantHold {
+				t.Errorf("got quality %d, scale %v, hold %d, want %d, %v, %d",
+					a.quality, a.scale, a.hold, tt.wantQuality, tt.wantScale, tt.wantHold)
+			}
+		})
+	}
+}
+
+func TestABRConverges(t *testing.T) {
+	a := NewABR(ABROptions{Enabled: true, TargetLatency: 200 * time.Millisecond, MinQuality: 30}, 75)
+
+	// Slow client ends at minimum quality and scale
+	for i := 0; i < 200; i++ {
+		a.Update(1000, 640, 480, time.Second)
+	}
+
+	if a.Quality() != 30 || a.Scale() != abrMinScale {
+		t.Errorf("expected minimum quality and scale, got %d, %v", a.Quality(), a.Scale())
+	}
+
+	// Fast client gets back full scale and quality
+	for i := 0; i < 500; i++ {
+		a.Update(1000, 640, 480, time.Millisecond)
+	}
+
+	if a.Quality() != 75 || a.Scale() != 1 {
+		t.Errorf("expected full quality and scale, got %d, %v", a.Quality(), a.Scale())
+	}
+
+	st := a.Stats()
+	if st.Width != 640 || st.Height != 480 || st.Quality != 75 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
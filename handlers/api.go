@@ -0,0 +1,1446 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/metrics"
+	"github.com/gen2brain/cam2ip/record"
+	"github.com/gen2brain/cam2ip/timelapse"
+)
+
+// apiPrefix is the path of API version 1.
+const apiPrefix = "/api/v1/"
+
+// CameraConfig is camera configuration reported by API.
+type CameraConfig struct {
+	Index     int            `json:"index"`
+	Rotate    int            `json:"rotate"`
+	Flip      string         `json:"flip"`
+	Codec     string         `json:"codec"`
+	Timestamp bool           `json:"timestamp"`
+	Motion    bool           `json:"motion"`
+	History   int            `json:"history"`
+	Streams   []StreamConfig `json:"streams"`
+}
+
+// StreamConfig is stream configuration reported by API.
+type StreamConfig struct {
+	Name    string  `json:"name"`
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	FPS     float64 `json:"fps"`
+	Quality int     `json:"quality"`
+}
+
+// APIOptions are options of API handler.
+type APIOptions struct {
+	Name    string
+	Version string
+	// Camera is configuration of the camera, it is listed with id 0.
+	Camera CameraConfig
+	// Retention applies retention policies of recordings.
+	Retention *record.Retention
+	// Exporter exports recordings to MP4 files.
+	Exporter *record.Exporter
+	// Timelapse captures frames of timelapse jobs.
+	Timelapse *timelapse.Scheduler
+	// CompileDir is the directory where timelapse videos are compiled, if empty it is os.TempDir().
+	CompileDir string
+}
+
+// APICamera is camera controlled through API.
+type APICamera interface {
+	StreamSource
+
+	// SetPrivacy turns privacy mode on or off.
+	SetPrivacy(on bool)
+
+	// Privacy reports whether privacy mode is on.
+	Privacy() bool
+}
+
+// API handler serves JSON REST API under /api/v1/.
+type API struct {
+	opts    APIOptions
+	camera  APICamera
+	db      *Database
+	bus     *events.Bus
+	started time.Time
+	mux     *http.ServeMux
+}
+
+// NewAPI returns new API handler.
+func NewAPI(opts APIOptions, camera APICamera, db *Database, bus *events.Bus) *API {
+	a := &API{opts: opts, camera: camera, db: db, bus: bus, started: time.Now(), mux: http.NewServeMux()}
+
+	a.mux.HandleFunc("GET /api/v1/status", a.status)
+	a.mux.HandleFunc("GET /api/v1/cameras", a.cameras)
+	a.mux.HandleFunc("GET /api/v1/cameras/{id}", a.getCamera)
+	a.mux.HandleFunc("PATCH /api/v1/cameras/{id}", a.patchCamera)
+	a.mux.HandleFunc("GET /api/v1/cameras/{id}/snapshot", a.snapshot)
+	a.mux.HandleFunc("POST /api/v1/cameras/{id}/trigger", a.trigger)
+	a.mux.HandleFunc("GET /api/v1/cameras/{id}/recordings", a.recordings)
+	a.mux.HandleFunc("GET /api/v1/cameras/{id}/recordings/days", a.recordingDays)
+	a.mux.HandleFunc("POST /api/v1/cameras/{id}/exports", a.createExport)
+	a.mux.HandleFunc("GET /api/v1/exports/{id}", a.getExport)
+	a.mux.HandleFunc("GET /api/v1/exports/{id}/file", a.exportFile)
+	a.mux.HandleFunc("GET /api/v1/recordings/retention", a.retention)
+	a.mux.HandleFunc("PATCH /api/v1/recordings/{id}", a.patchRecording)
+	a.mux.HandleFunc("GET /api/v1/timelapses", a.timelapses)
+	a.mux.HandleFunc("POST /api/v1/timelapses", a.createTimelapse)
+	a.mux.HandleFunc("GET /api/v1/timelapses/{id}", a.getTimelapse)
+	a.mux.HandleFunc("PUT /api/v1/timelapses/{id}", a.updateTimelapse)
+	a.mux.HandleFunc("DELETE /api/v1/timelapses/{id}", a.deleteTimelapse)
+	a.mux.HandleFunc("GET /api/v1/timelapses/{id}/video", a.timelapseVideo)
+	a.mux.HandleFunc("GET /api/v1/viewers", a.viewers)
+	a.mux.HandleFunc("GET /api/v1/users", a.users)
+	a.mux.HandleFunc("POST /api/v1/users", a.createUser)
+	a.mux.HandleFunc("DELETE /api/v1/users/{id}", a.deleteUser)
+	a.mux.HandleFunc("GET /api/v1/auth-logs", a.authLogs)
+	a.mux.HandleFunc(apiPrefix, a.fallback)
+
+	return a
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Описание API доступно без авторизации
+	if r.URL.Path == apiPrefix+"openapi.json" && (r.Method == "GET" || r.Method == "HEAD") {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(openAPIJSON))
+		return
+	}
+
+	// Сессия браузера или Basic авторизация, ошибка тоже в JSON
+	if !authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="cam2ip"`)
+		writeAPIError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	a.mux.ServeHTTP(w, r)
+}
+
+// apiError is the body of error responses.
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeAPIError(w http.ResponseWriter, code int, message string) {
+	writeJSONResponse(w, code, map[string]apiError{"error": {code, message}})
+}
+
+// fallback answers requests that did not match any route.
+func (a *API) fallback(w http.ResponseWriter, r *http.Request) {
+	// Путь существует, но с другим методом
+	var allow []string
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		req := r.Clone(r.Context())
+		req.Method = method
+
+		if _, pattern := a.mux.Handler(req); pattern != apiPrefix {
+			allow = append(allow, method)
+		}
+	}
+
+	if len(allow) > 0 {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeAPIError(w, http.StatusNotFound, "not found")
+}
+
+// apiStatus is server status.
+type apiStatus struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	StartedAt time.Time `json:"started_at"`
+	Uptime    float64   `json:"uptime"`
+	Camera    string    `json:"camera"`
+	Motion    bool      `json:"motion"`
+	Privacy   bool      `json:"privacy"`
+	Viewers   int       `json:"viewers"`
+	FPS       float64   `json:"fps"`
+	Frames    uint64    `json:"frames"`
+}
+
+func (a *API) status(w http.ResponseWriter, r *http.Request) {
+	s := a.bus.Status()
+
+	writeJSONResponse(w, http.StatusOK, apiStatus{
+		Name:      a.opts.Name,
+		Version:   a.opts.Version,
+		StartedAt: a.started.UTC().Truncate(time.Second),
+		Uptime:    time.Since(a.started).Truncate(time.Second).Seconds(),
+		Camera:    s.Camera,
+		Motion:    s.Motion,
+		Privacy:   a.camera.Privacy(),
+		Viewers:   s.Viewers,
+		FPS:       math.Round(metrics.CaptureFPS.Value()*10) / 10,
+		Frames:    uint64(metrics.CaptureFrames.Value()),
+	})
+}
+
+// apiCamera is camera with its state and configuration.
+type apiCamera struct {
+	ID      int          `json:"id"`
+	Name    string       `json:"name"`
+	Status  string       `json:"status"`
+	Motion  bool         `json:"motion"`
+	Privacy bool         `json:"privacy"`
+	Config  CameraConfig `json:"config"`
+}
+
+func (a *API) cameraState() apiCamera {
+	s := a.bus.Status()
+
+	return apiCamera{
+		ID:      0,
+		Name:    a.opts.Name,
+		Status:  s.Camera,
+		Motion:  s.Motion,
+		Privacy: a.camera.Privacy(),
+		Config:  a.opts.Camera,
+	}
+}
+
+// cameraFound reports whether id in path is a known camera, otherwise writes error.
+func (a *API) cameraFound(w http.ResponseWriter, r *http.Request) bool {
+	// Сервер обслуживает одну камеру с id 0
+	if r.PathValue("id") != "0" {
+		writeAPIError(w, http.StatusNotFound, "camera not found")
+		return false
+	}
+
+	return true
+}
+
+func (a *API) cameras(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, []apiCamera{a.cameraState()})
+}
+
+func (a *API) getCamera(w http.ResponseWriter, r *http.Request) {
+	if !a.cameraFound(w, r) {
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, a.cameraState())
+}
+
+func (a *API) patchCamera(w http.ResponseWriter, r *http.Request) {
+	if !a.cameraFound(w, r) {
+		return
+	}
+
+	var req struct {
+		Privacy *bool `json:"privacy"`
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if req.Privacy != nil {
+		a.camera.SetPrivacy(*req.Privacy)
+	}
+
+	writeJSONResponse(w, http.StatusOK, a.cameraState())
+}
+
+func (a *API) snapshot(w http.ResponseWriter, r *http.Request) {
+	if !a.cameraFound(w, r) {
+		return
+	}
+
+	st := a.camera.Stream(r.URL.Query().Get("stream"))
+	if st == nil {
+		writeAPIError(w, http.StatusNotFound, "stream not found")
+		return
+	}
+
+	f := st.Latest()
+	if f == nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "no frame captured yet")
+		return
+	}
+
+	data, err := f.JPEG()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "encode: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("Last-Modified", f.Time.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// maxTriggerDuration is the longest duration of external trigger.
+const maxTriggerDuration = 3600
+
+func (a *API) trigger(w http.ResponseWriter, r *http.Request) {
+	if !a.cameraFound(w, r) {
+		return
+	}
+
+	var req struct {
+		Name     string  `json:"name"`
+		Duration float64 `json:"duration"`
+	}
+
+	// Тело запроса необязательно
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if req.Duration < 0 || req.Duration > maxTriggerDuration {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("duration must be between 0 and %d seconds", maxTriggerDuration))
+		return
+	}
+
+	e := a.bus.Publish(events.Trigger, map[string]any{"source": "api", "name": req.Name, "duration": req.Duration})
+
+	writeJSONResponse(w, http.StatusAccepted, e)
+}
+
+// recordings lists recordings that overlap day in local time, today by default.
+func (a *API) recordings(w http.ResponseWriter, r *http.Request) {
+	if !a.cameraFound(w, r) {
+		return
+	}
+
+	day := time.Now()
+	if v := r.URL.Query().Get("day"); v != "" {
+		var err error
+		if day, err = time.ParseInLocation(time.DateOnly, v, time.Local); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid day, expected YYYY-MM-DD")
+			return
+		}
+	}
+
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+
+	recs, err := a.db.RecordingsBetween(from, from.AddDate(0, 0, 1))
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if recs == nil {
+		recs = make([]*record.Recording, 0)
+	}
+
+	writeJSONResponse(w, http.StatusOK, recs)
+}
+
+// apiRecordingDay is day with recordings.
+type apiRecordingDay struct {
+	Day      string `json:"day"`
+	Segments int    `json:"segments"`
+	Clips    int    `json:"clips"`
+	Size     int64  `json:"size"`
+}
+
+// recordingDays lists days in local time that have recordings, oldest first.
+func (a *API) recordingDays(w http.ResponseWriter, r *http.Request) {
+	if !a.cameraFound(w, r) {
+		return
+	}
+
+	recs, err := a.db.Recordings()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	days := make([]*apiRecordingDay, 0)
+	for _, rec := range recs {
+		day := rec.Start.Local().Format(time.DateOnly)
+		if len(days) == 0 || days[len(days)-1].Day != day {
+			days = append(days, &apiRecordingDay{Day: day})
+		}
+
+		d := days[len(days)-1]
+		if rec.Kind == record.KindClip {
+			d.Clips++
+		} else {
+			d.Segments++
+		}
+
+		d.Size += rec.Size
+	}
+
+	writeJSONResponse(w, http.StatusOK, days)
+}
+
+// retention reports what retention would delete now and what it deleted on the last run.
+func (a *API) retention(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Retention == nil {
+		writeAPIError(w, http.StatusNotFound, "recording is disabled")
+		return
+	}
+
+	rep, err := a.opts.Retention.Run(true)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]*record.Report{"plan": rep, "last": a.opts.Retention.Last()})
+}
+
+func (a *API) patchRecording(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	var req struct {
+		Locked *bool `json:"locked"`
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if _, err := a.db.GetRecording(id); err != nil {
+		writeAPIError(w, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	if req.Locked != nil {
+		if err := a.db.SetRecordingLocked(id, *req.Locked); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	rec, err := a.db.GetRecording(id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, rec)
+}
+
+// createExport starts export of time range of recordings to MP4.
+func (a *API) createExport(w http.ResponseWriter, r *http.Request) {
+	if !a.cameraFound(w, r) {
+		return
+	}
+
+	if a.opts.Exporter == nil {
+		writeAPIError(w, http.StatusNotFound, "recording is disabled")
+		return
+	}
+
+	var req record.ExportOptions
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if !req.To.After(req.From) || req.To.Sub(req.From) > record.MaxExportLength {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("to must be after from and at most %v later", record.MaxExportLength))
+		return
+	}
+
+	job, err := a.opts.Exporter.Start(req)
+	if errors.Is(err, record.ErrExportBusy) {
+		writeAPIError(w, http.StatusTooManyRequests, "too many running exports")
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusAccepted, job)
+}
+
+// getExport reports state and progress of export.
+func (a *API) getExport(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Exporter == nil {
+		writeAPIError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	job, ok := a.opts.Exporter.Get(r.PathValue("id"))
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, job)
+}
+
+// exportFile downloads MP4 file of finished export.
+func (a *API) exportFile(w http.ResponseWriter, r *http.Request) {
+	if a.opts.Exporter == nil {
+		writeAPIError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	job, ok := a.opts.Exporter.Get(r.PathValue("id"))
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "export not found")
+		return
+	}
+
+	name, ok := a.opts.Exporter.File(job.ID)
+	if !ok {
+		writeAPIError(w, http.StatusConflict, "export is "+job.State)
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "export not found")
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// Имя файла по времени начала в локальном времени сервера
+	filename := "cam2ip-" + job.From.Local().Format("20060102-150405") + ".mp4"
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	http.ServeContent(w, r, filename, fi.ModTime(), f)
+}
+
+// apiTimelapse is timelapse job with statistics of its frames.
+type apiTimelapse struct {
+	timelapse.Job
+	timelapse.Stats
+}
+
+// timelapseState returns job with statistics, statistics are empty when they can not be read.
+func (a *API) timelapseState(j timelapse.Job) apiTimelapse {
+	ret := apiTimelapse{Job: j}
+	if a.opts.Timelapse != nil {
+		ret.Stats, _ = a.opts.Timelapse.Stats(j.ID)
+	}
+
+	return ret
+}
+
+// timelapseJob returns job from request path, error response is written when there is none.
+func (a *API) timelapseJob(w http.ResponseWriter, r *http.Request) (*timelapse.Job, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "timelapse not found")
+		return nil, false
+	}
+
+	j, err := a.db.GetTimelapseJob(id)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "timelapse not found")
+		return nil, false
+	}
+
+	return j, true
+}
+
+// decodeTimelapseJob decodes and validates job from request body, error response is written when it is invalid.
+func decodeTimelapseJob(w http.ResponseWriter, r *http.Request) (*timelapse.Job, bool) {
+	var j timelapse.Job
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&j); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return nil, false
+	}
+
+	if err := j.Validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	return &j, true
+}
+
+func (a *API) timelapses(w http.ResponseWriter, r *http.Request) {
+	jobs, err := a.db.GetTimelapseJobs()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ret := make([]apiTimelapse, 0, len(jobs))
+	for _, j := range jobs {
+		ret = append(ret, a.timelapseState(j))
+	}
+
+	writeJSONResponse(w, http.StatusOK, ret)
+}
+
+func (a *API) createTimelapse(w http.ResponseWriter, r *http.Request) {
+	j, ok := decodeTimelapseJob(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.db.CreateTimelapseJob(j); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, a.timelapseState(*j))
+}
+
+func (a *API) getTimelapse(w http.ResponseWriter, r *http.Request) {
+	j, ok := a.timelapseJob(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, a.timelapseState(*j))
+}
+
+func (a *API) updateTimelapse(w http.ResponseWriter, r *http.Request) {
+	old, ok := a.timelapseJob(w, r)
+	if !ok {
+		return
+	}
+
+	j, ok := decodeTimelapseJob(w, r)
+	if !ok {
+		return
+	}
+
+	j.ID = old.ID
+	if err := a.db.UpdateTimelapseJob(j); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, a.timelapseState(*j))
+}
+
+// deleteTimelapse deletes job together with its frames.
+func (a *API) deleteTimelapse(w http.ResponseWriter, r *http.Request) {
+	j, ok := a.timelapseJob(w, r)
+	if !ok {
+		return
+	}
+
+	if err := a.db.DeleteTimelapseJob(j.ID); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if a.opts.Timelapse != nil {
+		if err := a.opts.Timelapse.Delete(j.ID); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// timelapseVideo compiles frames of job to AVI or GIF and downloads it.
+func (a *API) timelapseVideo(w http.ResponseWriter, r *http.Request) {
+	j, ok := a.timelapseJob(w, r)
+	if !ok {
+		return
+	}
+
+	if a.opts.Timelapse == nil {
+		writeAPIError(w, http.StatusNotFound, "timelapse is disabled")
+		return
+	}
+
+	q := r.URL.Query()
+	opts := timelapse.CompileOptions{Format: q.Get("format"), FPS: 10}
+
+	if opts.Format == "" {
+		opts.Format = timelapse.FormatAVI
+	}
+
+	if opts.Format == timelapse.FormatGIF {
+		opts.Width = 640
+	}
+
+	if opts.Format != timelapse.FormatAVI && opts.Format != timelapse.FormatGIF {
+		writeAPIError(w, http.StatusBadRequest, "format must be avi or gif")
+		return
+	}
+
+	var err error
+	if v := q.Get("fps"); v != "" {
+		if opts.FPS, err = strconv.ParseFloat(v, 64); err != nil || opts.FPS <= 0 || opts.FPS > 60 {
+			writeAPIError(w, http.StatusBadRequest, "fps must be between 0 and 60")
+			return
+		}
+	}
+
+	if v := q.Get("width"); v != "" {
+		if opts.Width, err = strconv.Atoi(v); err != nil || opts.Width <= 0 || opts.Width > exportMaxWidth {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("width must be between 1 and %d", exportMaxWidth))
+			return
+		}
+	}
+
+	// Границы задаются днем в локальном времени или временем RFC 3339, день to включается целиком
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+		days int
+	}{{"from", &opts.From, 0}, {"to", &opts.To, 1}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+
+		if *p.t, err = time.Parse(time.RFC3339, v); err == nil {
+			continue
+		}
+
+		day, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid "+p.name+", expected YYYY-MM-DD or RFC 3339 time")
+			return
+		}
+
+		*p.t = day.AddDate(0, 0, p.days)
+	}
+
+	// Видео собирается во временный файл, AVI требует перезаписи заголовка
+	if a.opts.CompileDir != "" {
+		if err := os.MkdirAll(a.opts.CompileDir, 0755); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	f, err := os.CreateTemp(a.opts.CompileDir, "cam2ip-timelapse-*")
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	err = a.opts.Timelapse.Compile(f, j.ID, opts)
+	if errors.Is(err, timelapse.ErrBusy) {
+		writeAPIError(w, http.StatusTooManyRequests, "too many running timelapse videos")
+		return
+	} else if errors.Is(err, timelapse.ErrNoFrames) {
+		writeAPIError(w, http.StatusNotFound, "no frames in time range")
+		return
+	} else if errors.Is(err, timelapse.ErrTooLarge) {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("frames are too large, lower width or time range, at most %d pixels", timelapse.MaxGIFPixels))
+		return
+	} else if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	contentType := "video/x-msvideo"
+	if opts.Format == timelapse.FormatGIF {
+		contentType = "image/gif"
+	}
+
+	filename := fmt.Sprintf("timelapse-%d.%s", j.ID, opts.Format)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	http.ServeContent(w, r, filename, time.Now(), f)
+}
+
+// apiViewer is connected viewer.
+type apiViewer struct {
+	ID          uint64    `json:"id"`
+	Handler     string    `json:"handler"`
+	Stream      string    `json:"stream"`
+	IP          string    `json:"ip"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+func (a *API) viewers(w http.ResponseWriter, r *http.Request) {
+	list := a.bus.Viewers()
+
+	ret := make([]apiViewer, 0, len(list))
+	for _, e := range list {
+		v := apiViewer{ID: e.ID, ConnectedAt: e.Time.UTC()}
+		v.Handler, _ = e.Data["handler"].(string)
+		v.Stream, _ = e.Data["stream"].(string)
+		v.IP, _ = e.Data["ip"].(string)
+
+		ret = append(ret, v)
+	}
+
+	writeJSONResponse(w, http.StatusOK, ret)
+}
+
+func (a *API) users(w http.ResponseWriter, r *http.Request) {
+	users, err := a.db.GetUsers()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, users)
+}
+
+func (a *API) createUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if strings.TrimSpace(req.Username) == "" || req.Password == "" {
+		writeAPIError(w, http.StatusBadRequest, "username and password are required")
+		return
+	}
+
+	if _, err := a.db.GetUserByUsername(req.Username); err == nil {
+		writeAPIError(w, http.StatusConflict, "user already exists")
+		return
+	}
+
+	if err := a.db.CreateUser(req.Username, req.Password, req.Email); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	user, err := a.db.GetUserByUsername(req.Username)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, user)
+}
+
+func (a *API) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	users, err := a.db.GetUsers()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var found *User
+	active := 0
+	for i, u := range users {
+		if u.ID == id {
+			found = &users[i]
+		}
+
+		if u.IsActive {
+			active++
+		}
+	}
+
+	if found == nil {
+		writeAPIError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	// Последнего активного пользователя не удаляем, иначе войти будет некому
+	if found.IsActive && active == 1 {
+		writeAPIError(w, http.StatusConflict, "cannot delete the last active user")
+		return
+	}
+
+	if err := a.db.DeleteUser(id); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) authLogs(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r.URL.Query().Get("limit"), 100)
+	if err != nil || limit < 1 || limit > 1000 {
+		writeAPIError(w, http.StatusBadRequest, "invalid limit, must be between 1 and 1000")
+		return
+	}
+
+	logs, err := a.db.GetRecentAuthLogs(limit)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if logs == nil {
+		logs = make([]AuthLog, 0)
+	}
+
+	writeJSONResponse(w, http.StatusOK, logs)
+}
+
+var openAPIJSON = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "cam2ip API",
+    "version": "1.0.0",
+    "description": "Status, configuration and control of cam2ip. Requests are authenticated with session cookie or HTTP Basic authentication."
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"basic": []}, {"session": []}],
+  "paths": {
+    "/status": {
+      "get": {
+        "summary": "Server status",
+        "responses": {
+          "200": {"description": "Status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/cameras": {
+      "get": {
+        "summary": "List cameras",
+        "responses": {
+          "200": {"description": "Cameras", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Camera"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/cameras/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/CameraID"}],
+      "get": {
+        "summary": "Camera state and configuration",
+        "responses": {
+          "200": {"description": "Camera", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Camera"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "summary": "Change camera settings",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "object", "additionalProperties": false, "properties": {"privacy": {"type": "boolean"}}}}}
+        },
+        "responses": {
+          "200": {"description": "Camera", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Camera"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/cameras/{id}/snapshot": {
+      "parameters": [
+        {"$ref": "#/components/parameters/CameraID"},
+        {"name": "stream", "in": "query", "schema": {"type": "string", "enum": ["main", "sub"], "default": "main"}}
+      ],
+      "get": {
+        "summary": "Latest frame as JPEG",
+        "responses": {
+          "200": {"description": "Snapshot", "content": {"image/jpeg": {"schema": {"type": "string", "format": "binary"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/cameras/{id}/trigger": {
+      "parameters": [{"$ref": "#/components/parameters/CameraID"}],
+      "post": {
+        "summary": "Trigger event recording, clip lasts for duration and post-roll",
+        "requestBody": {
+          "required": false,
+          "content": {"application/json": {"schema": {"type": "object", "additionalProperties": false, "properties": {
+            "name": {"type": "string", "description": "Name of the trigger source, e.g. door sensor"},
+            "duration": {"type": "number", "minimum": 0, "maximum": 3600, "default": 0, "description": "Seconds"}
+          }}}}
+        },
+        "responses": {
+          "202": {"description": "Published event", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Event"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/cameras/{id}/recordings": {
+      "parameters": [
+        {"$ref": "#/components/parameters/CameraID"},
+        {"name": "day", "in": "query", "schema": {"type": "string", "format": "date"}, "description": "Day in local time of the server, today by default"}
+      ],
+      "get": {
+        "summary": "Recordings that overlap day, ordered by start time",
+        "responses": {
+          "200": {"description": "Recordings", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Recording"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/cameras/{id}/recordings/days": {
+      "parameters": [{"$ref": "#/components/parameters/CameraID"}],
+      "get": {
+        "summary": "Days with recordings, oldest first",
+        "responses": {
+          "200": {"description": "Days", "content": {"application/json": {"schema": {"type": "array", "items": {"type": "object", "properties": {
+            "day": {"type": "string", "format": "date"},
+            "segments": {"type": "integer"},
+            "clips": {"type": "integer"},
+            "size": {"type": "integer"}
+          }}}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/cameras/{id}/exports": {
+      "parameters": [{"$ref": "#/components/parameters/CameraID"}],
+      "post": {
+        "summary": "Export time range of recordings to MP4, export runs in background",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "object", "additionalProperties": false, "required": ["from", "to"], "properties": {
+            "from": {"type": "string", "format": "date-time"},
+            "to": {"type": "string", "format": "date-time", "description": "At most 24 hours after from"},
+            "overlay": {"type": "boolean", "default": false, "description": "Burn time of each frame into the video"},
+            "label": {"type": "string", "description": "Text shown before time in overlay"}
+          }}}}
+        },
+        "responses": {
+          "202": {"description": "Started export", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Export"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/exports/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "State and progress of export, exports are kept for 24 hours",
+        "responses": {
+          "200": {"description": "Export", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Export"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/exports/{id}/file": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+      "get": {
+        "summary": "Download MP4 file of finished export",
+        "responses": {
+          "200": {"description": "MP4 file", "content": {"video/mp4": {"schema": {"type": "string", "format": "binary"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/recordings/retention": {
+      "get": {
+        "summary": "Dry run of retention policies and report of the last run",
+        "responses": {
+          "200": {"description": "Retention reports", "content": {"application/json": {"schema": {"type": "object", "properties": {
+            "plan": {"$ref": "#/components/schemas/RetentionReport"},
+            "last": {"allOf": [{"$ref": "#/components/schemas/RetentionReport"}], "nullable": true}
+          }}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/recordings/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}],
+      "patch": {
+        "summary": "Lock or unlock recording, locked recordings are never deleted by retention",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "object", "additionalProperties": false, "properties": {"locked": {"type": "boolean"}}}}}
+        },
+        "responses": {
+          "200": {"description": "Recording", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Recording"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/timelapses": {
+      "get": {
+        "summary": "Timelapse jobs with statistics of their frames",
+        "responses": {
+          "200": {"description": "Timelapse jobs", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Timelapse"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Create timelapse job",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TimelapseJob"}}}},
+        "responses": {
+          "201": {"description": "Created job", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Timelapse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/timelapses/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}],
+      "get": {
+        "summary": "Timelapse job",
+        "responses": {
+          "200": {"description": "Timelapse job", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Timelapse"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "put": {
+        "summary": "Replace timelapse job, captured frames are kept",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/TimelapseJob"}}}},
+        "responses": {
+          "200": {"description": "Updated job", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Timelapse"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete timelapse job and its frames",
+        "responses": {
+          "204": {"description": "Deleted"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/timelapses/{id}/video": {
+      "parameters": [
+        {"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}},
+        {"name": "format", "in": "query", "schema": {"type": "string", "enum": ["avi", "gif"], "default": "avi"}},
+        {"name": "fps", "in": "query", "schema": {"type": "number", "default": 10, "maximum": 60}},
+        {"name": "width", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1920}, "description": "Width of GIF frames, 640 by default, frames narrower than width keep their size"},
+        {"name": "from", "in": "query", "schema": {"type": "string"}, "description": "Day in local time or RFC 3339 time"},
+        {"name": "to", "in": "query", "schema": {"type": "string"}, "description": "Day in local time, included, or RFC 3339 time"}
+      ],
+      "get": {
+        "summary": "Compile frames to MJPEG AVI or animated GIF, GIF has at most 500 frames",
+        "responses": {
+          "200": {"description": "Video", "content": {
+            "video/x-msvideo": {"schema": {"type": "string", "format": "binary"}},
+            "image/gif": {"schema": {"type": "string", "format": "binary"}}
+          }},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/viewers": {
+      "get": {
+        "summary": "Connected viewers",
+        "responses": {
+          "200": {"description": "Viewers", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Viewer"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/users": {
+      "get": {
+        "summary": "List users",
+        "responses": {
+          "200": {"description": "Users", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/User"}}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Create user",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/NewUser"}}}
+        },
+        "responses": {
+          "201": {"description": "Created user", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/users/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}],
+      "delete": {
+        "summary": "Delete user, the last active user can not be deleted",
+        "responses": {
+          "204": {"description": "Deleted"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/auth-logs": {
+      "get": {
+        "summary": "Recent authentication attempts, newest first",
+        "parameters": [{"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}}],
+        "responses": {
+          "200": {"description": "Authentication logs", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AuthLog"}}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "basic": {"type": "http", "scheme": "basic"},
+      "session": {"type": "apiKey", "in": "cookie", "name": "session_id"}
+    },
+    "parameters": {
+      "CameraID": {"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "enum": [0]}}
+    },
+    "responses": {
+      "Error": {"description": "Error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {"type": "object", "properties": {"code": {"type": "integer"}, "message": {"type": "string"}}}
+        }
+      },
+      "Status": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "version": {"type": "string"},
+          "started_at": {"type": "string", "format": "date-time"},
+          "uptime": {"type": "number", "description": "Seconds since start"},
+          "camera": {"type": "string", "enum": ["online", "offline"]},
+          "motion": {"type": "boolean"},
+          "privacy": {"type": "boolean"},
+          "viewers": {"type": "integer"},
+          "fps": {"type": "number", "description": "Capture frame rate"},
+          "frames": {"type": "integer", "description": "Frames captured since start"}
+        }
+      },
+      "Camera": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "name": {"type": "string"},
+          "status": {"type": "string", "enum": ["online", "offline"]},
+          "motion": {"type": "boolean"},
+          "privacy": {"type": "boolean"},
+          "config": {
+            "type": "object",
+            "properties": {
+              "index": {"type": "integer"},
+              "rotate": {"type": "integer"},
+              "flip": {"type": "string"},
+              "codec": {"type": "string"},
+              "timestamp": {"type": "boolean"},
+              "motion": {"type": "boolean"},
+              "history": {"type": "integer", "description": "Frame history length in seconds"},
+              "streams": {"type": "array", "items": {"$ref": "#/components/schemas/Stream"}}
+            }
+          }
+        }
+      },
+      "Stream": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "width": {"type": "integer"},
+          "height": {"type": "integer"},
+          "fps": {"type": "number"},
+          "quality": {"type": "integer"}
+        }
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "type": {"type": "string"},
+          "time": {"type": "string", "format": "date-time"},
+          "data": {"type": "object"}
+        }
+      },
+      "Recording": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "kind": {"type": "string", "enum": ["segment", "clip"]},
+          "event": {"type": "string", "enum": ["motion", "tamper", "trigger"]},
+          "path": {"type": "string", "description": "Path relative to recordings directory"},
+          "start": {"type": "string", "format": "date-time"},
+          "end": {"type": "string", "format": "date-time"},
+          "frames": {"type": "integer"},
+          "size": {"type": "integer"},
+          "locked": {"type": "boolean"}
+        }
+      },
+      "Export": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "from": {"type": "string", "format": "date-time"},
+          "to": {"type": "string", "format": "date-time"},
+          "overlay": {"type": "boolean"},
+          "label": {"type": "string"},
+          "state": {"type": "string", "enum": ["running", "done", "failed"]},
+          "progress": {"type": "number", "minimum": 0, "maximum": 1},
+          "frames": {"type": "integer"},
+          "size": {"type": "integer"},
+          "error": {"type": "string"},
+          "created": {"type": "string", "format": "date-time"},
+          "finished": {"type": "string", "format": "date-time"}
+        }
+      },
+      "Usage": {
+        "type": "object",
+        "properties": {
+          "count": {"type": "integer"},
+          "size": {"type": "integer"}
+        }
+      },
+      "RetentionReport": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "dry_run": {"type": "boolean"},
+          "segments": {"$ref": "#/components/schemas/Usage"},
+          "clips": {"$ref": "#/components/schemas/Usage"},
+          "locked": {"$ref": "#/components/schemas/Usage"},
+          "free": {"type": "integer", "description": "Free disk space, -1 if unknown"},
+          "oldest": {"type": "string", "format": "date-time"},
+          "deleted": {"type": "array", "items": {"type": "object", "properties": {
+            "recording": {"$ref": "#/components/schemas/Recording"},
+            "reason": {"type": "string", "enum": ["age", "size", "free"]}
+          }}},
+          "freed": {"type": "integer"}
+        }
+      },
+      "TimelapseJob": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["name", "interval"],
+        "properties": {
+          "name": {"type": "string"},
+          "enabled": {"type": "boolean"},
+          "interval": {"type": "integer", "minimum": 1, "description": "Seconds between frames"},
+          "window_start": {"type": "string", "example": "07:00", "description": "Local time, HH:MM, empty is all day"},
+          "window_end": {"type": "string", "example": "19:00"},
+          "retention": {"type": "integer", "minimum": 0, "description": "Days frames are kept, 0 keeps them forever"}
+        }
+      },
+      "Timelapse": {
+        "allOf": [
+          {"$ref": "#/components/schemas/TimelapseJob"},
+          {"type": "object", "properties": {
+            "id": {"type": "integer"},
+            "frames": {"type": "integer"},
+            "size": {"type": "integer"},
+            "first": {"type": "string", "format": "date-time"},
+            "last": {"type": "string", "format": "date-time"}
+          }}
+        ]
+      },
+      "Viewer": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "handler": {"type": "string"},
+          "stream": {"type": "string"},
+          "ip": {"type": "string"},
+          "connected_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "User": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "username": {"type": "string"},
+          "email": {"type": "string"},
+          "is_active": {"type": "boolean"},
+          "created_at": {"type": "string", "format": "date-time"},
+          "updated_at": {"type": "string", "format": "date-time"}
+        }
+      },
+      "NewUser": {
+        "type": "object",
+        "required": ["username", "password"],
+        "properties": {
+          "username": {"type": "string"},
+          "password": {"type": "string"},
+          "email": {"type": "string"}
+        }
+      },
+      "AuthLog": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "integer"},
+          "username": {"type": "string"},
+          "ip_address": {"type": "string"},
+          "user_agent": {"type": "string"},
+          "success": {"type": "boolean"},
+          "created_at": {"type": "string", "format": "date-time"}
+        }
+      }
+    }
+  }
+}
+`
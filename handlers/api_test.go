@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/record"
+	"github.com/gen2brain/cam2ip/stream"
+	"github.com/gen2brain/cam2ip/timelapse"
+)
+
+// testCamera is camera without streams.
+type testCamera struct {
+	privacy bool
+}
+
+func (c *testCamera) Stream(name string) *stream.Stream {
+	return nil
+}
+
+func (c *testCamera) Latest() *stream.Frame {
+	return nil
+}
+
+func (c *testCamera) SetPrivacy(on bool) {
+	c.privacy = on
+}
+
+func (c *testCamera) Privacy() bool {
+	return c.privacy
+}
+
+func TestAPI(t *testing.T) {
+	// Database is created in data directory of working directory
+	t.Chdir(t.TempDir())
+
+	db, err := NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	rec := &record.Recording{Kind: record.KindSegment, Path: "segments/test.avi", Start: now.Add(-time.Minute), End: now, Frames: 60}
+	if err := db.AddRecording(rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.CreateTimelapseJob(&timelapse.Job{Name: "site", Enabled: true, Interval: 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	camera := &testCamera{}
+	opts := APIOptions{Name: "cam2ip", Version: "1.6", Camera: CameraConfig{Codec: "native"}, Timelapse: timelapse.New(t.TempDir(), db, camera)}
+	api := NewAPI(opts, camera, db, events.NewBus())
+
+	srv := httptest.NewServer(Trusted(api))
+	defer srv.Close()
+
+	do := func(method, path, body string) (*http.Response, []byte) {
+		t.Helper()
+
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("%s %s: unexpected content type %q", method, path, ct)
+		}
+
+		return resp, data
+	}
+
+	resp, data := do("GET", "/api/v1/status", "")
+
+	var status apiStatus
+	if err := json.Unmarshal(data, &status); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %d %s", resp.StatusCode, data)
+	}
+
+	if status.Name != "cam2ip" || status.Version != "1.6" || status.Camera != "online" || status.Privacy {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	resp, data = do("GET", "/api/v1/cameras", "")
+
+	var cameras []apiCamera
+	if err := json.Unmarshal(data, &cameras); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("cameras: %d %s", resp.StatusCode, data)
+	}
+
+	if len(cameras) != 1 || cameras[0].ID != 0 || cameras[0].Config.Codec != "native" {
+		t.Errorf("unexpected cameras %+v", cameras)
+	}
+
+	resp, data = do("PATCH", "/api/v1/cameras/0", `{"privacy":true}`)
+
+	var cam apiCamera
+	if err := json.Unmarshal(data, &cam); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch camera: %d %s", resp.StatusCode, data)
+	}
+
+	if !cam.Privacy || !camera.privacy {
+		t.Errorf("expected privacy on, got %+v", cam)
+	}
+
+	resp, data = do("PATCH", "/api/v1/recordings/1", `{"locked":true}`)
+
+	var got record.Recording
+	if err := json.Unmarshal(data, &got); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch recording: %d %s", resp.StatusCode, data)
+	}
+
+	if got.ID != rec.ID || !got.Locked {
+		t.Errorf("expected locked recording %d, got %+v", rec.ID, got)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		allow  string
+	}{
+		{"GET", "/api/v1/cameras/1", "", http.StatusNotFound, ""},
+		{"PATCH", "/api/v1/cameras/0", `{"privacy":true,"zoom":2}`, http.StatusBadRequest, ""},
+		{"PATCH", "/api/v1/cameras/0", `{"privacy":`, http.StatusBadRequest, ""},
+		{"PATCH", "/api/v1/recordings/1", `{"locked":true,"path":"x"}`, http.StatusBadRequest, ""},
+		{"PATCH", "/api/v1/recordings/99", `{"locked":true}`, http.StatusNotFound, ""},
+		{"DELETE", "/api/v1/cameras/0", "", http.StatusMethodNotAllowed, "GET, PATCH"},
+		{"POST", "/api/v1/timelapses/1", "", http.StatusMethodNotAllowed, "GET, PUT, DELETE"},
+		{"GET", "/api/v1/unknown", "", http.StatusNotFound, ""},
+		{"GET", "/api/v1/timelapses/1/video?format=gif&width=0", "", http.StatusBadRequest, ""},
+		{"GET", "/api/v1/timelapses/1/video?format=gif&width=4000", "", http.StatusBadRequest, ""},
+		{"GET", "/api/v1/timelapses/1/video?format=gif", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		resp, data := do(tt.method, tt.path, tt.body)
+
+		var e map[string]apiError
+		if err := json.Unmarshal(data, &e); err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.path, err)
+		}
+
+		if resp.StatusCode != tt.code || e["error"].Code != tt.code || e["error"].Message == "" {
+			t.Errorf("%s %s: expected error %d, got %d %s", tt.method, tt.path, tt.code, resp.StatusCode, data)
+		}
+
+		if allow := resp.Header.Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: expected Allow %q, got %q", tt.method, tt.path, tt.allow, allow)
+		}
+	}
+}
+
+func TestAPIUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(NewAPI(APIOptions{}, &testCamera{}, nil, events.NewBus()))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var e map[string]apiError
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized || e["error"].Code != http.StatusUnauthorized || e["error"].Message != "authentication required" {
+		t.Errorf("unexpected response %d %+v", resp.StatusCode, e)
+	}
+
+	if resp.Header.Get("WWW-Authenticate") == "" {
+		t.Error("expected WWW-Authenticate header")
+	}
+
+	// API description is served without authentication
+	resp, err = http.Get(srv.URL + "/api/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected openapi.json without auth, got %d", resp.StatusCode)
+	}
+}
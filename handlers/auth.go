@@ -4,6 +4,9 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/metrics"
 )
 
 // Auth handler.
@@ -182,23 +185,10 @@ func (a *Auth) handleLogin(w http.ResponseWriter, r *http.Request) {
 	ipAddress := GetClientIP(r)
 	userAgent := r.UserAgent()
 
-	// Получаем экземпляры базы данных и логгера
-	db := GetDatabase()
 	logger := GetLogger()
 
 	// Проверяем авторизацию через базу данных
-	_, err = db.AuthenticateUser(username, password)
-	success := err == nil
-
-	// Логируем попытку авторизации
-	logger.LogAuth(username, ipAddress, userAgent, success)
-
-	// Также сохраняем в базу данных
-	if dbErr := db.LogAuthAttempt(username, ipAddress, userAgent, success); dbErr != nil {
-		logger.LogError("Failed to log auth attempt to database", dbErr)
-	} else {
-		logger.LogInfo(fmt.Sprintf("Auth attempt logged to database for user: %s", username))
-	}
+	success := CheckCredentials(username, password, ipAddress, userAgent)
 
 	if success {
 		// Создаем сессию
@@ -217,3 +207,33 @@ func (a *Auth) handleLogin(w http.ResponseWriter, r *http.Request) {
 	errorMsg := "Неверное имя пользователя или пароль"
 	http.Redirect(w, r, "/?error="+errorMsg, http.StatusSeeOther)
 }
+
+// CheckCredentials authenticates user against the database and logs the attempt
+func CheckCredentials(username, password, ipAddress, userAgent string) bool {
+	// Получаем экземпляры базы данных и логгера
+	db := GetDatabase()
+	logger := GetLogger()
+
+	// Проверяем авторизацию через базу данных
+	_, err := db.AuthenticateUser(username, password)
+	success := err == nil
+
+	// Логируем попытку авторизации
+	logger.LogAuth(username, ipAddress, userAgent, success)
+
+	if success {
+		metrics.Logins.With("success").Inc()
+	} else {
+		metrics.Logins.With("failure").Inc()
+		events.Publish(events.AuthFailed, map[string]any{"username": username, "ip": ipAddress, "user_agent": userAgent})
+	}
+
+	// Также сохраняем в базу данных
+	if dbErr := db.LogAuthAttempt(username, ipAddress, userAgent, success); dbErr != nil {
+		logger.LogError("Failed to log auth attempt to database", dbErr)
+	} else {
+		logger.LogInfo(fmt.Sprintf("Auth attempt logged to database for user: %s", username))
+	}
+
+	return success
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/cam2ip/image"
+)
+
+// maxBenchOps is the maximum number of encodes of one benchmark, summed over all codecs.
+const maxBenchOps = 300
+
+// CodecBench handler.
+type CodecBench struct {
+	reader ImageReader
+	opts   image.EncoderOptions
+
+	// Одновременно выполняется только один замер
+	mu sync.Mutex
+}
+
+// CodecResult is a benchmark result for one codec.
+type CodecResult struct {
+	Codec    string  `json:"codec"`
+	Current  bool    `json:"current"`
+	Size     int     `json:"size"`
+	EncodeMs float64 `json:"encode_ms"`
+	DecodeMs float64 `json:"decode_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// NewCodecBench returns new CodecBench handler.
+func NewCodecBench(reader ImageReader, opts image.EncoderOptions) *CodecBench {
+	return &CodecBench{reader: reader, opts: opts}
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (c *CodecBench) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	codecs := image.Codecs()
+
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 1 || i*len(codecs) > maxBenchOps {
+			http.Error(w, "400 Bad Request", http.StatusBadRequest)
+
+			return
+		}
+
+		n = i
+	}
+
+	if !c.mu.TryLock() {
+		http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+
+		return
+	}
+	defer c.mu.Unlock()
+
+	// Замер может длиться дольше WriteTimeout сервера
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	img, err := c.reader.Read()
+	if err != nil {
+		log.Printf("codec-bench: read: %v", err)
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	current := image.CurrentCodec().Name()
+	results := make([]CodecResult, 0)
+
+	for _, codec := range codecs {
+		res := CodecResult{Codec: codec.Name(), Current: codec.Name() == current}
+
+		buf := new(bytes.Buffer)
+
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			buf.Reset()
+
+			err = codec.Encode(buf, img, c.opts)
+			if err != nil {
+				break
+			}
+		}
+
+		if err != nil {
+			res.Error = err.Error()
+			results = append(results, res)
+
+			continue
+		}
+
+		res.EncodeMs = msPerOp(time.Since(start), n)
+		res.Size = buf.Len()
+
+		start = time.Now()
+		for i := 0; i < n; i++ {
+			_, err = codec.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				res.Error = err.Error()
+
+				break
+			}
+		}
+
+		if err == nil {
+			res.DecodeMs = msPerOp(time.Since(start), n)
+		}
+
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(results)
+}
+
+func msPerOp(d time.Duration, n int) float64 {
+	return float64(d.Microseconds()) / float64(n) / 1000
+}
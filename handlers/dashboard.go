@@ -3,15 +3,22 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
 )
 
 // Dashboard handler.
 type Dashboard struct {
+	stream string
 }
 
-// NewDashboard returns new Dashboard handler.
-func NewDashboard() *Dashboard {
-	return &Dashboard{}
+// NewDashboard returns new Dashboard handler, camera grid uses substream when it is enabled.
+func NewDashboard(substream bool) *Dashboard {
+	d := &Dashboard{stream: "main"}
+	if substream {
+		d.stream = "sub"
+	}
+
+	return d
 }
 
 // ServeHTTP handles requests on incoming connections.
@@ -121,6 +128,44 @@ func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
             background-color: #d4edda;
             color: #155724;
         }
+        .camera-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fill, minmax(320px, 1fr));
+            gap: 1rem;
+            margin-bottom: 2rem;
+        }
+        .camera-tile {
+            background: #000;
+            border-radius: 8px;
+            overflow: hidden;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+        }
+        .camera-tile img {
+            display: block;
+            width: 100%;
+        }
+        .events {
+            background: white;
+            padding: 1rem 2rem;
+            border-radius: 8px;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+            margin-bottom: 2rem;
+        }
+        .events ul {
+            list-style: none;
+            padding: 0;
+            margin: 0;
+            font-family: monospace;
+            font-size: 0.875rem;
+            color: #555;
+        }
+        .events li button {
+            margin-left: 0.5rem;
+        }
+        .events form input {
+            padding: 0.3rem;
+            width: 6rem;
+        }
     </style>
 </head>
 <body>
@@ -135,6 +180,35 @@ func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
             <p>Вы успешно авторизованы. Выберите один из доступных сервисов:</p>
         </div>
         
+        <div class="camera-grid">
+            <a class="camera-tile" href="/html">
+                <img src="/mjpeg?stream={STREAM}" alt="camera">
+            </a>
+        </div>
+        
+        <div class="events">
+            <h3>События <span id="camera-status" class="status online">online</span></h3>
+            <ul id="events"></ul>
+        </div>
+        
+        <div class="events" id="recordings" style="display: none">
+            <h3>Записи</h3>
+            <ul id="retention"></ul>
+        </div>
+        
+        <div class="events">
+            <h3>Таймлапс</h3>
+            <ul id="timelapses"></ul>
+            <form id="timelapse-form">
+                <input name="name" placeholder="Название" required>
+                <input name="interval" type="number" min="1" value="60" title="Интервал, секунды" required>
+                <input name="window_start" type="time" title="Начало окна">
+                <input name="window_end" type="time" title="Конец окна">
+                <input name="retention" type="number" min="0" value="0" title="Хранить дней, 0 без ограничения">
+                <button type="submit">Добавить</button>
+            </form>
+        </div>
+        
         <div class="services-grid">
             <div class="service-card">
                 <h3>HTML Видеопоток</h3>
@@ -153,11 +227,177 @@ func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
                 <p>Motion JPEG поток для просмотра в медиаплеерах</p>
                 <a href="/mjpeg" class="service-link">Открыть MJPEG</a>
             </div>
+            
+            <div class="service-card">
+                <h3>GIF Экспорт</h3>
+                <p>Анимированный GIF из истории кадров за последнюю минуту</p>
+                <a href="/export/gif?duration=60s&download=1" class="service-link">Скачать GIF</a>
+            </div>
+            
+            <div class="service-card">
+                <h3>Записи</h3>
+                <p>Просмотр записей и клипов событий на шкале времени</p>
+                <a href="/recordings" class="service-link">Открыть записи</a>
+            </div>
+            
+            <div class="service-card">
+                <h3>Галерея</h3>
+                <p>Сохранённые снимки и экспортированные GIF</p>
+                <a href="/gallery/" class="service-link">Открыть галерею</a>
+            </div>
+            
+            <div class="service-card">
+                <h3>Уведомления</h3>
+                <p>Webhook и email уведомления о движении, вмешательстве и недоступности камеры</p>
+                <a href="/notifications" class="service-link">Настроить</a>
+            </div>
         </div>
     </div>
+
+    <script>
+        var es = new EventSource("/events");
+        var list = document.getElementById("events");
+        var cameraStatus = document.getElementById("camera-status");
+
+        function setCamera(state) {
+            cameraStatus.textContent = state;
+            cameraStatus.style.backgroundColor = state === "online" ? "" : "#f8d7da";
+        }
+
+        es.addEventListener("status", function(e) {
+            setCamera(JSON.parse(e.data).camera);
+        });
+
+        ["motion_start", "motion_end", "camera_offline", "camera_online", "viewer_connected",
+         "viewer_disconnected", "recording_started", "auth_failed", "tamper", "privacy_on", "privacy_off", "trigger"].forEach(function(type) {
+            es.addEventListener(type, function(e) {
+                var ev = JSON.parse(e.data);
+                if (type === "camera_offline" || type === "camera_online") {
+                    setCamera(type.substring(7));
+                }
+                var li = document.createElement("li");
+                li.textContent = new Date(ev.time).toLocaleTimeString() + " " + type + (ev.data ? " " + JSON.stringify(ev.data) : "");
+                list.insertBefore(li, list.firstChild);
+                while (list.children.length > 10) {
+                    list.removeChild(list.lastChild);
+                }
+            });
+        });
+
+        function size(bytes) {
+            if (bytes < 0) {
+                return "неизвестно";
+            }
+            return (bytes / 1048576).toFixed(1) + " MB";
+        }
+
+        function retention() {
+            fetch("/api/v1/recordings/retention").then(function(r) {
+                return r.ok ? r.json() : null;
+            }).then(function(rep) {
+                if (!rep) {
+                    return;
+                }
+                var p = rep.plan, lines = [
+                    "Сегменты: " + p.segments.count + ", " + size(p.segments.size),
+                    "Клипы: " + p.clips.count + ", " + size(p.clips.size) + ", защищены " + p.locked.count,
+                    "Свободно на диске: " + size(p.free),
+                    "Самая старая запись: " + (p.oldest ? new Date(p.oldest).toLocaleString() : "нет"),
+                    "Будет удалено по политикам хранения: " + p.deleted.length + ", " + size(p.freed)
+                ];
+                if (rep.last) {
+                    lines.push("Последняя очистка " + new Date(rep.last.time).toLocaleString() + ": удалено " + rep.last.deleted.length + ", " + size(rep.last.freed));
+                }
+                var ul = document.getElementById("retention");
+                ul.innerHTML = "";
+                lines.forEach(function(line) {
+                    var li = document.createElement("li");
+                    li.textContent = line;
+                    ul.appendChild(li);
+                });
+                document.getElementById("recordings").style.display = "";
+            });
+        }
+
+        retention();
+        setInterval(retention, 60000);
+
+        function timelapseRequest(method, url, body) {
+            return fetch(url, {
+                method: method,
+                headers: {"Content-Type": "application/json"},
+                body: body ? JSON.stringify(body) : undefined
+            }).then(function(r) {
+                if (!r.ok) {
+                    return r.json().then(function(e) {
+                        alert(e.error.message);
+                    });
+                }
+            }).then(timelapses);
+        }
+
+        function timelapses() {
+            fetch("/api/v1/timelapses").then(function(r) {
+                return r.ok ? r.json() : [];
+            }).then(function(jobs) {
+                var ul = document.getElementById("timelapses");
+                ul.innerHTML = "";
+                jobs.forEach(function(j) {
+                    var li = document.createElement("li");
+                    li.textContent = j.name + ": каждые " + j.interval + " с" +
+                        (j.window_start ? ", " + j.window_start + "-" + j.window_end : "") +
+                        (j.retention ? ", хранить " + j.retention + " дн." : "") +
+                        ", кадров " + j.frames + ", " + size(j.size) + " ";
+                    ["avi", "gif"].forEach(function(format) {
+                        var a = document.createElement("a");
+                        a.href = "/api/v1/timelapses/" + j.id + "/video?format=" + format;
+                        a.textContent = format.toUpperCase();
+                        li.appendChild(a);
+                        li.appendChild(document.createTextNode(" "));
+                    });
+                    var toggle = document.createElement("button");
+                    toggle.textContent = j.enabled ? "Остановить" : "Запустить";
+                    toggle.onclick = function() {
+                        var job = {name: j.name, enabled: !j.enabled, interval: j.interval,
+                            window_start: j.window_start, window_end: j.window_end, retention: j.retention};
+                        timelapseRequest("PUT", "/api/v1/timelapses/" + j.id, job);
+                    };
+                    var del = document.createElement("button");
+                    del.textContent = "Удалить";
+                    del.onclick = function() {
+                        if (confirm("Удалить задание " + j.name + " и все его кадры?")) {
+                            timelapseRequest("DELETE", "/api/v1/timelapses/" + j.id);
+                        }
+                    };
+                    li.appendChild(toggle);
+                    li.appendChild(del);
+                    ul.appendChild(li);
+                });
+            });
+        }
+
+        document.getElementById("timelapse-form").addEventListener("submit", function(e) {
+            e.preventDefault();
+            var f = e.target;
+            timelapseRequest("POST", "/api/v1/timelapses", {
+                name: f.elements["name"].value,
+                enabled: true,
+                interval: Number(f.elements["interval"].value),
+                window_start: f.elements["window_start"].value,
+                window_end: f.elements["window_end"].value,
+                retention: Number(f.elements["retention"].value)
+            });
+            f.reset();
+        });
+
+        timelapses();
+        setInterval(timelapses, 60000);
+    </script>
 </body>
 </html>`
 
+	dashboardHTML = strings.Replace(dashboardHTML, "{STREAM}", d.stream, -1)
+
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(dashboardHTML))
@@ -7,8 +7,16 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/hub"
+	"github.com/gen2brain/cam2ip/notify"
+	"github.com/gen2brain/cam2ip/record"
+	"github.com/gen2brain/cam2ip/timelapse"
 )
 
 // Database represents the database connection and operations
@@ -76,6 +84,70 @@ func (d *Database) initTables() error {
 	CREATE INDEX IF NOT EXISTS idx_auth_logs_username ON auth_logs(username);
 	CREATE INDEX IF NOT EXISTS idx_auth_logs_created_at ON auth_logs(created_at);`
 
+	// Создаем таблицу получателей уведомлений
+	createNotificationTargetsTable := `
+	CREATE TABLE IF NOT EXISTS notification_targets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		enabled BOOLEAN DEFAULT 1,
+		url TEXT,
+		address TEXT,
+		username TEXT,
+		password TEXT,
+		from_address TEXT,
+		to_addresses TEXT,
+		events TEXT,
+		cooldown INTEGER DEFAULT 0,
+		quiet_start TEXT,
+		quiet_end TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Создаем таблицу узлов hub
+	createHubNodesTable := `
+	CREATE TABLE IF NOT EXISTS hub_nodes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		username TEXT,
+		password TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Создаем таблицу записей, время хранится в UTC
+	createRecordingsTable := `
+	CREATE TABLE IF NOT EXISTS recordings (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		event TEXT,
+		path TEXT UNIQUE NOT NULL,
+		start_time DATETIME NOT NULL,
+		end_time DATETIME NOT NULL,
+		frames INTEGER DEFAULT 0,
+		size INTEGER DEFAULT 0,
+		locked BOOLEAN DEFAULT 0,
+		thumbnail BLOB,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_recordings_start_time ON recordings(start_time);`
+
+	// Создаем таблицу заданий таймлапса, кадры хранятся в файлах
+	createTimelapseJobsTable := `
+	CREATE TABLE IF NOT EXISTS timelapse_jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled BOOLEAN DEFAULT 1,
+		interval INTEGER NOT NULL,
+		window_start TEXT,
+		window_end TEXT,
+		retention INTEGER DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
 	if _, err := d.db.Exec(createUsersTable); err != nil {
 		return fmt.Errorf("failed to create users table: %v", err)
 	}
@@ -88,6 +160,27 @@ func (d *Database) initTables() error {
 		return fmt.Errorf("failed to create indexes: %v", err)
 	}
 
+	if _, err := d.db.Exec(createNotificationTargetsTable); err != nil {
+		return fmt.Errorf("failed to create notification_targets table: %v", err)
+	}
+
+	if _, err := d.db.Exec(createHubNodesTable); err != nil {
+		return fmt.Errorf("failed to create hub_nodes table: %v", err)
+	}
+
+	if _, err := d.db.Exec(createRecordingsTable); err != nil {
+		return fmt.Errorf("failed to create recordings table: %v", err)
+	}
+
+	if _, err := d.db.Exec(createTimelapseJobsTable); err != nil {
+		return fmt.Errorf("failed to create timelapse_jobs table: %v", err)
+	}
+
+	// Таблица записей могла быть создана до появления блокировки
+	if err := d.addColumn("recordings", "locked", "BOOLEAN DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate recordings table: %v", err)
+	}
+
 	// Создаем пользователя по умолчанию если его нет
 	return d.createDefaultUser()
 }
@@ -233,6 +326,466 @@ func (d *Database) GetRecentAuthLogs(limit int) ([]AuthLog, error) {
 	return logs, nil
 }
 
+// GetUsers retrieves all users ordered by id
+func (d *Database) GetUsers() ([]User, error) {
+	rows, err := d.db.Query(`
+		SELECT id, username, email, is_active, created_at, updated_at
+		FROM users
+		ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %v", err)
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.Username, &user.Email,
+			&user.IsActive, &user.CreatedAt, &user.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// DeleteUser deletes a user by id
+func (d *Database) DeleteUser(id int) error {
+	_, err := d.db.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+
+	return nil
+}
+
+// notificationTargetColumns are columns scanned by scanNotificationTarget
+const notificationTargetColumns = `id, name, kind, enabled, url, address, username, password,
+	from_address, to_addresses, events, cooldown, quiet_start, quiet_end`
+
+// scanNotificationTarget scans a notification target row
+func scanNotificationTarget(row interface{ Scan(...any) error }) (notify.Target, error) {
+	var t notify.Target
+	var url, address, username, password, from, to, evs, quietStart, quietEnd sql.NullString
+
+	err := row.Scan(&t.ID, &t.Name, &t.Kind, &t.Enabled, &url, &address, &username, &password,
+		&from, &to, &evs, &t.Cooldown, &quietStart, &quietEnd)
+	if err != nil {
+		return t, err
+	}
+
+	t.URL, t.Address, t.Username, t.Password = url.String, address.String, username.String, password.String
+	t.From, t.To, t.QuietStart, t.QuietEnd = from.String, to.String, quietStart.String, quietEnd.String
+
+	// События хранятся через запятую
+	for _, e := range strings.Split(evs.String, ",") {
+		if e != "" {
+			t.Events = append(t.Events, events.Type(e))
+		}
+	}
+
+	return t, nil
+}
+
+// joinEvents returns comma separated event types
+func joinEvents(list []events.Type) string {
+	s := make([]string, len(list))
+	for i, e := range list {
+		s[i] = string(e)
+	}
+
+	return strings.Join(s, ",")
+}
+
+// GetNotificationTargets retrieves all notification targets
+func (d *Database) GetNotificationTargets() ([]notify.Target, error) {
+	rows, err := d.db.Query("SELECT " + notificationTargetColumns + " FROM notification_targets ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification targets: %v", err)
+	}
+	defer rows.Close()
+
+	targets := make([]notify.Target, 0)
+	for rows.Next() {
+		t, err := scanNotificationTarget(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification target: %v", err)
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, rows.Err()
+}
+
+// GetNotificationTarget retrieves a notification target by id
+func (d *Database) GetNotificationTarget(id int64) (*notify.Target, error) {
+	row := d.db.QueryRow("SELECT "+notificationTargetColumns+" FROM notification_targets WHERE id = ?", id)
+
+	t, err := scanNotificationTarget(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("notification target not found")
+		}
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	return &t, nil
+}
+
+// CreateNotificationTarget creates a new notification target and sets its id
+func (d *Database) CreateNotificationTarget(t *notify.Target) error {
+	res, err := d.db.Exec(`
+		INSERT INTO notification_targets (name, kind, enabled, url, address, username, password,
+			from_address, to_addresses, events, cooldown, quiet_start, quiet_end)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.Name, t.Kind, t.Enabled, t.URL, t.Address, t.Username, t.Password,
+		t.From, t.To, joinEvents(t.Events), t.Cooldown, t.QuietStart, t.QuietEnd)
+
+	if err != nil {
+		return fmt.Errorf("failed to create notification target: %v", err)
+	}
+
+	t.ID, err = res.LastInsertId()
+
+	return err
+}
+
+// UpdateNotificationTarget updates a notification target
+func (d *Database) UpdateNotificationTarget(t *notify.Target) error {
+	res, err := d.db.Exec(`
+		UPDATE notification_targets SET name = ?, kind = ?, enabled = ?, url = ?, address = ?,
+			username = ?, password = ?, from_address = ?, to_addresses = ?, events = ?, cooldown = ?,
+			quiet_start = ?, quiet_end = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		t.Name, t.Kind, t.Enabled, t.URL, t.Address, t.Username, t.Password,
+		t.From, t.To, joinEvents(t.Events), t.Cooldown, t.QuietStart, t.QuietEnd, t.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update notification target: %v", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("notification target not found")
+	}
+
+	return nil
+}
+
+// DeleteNotificationTarget deletes a notification target
+func (d *Database) DeleteNotificationTarget(id int64) error {
+	_, err := d.db.Exec("DELETE FROM notification_targets WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification target: %v", err)
+	}
+
+	return nil
+}
+
+// GetHubNodes retrieves all hub nodes
+func (d *Database) GetHubNodes() ([]hub.Node, error) {
+	rows, err := d.db.Query("SELECT id, name, url, username, password FROM hub_nodes ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hub nodes: %v", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]hub.Node, 0)
+	for rows.Next() {
+		var n hub.Node
+		var username, password sql.NullString
+
+		if err := rows.Scan(&n.ID, &n.Name, &n.URL, &username, &password); err != nil {
+			return nil, fmt.Errorf("failed to scan hub node: %v", err)
+		}
+
+		n.Username, n.Password = username.String, password.String
+		nodes = append(nodes, n)
+	}
+
+	return nodes, rows.Err()
+}
+
+// GetHubNode retrieves a hub node by id
+func (d *Database) GetHubNode(id int64) (*hub.Node, error) {
+	var n hub.Node
+	var username, password sql.NullString
+
+	err := d.db.QueryRow("SELECT id, name, url, username, password FROM hub_nodes WHERE id = ?", id).Scan(
+		&n.ID, &n.Name, &n.URL, &username, &password)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("hub node not found")
+		}
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	n.Username, n.Password = username.String, password.String
+
+	return &n, nil
+}
+
+// CreateHubNode creates a new hub node and sets its id
+func (d *Database) CreateHubNode(n *hub.Node) error {
+	res, err := d.db.Exec("INSERT INTO hub_nodes (name, url, username, password) VALUES (?, ?, ?, ?)",
+		n.Name, n.URL, n.Username, n.Password)
+	if err != nil {
+		return fmt.Errorf("failed to create hub node: %v", err)
+	}
+
+	n.ID, err = res.LastInsertId()
+
+	return err
+}
+
+// UpdateHubNode updates a hub node
+func (d *Database) UpdateHubNode(n *hub.Node) error {
+	res, err := d.db.Exec(`
+		UPDATE hub_nodes SET name = ?, url = ?, username = ?, password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		n.Name, n.URL, n.Username, n.Password, n.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update hub node: %v", err)
+	}
+
+	if c, _ := res.RowsAffected(); c == 0 {
+		return fmt.Errorf("hub node not found")
+	}
+
+	return nil
+}
+
+// DeleteHubNode deletes a hub node
+func (d *Database) DeleteHubNode(id int64) error {
+	_, err := d.db.Exec("DELETE FROM hub_nodes WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete hub node: %v", err)
+	}
+
+	return nil
+}
+
+// AddRecording adds a finished recording and sets its id
+func (d *Database) AddRecording(rec *record.Recording) error {
+	res, err := d.db.Exec(`
+		INSERT INTO recordings (kind, event, path, start_time, end_time, frames, size, thumbnail)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		rec.Kind, rec.Event, rec.Path, rec.Start.UTC(), rec.End.UTC(), rec.Frames, rec.Size, rec.Thumbnail)
+	if err != nil {
+		return fmt.Errorf("failed to add recording: %v", err)
+	}
+
+	rec.ID, err = res.LastInsertId()
+
+	return err
+}
+
+// Recordings retrieves all recordings without thumbnails ordered by start time
+func (d *Database) Recordings() ([]*record.Recording, error) {
+	return d.queryRecordings(`
+		SELECT id, kind, event, path, start_time, end_time, frames, size, locked
+		FROM recordings ORDER BY start_time, id`)
+}
+
+// queryRecordings retrieves recordings without thumbnails selected by query
+func (d *Database) queryRecordings(query string, args ...any) ([]*record.Recording, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recordings: %v", err)
+	}
+	defer rows.Close()
+
+	var recs []*record.Recording
+	for rows.Next() {
+		var rec record.Recording
+		var event sql.NullString
+
+		if err := rows.Scan(&rec.ID, &rec.Kind, &event, &rec.Path, &rec.Start, &rec.End, &rec.Frames, &rec.Size, &rec.Locked); err != nil {
+			return nil, fmt.Errorf("failed to scan recording: %v", err)
+		}
+
+		rec.Event = event.String
+		recs = append(recs, &rec)
+	}
+
+	return recs, rows.Err()
+}
+
+// RecordingsBetween retrieves recordings without thumbnails that overlap time range ordered by start time
+func (d *Database) RecordingsBetween(from, to time.Time) ([]*record.Recording, error) {
+	return d.queryRecordings(`
+		SELECT id, kind, event, path, start_time, end_time, frames, size, locked
+		FROM recordings WHERE start_time < ? AND end_time > ? ORDER BY start_time, id`, to.UTC(), from.UTC())
+}
+
+// GetRecording retrieves a recording by id
+func (d *Database) GetRecording(id int64) (*record.Recording, error) {
+	var rec record.Recording
+	var event sql.NullString
+
+	err := d.db.QueryRow(`
+		SELECT id, kind, event, path, start_time, end_time, frames, size, locked, thumbnail
+		FROM recordings WHERE id = ?`, id).Scan(
+		&rec.ID, &rec.Kind, &event, &rec.Path, &rec.Start, &rec.End, &rec.Frames, &rec.Size, &rec.Locked, &rec.Thumbnail)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("recording not found")
+		}
+		return nil, fmt.Errorf("failed to get recording: %v", err)
+	}
+
+	rec.Event = event.String
+
+	return &rec, nil
+}
+
+// DeleteRecording deletes a recording
+func (d *Database) DeleteRecording(id int64) error {
+	_, err := d.db.Exec("DELETE FROM recordings WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete recording: %v", err)
+	}
+
+	return nil
+}
+
+// SetRecordingLocked locks or unlocks a recording
+func (d *Database) SetRecordingLocked(id int64, locked bool) error {
+	res, err := d.db.Exec("UPDATE recordings SET locked = ? WHERE id = ?", locked, id)
+	if err != nil {
+		return fmt.Errorf("failed to update recording: %v", err)
+	}
+
+	if c, _ := res.RowsAffected(); c == 0 {
+		return fmt.Errorf("recording not found")
+	}
+
+	return nil
+}
+
+// timelapseJobColumns are columns scanned by scanTimelapseJob
+const timelapseJobColumns = "id, name, enabled, interval, window_start, window_end, retention"
+
+// scanTimelapseJob scans a timelapse job row
+func scanTimelapseJob(row interface{ Scan(...any) error }) (timelapse.Job, error) {
+	var j timelapse.Job
+	var windowStart, windowEnd sql.NullString
+
+	err := row.Scan(&j.ID, &j.Name, &j.Enabled, &j.Interval, &windowStart, &windowEnd, &j.Retention)
+	j.WindowStart, j.WindowEnd = windowStart.String, windowEnd.String
+
+	return j, err
+}
+
+// GetTimelapseJobs retrieves all timelapse jobs
+func (d *Database) GetTimelapseJobs() ([]timelapse.Job, error) {
+	rows, err := d.db.Query("SELECT " + timelapseJobColumns + " FROM timelapse_jobs ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query timelapse jobs: %v", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]timelapse.Job, 0)
+	for rows.Next() {
+		j, err := scanTimelapseJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan timelapse job: %v", err)
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// GetTimelapseJob retrieves a timelapse job by id
+func (d *Database) GetTimelapseJob(id int64) (*timelapse.Job, error) {
+	row := d.db.QueryRow("SELECT "+timelapseJobColumns+" FROM timelapse_jobs WHERE id = ?", id)
+
+	j, err := scanTimelapseJob(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("timelapse job not found")
+		}
+		return nil, fmt.Errorf("database error: %v", err)
+	}
+
+	return &j, nil
+}
+
+// CreateTimelapseJob creates a new timelapse job and sets its id
+func (d *Database) CreateTimelapseJob(j *timelapse.Job) error {
+	res, err := d.db.Exec(`
+		INSERT INTO timelapse_jobs (name, enabled, interval, window_start, window_end, retention)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		j.Name, j.Enabled, j.Interval, j.WindowStart, j.WindowEnd, j.Retention)
+
+	if err != nil {
+		return fmt.Errorf("failed to create timelapse job: %v", err)
+	}
+
+	j.ID, err = res.LastInsertId()
+
+	return err
+}
+
+// UpdateTimelapseJob updates a timelapse job
+func (d *Database) UpdateTimelapseJob(j *timelapse.Job) error {
+	res, err := d.db.Exec(`
+		UPDATE timelapse_jobs SET name = ?, enabled = ?, interval = ?, window_start = ?, window_end = ?,
+			retention = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		j.Name, j.Enabled, j.Interval, j.WindowStart, j.WindowEnd, j.Retention, j.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update timelapse job: %v", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("timelapse job not found")
+	}
+
+	return nil
+}
+
+// DeleteTimelapseJob deletes a timelapse job
+func (d *Database) DeleteTimelapseJob(id int64) error {
+	_, err := d.db.Exec("DELETE FROM timelapse_jobs WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete timelapse job: %v", err)
+	}
+
+	return nil
+}
+
+// addColumn adds column to table if it does not exist
+func (d *Database) addColumn(table, column, definition string) error {
+	rows, err := d.db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+
+		if name == column {
+			return nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+
+	return err
+}
+
 // Global database instance
 var globalDB *Database
 
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/metrics"
+)
+
+const (
+	eventsHeartbeat  = 15 * time.Second
+	eventsBufferSize = 64
+)
+
+// Events handler streams events as Server-Sent Events.
+type Events struct {
+	bus *events.Bus
+}
+
+// NewEvents returns new Events handler.
+func NewEvents(bus *events.Bus) *Events {
+	return &Events{bus}
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (e *Events) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	types := make(map[events.Type]bool)
+	if v := r.URL.Query().Get("types"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			types[events.Type(strings.TrimSpace(t))] = true
+		}
+	}
+
+	match := func(ev events.Event) bool {
+		return len(types) == 0 || types[ev.Type]
+	}
+
+	ch, cancel := e.bus.Subscribe(eventsBufferSize)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	// Status is sent first, so clients do not have to wait for the next event to know current state.
+	status, _ := json.Marshal(e.bus.Status())
+	_, err := fmt.Fprintf(w, "retry: 3000\nevent: status\ndata: %s\n\n", status)
+	if err != nil {
+		return
+	}
+
+	// Reconnecting clients get events they missed.
+	if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		for _, ev := range e.bus.Since(id) {
+			if match(ev) && writeEvent(w, ev) != nil {
+				return
+			}
+		}
+	}
+
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			if !match(ev) {
+				continue
+			}
+
+			err = writeEvent(w, ev)
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, ev events.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data)
+
+	return err
+}
+
+// trackViewer publishes viewer connected event and returns function that publishes disconnected event.
+func trackViewer(r *http.Request, stream, handler string) func() {
+	disconnect := events.Connect(map[string]any{"stream": stream, "handler": handler, "ip": GetClientIP(r)})
+	metrics.Viewers.With(handler).Inc()
+
+	return func() {
+		metrics.Viewers.With(handler).Dec()
+		disconnect()
+	}
+}
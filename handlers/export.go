@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/storage"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+const (
+	exportMaxDuration = 24 * time.Hour
+	exportMaxFPS      = 10
+	exportMaxWidth    = 1920
+	exportMaxFrames   = 3000
+
+	// exportMaxPixels bounds memory of quantized frames, e.g. 3000 frames of 320x240 or 115 frames of 1920x1080.
+	exportMaxPixels = 320 * 240 * exportMaxFrames
+)
+
+// FrameHistory provides recently captured frames.
+type FrameHistory interface {
+	// History returns frames captured after since, oldest first.
+	History(since time.Time) []*stream.Frame
+
+	// HistoryLength returns how far back history goes.
+	HistoryLength() time.Duration
+}
+
+// FrameStore provides stored frames for periods longer than history.
+type FrameStore interface {
+	// Frames calls fn with stored frames in range, sampled at most at fps, oldest first, error of fn stops reading.
+	Frames(from, to time.Time, fps float64, fn func(f *stream.Frame) error) error
+}
+
+// errExportTooLarge is returned when frames of export would take more than exportMaxPixels.
+var errExportTooLarge = errors.New("export: frames are too large")
+
+// Export handler.
+type Export struct {
+	history FrameHistory
+	store   FrameStore
+	gallery storage.Storage
+}
+
+// NewExport returns new Export handler, store can be nil, saved exports are stored under gallery/ key prefix.
+func NewExport(history FrameHistory, store FrameStore, gallery storage.Storage) *Export {
+	return &Export{history, store, gallery}
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (e *Export) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" && r.Method != "POST" {
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	q := r.URL.Query()
+
+	duration, err := queryDuration(q.Get("duration"), 30*time.Second)
+	if err != nil || duration <= 0 || duration > exportMaxDuration {
+		http.Error(w, "400 Bad Request: invalid duration", http.StatusBadRequest)
+
+		return
+	}
+
+	fps, err := queryFloat(q.Get("fps"), 2)
+	if err != nil || fps <= 0 || fps > exportMaxFPS {
+		http.Error(w, "400 Bad Request: invalid fps", http.StatusBadRequest)
+
+		return
+	}
+
+	width, err := queryInt(q.Get("width"), 320)
+	if err != nil || width <= 0 || width > exportMaxWidth {
+		http.Error(w, "400 Bad Request: invalid width", http.StatusBadRequest)
+
+		return
+	}
+
+	if duration.Seconds()*fps > exportMaxFrames {
+		http.Error(w, fmt.Sprintf("400 Bad Request: more than %d frames", exportMaxFrames), http.StatusBadRequest)
+
+		return
+	}
+
+	// Кадры за длинный период читаются из записей дольше WriteTimeout сервера
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	to := time.Now()
+	from := to.Add(-duration)
+
+	g := image.NewGIF(width, fps)
+
+	// Кадры добавляются по одному, из записей они читаются и сразу сжимаются в палитру, поэтому
+	// размер проверяется по первому кадру и наибольшему числу кадров до их чтения
+	limit := int(math.Ceil(duration.Seconds() * fps))
+
+	add := func(f *stream.Frame) error {
+		img, err := f.Image()
+		if err != nil {
+			log.Printf("export: decode: %v", err)
+			return nil
+		}
+
+		if g.Len() == 0 {
+			fw, fh := img.Bounds().Dx(), img.Bounds().Dy()
+			if width < fw {
+				fw, fh = width, max(fh*width/fw, 1)
+			}
+
+			if limit*fw*fh > exportMaxPixels {
+				return errExportTooLarge
+			}
+		}
+
+		g.AddAt(img, f.Time)
+
+		return nil
+	}
+
+	switch {
+	case duration <= e.history.HistoryLength():
+		frames := sample(e.history.History(from), fps)
+		limit = len(frames)
+
+		for _, f := range frames {
+			if err = add(f); err != nil {
+				break
+			}
+		}
+	case e.store != nil:
+		err = e.store.Frames(from, to, fps, add)
+	default:
+		msg := fmt.Sprintf("400 Bad Request: duration exceeds history of %s", e.history.HistoryLength())
+		http.Error(w, msg, http.StatusBadRequest)
+
+		return
+	}
+
+	if errors.Is(err, errExportTooLarge) {
+		msg := fmt.Sprintf("400 Bad Request: %d frames of width %d are too large, lower width, fps or duration", limit, width)
+		http.Error(w, msg, http.StatusBadRequest)
+
+		return
+	} else if err != nil {
+		log.Printf("export: frames: %v", err)
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	if g.Len() == 0 {
+		http.Error(w, "404 Not Found: no frames", http.StatusNotFound)
+
+		return
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = g.Encode(buf)
+	if err != nil {
+		log.Printf("export: encode: %v", err)
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	name := fmt.Sprintf("cam2ip-%s.gif", to.Format("20060102-150405"))
+
+	if q.Get("save") != "" {
+		// Экспорты, сохраненные в одну секунду, не перезаписывают друг друга
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			log.Printf("export: save: %v", err)
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+
+			return
+		}
+
+		name = fmt.Sprintf("cam2ip-%s-%s.gif", to.Format("20060102-150405"), hex.EncodeToString(suffix))
+
+		err = e.gallery.Put(r.Context(), "gallery/"+name, bytes.NewReader(buf.Bytes()), "image/gif")
+		if err != nil {
+			log.Printf("export: save: %v", err)
+			http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"name":   name,
+			"url":    "/gallery/" + name,
+			"frames": g.Len(),
+		})
+
+		return
+	}
+
+	disposition := "inline"
+	if q.Get("download") != "" {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=%q", disposition, name))
+
+	_, _ = w.Write(buf.Bytes())
+}
+
+// sample drops frames so that they are at least 1/fps apart.
+func sample(frames []*stream.Frame, fps float64) []*stream.Frame {
+	interval := time.Duration(float64(time.Second) / fps)
+
+	ret := make([]*stream.Frame, 0, len(frames))
+
+	var last time.Time
+	for _, f := range frames {
+		if !last.IsZero() && f.Time.Sub(last) < interval*9/10 {
+			continue
+		}
+
+		last = f.Time
+		ret = append(ret, f)
+	}
+
+	return ret
+}
+
+func queryDuration(v string, def time.Duration) (time.Duration, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	return time.ParseDuration(v)
+}
+
+func queryFloat(v string, def float64) (float64, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.ParseFloat(v, 64)
+}
+
+func queryInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(v)
+}
@@ -11,8 +11,8 @@ type HTML struct {
 	Template []byte
 }
 
-// NewHTML returns new HTML handler.
-func NewHTML(width, height float64, noWebGL bool) *HTML {
+// NewHTML returns new HTML handler, mobile clients use substream when it is enabled.
+func NewHTML(width, height float64, noWebGL, substream bool) *HTML {
 	h := &HTML{}
 
 	tpl := htmlWebGL
@@ -21,6 +21,7 @@ func NewHTML(width, height float64, noWebGL bool) *HTML {
 	}
 	tpl = strings.Replace(tpl, "{WIDTH}", fmt.Sprintf("%.0f", width), -1)
 	tpl = strings.Replace(tpl, "{HEIGHT}", fmt.Sprintf("%.0f", height), -1)
+	tpl = strings.Replace(tpl, "{SUBSTREAM}", fmt.Sprintf("%t", substream), -1)
 
 	h.Template = []byte(tpl)
 	return h
@@ -45,26 +46,44 @@ var html = `<html>
         <meta charset="utf-8"/>
         <title>cam2ip</title>
         <script>
+		var stream = new URLSearchParams(window.location.search).get("stream") ||
+			({SUBSTREAM} && /Mobi|Android/i.test(navigator.userAgent) ? "sub" : "main");
+
 		if (location.protocol === 'https:') {
-  			ws = new WebSocket("wss://" + window.location.host + "/socket");
+  			ws = new WebSocket("wss://" + window.location.host + "/socket?stream=" + stream);
 		} else {
-  			ws = new WebSocket("ws://" + window.location.host + "/socket");
+  			ws = new WebSocket("ws://" + window.location.host + "/socket?stream=" + stream);
 		}
+        ws.binaryType = "arraybuffer";
         var image = new Image();
 
         ws.onopen = function() {
-            var context = document.getElementById("canvas").getContext("2d", {alpha: false});
+            var canvas = document.getElementById("canvas");
+            var context = canvas.getContext("2d", {alpha: false});
             image.onload = function() {
-                context.drawImage(image, 0, 0);
+                context.drawImage(image, 0, 0, canvas.width, canvas.height);
+                URL.revokeObjectURL(image.src);
             }
         }
 
         ws.onmessage = function(e) {
-            image.setAttribute("src", "data:image/jpeg;base64," + e.data);
+            if (typeof e.data === "string") {
+                var s = JSON.parse(e.data);
+                if (s.type === "stats") {
+                    document.getElementById("stats").textContent = "quality " + s.quality + " | " + s.width + "x" + s.height +
+                        " | " + s.bitrate + " kbit/s | " + s.latency.toFixed(1) + " ms";
+                }
+                return;
+            }
+            var size = new DataView(e.data).getUint16(2);
+            var url = URL.createObjectURL(new Blob([new Uint8Array(e.data, size)], {type: "image/jpeg"}));
+            image.onerror = function() { URL.revokeObjectURL(url); };
+            image.setAttribute("src", url);
         }
         </script>
     </head>
     <body style="background-color: #000000">
+        <div id="stats" style="position:fixed; top:4px; left:4px; color:#ffffff; font:12px monospace; opacity:0.7"></div>
         <table style="width:100%; height:100%">
             <tr style="height:100%">
                 <td style="height:100%; text-align:center">
@@ -82,11 +101,15 @@ var htmlWebGL = `<html>
         <script>
 		var texture, vloc, tloc, vertexBuff, textureBuff;
 
+		var stream = new URLSearchParams(window.location.search).get("stream") ||
+			({SUBSTREAM} && /Mobi|Android/i.test(navigator.userAgent) ? "sub" : "main");
+
 		if (location.protocol === 'https:') {
-  			ws = new WebSocket("wss://" + window.location.host + "/socket");
+  			ws = new WebSocket("wss://" + window.location.host + "/socket?stream=" + stream);
 		} else {
-  			ws = new WebSocket("ws://" + window.location.host + "/socket");
+  			ws = new WebSocket("ws://" + window.location.host + "/socket?stream=" + stream);
 		}
+		ws.binaryType = "arraybuffer";
 		var image = new Image();
 
 		ws.onopen = function() {
@@ -158,15 +181,28 @@ var htmlWebGL = `<html>
 				gl.vertexAttribPointer(tloc, 2, gl.FLOAT, false, 0, 0);
 
 				gl.drawArrays(gl.TRIANGLE_FAN, 0, 4);
+				URL.revokeObjectURL(image.src);
 			}
 		}
 
 		ws.onmessage = function(e) {
-			image.setAttribute("src", "data:image/jpeg;base64," + e.data);
+			if (typeof e.data === "string") {
+				var s = JSON.parse(e.data);
+				if (s.type === "stats") {
+					document.getElementById("stats").textContent = "quality " + s.quality + " | " + s.width + "x" + s.height +
+						" | " + s.bitrate + " kbit/s | " + s.latency.toFixed(1) + " ms";
+				}
+				return;
+			}
+			var size = new DataView(e.data).getUint16(2);
+			var url = URL.createObjectURL(new Blob([new Uint8Array(e.data, size)], {type: "image/jpeg"}));
+			image.onerror = function() { URL.revokeObjectURL(url); };
+			image.setAttribute("src", url);
 		}
         </script>
     </head>
     <body style="background-color: #000000">
+        <div id="stats" style="position:fixed; top:4px; left:4px; color:#ffffff; font:12px monospace; opacity:0.7"></div>
         <table style="width:100%; height:100%">
             <tr style="height:100%">
                 <td style="height:100%; text-align:center">
@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gen2brain/cam2ip/hub"
+)
+
+// HubDashboard handler shows nodes of hub in a grid and manages them.
+type HubDashboard struct {
+	db  *Database
+	hub *hub.Hub
+	mux *http.ServeMux
+}
+
+// NewHubDashboard returns new HubDashboard handler.
+func NewHubDashboard(db *Database, h *hub.Hub) *HubDashboard {
+	d := &HubDashboard{db: db, hub: h, mux: http.NewServeMux()}
+
+	d.mux.HandleFunc("GET /dashboard", d.page)
+	d.mux.HandleFunc("GET /hub/nodes", d.list)
+	d.mux.HandleFunc("POST /hub/nodes", d.create)
+	d.mux.HandleFunc("PUT /hub/nodes/{id}", d.update)
+	d.mux.HandleFunc("DELETE /hub/nodes/{id}", d.delete)
+
+	return d
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (d *HubDashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+func (d *HubDashboard) page(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(hubHTML))
+}
+
+func (d *HubDashboard) list(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, d.hub.States())
+}
+
+func (d *HubDashboard) create(w http.ResponseWriter, r *http.Request) {
+	var n hub.Node
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if err := n.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := d.db.CreateHubNode(&n); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	d.hub.Reload()
+
+	n.Password = ""
+	writeJSONResponse(w, http.StatusCreated, n)
+}
+
+func (d *HubDashboard) update(w http.ResponseWriter, r *http.Request) {
+	old, ok := d.node(w, r)
+	if !ok {
+		return
+	}
+
+	var n hub.Node
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Сохраненный пароль узла не отправляется на другой адрес, при смене адреса или имени нужен новый пароль
+	n.ID = old.ID
+	if n.Password == "" && old.Password != "" {
+		if !sameOrigin(n.URL, old.URL) || n.Username != old.Username {
+			http.Error(w, "password is required when url or username changes", http.StatusBadRequest)
+			return
+		}
+
+		n.Password = old.Password
+	}
+
+	if err := n.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := d.db.UpdateHubNode(&n); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	d.hub.Reload()
+
+	n.Password = ""
+	writeJSONResponse(w, http.StatusOK, n)
+}
+
+func (d *HubDashboard) delete(w http.ResponseWriter, r *http.Request) {
+	n, ok := d.node(w, r)
+	if !ok {
+		return
+	}
+
+	if err := d.db.DeleteHubNode(n.ID); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	d.hub.Reload()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// node returns node from request path, error response is written when there is none.
+func (d *HubDashboard) node(w http.ResponseWriter, r *http.Request) (*hub.Node, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+
+	n, err := d.db.GetHubNode(id)
+	if err != nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return n, true
+}
+
+var hubHTML = `<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Hub - cam2ip</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            background-color: #f4f4f4;
+            margin: 0;
+            padding: 0;
+        }
+        .header {
+            background-color: #007bff;
+            color: white;
+            padding: 1rem 2rem;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        .header h1 {
+            margin: 0;
+            display: inline-block;
+        }
+        .logout-btn {
+            float: right;
+            background-color: #dc3545;
+            color: white;
+            padding: 0.5rem 1rem;
+            border-radius: 4px;
+            text-decoration: none;
+            margin-top: 0.5rem;
+        }
+        .container {
+            max-width: 1400px;
+            margin: 2rem auto;
+            padding: 0 2rem;
+        }
+        .camera-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fill, minmax(320px, 1fr));
+            gap: 1rem;
+            margin-bottom: 2rem;
+        }
+        .camera-tile {
+            background: white;
+            border-radius: 8px;
+            overflow: hidden;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+        }
+        .camera-tile img {
+            display: block;
+            width: 100%;
+            min-height: 180px;
+            background: #000;
+        }
+        .camera-tile p {
+            margin: 0.5rem 1rem;
+            color: #555;
+            font-size: 0.875rem;
+        }
+        .status {
+            display: inline-block;
+            padding: 0.1rem 0.6rem;
+            border-radius: 12px;
+            font-size: 0.75rem;
+            font-weight: bold;
+            background-color: #d4edda;
+            color: #155724;
+        }
+        .status.offline {
+            background-color: #f8d7da;
+            color: #721c24;
+        }
+        .card {
+            background: white;
+            padding: 1rem 2rem;
+            border-radius: 8px;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+            margin-bottom: 2rem;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+        }
+        th, td {
+            text-align: left;
+            padding: 0.5rem;
+            border-bottom: 1px solid #eee;
+            font-size: 0.875rem;
+        }
+        label {
+            display: block;
+            margin: 0.5rem 0;
+        }
+        input[type=text], input[type=password] {
+            width: 100%;
+            padding: 0.4rem;
+            box-sizing: border-box;
+        }
+        button {
+            background-color: #007bff;
+            color: white;
+            border: none;
+            padding: 0.4rem 0.8rem;
+            border-radius: 4px;
+            cursor: pointer;
+        }
+        button.danger {
+            background-color: #dc3545;
+        }
+        #message {
+            font-family: monospace;
+        }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>cam2ip - Hub</h1>
+        <a href="/logout" class="logout-btn">Выйти</a>
+    </div>
+
+    <div class="container">
+        <div class="camera-grid" id="grid"></div>
+
+        <div class="card">
+            <h3>Узлы</h3>
+            <table>
+                <thead><tr><th>Имя</th><th>URL</th><th>Состояние</th><th>Камера</th><th>FPS</th><th>Зрители</th><th>Задержка, мс</th><th></th></tr></thead>
+                <tbody id="nodes"></tbody>
+            </table>
+            <p id="message"></p>
+        </div>
+
+        <div class="card">
+            <h3 id="form-title">Новый узел</h3>
+            <form id="form">
+                <input type="hidden" name="id">
+                <label>Имя <input type="text" name="name" required></label>
+                <label>URL <input type="text" name="url" placeholder="http://pi1.lan:56000" required></label>
+                <label>Пользователь <input type="text" name="username" placeholder="admin"></label>
+                <label>Пароль <input type="password" name="password" placeholder="не меняется, если пусто и адрес тот же"></label>
+                <button type="submit">Сохранить</button>
+                <button type="button" id="reset">Очистить</button>
+            </form>
+        </div>
+    </div>
+
+    <script>
+        var form = document.getElementById("form");
+        var message = document.getElementById("message");
+        var grid = document.getElementById("grid");
+        var tiles = {};
+
+        function show(text) {
+            message.textContent = text;
+        }
+
+        function request(method, url, body) {
+            return fetch(url, {
+                method: method,
+                headers: {"Content-Type": "application/json"},
+                body: body ? JSON.stringify(body) : undefined
+            }).then(function(res) {
+                if (!res.ok) {
+                    return res.text().then(function(text) { throw new Error(text); });
+                }
+                return res.status === 204 ? null : res.json();
+            });
+        }
+
+        function tile(n) {
+            var t = tiles[n.id];
+            if (!t) {
+                t = {el: document.createElement("div"), img: document.createElement("img"), info: document.createElement("p"), online: false};
+                t.el.className = "camera-tile";
+                t.img.alt = n.name;
+                t.img.onclick = function() { window.open("/node/" + n.id + "/mjpeg"); };
+                t.el.appendChild(t.img);
+                t.el.appendChild(t.info);
+                tiles[n.id] = t;
+            }
+
+            // Поток переподключается, когда узел снова доступен
+            if (n.online && !t.online) {
+                t.img.src = "/node/" + n.id + "/mjpeg?stream=sub&t=" + Date.now();
+            } else if (!n.online) {
+                t.img.removeAttribute("src");
+            }
+            t.online = n.online;
+
+            var s = n.status;
+            t.info.innerHTML = "";
+            var b = document.createElement("b");
+            b.textContent = n.name + " ";
+            var badge = document.createElement("span");
+            badge.className = "status" + (n.online && s.camera === "online" ? "" : " offline");
+            badge.textContent = n.online ? s.camera : "offline";
+            t.info.appendChild(b);
+            t.info.appendChild(badge);
+            t.info.appendChild(document.createTextNode(n.online ? " " + s.fps + " fps, зрителей: " + s.viewers : " " + (n.error || "")));
+
+            return t.el;
+        }
+
+        function row(n) {
+            var tr = document.createElement("tr");
+            var s = n.status || {};
+            [n.name, n.url, n.online ? "online" : "offline: " + (n.error || "нет данных"), s.camera || "", n.online ? s.fps : "",
+             n.online ? s.viewers : "", n.online ? n.latency : ""].forEach(function(v) {
+                var td = document.createElement("td");
+                td.textContent = v;
+                tr.appendChild(td);
+            });
+
+            var td = document.createElement("td");
+            [["Изменить", "", function() { edit(n); }], ["Удалить", "danger", function() { remove(n); }]].forEach(function(b) {
+                var btn = document.createElement("button");
+                btn.textContent = b[0];
+                btn.className = b[1];
+                btn.onclick = b[2];
+                td.appendChild(btn);
+                td.appendChild(document.createTextNode(" "));
+            });
+            tr.appendChild(td);
+
+            return tr;
+        }
+
+        function load() {
+            request("GET", "/hub/nodes").then(function(list) {
+                var tbody = document.getElementById("nodes");
+                tbody.innerHTML = "";
+                var ids = {};
+                list.forEach(function(n) {
+                    ids[n.id] = true;
+                    grid.appendChild(tile(n));
+                    tbody.appendChild(row(n));
+                });
+                Object.keys(tiles).forEach(function(id) {
+                    if (!ids[id]) {
+                        grid.removeChild(tiles[id].el);
+                        delete tiles[id];
+                    }
+                });
+            }).catch(function(e) { show(e.message); });
+        }
+
+        function remove(n) {
+            if (!confirm("Удалить " + n.name + "?")) {
+                return;
+            }
+            request("DELETE", "/hub/nodes/" + n.id).then(load).catch(function(e) { show(e.message); });
+        }
+
+        function edit(n) {
+            document.getElementById("form-title").textContent = "Изменить " + n.name;
+            ["id", "name", "url", "username"].forEach(function(k) {
+                form[k].value = n[k] === undefined ? "" : n[k];
+            });
+            form.password.value = "";
+        }
+
+        document.getElementById("reset").onclick = function() {
+            form.reset();
+            form.id.value = "";
+            document.getElementById("form-title").textContent = "Новый узел";
+        };
+
+        form.onsubmit = function(e) {
+            e.preventDefault();
+            var n = {name: form.name.value, url: form.url.value, username: form.username.value, password: form.password.value};
+            var id = form.id.value;
+            request(id ? "PUT" : "POST", "/hub/nodes" + (id ? "/" + id : ""), n).then(function() {
+                document.getElementById("reset").onclick();
+                show("Сохранено");
+                setTimeout(load, 1000);
+            }).catch(function(e) { show("Ошибка: " + e.message); });
+        };
+
+        load();
+        setInterval(load, 5000);
+    </script>
+</body>
+</html>`
+
+// sameOrigin reports whether URLs have the same scheme, host and port.
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
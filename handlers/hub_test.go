@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/cam2ip/hub"
+)
+
+func TestHubDashboardUpdate(t *testing.T) {
+	// Database is created in data directory of working directory
+	t.Chdir(t.TempDir())
+
+	db, err := NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	node := &hub.Node{Name: "pi1", URL: "http://pi1.lan:56000", Username: "admin", Password: "secret"}
+	if err := db.CreateHubNode(node); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewHubDashboard(db, hub.New(db, time.Minute)))
+	defer srv.Close()
+
+	tests := []struct {
+		body     string
+		code     int
+		password string
+	}{
+		{`{"name":"garden","url":"http://pi1.lan:56000/","username":"admin"}`, http.StatusOK, "secret"},
+		{`{"name":"pi1","url":"http://evil.example.com:56000","username":"admin"}`, http.StatusBadRequest, "secret"},
+		{`{"name":"pi1","url":"https://pi1.lan:56000","username":"admin"}`, http.StatusBadRequest, "secret"},
+		{`{"name":"pi1","url":"http://pi1.lan:56000","username":"root"}`, http.StatusBadRequest, "secret"},
+		{`{"name":"pi1","url":"http://pi2.lan:56000","username":"admin","password":"new"}`, http.StatusOK, "new"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("PUT", srv.URL+"/hub/nodes/1", strings.NewReader(tt.body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		got, err := db.GetHubNode(node.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != tt.code || got.Password != tt.password {
+			t.Errorf("%s: expected %d with password %q, got %d with %q", tt.body, tt.code, tt.password, resp.StatusCode, got.Password)
+		}
+	}
+}
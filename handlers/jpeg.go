@@ -1,21 +1,24 @@
 package handlers
 
 import (
+	"bytes"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/metrics"
 )
 
 // JPEG handler.
 type JPEG struct {
-	reader  ImageReader
-	quality int
+	reader ImageReader
+	opts   image.EncoderOptions
 }
 
 // NewJPEG returns new JPEG handler.
-func NewJPEG(reader ImageReader, quality int) *JPEG {
-	return &JPEG{reader, quality}
+func NewJPEG(reader ImageReader, opts image.EncoderOptions) *JPEG {
+	return &JPEG{reader, opts}
 }
 
 // ServeHTTP handles requests on incoming connections.
@@ -38,11 +41,18 @@ func (j *JPEG) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = image.NewEncoder(w, j.quality).Encode(img)
+	buf := new(bytes.Buffer)
+
+	err = image.NewEncoder(buf, j.opts).Encode(img)
 	if err != nil {
 		log.Printf("jpeg: encode: %v", err)
 		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
 
 		return
 	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+
+	n, _ := w.Write(buf.Bytes())
+	metrics.BytesSent.With("jpeg").Add(float64(n))
 }
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gen2brain/cam2ip/metrics"
+)
+
+// Metrics handler serves metrics in Prometheus text format.
+type Metrics struct {
+	registry *metrics.Registry
+	token    string
+	allow    []*net.IPNet
+}
+
+// NewMetrics returns new Metrics handler, access is allowed with bearer token or from allowed networks,
+// e.g. "127.0.0.1/8,::1,10.0.0.5".
+func NewMetrics(registry *metrics.Registry, token, allow string) (*Metrics, error) {
+	m := &Metrics{registry: registry, token: token}
+
+	for _, s := range strings.Split(allow, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		// Одиночный адрес считаем сетью из одного адреса
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", s)
+		}
+
+		m.allow = append(m.allow, n)
+	}
+
+	return m, nil
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !m.allowed(r) {
+		http.Error(w, "403 Forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+
+	_, _ = m.registry.WriteTo(w)
+}
+
+// allowed checks bearer token and remote address, proxy headers are not trusted here.
+func (m *Metrics) allowed(r *http.Request) bool {
+	if m.token != "" {
+		auth := r.Header.Get("Authorization")
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok && subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) == 1 {
+			return true
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range m.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sentCounter counts bytes written to viewers.
+type sentCounter struct {
+	w io.Writer
+	c *metrics.Counter
+}
+
+func (s sentCounter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.c.Add(float64(n))
+
+	return n, err
+}
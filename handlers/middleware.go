@@ -2,18 +2,55 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"strings"
 )
 
+// trustedKey is context key of requests authenticated before they reached the server.
+type trustedKey struct{}
+
+// Trusted marks requests as authenticated, it is used for requests that come through relay tunnel,
+// relay authenticates users itself.
+func Trusted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), trustedKey{}, true)))
+	})
+}
+
+// trusted reports whether request was marked by Trusted.
+func trusted(r *http.Request) bool {
+	return r.Context().Value(trustedKey{}) != nil
+}
+
 // AuthMiddleware проверяет авторизацию пользователя
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Запросы через relay уже авторизованы на relay
+		if trusted(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Получаем session ID из cookie
 		sessionID := sessionManager.GetSessionFromRequest(r)
 
 		// Проверяем валидность сессии
 		if !sessionManager.IsValidSession(sessionID) {
-			// Если сессия недействительна, переадресовываем на страницу авторизации
+			// Клиенты без cookie (NVR, ONVIF, плееры) используют Basic авторизацию
+			username, password, ok := r.BasicAuth()
+			if ok && CheckCredentials(username, password, GetClientIP(r), r.UserAgent()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Браузер переадресовываем на страницу авторизации, остальным клиентам отвечаем 401
+			if ok || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+				w.Header().Set("WWW-Authenticate", `Basic realm="cam2ip"`)
+				http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
@@ -29,3 +66,14 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authorized reports whether request has a valid session or Basic credentials.
+func authorized(r *http.Request) bool {
+	if trusted(r) || sessionManager.IsValidSession(sessionManager.GetSessionFromRequest(r)) {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+
+	return ok && CheckCredentials(username, password, GetClientIP(r), r.UserAgent())
+}
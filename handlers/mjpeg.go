@@ -1,26 +1,28 @@
 package handlers
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+	"strconv"
 	"time"
 
-	"github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/metrics"
 )
 
 // MJPEG handler.
 type MJPEG struct {
-	reader  ImageReader
+	streams StreamSource
 	delay   int
-	quality int
+	abr     ABROptions
 }
 
 // NewMJPEG returns new MJPEG handler.
-func NewMJPEG(reader ImageReader, delay, quality int) *MJPEG {
-	return &MJPEG{reader, delay, quality}
+func NewMJPEG(streams StreamSource, delay int, abr ABROptions) *MJPEG {
+	return &MJPEG{streams, delay, abr}
 }
 
 // ServeHTTP handles requests on incoming connections.
@@ -31,15 +33,33 @@ func (m *MJPEG) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mimeWriter := multipart.NewWriter(w)
+	st := m.streams.Stream(r.URL.Query().Get("stream"))
+	if st == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+
+		return
+	}
+
+	mimeWriter := multipart.NewWriter(sentCounter{w, metrics.BytesSent.With("mjpeg")})
 	_ = mimeWriter.SetBoundary("--boundary")
 
 	w.Header().Add("Connection", "close")
 	w.Header().Add("Cache-Control", "no-store, no-cache")
 	w.Header().Add("Content-Type", fmt.Sprintf("multipart/x-mixed-replace;boundary=%s", mimeWriter.Boundary()))
 
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	defer trackViewer(r, st.Name(), "mjpeg")()
+
+	opts := st.EncoderOptions()
+	abr := NewABR(m.abr, opts.Quality)
+	buf := new(bytes.Buffer)
+
 	done := r.Context().Done()
 
+	var lastSeq uint64
+
 loop:
 	for {
 		select {
@@ -47,27 +67,45 @@ loop:
 			break loop
 
 		default:
+			f, err := st.Next()
+			if err != nil {
+				log.Printf("mjpeg: read: %v", err)
+				break loop
+			}
+
+			metrics.Dropped("mjpeg", &lastSeq, f.Seq)
+
+			data, width, height, quality, err := encodeFrame(f, opts, abr, buf)
+			if err != nil {
+				log.Printf("mjpeg: encode: %v", err)
+				continue
+			}
+
 			partHeader := make(textproto.MIMEHeader)
 			partHeader.Add("Content-Type", "image/jpeg")
+			partHeader.Add("Content-Length", strconv.Itoa(len(data)))
+			partHeader.Add("X-Quality", strconv.Itoa(quality))
+			partHeader.Add("X-Resolution", fmt.Sprintf("%dx%d", width, height))
+
+			start := time.Now()
 
 			partWriter, err := mimeWriter.CreatePart(partHeader)
 			if err != nil {
 				log.Printf("mjpeg: createPart: %v", err)
-				continue
+				break loop
 			}
 
-			img, err := m.reader.Read()
-			if err != nil {
-				log.Printf("mjpeg: read: %v", err)
-				continue
+			_, err = partWriter.Write(data)
+			if err == nil {
+				err = rc.Flush()
 			}
 
-			err = image.NewEncoder(partWriter, m.quality).Encode(img)
 			if err != nil {
-				log.Printf("mjpeg: encode: %v", err)
-				continue
+				break loop
 			}
 
+			abr.Update(len(data), width, height, time.Since(start))
+
 			if m.delay > 0 {
 				time.Sleep(time.Duration(m.delay) * time.Millisecond)
 			}
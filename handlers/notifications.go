@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gen2brain/cam2ip/notify"
+)
+
+// Notifications handler manages notification targets.
+type Notifications struct {
+	db       *Database
+	notifier *notify.Notifier
+	mux      *http.ServeMux
+}
+
+// NewNotifications returns new Notifications handler.
+func NewNotifications(db *Database, notifier *notify.Notifier) *Notifications {
+	n := &Notifications{db: db, notifier: notifier, mux: http.NewServeMux()}
+
+	n.mux.HandleFunc("GET /notifications", n.page)
+	n.mux.HandleFunc("GET /notifications/targets", n.list)
+	n.mux.HandleFunc("POST /notifications/targets", n.create)
+	n.mux.HandleFunc("PUT /notifications/targets/{id}", n.update)
+	n.mux.HandleFunc("DELETE /notifications/targets/{id}", n.delete)
+	n.mux.HandleFunc("POST /notifications/targets/{id}/test", n.test)
+
+	return n
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (n *Notifications) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mux.ServeHTTP(w, r)
+}
+
+func (n *Notifications) page(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(notificationsHTML))
+}
+
+func (n *Notifications) list(w http.ResponseWriter, r *http.Request) {
+	targets, err := n.db.GetNotificationTargets()
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Пароли SMTP не отдаем клиенту
+	for i := range targets {
+		targets[i].Password = ""
+	}
+
+	writeJSONResponse(w, http.StatusOK, targets)
+}
+
+func (n *Notifications) create(w http.ResponseWriter, r *http.Request) {
+	var t notify.Target
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	if err := t.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := n.db.CreateNotificationTarget(&t); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	t.Password = ""
+	writeJSONResponse(w, http.StatusCreated, t)
+}
+
+func (n *Notifications) update(w http.ResponseWriter, r *http.Request) {
+	old, ok := n.target(w, r)
+	if !ok {
+		return
+	}
+
+	var t notify.Target
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Пустой пароль означает, что он не меняется, но сохраненный пароль не отправляется на другой сервер
+	t.ID = old.ID
+	if t.Password == "" && old.Password != "" {
+		if t.Kind != old.Kind || t.Address != old.Address || t.Username != old.Username {
+			http.Error(w, "password is required when server or username changes", http.StatusBadRequest)
+			return
+		}
+
+		t.Password = old.Password
+	}
+
+	if err := t.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := n.db.UpdateNotificationTarget(&t); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	t.Password = ""
+	writeJSONResponse(w, http.StatusOK, t)
+}
+
+func (n *Notifications) delete(w http.ResponseWriter, r *http.Request) {
+	t, ok := n.target(w, r)
+	if !ok {
+		return
+	}
+
+	if err := n.db.DeleteNotificationTarget(t.ID); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (n *Notifications) test(w http.ResponseWriter, r *http.Request) {
+	t, ok := n.target(w, r)
+	if !ok {
+		return
+	}
+
+	// Отправка может занять больше времени, чем таймаут записи сервера
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(30 * time.Second))
+
+	// Ошибку доставки возвращаем как есть, чтобы было видно, что не так с настройками
+	if err := n.notifier.Test(*t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+// target returns target from request path, error response is written when there is none.
+func (n *Notifications) target(w http.ResponseWriter, r *http.Request) (*notify.Target, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+
+	t, err := n.db.GetNotificationTarget(id)
+	if err != nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return t, true
+}
+
+func writeJSONResponse(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+var notificationsHTML = `<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Уведомления - cam2ip</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            background-color: #f4f4f4;
+            margin: 0;
+            padding: 0;
+        }
+        .header {
+            background-color: #007bff;
+            color: white;
+            padding: 1rem 2rem;
+        }
+        .header h1 {
+            margin: 0;
+            display: inline-block;
+        }
+        .header a {
+            float: right;
+            color: white;
+            margin-top: 0.5rem;
+        }
+        .container {
+            max-width: 1000px;
+            margin: 2rem auto;
+            padding: 0 2rem;
+        }
+        .card {
+            background: white;
+            padding: 1rem 2rem;
+            border-radius: 8px;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+            margin-bottom: 2rem;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+        }
+        th, td {
+            text-align: left;
+            padding: 0.5rem;
+            border-bottom: 1px solid #eee;
+            font-size: 0.875rem;
+        }
+        label {
+            display: block;
+            margin: 0.5rem 0;
+        }
+        input[type=text], input[type=password], input[type=number], select {
+            width: 100%;
+            padding: 0.4rem;
+            box-sizing: border-box;
+        }
+        button {
+            background-color: #007bff;
+            color: white;
+            border: none;
+            padding: 0.4rem 0.8rem;
+            border-radius: 4px;
+            cursor: pointer;
+        }
+        button.danger {
+            background-color: #dc3545;
+        }
+        #message {
+            font-family: monospace;
+        }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>cam2ip - Уведомления</h1>
+        <a href="/dashboard">Панель управления</a>
+    </div>
+
+    <div class="container">
+        <div class="card">
+            <h3>Получатели</h3>
+            <table>
+                <thead><tr><th>Имя</th><th>Тип</th><th>Адрес</th><th>События</th><th>Пауза, с</th><th>Тихие часы</th><th></th></tr></thead>
+                <tbody id="targets"></tbody>
+            </table>
+            <p id="message"></p>
+        </div>
+
+        <div class="card">
+            <h3 id="form-title">Новый получатель</h3>
+            <form id="form">
+                <input type="hidden" name="id">
+                <label>Имя <input type="text" name="name" required></label>
+                <label>Тип
+                    <select name="kind">
+                        <option value="webhook">Webhook</option>
+                        <option value="email">Email (SMTP)</option>
+                    </select>
+                </label>
+                <label><input type="checkbox" name="enabled" checked> Включен</label>
+                <div id="webhook">
+                    <label>URL <input type="text" name="url" placeholder="https://example.com/hook"></label>
+                </div>
+                <div id="email">
+                    <label>SMTP сервер <input type="text" name="address" placeholder="smtp.example.com:587"></label>
+                    <label>Пользователь <input type="text" name="username"></label>
+                    <label>Пароль <input type="password" name="password" placeholder="не меняется, если пусто и сервер тот же"></label>
+                    <label>От <input type="text" name="from" placeholder="cam2ip@example.com"></label>
+                    <label>Кому <input type="text" name="to" placeholder="admin@example.com, security@example.com"></label>
+                </div>
+                <label>События</label>
+                <label><input type="checkbox" name="events" value="motion_start" checked> Движение</label>
+                <label><input type="checkbox" name="events" value="tamper" checked> Вмешательство</label>
+                <label><input type="checkbox" name="events" value="trigger"> Внешний триггер</label>
+                <label><input type="checkbox" name="events" value="camera_offline" checked> Камера недоступна</label>
+                <label><input type="checkbox" name="events" value="camera_online"> Камера снова доступна</label>
+                <label><input type="checkbox" name="events" value="auth_failed" checked> Неудачные входы</label>
+                <label>Пауза между уведомлениями, секунд <input type="number" name="cooldown" min="0" value="300"></label>
+                <label>Тихие часы с <input type="text" name="quiet_start" placeholder="22:00"></label>
+                <label>до <input type="text" name="quiet_end" placeholder="07:00"></label>
+                <button type="submit">Сохранить</button>
+                <button type="button" id="reset">Очистить</button>
+            </form>
+        </div>
+    </div>
+
+    <script>
+        var form = document.getElementById("form");
+        var message = document.getElementById("message");
+        var targets = [];
+
+        function show(text) {
+            message.textContent = text;
+        }
+
+        function toggleKind() {
+            var email = form.kind.value === "email";
+            document.getElementById("email").style.display = email ? "" : "none";
+            document.getElementById("webhook").style.display = email ? "none" : "";
+        }
+
+        function request(method, url, body) {
+            return fetch(url, {
+                method: method,
+                headers: {"Content-Type": "application/json"},
+                body: body ? JSON.stringify(body) : undefined
+            }).then(function(res) {
+                if (!res.ok) {
+                    return res.text().then(function(text) { throw new Error(text); });
+                }
+                return res.status === 204 ? null : res.json();
+            });
+        }
+
+        function load() {
+            request("GET", "/notifications/targets").then(function(list) {
+                targets = list;
+                var tbody = document.getElementById("targets");
+                tbody.innerHTML = "";
+                list.forEach(function(t) {
+                    var tr = document.createElement("tr");
+                    [t.name + (t.enabled ? "" : " (выключен)"), t.kind, t.kind === "email" ? t.to : t.url,
+                     (t.events || []).join(", "), t.cooldown, t.quiet_start ? t.quiet_start + "-" + t.quiet_end : ""].forEach(function(v) {
+                        var td = document.createElement("td");
+                        td.textContent = v;
+                        tr.appendChild(td);
+                    });
+
+                    var td = document.createElement("td");
+                    [["Тест", "", function() { test(t); }], ["Изменить", "", function() { edit(t); }], ["Удалить", "danger", function() { remove(t); }]].forEach(function(b) {
+                        var btn = document.createElement("button");
+                        btn.textContent = b[0];
+                        btn.className = b[1];
+                        btn.onclick = b[2];
+                        td.appendChild(btn);
+                        td.appendChild(document.createTextNode(" "));
+                    });
+                    tr.appendChild(td);
+                    tbody.appendChild(tr);
+                });
+            }).catch(function(e) { show(e.message); });
+        }
+
+        function test(t) {
+            show("Отправка тестового уведомления " + t.name + "...");
+            request("POST", "/notifications/targets/" + t.id + "/test").then(function() {
+                show("Тестовое уведомление " + t.name + " отправлено");
+            }).catch(function(e) { show("Ошибка: " + e.message); });
+        }
+
+        function remove(t) {
+            if (!confirm("Удалить " + t.name + "?")) {
+                return;
+            }
+            request("DELETE", "/notifications/targets/" + t.id).then(load).catch(function(e) { show(e.message); });
+        }
+
+        function edit(t) {
+            document.getElementById("form-title").textContent = "Изменить " + t.name;
+            ["id", "name", "kind", "url", "address", "username", "from", "to", "cooldown", "quiet_start", "quiet_end"].forEach(function(k) {
+                form[k].value = t[k] === undefined ? "" : t[k];
+            });
+            form.password.value = "";
+            form.enabled.checked = t.enabled;
+            form.querySelectorAll("input[name=events]").forEach(function(c) {
+                c.checked = (t.events || []).indexOf(c.value) >= 0;
+            });
+            toggleKind();
+        }
+
+        form.kind.onchange = toggleKind;
+
+        document.getElementById("reset").onclick = function() {
+            form.reset();
+            form.id.value = "";
+            document.getElementById("form-title").textContent = "Новый получатель";
+            toggleKind();
+        };
+
+        form.onsubmit = function(e) {
+            e.preventDefault();
+            var t = {
+                name: form.name.value, kind: form.kind.value, enabled: form.enabled.checked,
+                url: form.url.value, address: form.address.value, username: form.username.value,
+                password: form.password.value, from: form.from.value, to: form.to.value,
+                events: Array.prototype.map.call(form.querySelectorAll("input[name=events]:checked"), function(c) { return c.value; }),
+                cooldown: parseInt(form.cooldown.value, 10) || 0,
+                quiet_start: form.quiet_start.value, quiet_end: form.quiet_end.value
+            };
+            var id = form.id.value;
+            request(id ? "PUT" : "POST", "/notifications/targets" + (id ? "/" + id : ""), t).then(function() {
+                document.getElementById("reset").onclick();
+                show("Сохранено");
+                load();
+            }).catch(function(e) { show("Ошибка: " + e.message); });
+        };
+
+        toggleKind();
+        load();
+    </script>
+</body>
+</html>`
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gen2brain/cam2ip/notify"
+)
+
+func TestNotificationsUpdate(t *testing.T) {
+	// Database is created in data directory of working directory
+	t.Chdir(t.TempDir())
+
+	db, err := NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	target := &notify.Target{Name: "mail", Kind: notify.KindEmail, Enabled: true, Address: "smtp.example.com:587",
+		Username: "cam", Password: "secret", From: "cam@example.com", To: "me@example.com"}
+	if err := db.CreateNotificationTarget(target); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewNotifications(db, nil))
+	defer srv.Close()
+
+	tests := []struct {
+		body     string
+		code     int
+		password string
+	}{
+		{`{"name":"mail","kind":"email","address":"smtp.example.com:587","username":"cam","from":"cam@example.com","to":"me@example.com"}`, http.StatusOK, "secret"},
+		{`{"name":"mail","kind":"email","address":"evil.example.com:587","username":"cam","from":"cam@example.com","to":"me@example.com"}`, http.StatusBadRequest, "secret"},
+		{`{"name":"mail","kind":"email","address":"smtp.example.com:25","username":"cam","from":"cam@example.com","to":"me@example.com"}`, http.StatusBadRequest, "secret"},
+		{`{"name":"mail","kind":"email","address":"smtp.example.com:587","username":"other","from":"cam@example.com","to":"me@example.com"}`, http.StatusBadRequest, "secret"},
+		{`{"name":"mail","kind":"email","address":"evil.example.com:587","username":"cam","password":"new","from":"cam@example.com","to":"me@example.com"}`, http.StatusOK, "new"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("PUT", srv.URL+"/notifications/targets/1", strings.NewReader(tt.body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		got, err := db.GetNotificationTarget(target.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != tt.code || got.Password != tt.password {
+			t.Errorf("%s: expected %d with password %q, got %d with %q", tt.body, tt.code, tt.password, resp.StatusCode, got.Password)
+		}
+	}
+}
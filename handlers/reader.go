@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"image"
+
+	"github.com/gen2brain/cam2ip/stream"
 )
 
 // ImageReader interface
@@ -12,3 +14,9 @@ type ImageReader interface {
 	// Close closes camera/video.
 	Close() error
 }
+
+// StreamSource interface
+type StreamSource interface {
+	// Stream returns stream by name, empty name is the main stream, nil if there is no such stream.
+	Stream(name string) *stream.Stream
+}
@@ -0,0 +1,611 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gen2brain/cam2ip/metrics"
+	"github.com/gen2brain/cam2ip/record"
+)
+
+// maxPlaybackGap is the longest pause of playback, gaps between recordings are skipped.
+const maxPlaybackGap = time.Second
+
+// Recordings handler shows recordings on timeline, plays them as MJPEG and serves files.
+type Recordings struct {
+	dir string
+	db  *Database
+	mux *http.ServeMux
+}
+
+// NewRecordings returns new Recordings handler of recordings in dir.
+func NewRecordings(dir string, db *Database) *Recordings {
+	h := &Recordings{dir: dir, db: db, mux: http.NewServeMux()}
+
+	h.mux.HandleFunc("GET /recordings", h.page)
+	h.mux.HandleFunc("GET /recordings/play", h.play)
+	h.mux.HandleFunc("GET /recordings/{id}/file", h.file)
+	h.mux.HandleFunc("GET /recordings/{id}/thumbnail", h.thumbnail)
+
+	return h
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (h *Recordings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Recordings) page(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(recordingsHTML))
+}
+
+// play streams recordings from time as MJPEG, with speed 2 or 4 faster than real time.
+func (h *Recordings) play(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	from, err := time.Parse(time.RFC3339, q.Get("from"))
+	if err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	// Без конца воспроизводятся сутки
+	to := from.Add(24 * time.Hour)
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil || !to.After(from) {
+			http.Error(w, "400 Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	speed := 1
+	if v := q.Get("speed"); v != "" {
+		speed, err = strconv.Atoi(v)
+		if err != nil || (speed != 1 && speed != 2 && speed != 4) {
+			http.Error(w, "400 Bad Request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	recs, err := h.db.RecordingsBetween(from, to)
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Можно воспроизводить только сегменты или только клипы
+	if kind := q.Get("kind"); kind != "" {
+		filtered := recs[:0]
+		for _, rec := range recs {
+			if rec.Kind == kind {
+				filtered = append(filtered, rec)
+			}
+		}
+
+		recs = filtered
+	}
+
+	if len(recs) == 0 {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+
+	pb := record.NewPlayback(h.dir, recs, from)
+	defer pb.Close()
+
+	mimeWriter := multipart.NewWriter(sentCounter{w, metrics.BytesSent.With("playback")})
+	_ = mimeWriter.SetBoundary("--boundary")
+
+	w.Header().Add("Connection", "close")
+	w.Header().Add("Cache-Control", "no-store, no-cache")
+	w.Header().Add("Content-Type", fmt.Sprintf("multipart/x-mixed-replace;boundary=%s", mimeWriter.Boundary()))
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	defer trackViewer(r, "recordings", "playback")()
+
+	done := r.Context().Done()
+
+	var prev time.Time
+	deadline := time.Now()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		data, t, err := pb.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("playback: %v", err)
+			}
+
+			break
+		}
+
+		if !t.Before(to) {
+			break
+		}
+
+		// Кадры идут с интервалами записи, ускоренные в speed раз
+		if !prev.IsZero() {
+			deadline = deadline.Add(min(t.Sub(prev), maxPlaybackGap) / time.Duration(speed))
+			timer.Reset(time.Until(deadline))
+
+			select {
+			case <-done:
+				return
+			case <-timer.C:
+			}
+		}
+
+		prev = t
+
+		partHeader := make(textproto.MIMEHeader)
+		partHeader.Add("Content-Type", "image/jpeg")
+		partHeader.Add("Content-Length", strconv.Itoa(len(data)))
+		partHeader.Add("X-Timestamp", t.UTC().Format(time.RFC3339Nano))
+
+		partWriter, err := mimeWriter.CreatePart(partHeader)
+		if err != nil {
+			break
+		}
+
+		_, err = partWriter.Write(data)
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	_ = mimeWriter.Close()
+}
+
+// file serves recording for download, Range requests are supported.
+func (h *Recordings) file(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.recording(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := os.Open(filepath.Join(h.dir, filepath.FromSlash(rec.Path)))
+	if err != nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Файл может быть большим, время записи не ограничивается
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	name := path.Base(rec.Path)
+
+	w.Header().Set("Content-Type", "video/x-msvideo")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+}
+
+func (h *Recordings) thumbnail(w http.ResponseWriter, r *http.Request) {
+	rec, ok := h.recording(w, r)
+	if !ok {
+		return
+	}
+
+	if len(rec.Thumbnail) == 0 {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("Content-Length", strconv.Itoa(len(rec.Thumbnail)))
+	w.Write(rec.Thumbnail)
+}
+
+// recording returns recording from request path, error response is written when there is none.
+func (h *Recordings) recording(w http.ResponseWriter, r *http.Request) (*record.Recording, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "400 Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+
+	rec, err := h.db.GetRecording(id)
+	if err != nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return nil, false
+	}
+
+	return rec, true
+}
+
+var recordingsHTML = `<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Записи - cam2ip</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            background-color: #f4f4f4;
+            margin: 0;
+            padding: 0;
+        }
+        .header {
+            background-color: #007bff;
+            color: white;
+            padding: 1rem 2rem;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        .header h1 {
+            margin: 0;
+            display: inline-block;
+        }
+        .logout-btn {
+            float: right;
+            background-color: #dc3545;
+            color: white;
+            padding: 0.5rem 1rem;
+            border-radius: 4px;
+            text-decoration: none;
+            margin-top: 0.5rem;
+        }
+        .container {
+            max-width: 1400px;
+            margin: 2rem auto;
+            padding: 0 2rem;
+        }
+        .card {
+            background: white;
+            padding: 1rem 2rem;
+            border-radius: 8px;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+            margin-bottom: 2rem;
+        }
+        .player {
+            display: block;
+            width: 100%;
+            max-width: 960px;
+            min-height: 240px;
+            margin: 1rem auto;
+            background: #000;
+        }
+        .timeline {
+            position: relative;
+            height: 48px;
+            background: #eee;
+            border-radius: 4px;
+            cursor: pointer;
+            margin: 1rem 0 0.25rem 0;
+        }
+        .timeline .segment {
+            position: absolute;
+            top: 0;
+            height: 100%;
+            background: #9ec5fe;
+        }
+        .timeline .clip {
+            position: absolute;
+            top: 0;
+            height: 60%;
+            min-width: 3px;
+            background: #fd7e14;
+        }
+        .timeline .clip.tamper {
+            background: #dc3545;
+        }
+        .timeline .clip.trigger {
+            background: #6f42c1;
+        }
+        .timeline .cursor {
+            position: absolute;
+            top: 0;
+            height: 100%;
+            width: 2px;
+            background: #000;
+        }
+        .hours {
+            display: flex;
+            justify-content: space-between;
+            font-size: 0.75rem;
+            color: #777;
+        }
+        table {
+            width: 100%;
+            border-collapse: collapse;
+        }
+        th, td {
+            text-align: left;
+            padding: 0.5rem;
+            border-bottom: 1px solid #eee;
+            font-size: 0.875rem;
+        }
+        td img {
+            width: 80px;
+            display: block;
+        }
+        select, input[type=date], input[type=time] {
+            padding: 0.3rem;
+        }
+        button {
+            background-color: #007bff;
+            color: white;
+            border: none;
+            padding: 0.4rem 0.8rem;
+            border-radius: 4px;
+            cursor: pointer;
+        }
+        a {
+            color: #007bff;
+        }
+        #position {
+            font-family: monospace;
+            margin-left: 1rem;
+        }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>cam2ip - Записи</h1>
+        <a href="/logout" class="logout-btn">Выйти</a>
+    </div>
+
+    <div class="container">
+        <div class="card">
+            <label>Камера <select id="camera"><option value="0">0</option></select></label>
+            <label>День <input type="date" id="day"></label>
+            <label>Скорость <select id="speed">
+                <option value="1">1x</option>
+                <option value="2">2x</option>
+                <option value="4">4x</option>
+            </select></label>
+            <button id="stop">Стоп</button>
+            <span id="position"></span>
+
+            <img id="player" class="player" alt="">
+
+            <div class="timeline" id="timeline"><div class="cursor" id="cursor"></div></div>
+            <div class="hours"><span>00</span><span>03</span><span>06</span><span>09</span><span>12</span><span>15</span><span>18</span><span>21</span><span>24</span></div>
+        </div>
+
+        <div class="card">
+            <label>С <input type="time" id="export-from" step="1" value="00:00:00"></label>
+            <label>По <input type="time" id="export-to" step="1" value="01:00:00"></label>
+            <label><input type="checkbox" id="export-overlay"> Время на кадрах</label>
+            <button id="export">Экспорт в MP4</button>
+            <span id="export-state"></span>
+        </div>
+
+        <div class="card">
+            <table>
+                <thead><tr><th></th><th>Начало</th><th>Длительность</th><th>Тип</th><th>Размер</th><th></th></tr></thead>
+                <tbody id="list"></tbody>
+            </table>
+        </div>
+    </div>
+
+    <script>
+        var dayInput = document.getElementById("day");
+        var timeline = document.getElementById("timeline");
+        var cursor = document.getElementById("cursor");
+        var player = document.getElementById("player");
+        var position = document.getElementById("position");
+        var playing = null;
+
+        function pad(n) {
+            return (n < 10 ? "0" : "") + n;
+        }
+
+        function today() {
+            var d = new Date();
+            return d.getFullYear() + "-" + pad(d.getMonth() + 1) + "-" + pad(d.getDate());
+        }
+
+        function dayStart() {
+            var p = dayInput.value.split("-");
+            return new Date(p[0], p[1] - 1, p[2]);
+        }
+
+        function percent(t) {
+            var ms = t - dayStart();
+            return Math.max(0, Math.min(100, ms / 864000)) + "%";
+        }
+
+        function play(from) {
+            var speed = document.getElementById("speed").value;
+            player.src = "/recordings/play?from=" + encodeURIComponent(from.toISOString().replace(/\.\d+Z$/, "Z")) + "&speed=" + speed;
+            playing = {from: from, started: Date.now(), speed: Number(speed)};
+        }
+
+        function stop() {
+            player.removeAttribute("src");
+            playing = null;
+        }
+
+        // Положение на шкале считается по времени воспроизведения
+        setInterval(function() {
+            if (!playing) {
+                position.textContent = "";
+                return;
+            }
+            var t = new Date(playing.from.getTime() + (Date.now() - playing.started) * playing.speed);
+            cursor.style.left = percent(t);
+            position.textContent = t.toLocaleString();
+        }, 500);
+
+        function size(bytes) {
+            return (bytes / 1048576).toFixed(1) + " MB";
+        }
+
+        function lock(rec, button) {
+            fetch("/api/v1/recordings/" + rec.id, {
+                method: "PATCH",
+                headers: {"Content-Type": "application/json"},
+                body: JSON.stringify({locked: !rec.locked})
+            }).then(function(r) {
+                return r.json();
+            }).then(function(updated) {
+                rec.locked = updated.locked;
+                button.textContent = rec.locked ? "Разблокировать" : "Заблокировать";
+            });
+        }
+
+        function load() {
+            var camera = document.getElementById("camera").value;
+            fetch("/api/v1/cameras/" + camera + "/recordings?day=" + dayInput.value).then(function(r) {
+                return r.ok ? r.json() : [];
+            }).then(function(recs) {
+                timeline.querySelectorAll(".segment, .clip").forEach(function(el) {
+                    el.remove();
+                });
+                var list = document.getElementById("list");
+                list.innerHTML = "";
+
+                recs.forEach(function(rec) {
+                    var start = new Date(rec.start), end = new Date(rec.end);
+
+                    var bar = document.createElement("div");
+                    bar.className = rec.kind === "clip" ? "clip " + rec.event : "segment";
+                    bar.style.left = percent(start);
+                    bar.style.width = "calc(" + percent(end) + " - " + percent(start) + ")";
+                    bar.title = start.toLocaleTimeString() + (rec.event ? " " + rec.event : "");
+                    timeline.insertBefore(bar, cursor);
+
+                    var tr = document.createElement("tr");
+                    var img = document.createElement("img");
+                    img.src = "/recordings/" + rec.id + "/thumbnail";
+                    img.onerror = function() {
+                        img.remove();
+                    };
+                    var cells = [img, start.toLocaleTimeString(), Math.round((end - start) / 1000) + " с",
+                        rec.kind === "clip" ? "клип, " + rec.event : "сегмент", size(rec.size)];
+                    cells.forEach(function(c) {
+                        var td = document.createElement("td");
+                        if (typeof c === "string") {
+                            td.textContent = c;
+                        } else {
+                            td.appendChild(c);
+                        }
+                        tr.appendChild(td);
+                    });
+
+                    var actions = document.createElement("td");
+                    var pb = document.createElement("button");
+                    pb.textContent = "Смотреть";
+                    pb.onclick = function() {
+                        play(start);
+                    };
+                    var download = document.createElement("a");
+                    download.href = "/recordings/" + rec.id + "/file";
+                    download.textContent = "Скачать";
+                    actions.appendChild(pb);
+                    actions.appendChild(document.createTextNode(" "));
+                    actions.appendChild(download);
+                    if (rec.kind === "clip") {
+                        var lb = document.createElement("button");
+                        lb.textContent = rec.locked ? "Разблокировать" : "Заблокировать";
+                        lb.onclick = function() {
+                            lock(rec, lb);
+                        };
+                        actions.appendChild(document.createTextNode(" "));
+                        actions.appendChild(lb);
+                    }
+                    tr.appendChild(actions);
+                    list.appendChild(tr);
+                });
+            });
+        }
+
+        function exportTime(id) {
+            var p = document.getElementById(id).value.split(":");
+            var d = dayStart();
+            d.setHours(p[0] || 0, p[1] || 0, p[2] || 0);
+            return d;
+        }
+
+        // Экспорт выполняется на сервере, прогресс запрашивается раз в секунду
+        function exportRange() {
+            var state = document.getElementById("export-state");
+            var from = exportTime("export-from"), to = exportTime("export-to");
+            if (to <= from) {
+                to = new Date(to.getTime() + 86400000);
+            }
+            state.textContent = "Запуск...";
+            fetch("/api/v1/cameras/" + document.getElementById("camera").value + "/exports", {
+                method: "POST",
+                headers: {"Content-Type": "application/json"},
+                body: JSON.stringify({from: from.toISOString(), to: to.toISOString(), overlay: document.getElementById("export-overlay").checked})
+            }).then(function(r) {
+                return r.json();
+            }).then(function poll(job) {
+                if (!job.id) {
+                    state.textContent = "Ошибка: " + job.error.message;
+                    return;
+                }
+                if (job.state === "running") {
+                    state.textContent = "Экспорт " + Math.round(job.progress * 100) + "%, кадров " + job.frames;
+                    setTimeout(function() {
+                        fetch("/api/v1/exports/" + job.id).then(function(r) {
+                            return r.json();
+                        }).then(poll);
+                    }, 1000);
+                    return;
+                }
+                if (job.state === "failed") {
+                    state.textContent = "Ошибка: " + job.error;
+                    return;
+                }
+                state.textContent = "Готово, кадров " + job.frames + ", " + size(job.size) + " ";
+                var a = document.createElement("a");
+                a.href = "/api/v1/exports/" + job.id + "/file";
+                a.textContent = "Скачать";
+                state.appendChild(a);
+            });
+        }
+
+        document.getElementById("export").addEventListener("click", exportRange);
+
+        timeline.addEventListener("click", function(e) {
+            var rect = timeline.getBoundingClientRect();
+            var ms = (e.clientX - rect.left) / rect.width * 86400000;
+            play(new Date(dayStart().getTime() + ms));
+        });
+
+        document.getElementById("stop").addEventListener("click", stop);
+        document.getElementById("speed").addEventListener("change", function() {
+            if (playing) {
+                play(new Date(playing.from.getTime() + (Date.now() - playing.started) * playing.speed));
+            }
+        });
+        dayInput.addEventListener("change", function() {
+            stop();
+            load();
+        });
+
+        dayInput.value = today();
+        load();
+    </script>
+</body>
+</html>`
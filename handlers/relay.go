@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gen2brain/cam2ip/relay"
+)
+
+// RelayDashboard handler lists cameras connected to relay.
+type RelayDashboard struct {
+	devices func() []relay.Device
+}
+
+// NewRelayDashboard returns new RelayDashboard handler, devices returns connected cameras.
+func NewRelayDashboard(devices func() []relay.Device) *RelayDashboard {
+	return &RelayDashboard{devices}
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (d *RelayDashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_ = relayDashboardTemplate.Execute(w, d.devices())
+}
+
+var relayDashboardTemplate = template.Must(template.New("relay").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta http-equiv="refresh" content="60">
+    <title>Камеры - cam2ip relay</title>
+    <style>
+        body {
+            font-family: Arial, sans-serif;
+            background-color: #f4f4f4;
+            margin: 0;
+            padding: 0;
+        }
+        .header {
+            background-color: #007bff;
+            color: white;
+            padding: 1rem 2rem;
+            box-shadow: 0 2px 4px rgba(0,0,0,0.1);
+        }
+        .header h1 {
+            margin: 0;
+            display: inline-block;
+        }
+        .logout-btn {
+            float: right;
+            background-color: #dc3545;
+            color: white;
+            padding: 0.5rem 1rem;
+            border-radius: 4px;
+            text-decoration: none;
+            margin-top: 0.5rem;
+        }
+        .container {
+            max-width: 1200px;
+            margin: 2rem auto;
+            padding: 0 2rem;
+        }
+        .camera-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fill, minmax(320px, 1fr));
+            gap: 1rem;
+        }
+        .camera-tile {
+            background: white;
+            border-radius: 8px;
+            overflow: hidden;
+            box-shadow: 0 2px 10px rgba(0,0,0,0.1);
+        }
+        .camera-tile img {
+            display: block;
+            width: 100%;
+            background: #000;
+        }
+        .camera-tile p {
+            margin: 0.5rem 1rem;
+            color: #555;
+            font-size: 0.875rem;
+        }
+        .camera-tile a {
+            color: #007bff;
+            margin-right: 0.5rem;
+        }
+        .empty {
+            background: white;
+            padding: 2rem;
+            border-radius: 8px;
+            color: #666;
+        }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>cam2ip relay - Камеры</h1>
+        <a href="/logout" class="logout-btn">Выйти</a>
+    </div>
+
+    <div class="container">
+        {{if .}}
+        <div class="camera-grid">
+            {{range .}}
+            <div class="camera-tile">
+                <a href="/cam/{{.ID}}/mjpeg"><img src="/cam/{{.ID}}/mjpeg?stream=sub" alt="{{.ID}}"></a>
+                <p><b>{{.ID}}</b> подключена {{.ConnectedAt.Format "2006-01-02 15:04:05"}} с {{.Addr}}</p>
+                <p>
+                    <a href="/cam/{{.ID}}/mjpeg">MJPEG</a>
+                    <a href="/cam/{{.ID}}/jpeg">JPEG</a>
+                    <a href="/cam/{{.ID}}/api/v1/status">Статус</a>
+                </p>
+            </div>
+            {{end}}
+        </div>
+        {{else}}
+        <div class="empty">Нет подключенных камер</div>
+        {{end}}
+    </div>
+</body>
+</html>`))
@@ -3,29 +3,78 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/coder/websocket"
 
-	"github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/metrics"
+	"github.com/gen2brain/cam2ip/stream"
 )
 
+const (
+	socketVersion      = 1
+	socketHeaderSize   = 24
+	socketPingInterval = 15 * time.Second
+	socketPingTimeout  = 10 * time.Second
+	socketStatsPeriod  = time.Second
+	socketMaxFPS       = 60
+)
+
+// Binary message types.
+const (
+	SocketFrame    = 0
+	SocketSnapshot = 1
+)
+
+// SocketControl is a control message sent by client, or a reply sent by server.
+//
+// Client sends pause, resume, fps, quality, snapshot and ping,
+// server replies with pong and error, and sends stats periodically.
+type SocketControl struct {
+	Type    string          `json:"type"`
+	FPS     float64         `json:"fps,omitempty"`
+	Quality int             `json:"quality,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// socketStats is the stats message.
+type socketStats struct {
+	Type string `json:"type"`
+	ABRStats
+	Paused bool    `json:"paused"`
+	FPS    float64 `json:"fps"`
+}
+
 // Socket handler.
 type Socket struct {
-	reader  ImageReader
+	streams StreamSource
 	delay   int
-	quality int
+	abr     ABROptions
 }
 
 // NewSocket returns new socket handler.
-func NewSocket(reader ImageReader, delay, quality int) *Socket {
-	return &Socket{reader, delay, quality}
+func NewSocket(streams StreamSource, delay int, abr ABROptions) *Socket {
+	return &Socket{streams, delay, abr}
 }
 
 // ServeHTTP handles requests on incoming connections.
+//
+// Frames are sent as binary messages, a header of socketHeaderSize bytes, big endian:
+// version (1), type (1), header size (2), sequence number (8), timestamp in unix milliseconds (8),
+// width (2) and height (2), followed by JPEG data. Control messages are JSON text messages.
 func (s *Socket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	st := s.streams.Stream(r.URL.Query().Get("stream"))
+	if st == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+
+		return
+	}
+
 	conn, err := websocket.Accept(w, r, nil)
 	if err != nil {
 		log.Printf("socket: accept: %v", err)
@@ -33,34 +82,215 @@ func (s *Socket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := context.Background()
+	defer conn.CloseNow()
+	defer trackViewer(r, st.Name(), "socket")()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	controls := make(chan SocketControl, 8)
+
+	go readControl(ctx, cancel, conn, controls)
+	go keepalive(ctx, cancel, conn)
+
+	frames, unsubscribe := st.Subscribe()
+	defer unsubscribe()
+
+	opts := st.EncoderOptions()
+	abr := NewABR(s.abr, opts.Quality)
+	buf := new(bytes.Buffer)
+	msg := new(bytes.Buffer)
+
+	interval := time.Duration(s.delay) * time.Millisecond
+	paused, snapshot := false, false
+
+	var last time.Time
+	var lastSeq uint64
+
+	stats := time.NewTicker(socketStatsPeriod)
+	defer stats.Stop()
 
 	for {
-		img, err := s.reader.Read()
-		if err != nil {
-			log.Printf("socket: read: %v", err)
-			break
+		select {
+		case <-ctx.Done():
+			_ = conn.Close(websocket.StatusNormalClosure, "")
+
+			return
+		case c := <-controls:
+			reply := SocketControl{}
+
+			switch c.Type {
+			case "pause":
+				paused = true
+			case "resume":
+				paused = false
+			case "fps":
+				if c.FPS < 0 || c.FPS > socketMaxFPS {
+					reply = SocketControl{Type: "error", Error: "invalid fps"}
+				} else if c.FPS == 0 {
+					interval = time.Duration(s.delay) * time.Millisecond
+				} else {
+					interval = time.Duration(float64(time.Second) / c.FPS)
+				}
+			case "quality":
+				if c.Quality < 1 || c.Quality > 100 {
+					reply = SocketControl{Type: "error", Error: "invalid quality"}
+				} else {
+					abr.SetQuality(c.Quality)
+				}
+			case "snapshot":
+				snapshot = true
+			case "ping":
+				reply = SocketControl{Type: "pong", ID: c.ID}
+			case "":
+				reply = SocketControl{Type: "error", Error: "invalid message"}
+			default:
+				reply = SocketControl{Type: "error", Error: "unknown message type " + c.Type}
+			}
+
+			if reply.Type != "" && !writeJSON(ctx, conn, reply) {
+				return
+			}
+		case <-stats.C:
+			fps := 0.0
+			if interval > 0 {
+				fps = float64(time.Second) / float64(interval)
+			}
+
+			if !writeJSON(ctx, conn, socketStats{"stats", abr.Stats(), paused, fps}) {
+				return
+			}
+		case f, ok := <-frames:
+			if !ok {
+				_ = conn.Close(websocket.StatusGoingAway, "stream closed")
+
+				return
+			}
+
+			metrics.Dropped("socket", &lastSeq, f.Seq)
+
+			if snapshot {
+				snapshot = false
+
+				// Snapshot is always sent at full stream quality and resolution.
+				data, err := f.JPEG()
+				if err != nil {
+					log.Printf("socket: encode: %v", err)
+					continue
+				}
+
+				img, err := f.Image()
+				if err != nil {
+					log.Printf("socket: decode: %v", err)
+					continue
+				}
+
+				b := img.Bounds()
+				if !writeFrame(ctx, conn, msg, SocketSnapshot, f, b.Dx(), b.Dy(), data) {
+					return
+				}
+			}
+
+			if paused || (interval > 0 && f.Time.Sub(last) < interval*9/10) {
+				continue
+			}
+
+			last = f.Time
+
+			data, width, height, _, err := encodeFrame(f, opts, abr, buf)
+			if err != nil {
+				log.Printf("socket: encode: %v", err)
+				continue
+			}
+
+			start := time.Now()
+
+			if !writeFrame(ctx, conn, msg, SocketFrame, f, width, height, data) {
+				return
+			}
+
+			abr.Update(len(data), width, height, time.Since(start))
 		}
+	}
+}
 
-		w := new(bytes.Buffer)
+// readControl reads control messages until connection fails, then cancels the context.
+func readControl(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, controls chan<- SocketControl) {
+	defer cancel()
 
-		err = image.NewEncoder(w, s.quality).Encode(img)
+	for {
+		typ, data, err := conn.Read(ctx)
 		if err != nil {
-			log.Printf("socket: encode: %v", err)
+			return
+		}
+
+		if typ != websocket.MessageText {
 			continue
 		}
 
-		b64 := image.EncodeToString(w.Bytes())
+		var c SocketControl
+		_ = json.Unmarshal(data, &c)
 
-		err = conn.Write(ctx, websocket.MessageText, []byte(b64))
-		if err != nil {
-			break
+		select {
+		case controls <- c:
+		case <-ctx.Done():
+			return
 		}
+	}
+}
+
+// keepalive pings client periodically and cancels the context when it stops responding.
+func keepalive(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn) {
+	t := time.NewTicker(socketPingInterval)
+	defer t.Stop()
 
-		if s.delay > 0 {
-			time.Sleep(time.Duration(s.delay) * time.Millisecond)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			pctx, pcancel := context.WithTimeout(ctx, socketPingTimeout)
+			err := conn.Ping(pctx)
+			pcancel()
+
+			if err != nil {
+				cancel()
+
+				return
+			}
 		}
 	}
+}
+
+func writeJSON(ctx context.Context, conn *websocket.Conn, v any) bool {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+
+	return conn.Write(ctx, websocket.MessageText, data) == nil
+}
+
+func writeFrame(ctx context.Context, conn *websocket.Conn, msg *bytes.Buffer, typ byte, f *stream.Frame, width, height int, data []byte) bool {
+	var header [socketHeaderSize]byte
+
+	header[0] = socketVersion
+	header[1] = typ
+	binary.BigEndian.PutUint16(header[2:], socketHeaderSize)
+	binary.BigEndian.PutUint64(header[4:], f.Seq)
+	binary.BigEndian.PutUint64(header[12:], uint64(f.Time.UnixMilli()))
+	binary.BigEndian.PutUint16(header[20:], uint16(width))
+	binary.BigEndian.PutUint16(header[22:], uint16(height))
+
+	msg.Reset()
+	msg.Write(header[:])
+	msg.Write(data)
+
+	if conn.Write(ctx, websocket.MessageBinary, msg.Bytes()) != nil {
+		return false
+	}
+
+	metrics.BytesSent.With("socket").Add(float64(msg.Len()))
 
-	_ = conn.Close(websocket.StatusNormalClosure, "")
+	return true
 }
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	im "github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+// testReader returns frames of the given image every 10 milliseconds.
+type testReader struct {
+	img image.Image
+}
+
+func (r testReader) Read() (image.Image, error) {
+	time.Sleep(10 * time.Millisecond)
+
+	return r.img, nil
+}
+
+func (testReader) Close() error {
+	return nil
+}
+
+func testPipeline(t *testing.T, img image.Image) *stream.Pipeline {
+	t.Helper()
+
+	p := stream.New(testReader{img}, stream.Options{Encoder: im.DefaultEncoderOptions})
+	t.Cleanup(func() { _ = p.Close() })
+
+	return p
+}
+
+func TestSocket(t *testing.T) {
+	p := testPipeline(t, image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio420))
+
+	srv := httptest.NewServer(NewSocket(p, 10, ABROptions{}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+
+	// next returns the next binary message of type typ, or the next control message when typ is negative
+	next := func(typ int) ([]byte, SocketControl) {
+		for {
+			mt, data, err := conn.Read(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mt == websocket.MessageBinary && typ >= 0 && int(data[1]) == typ {
+				return data, SocketControl{}
+			}
+
+			var c SocketControl
+			if mt == websocket.MessageText && typ < 0 {
+				if err := json.Unmarshal(data, &c); err != nil {
+					t.Fatal(err)
+				}
+
+				if c.Type != "stats" {
+					return nil, c
+				}
+			}
+		}
+	}
+
+	send := func(c string) {
+		if err := conn.Write(ctx, websocket.MessageText, []byte(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkHeader := func(data []byte, typ byte) {
+		t.Helper()
+
+		if len(data) <= socketHeaderSize {
+			t.Fatalf("message of %d bytes is too short", len(data))
+		}
+
+		if data[0] != socketVersion || data[1] != typ || binary.BigEndian.Uint16(data[2:]) != socketHeaderSize {
+			t.Errorf("unexpected version %d, type %d, header size %d", data[0], data[1], binary.BigEndian.Uint16(data[2:]))
+		}
+
+		if seq := binary.BigEndian.Uint64(data[4:]); seq == 0 {
+			t.Error("expected sequence number")
+		}
+
+		ts := time.UnixMilli(int64(binary.BigEndian.Uint64(data[12:])))
+		if d := time.Since(ts); d < 0 || d > 5*time.Second {
+			t.Errorf("unexpected timestamp %v", ts)
+		}
+
+		width, height := binary.BigEndian.Uint16(data[20:]), binary.BigEndian.Uint16(data[22:])
+		if width != 64 || height != 48 {
+			t.Errorf("expected 64x48, got %dx%d", width, height)
+		}
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data[socketHeaderSize:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.Width != int(width) || cfg.Height != int(height) {
+			t.Errorf("header size %dx%d differs from jpeg %dx%d", width, height, cfg.Width, cfg.Height)
+		}
+	}
+
+	data, _ := next(SocketFrame)
+	checkHeader(data, SocketFrame)
+
+	send(`{"type":"snapshot"}`)
+	data, _ = next(SocketSnapshot)
+	checkHeader(data, SocketSnapshot)
+
+	send(`{"type":"ping","id":7}`)
+	if _, c := next(-1); c.Type != "pong" || string(c.ID) != "7" {
+		t.Errorf("expected pong with id 7, got %+v", c)
+	}
+
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{`{"type":"fps","fps":100}`, "invalid fps"},
+		{`{"type":"quality","quality":0}`, "invalid quality"},
+		{`{"type":"rewind"}`, "unknown message type rewind"},
+		{`not json`, "invalid message"},
+	}
+
+	for _, tt := range tests {
+		send(tt.msg)
+		if _, c := next(-1); c.Type != "error" || c.Error != tt.want {
+			t.Errorf("%s: expected error %q, got %+v", tt.msg, tt.want, c)
+		}
+	}
+
+	// Paused client gets only stats after pong
+	send(`{"type":"pause"}`)
+	send(`{"type":"ping","id":8}`)
+	_, _ = next(-1)
+
+	mt, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c SocketControl
+	if mt != websocket.MessageText || json.Unmarshal(data, &c) != nil || c.Type != "stats" {
+		t.Errorf("expected stats while paused, got message of type %v", mt)
+	}
+}
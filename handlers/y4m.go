@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gen2brain/cam2ip/metrics"
+)
+
+const (
+	y4mDefaultFPS = 10
+	y4mMaxFPS     = 60
+)
+
+// Y4M handler streams raw frames as YUV4MPEG2.
+type Y4M struct {
+	streams StreamSource
+}
+
+// NewY4M returns new Y4M handler.
+func NewY4M(streams StreamSource) *Y4M {
+	return &Y4M{streams}
+}
+
+// ServeHTTP handles requests on incoming connections.
+//
+// Output is constant frame rate, the latest frame is written at fps given in request and repeated when camera is slower.
+func (y *Y4M) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	st := y.streams.Stream(r.URL.Query().Get("stream"))
+	if st == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+
+		return
+	}
+
+	fps, err := queryInt(r.URL.Query().Get("fps"), y4mDefaultFPS)
+	if err != nil || fps <= 0 || fps > y4mMaxFPS {
+		http.Error(w, "400 Bad Request: invalid fps", http.StatusBadRequest)
+
+		return
+	}
+
+	f, err := st.Next()
+	if err != nil {
+		http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	img, err := f.Image()
+	if err != nil {
+		log.Printf("y4m: decode: %v", err)
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+
+		return
+	}
+
+	ycbcr := toYCbCr(img)
+	rect := ycbcr.Rect
+
+	colorspace, ok := y4mColorspace(ycbcr.SubsampleRatio)
+	if !ok {
+		http.Error(w, "500 Internal Server Error: unsupported subsampling", http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/x-yuv4mpeg")
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	if r.Method == "HEAD" {
+		return
+	}
+
+	defer trackViewer(r, st.Name(), "y4m")()
+
+	bw := bufio.NewWriterSize(sentCounter{w, metrics.BytesSent.With("y4m")}, 1<<16)
+
+	// Go image.YCbCr holds JFIF (full range, BT.601) samples, both for decoded MJPG and raw YUYV frames.
+	_, err = fmt.Fprintf(bw, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 %s XCOLORRANGE=FULL\n", rect.Dx(), rect.Dy(), fps, colorspace)
+	if err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(fps))
+	defer ticker.Stop()
+
+	for {
+		if latest := st.Latest(); latest != nil && latest != f {
+			img, err := latest.Image()
+			if err == nil {
+				f = latest
+				ycbcr = toYCbCr(img)
+			}
+		}
+
+		if ycbcr.Rect.Dx() != rect.Dx() || ycbcr.Rect.Dy() != rect.Dy() {
+			log.Printf("y4m: frame size changed from %v to %v", rect.Size(), ycbcr.Rect.Size())
+
+			return
+		}
+
+		err = writeY4MFrame(bw, ycbcr)
+		if err == nil {
+			err = bw.Flush()
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// y4mColorspace returns Y4M colorspace tag for subsampling ratio.
+func y4mColorspace(ratio image.YCbCrSubsampleRatio) (string, bool) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio420:
+		// JPEG chroma siting.
+		return "C420jpeg", true
+	case image.YCbCrSubsampleRatio422:
+		return "C422", true
+	case image.YCbCrSubsampleRatio444:
+		return "C444", true
+	}
+
+	return "", false
+}
+
+// toYCbCr returns YCbCr image as is when Y4M supports its subsampling, otherwise converts it to 4:2:0.
+func toYCbCr(img image.Image) *image.YCbCr {
+	if y, ok := img.(*image.YCbCr); ok {
+		if _, ok := y4mColorspace(y.SubsampleRatio); ok {
+			return y
+		}
+	}
+
+	b := img.Bounds()
+	dst := image.NewYCbCr(image.Rect(0, 0, b.Dx(), b.Dy()), image.YCbCrSubsampleRatio420)
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+
+			dst.Y[dst.YOffset(x, y)] = yy
+			if x%2 == 0 && y%2 == 0 {
+				off := dst.COffset(x, y)
+				dst.Cb[off] = cb
+				dst.Cr[off] = cr
+			}
+		}
+	}
+
+	return dst
+}
+
+// writeY4MFrame writes frame header and planes.
+func writeY4MFrame(w *bufio.Writer, img *image.YCbCr) error {
+	_, err := w.WriteString("FRAME\n")
+	if err != nil {
+		return err
+	}
+
+	r := img.Rect
+	width, height := r.Dx(), r.Dy()
+
+	// Chroma plane size and vertical subsampling factor.
+	cw, ch, vs := width, height, 1
+	switch img.SubsampleRatio {
+	case image.YCbCrSubsampleRatio420:
+		cw, ch, vs = (width+1)/2, (height+1)/2, 2
+	case image.YCbCrSubsampleRatio422:
+		cw = (width + 1) / 2
+	}
+
+	for y := 0; y < height; y++ {
+		off := img.YOffset(r.Min.X, r.Min.Y+y)
+
+		_, err = w.Write(img.Y[off : off+width])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, plane := range [][]byte{img.Cb, img.Cr} {
+		for y := 0; y < ch; y++ {
+			// COffset takes luma coordinates.
+			off := img.COffset(r.Min.X, r.Min.Y+y*vs)
+
+			_, err = w.Write(plane[off : off+cw])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteY4MFrame(t *testing.T) {
+	// Odd size rounds chroma planes up
+	tests := []struct {
+		ratio      image.YCbCrSubsampleRatio
+		colorspace string
+		size       int
+	}{
+		{image.YCbCrSubsampleRatio420, "C420jpeg", 5*3 + 2*3*2},
+		{image.YCbCrSubsampleRatio422, "C422", 5*3 + 2*3*3},
+		{image.YCbCrSubsampleRatio444, "C444", 5*3 + 2*5*3},
+	}
+
+	for _, tt := range tests {
+		img := image.NewYCbCr(image.Rect(0, 0, 5, 3), tt.ratio)
+
+		if cs, ok := y4mColorspace(tt.ratio); !ok || cs != tt.colorspace {
+			t.Errorf("%v: expected colorspace %s, got %s", tt.ratio, tt.colorspace, cs)
+		}
+
+		buf := new(bytes.Buffer)
+		bw := bufio.NewWriter(buf)
+
+		if err := writeY4MFrame(bw, img); err != nil {
+			t.Fatal(err)
+		}
+
+		_ = bw.Flush()
+
+		if !strings.HasPrefix(buf.String(), "FRAME\n") || buf.Len()-len("FRAME\n") != tt.size {
+			t.Errorf("%s: expected frame of %d bytes, got %d", tt.colorspace, tt.size, buf.Len()-len("FRAME\n"))
+		}
+	}
+
+	if _, ok := y4mColorspace(image.YCbCrSubsampleRatio440); ok {
+		t.Error("expected 4:4:0 to be unsupported")
+	}
+
+	// Unsupported subsampling and other images are converted to 4:2:0
+	for _, img := range []image.Image{image.NewYCbCr(image.Rect(0, 0, 4, 4), image.YCbCrSubsampleRatio440), image.NewGray(image.Rect(0, 0, 4, 4))} {
+		if y := toYCbCr(img); y.SubsampleRatio != image.YCbCrSubsampleRatio420 || y.Rect.Dx() != 4 {
+			t.Errorf("%T: unexpected conversion to %v", img, y.SubsampleRatio)
+		}
+	}
+}
+
+func TestY4M(t *testing.T) {
+	tests := []struct {
+		img    image.Image
+		header string
+		frame  int
+	}{
+		{image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio420), "YUV4MPEG2 W64 H48 F25:1 Ip A1:1 C420jpeg XCOLORRANGE=FULL\n", 64 * 48 * 3 / 2},
+		{image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio422), "YUV4MPEG2 W64 H48 F25:1 Ip A1:1 C422 XCOLORRANGE=FULL\n", 64 * 48 * 2},
+		{image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio444), "YUV4MPEG2 W64 H48 F25:1 Ip A1:1 C444 XCOLORRANGE=FULL\n", 64 * 48 * 3},
+		{image.NewRGBA(image.Rect(0, 0, 64, 48)), "YUV4MPEG2 W64 H48 F25:1 Ip A1:1 C420jpeg XCOLORRANGE=FULL\n", 64 * 48 * 3 / 2},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(NewY4M(testPipeline(t, tt.img)))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"?fps=25", nil)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		br := bufio.NewReader(resp.Body)
+
+		header, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header != tt.header || resp.Header.Get("Content-Type") != "video/x-yuv4mpeg" {
+			t.Errorf("unexpected header %q", header)
+		}
+
+		// Two frames, each with FRAME header and planes
+		for i := 0; i < 2; i++ {
+			frame := make([]byte, len("FRAME\n")+tt.frame)
+			if _, err := io.ReadFull(br, frame); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.HasPrefix(frame, []byte("FRAME\n")) {
+				t.Errorf("%s: frame %d: missing FRAME header", strings.Fields(tt.header)[5], i)
+			}
+		}
+
+		cancel()
+		_ = resp.Body.Close()
+		srv.Close()
+	}
+}
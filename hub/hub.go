@@ -0,0 +1,448 @@
+// Package hub aggregates remote cam2ip instances, it checks their health and proxies requests to them.
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodePrefix is the path prefix of requests proxied to nodes, followed by node id.
+const NodePrefix = "/node/"
+
+// statusPath is the path of node status in cam2ip API.
+const statusPath = "/api/v1/status"
+
+// Node is a remote cam2ip instance.
+type Node struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// URL is the base URL of node, e.g. http://pi1.lan:56000.
+	URL      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+}
+
+// Validate checks that node can be used.
+func (n *Node) Validate() error {
+	if strings.TrimSpace(n.Name) == "" {
+		return errors.New("name is required")
+	}
+
+	u, err := url.Parse(n.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be http or https URL of cam2ip")
+	}
+
+	return nil
+}
+
+// Status is status reported by node API.
+type Status struct {
+	Name    string  `json:"name"`
+	Version string  `json:"version"`
+	Uptime  float64 `json:"uptime"`
+	Camera  string  `json:"camera"`
+	Motion  bool    `json:"motion"`
+	Privacy bool    `json:"privacy"`
+	Viewers int     `json:"viewers"`
+	FPS     float64 `json:"fps"`
+}
+
+// State is node with result of the last health check.
+type State struct {
+	Node
+	Online    bool      `json:"online"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Latency   int64     `json:"latency"`
+	Status    *Status   `json:"status,omitempty"`
+}
+
+// Store provides registered nodes.
+type Store interface {
+	// GetHubNodes returns all nodes.
+	GetHubNodes() ([]Node, error)
+}
+
+// Hub checks health of nodes and proxies requests to them.
+type Hub struct {
+	store    Store
+	interval time.Duration
+	client   *http.Client
+
+	mu      sync.Mutex
+	nodes   map[int64]*node
+	reload  chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+}
+
+type node struct {
+	state  State
+	proxy  *httputil.ReverseProxy
+	client *http.Client
+}
+
+// New returns new Hub, nodes are checked every interval.
+func New(store Store, interval time.Duration) *Hub {
+	return &Hub{
+		store:    store,
+		interval: interval,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		nodes:    make(map[int64]*node),
+		reload:   make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start loads nodes and checks them in background.
+func (h *Hub) Start() {
+	go func() {
+		defer close(h.done)
+
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		h.Reload()
+
+		for {
+			select {
+			case <-h.closing:
+				return
+			case <-ticker.C:
+			case <-h.reload:
+				if err := h.load(); err != nil {
+					log.Printf("hub: %v", err)
+				}
+			}
+
+			h.check()
+		}
+	}()
+}
+
+// Close stops health checks.
+func (h *Hub) Close() error {
+	close(h.closing)
+	<-h.done
+
+	return nil
+}
+
+// Reload loads nodes from store again and checks them, it is called after nodes change.
+func (h *Hub) Reload() {
+	select {
+	case h.reload <- struct{}{}:
+	default:
+	}
+}
+
+// States returns nodes with their last health check, sorted by name.
+func (h *Hub) States() []State {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ret := make([]State, 0, len(h.nodes))
+	for _, n := range h.nodes {
+		st := n.state
+		st.Password = ""
+		ret = append(ret, st)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+
+		return ret[i].ID < ret[j].ID
+	})
+
+	return ret
+}
+
+// ServeHTTP proxies requests under NodePrefix to node, hub login replaces node credentials.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, NodePrefix), "/")
+
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+
+	h.mu.Lock()
+	n := h.nodes[id]
+	h.mu.Unlock()
+
+	if n == nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+
+	n.proxy.ServeHTTP(w, r)
+}
+
+// load replaces nodes with nodes from store, states of unchanged nodes are kept.
+func (h *Hub) load() error {
+	list, err := h.store.GetHubNodes()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	nodes := make(map[int64]*node, len(list))
+	for _, nd := range list {
+		old, ok := h.nodes[nd.ID]
+		if ok && old.state.Node == nd {
+			nodes[nd.ID] = old
+			continue
+		}
+
+		// Health checks and proxied requests share session of node
+		sess := newSession(nd)
+
+		proxy, err := newProxy(nd, sess)
+		if err != nil {
+			log.Printf("hub: node %s: %v", nd.Name, err)
+			continue
+		}
+
+		client := &http.Client{Transport: sess, Timeout: h.client.Timeout}
+
+		nodes[nd.ID] = &node{state: State{Node: nd}, proxy: proxy, client: client}
+	}
+
+	h.nodes = nodes
+
+	return nil
+}
+
+// check checks all nodes concurrently.
+func (h *Hub) check() {
+	type check struct {
+		nd     Node
+		client *http.Client
+	}
+
+	h.mu.Lock()
+	list := make([]check, 0, len(h.nodes))
+	for _, n := range h.nodes {
+		list = append(list, check{n.state.Node, n.client})
+	}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+
+	for _, c := range list {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			nd := c.nd
+			st := checkNode(nd, c.client)
+
+			h.mu.Lock()
+			if n, ok := h.nodes[nd.ID]; ok && n.state.Node == nd {
+				if n.state.Online && !st.Online {
+					log.Printf("hub: node %s is offline: %s", nd.Name, st.Error)
+				}
+
+				n.state = st
+			}
+			h.mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+}
+
+// checkNode reads status of node with client of its session.
+func checkNode(nd Node, client *http.Client) State {
+	st := State{Node: nd, CheckedAt: time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(nd.URL, "/")+statusPath, nil)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	defer resp.Body.Close()
+
+	st.Latency = time.Since(st.CheckedAt).Milliseconds()
+
+	if resp.StatusCode != http.StatusOK {
+		st.Error = resp.Status
+		return st
+	}
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		st.Error = fmt.Sprintf("invalid status: %v", err)
+		return st
+	}
+
+	st.Online = true
+	st.Status = &status
+
+	return st
+}
+
+// newProxy returns proxy to node, NodePrefix and node id are removed from path.
+func newProxy(nd Node, sess *session) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(nd.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := NodePrefix + strconv.FormatInt(nd.ID, 10)
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Path = strings.TrimPrefix(pr.In.URL.Path, prefix)
+			pr.Out.URL.RawPath = ""
+			pr.SetURL(target)
+			pr.SetXForwarded()
+
+			// Hub session is not for node, session transport adds session of node
+			pr.Out.Header.Del("Cookie")
+			pr.Out.Header.Del("Authorization")
+		},
+		Transport:     sess,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("hub: node %s: %v", nd.Name, err)
+			http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		},
+	}, nil
+}
+
+// loginPath is the path of login form of node.
+const loginPath = "/login"
+
+// sessionCookie is the name of session cookie of node.
+const sessionCookie = "session_id"
+
+// session logs in to node once and adds its session cookie to requests, so that node does not check and log
+// credentials on every health check and proxied request. It logs in again when node answers 401, e.g. after
+// restart of node or when session expires.
+type session struct {
+	node Node
+	base http.RoundTripper
+
+	mu     sync.Mutex
+	cookie string
+}
+
+func newSession(nd Node) *session {
+	return &session{node: nd, base: http.DefaultTransport}
+}
+
+// RoundTrip sends request with session cookie, request is sent again after new login when session is not valid.
+func (s *session) RoundTrip(req *http.Request) (*http.Response, error) {
+	if s.node.Username == "" {
+		return s.base.RoundTrip(req)
+	}
+
+	cookie := s.login(req.Context(), "")
+
+	resp, err := s.base.RoundTrip(withCookie(req, cookie))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || cookie == "" {
+		return resp, err
+	}
+
+	// Body that was already read cannot be sent again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	retry := s.login(req.Context(), cookie)
+	if retry == "" || retry == cookie {
+		return resp, nil
+	}
+
+	_ = resp.Body.Close()
+
+	out := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if out.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.base.RoundTrip(withCookie(out, retry))
+}
+
+// login returns session cookie, it logs in when there is none or when the current one is stale.
+// Empty cookie is returned when login fails, request is then sent without it and node answers 401.
+func (s *session) login(ctx context.Context, stale string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cookie != "" && s.cookie != stale {
+		return s.cookie
+	}
+
+	s.cookie = ""
+
+	form := url.Values{"username": {s.node.Username}, "password": {s.node.Password}}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(s.node.URL, "/")+loginPath, strings.NewReader(form.Encode()))
+	if err != nil {
+		return ""
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Successful login sets cookie and redirects to dashboard, redirect is not followed
+	resp, err := s.base.RoundTrip(req)
+	if err != nil {
+		log.Printf("hub: node %s: login: %v", s.node.Name, err)
+		return ""
+	}
+	_ = resp.Body.Close()
+
+	for _, c := range resp.Cookies() {
+		if c.Name == sessionCookie && c.Value != "" {
+			s.cookie = c.Value
+		}
+	}
+
+	return s.cookie
+}
+
+// withCookie returns copy of request with session cookie, request is not changed when cookie is empty.
+func withCookie(req *http.Request, cookie string) *http.Request {
+	if cookie == "" {
+		return req
+	}
+
+	out := req.Clone(req.Context())
+	out.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+
+	return out
+}
@@ -0,0 +1,145 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type store []Node
+
+func (s store) GetHubNodes() ([]Node, error) {
+	return s, nil
+}
+
+func TestHub(t *testing.T) {
+	var mu sync.Mutex
+	sessions := make(map[string]bool)
+	logins := 0
+
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Path == loginPath {
+			if r.FormValue("username") == "admin" && r.FormValue("password") == "secret" {
+				logins++
+				id := fmt.Sprintf("s%d", logins)
+				sessions[id] = true
+				http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id})
+			}
+
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			return
+		}
+
+		if c, err := r.Cookie(sessionCookie); err != nil || !sessions[c.Value] || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == statusPath {
+			fmt.Fprint(w, `{"name":"cam2ip","version":"1.6","camera":"online","viewers":2,"fps":15.2}`)
+			return
+		}
+
+		fmt.Fprintf(w, "%s %s", r.URL.Path, r.URL.RawQuery)
+	}))
+	defer node.Close()
+
+	h := New(store{
+		{ID: 1, Name: "garage", URL: node.URL, Username: "admin", Password: "secret"},
+		{ID: 2, Name: "attic", URL: node.URL, Username: "admin", Password: "wrong"},
+	}, time.Hour)
+
+	h.Start()
+	defer h.Close()
+
+	var states []State
+	for i := 0; i < 100; i++ {
+		states = h.States()
+		if len(states) == 2 && !states[0].CheckedAt.IsZero() && !states[1].CheckedAt.IsZero() {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	attic, garage := states[0], states[1]
+
+	if attic.Online || attic.Error != "401 Unauthorized" {
+		t.Errorf("unexpected attic state %+v", attic)
+	}
+
+	if !garage.Online || garage.Status == nil || garage.Status.FPS != 15.2 || garage.Status.Viewers != 2 || garage.Password != "" {
+		t.Errorf("unexpected garage state %+v", garage)
+	}
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/node/1/mjpeg?stream=sub", nil)
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: "x"})
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if want := `/mjpeg stream=sub`; string(body) != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+
+	// Checks and proxied requests reuse the session, node restart invalidates it and hub logs in again
+	h.check()
+
+	mu.Lock()
+	clear(sessions)
+	mu.Unlock()
+
+	resp, err = http.Get(ts.URL + "/node/1/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	mu.Lock()
+	n := logins
+	mu.Unlock()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "/jpeg " || n != 2 {
+		t.Errorf("expected request after second login, got %d %q after %d logins", resp.StatusCode, body, n)
+	}
+
+	resp, err = http.Get(ts.URL + "/node/3/mjpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown node: %d", resp.StatusCode)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, n := range []Node{{Name: "a", URL: "ftp://x"}, {Name: "", URL: "http://x"}, {Name: "a", URL: "http://"}} {
+		if n.Validate() == nil {
+			t.Errorf("invalid node %+v accepted", n)
+		}
+	}
+
+	n := Node{Name: "a", URL: "http://pi1.lan:56000"}
+	if err := n.Validate(); err != nil {
+		t.Error(err)
+	}
+}
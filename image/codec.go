@@ -0,0 +1,89 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"sync"
+)
+
+// Codec is a JPEG encoder/decoder backend.
+type Codec interface {
+	// Name returns the codec name.
+	Name() string
+
+	// Encode encodes image to JPEG.
+	Encode(w io.Writer, img image.Image, opts EncoderOptions) error
+
+	// Decode decodes image from JPEG.
+	Decode(r io.Reader) (image.Image, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   []Codec
+	current  Codec
+)
+
+// Register makes a codec available by its name.
+func Register(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	for _, v := range codecs {
+		if v.Name() == c.Name() {
+			panic("image: Register called twice for codec " + c.Name())
+		}
+	}
+
+	codecs = append(codecs, c)
+}
+
+// Codecs returns all registered codecs, in order of registration.
+func Codecs() []Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	return append([]Codec(nil), codecs...)
+}
+
+// Lookup returns codec with the given name.
+func Lookup(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("image: unknown codec %q", name)
+}
+
+// SetCodec selects the codec used by NewEncoder and NewDecoder.
+func SetCodec(name string) error {
+	c, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+
+	codecsMu.Lock()
+	current = c
+	codecsMu.Unlock()
+
+	return nil
+}
+
+// CurrentCodec returns the selected codec.
+func CurrentCodec() Codec {
+	codecsMu.RLock()
+	c := current
+	codecsMu.RUnlock()
+
+	if c == nil {
+		c, _ = Lookup(DefaultCodec)
+	}
+
+	return c
+}
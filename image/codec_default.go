@@ -0,0 +1,6 @@
+//go:build !libjpeg
+
+package image
+
+// DefaultCodec is the name of the codec used when none is selected.
+const DefaultCodec = "native"
@@ -0,0 +1,57 @@
+package image
+
+import (
+	"image"
+	"io"
+
+	"github.com/gen2brain/jpegli"
+)
+
+func init() {
+	Register(jpegliCodec{})
+}
+
+// jpegliCodec uses jpegli compiled to WASM and run with wazero, it is available in every build.
+type jpegliCodec struct{}
+
+func (jpegliCodec) Name() string {
+	return "jpegli"
+}
+
+func (jpegliCodec) Encode(w io.Writer, img image.Image, opts EncoderOptions) error {
+	progressiveLevel := 0
+	if opts.Progressive {
+		progressiveLevel = 2
+	}
+
+	return jpegli.Encode(w, img, &jpegli.EncodingOptions{
+		Quality:              opts.Quality,
+		ProgressiveLevel:     progressiveLevel,
+		ChromaSubsampling:    opts.Subsampling,
+		DCTMethod:            jpegliDCTMethod(opts.DCTMethod),
+		OptimizeCoding:       opts.OptimizeCoding,
+		AdaptiveQuantization: opts.AdaptiveQuantization,
+		StandardQuantTables:  false,
+		FancyDownsampling:    false,
+	})
+}
+
+func (jpegliCodec) Decode(r io.Reader) (image.Image, error) {
+	return jpegli.DecodeWithOptions(r, &jpegli.DecodingOptions{
+		DCTMethod:       jpegli.DCTIFast,
+		FancyUpsampling: false,
+		BlockSmoothing:  false,
+		ArithCode:       true,
+	})
+}
+
+func jpegliDCTMethod(m DCTMethod) jpegli.DCTMethod {
+	switch m {
+	case DCTISlow:
+		return jpegli.DCTISlow
+	case DCTFloat:
+		return jpegli.DCTFloat
+	}
+
+	return jpegli.DCTIFast
+}
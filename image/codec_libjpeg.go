@@ -0,0 +1,55 @@
+//go:build libjpeg
+
+package image
+
+import (
+	"image"
+	"io"
+
+	"github.com/pixiv/go-libjpeg/jpeg"
+)
+
+// DefaultCodec is the name of the codec used when none is selected, libjpeg builds use libjpeg.
+const DefaultCodec = "libjpeg"
+
+func init() {
+	Register(libjpegCodec{})
+}
+
+// libjpegCodec uses libjpeg(-turbo) over cgo, it is available only when built with libjpeg tag.
+//
+// Subsampling and AdaptiveQuantization are not supported by go-libjpeg and are ignored,
+// YCbCr images keep their own subsampling, other images are encoded as 4:2:0.
+type libjpegCodec struct{}
+
+func (libjpegCodec) Name() string {
+	return "libjpeg"
+}
+
+func (libjpegCodec) Encode(w io.Writer, img image.Image, opts EncoderOptions) error {
+	return jpeg.Encode(w, img, &jpeg.EncoderOptions{
+		Quality:         opts.Quality,
+		DCTMethod:       libjpegDCTMethod(opts.DCTMethod),
+		ProgressiveMode: opts.Progressive,
+		OptimizeCoding:  opts.OptimizeCoding,
+	})
+}
+
+func (libjpegCodec) Decode(r io.Reader) (image.Image, error) {
+	return jpeg.Decode(r, &jpeg.DecoderOptions{
+		DCTMethod:              jpeg.DCTIFast,
+		DisableFancyUpsampling: true,
+		DisableBlockSmoothing:  true,
+	})
+}
+
+func libjpegDCTMethod(m DCTMethod) jpeg.DCTMethod {
+	switch m {
+	case DCTISlow:
+		return jpeg.DCTISlow
+	case DCTFloat:
+		return jpeg.DCTFloat
+	}
+
+	return jpeg.DCTIFast
+}
@@ -0,0 +1,28 @@
+package image
+
+import (
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+func init() {
+	Register(nativeCodec{})
+}
+
+// nativeCodec uses standard library image/jpeg.
+//
+// Native encoder always uses baseline 4:2:0 with standard Huffman tables, only Quality is honored.
+type nativeCodec struct{}
+
+func (nativeCodec) Name() string {
+	return "native"
+}
+
+func (nativeCodec) Encode(w io.Writer, img image.Image, opts EncoderOptions) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+}
+
+func (nativeCodec) Decode(r io.Reader) (image.Image, error) {
+	return jpeg.Decode(r)
+}
@@ -1,25 +1,31 @@
-//go:build !libjpeg && !jpegli
-
 // Package image.
 package image
 
 import (
 	"image"
-	"image/jpeg"
 	"io"
+	"time"
+
+	"github.com/gen2brain/cam2ip/metrics"
 )
 
-// NewDecoder returns a new Decoder.
+// NewDecoder returns a new Decoder using the selected codec.
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r}
+	return &Decoder{r, CurrentCodec()}
 }
 
 // Decoder struct.
 type Decoder struct {
-	r io.Reader
+	r     io.Reader
+	codec Codec
 }
 
 // Decode decodes image from JPEG.
 func (d Decoder) Decode() (image.Image, error) {
-	return jpeg.Decode(d.r)
+	start := time.Now()
+	defer func() {
+		metrics.DecodeDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	return d.codec.Decode(d.r)
 }
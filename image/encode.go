@@ -1,31 +1,36 @@
-//go:build !libjpeg && !jpegli
-
 // Package image.
 package image
 
 import (
 	"image"
-	"image/jpeg"
 	"io"
+	"time"
+
+	"github.com/gen2brain/cam2ip/metrics"
 )
 
-// NewEncoder returns a new Encoder.
-func NewEncoder(w io.Writer, quality int) *Encoder {
-	return &Encoder{w, quality}
+// NewEncoder returns a new Encoder using the selected codec.
+func NewEncoder(w io.Writer, opts EncoderOptions) *Encoder {
+	return &Encoder{w, opts, CurrentCodec()}
 }
 
 // Encoder struct.
 type Encoder struct {
-	w       io.Writer
-	quality int
+	w     io.Writer
+	opts  EncoderOptions
+	codec Codec
 }
 
 // Encode encodes image to JPEG.
 func (e Encoder) Encode(img image.Image) error {
-	err := jpeg.Encode(e.w, img, &jpeg.Options{Quality: e.quality})
+	start := time.Now()
+
+	err := e.codec.Encode(e.w, img, e.opts)
 	if err != nil {
 		return err
 	}
 
+	metrics.EncodeDuration.Observe(time.Since(start).Seconds())
+
 	return nil
 }
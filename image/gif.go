@@ -0,0 +1,70 @@
+package image
+
+import (
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// gifMaxDelay is the longest frame delay in 1/100s, longer gaps between frames, e.g. between recordings, are shortened.
+const gifMaxDelay = 1000
+
+// GIF builds animated GIF from frames.
+type GIF struct {
+	width int
+	delay int
+	last  time.Time
+	g     gif.GIF
+}
+
+// NewGIF returns new GIF, frames are scaled to width (0 keeps original size), frames added with Add are shown at fps.
+func NewGIF(width int, fps float64) *GIF {
+	delay := 100
+	if fps > 0 {
+		delay = max(int(100/fps), 2)
+	}
+
+	return &GIF{width: width, delay: delay}
+}
+
+// Add quantizes image to web-safe palette with Floyd-Steinberg dithering and appends it as a frame.
+func (g *GIF) Add(img image.Image) {
+	b := img.Bounds()
+	if g.width > 0 && g.width < b.Dx() {
+		height := max(b.Dy()*g.width/b.Dx(), 1)
+		img = transform.Resize(img, g.width, height, transform.Linear)
+		b = img.Bounds()
+	}
+
+	p := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.Plan9)
+	draw.FloydSteinberg.Draw(p, p.Bounds(), img, b.Min)
+
+	g.g.Image = append(g.g.Image, p)
+	g.g.Delay = append(g.g.Delay, g.delay)
+}
+
+// AddAt adds image captured at time t, the previous frame is shown until t, so that GIF plays in real time
+// whatever the rate of frames is. The last frame is shown for 1/fps.
+func (g *GIF) AddAt(img image.Image, t time.Time) {
+	if n := len(g.g.Delay); n > 0 {
+		g.g.Delay[n-1] = min(max(int(t.Sub(g.last)/(10*time.Millisecond)), 2), gifMaxDelay)
+	}
+
+	g.last = t
+	g.Add(img)
+}
+
+// Len returns number of frames.
+func (g *GIF) Len() int {
+	return len(g.g.Image)
+}
+
+// Encode writes animated GIF to w.
+func (g *GIF) Encode(w io.Writer) error {
+	return gif.EncodeAll(w, &g.g)
+}
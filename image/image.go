@@ -35,6 +35,11 @@ func Flip(img image.Image, dir string) image.Image {
 }
 
 func Timestamp(img image.Image, format string) image.Image {
+	return Text(img, time.Now().Format(format))
+}
+
+// Text draws text in the top left corner of image.
+func Text(img image.Image, text string) image.Image {
 	dimg, ok := img.(draw.Image)
 	if !ok {
 		b := img.Bounds()
@@ -42,7 +47,36 @@ func Timestamp(img image.Image, format string) image.Image {
 		draw.Draw(dimg, b, img, b.Min, draw.Src)
 	}
 
-	pixfont.DrawString(dimg, 10, 10, time.Now().Format(format), color.White)
+	pixfont.DrawString(dimg, 10, 10, text, color.White)
 
 	return dimg
 }
+
+// Resize scales image by the given factor, images are returned unchanged for scale of 1 or more.
+func Resize(img image.Image, scale float64) image.Image {
+	if scale >= 1 || scale <= 0 {
+		return img
+	}
+
+	b := img.Bounds()
+
+	width := max(int(float64(b.Dx())*scale)&^1, 2)
+	height := max(int(float64(b.Dy())*scale)&^1, 2)
+
+	return transform.Resize(img, width, height, transform.Linear)
+}
+
+// ResizeTo scales image to width and height, zero height keeps aspect ratio.
+func ResizeTo(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+
+	if height <= 0 {
+		height = max(b.Dy()*width/b.Dx(), 1)
+	}
+
+	if width == b.Dx() && height == b.Dy() {
+		return img
+	}
+
+	return transform.Resize(img, width, height, transform.Linear)
+}
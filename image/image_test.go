@@ -3,9 +3,12 @@ package image_test
 import (
 	"bytes"
 	_ "embed"
+	stdimage "image"
+	"image/gif"
 	"image/jpeg"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/gen2brain/cam2ip/image"
 )
@@ -14,11 +17,15 @@ import (
 var testJpg []byte
 
 func BenchmarkDecode(b *testing.B) {
-	for i := 0; i < b.N; i++ {
-		_, err := image.NewDecoder(bytes.NewReader(testJpg)).Decode()
-		if err != nil {
-			b.Fatal(err)
-		}
+	for _, codec := range image.Codecs() {
+		b.Run(codec.Name(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := codec.Decode(bytes.NewReader(testJpg))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
 	}
 }
 
@@ -28,11 +35,15 @@ func BenchmarkEncode(b *testing.B) {
 		b.Fatal(err)
 	}
 
-	for i := 0; i < b.N; i++ {
-		err := image.NewEncoder(io.Discard).Encode(img)
-		if err != nil {
-			b.Fatal(err)
-		}
+	for _, codec := range image.Codecs() {
+		b.Run(codec.Name(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := codec.Encode(io.Discard, img, image.DefaultEncoderOptions)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
 	}
 }
 
@@ -41,3 +52,92 @@ func BenchmarkBase64(b *testing.B) {
 		_ = image.EncodeToString(testJpg)
 	}
 }
+
+func TestEncoderOptions(t *testing.T) {
+	img, err := jpeg.Decode(bytes.NewReader(testJpg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, codec := range image.Codecs() {
+		for _, s := range []string{"444", "440", "422", "420"} {
+			subsampling, err := image.ParseSubsampling(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := image.EncoderOptions{
+				Quality:              80,
+				Subsampling:          subsampling,
+				Progressive:          true,
+				OptimizeCoding:       true,
+				AdaptiveQuantization: true,
+				DCTMethod:            image.DCTISlow,
+			}
+
+			w := new(bytes.Buffer)
+
+			err = codec.Encode(w, img, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = codec.Decode(w)
+			if err != nil {
+				t.Errorf("%s: %s: %v", codec.Name(), s, err)
+			}
+		}
+	}
+
+	if _, err := image.ParseDCTMethod("fastest"); err == nil {
+		t.Error("expected error for invalid dct method")
+	}
+}
+
+func TestSetCodec(t *testing.T) {
+	defer image.SetCodec(image.DefaultCodec)
+
+	for _, codec := range image.Codecs() {
+		err := image.SetCodec(codec.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if image.CurrentCodec().Name() != codec.Name() {
+			t.Errorf("expected codec %s, got %s", codec.Name(), image.CurrentCodec().Name())
+		}
+	}
+
+	if err := image.SetCodec("unknown"); err == nil {
+		t.Error("expected error for unknown codec")
+	}
+}
+
+func TestGIFAddAt(t *testing.T) {
+	img := stdimage.NewGray(stdimage.Rect(0, 0, 8, 8))
+	start := time.Now()
+
+	// Frames of 2 fps history and a gap between recordings, exported at 10 fps
+	g := image.NewGIF(0, 10)
+	for _, d := range []time.Duration{0, 500 * time.Millisecond, time.Second, time.Second + 5*time.Millisecond, time.Hour} {
+		g.AddAt(img, start.Add(d))
+	}
+
+	var buf bytes.Buffer
+	if err := g.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dec, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{50, 50, 2, 1000, 10}
+	for i, d := range dec.Delay {
+		if d != want[i] {
+			t.Errorf("expected delays %v, got %v", want, dec.Delay)
+			break
+		}
+	}
+}
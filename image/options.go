@@ -0,0 +1,89 @@
+package image
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// DCTMethod is the DCT algorithm method.
+type DCTMethod int
+
+const (
+	// DCTISlow is slow but accurate integer algorithm.
+	DCTISlow DCTMethod = iota
+	// DCTIFast is faster, less accurate integer method.
+	DCTIFast
+	// DCTFloat is floating-point method.
+	DCTFloat
+)
+
+// String returns the name of the DCT method.
+func (m DCTMethod) String() string {
+	switch m {
+	case DCTISlow:
+		return "islow"
+	case DCTIFast:
+		return "ifast"
+	case DCTFloat:
+		return "float"
+	}
+
+	return fmt.Sprintf("DCTMethod(%d)", int(m))
+}
+
+// EncoderOptions are the JPEG encoding parameters.
+//
+// Not every backend supports every option, unsupported options are silently ignored.
+// Native image/jpeg honors only Quality, libjpeg ignores Subsampling and AdaptiveQuantization.
+type EncoderOptions struct {
+	// Quality in the range [1,100].
+	Quality int
+	// Chroma subsampling, 444|440|422|420.
+	Subsampling image.YCbCrSubsampleRatio
+	// Progressive encoding instead of baseline.
+	Progressive bool
+	// Optimized Huffman tables.
+	OptimizeCoding bool
+	// Adaptive quantization for creating more zero coefficients.
+	AdaptiveQuantization bool
+	// DCTMethod is the DCT algorithm method.
+	DCTMethod DCTMethod
+}
+
+// DefaultEncoderOptions are the options favoring speed over size.
+var DefaultEncoderOptions = EncoderOptions{
+	Quality:     75,
+	Subsampling: image.YCbCrSubsampleRatio420,
+	DCTMethod:   DCTIFast,
+}
+
+// ParseSubsampling parses chroma subsampling, valid values are 444, 440, 422 and 420.
+func ParseSubsampling(s string) (image.YCbCrSubsampleRatio, error) {
+	switch strings.TrimPrefix(s, "4:") {
+	case "444", "4:4":
+		return image.YCbCrSubsampleRatio444, nil
+	case "440", "4:0":
+		return image.YCbCrSubsampleRatio440, nil
+	case "422", "2:2":
+		return image.YCbCrSubsampleRatio422, nil
+	case "420", "2:0":
+		return image.YCbCrSubsampleRatio420, nil
+	}
+
+	return 0, fmt.Errorf("image: invalid subsampling %q", s)
+}
+
+// ParseDCTMethod parses DCT method, valid values are islow, ifast and float.
+func ParseDCTMethod(s string) (DCTMethod, error) {
+	switch strings.ToLower(s) {
+	case "islow":
+		return DCTISlow, nil
+	case "ifast":
+		return DCTIFast, nil
+	case "float":
+		return DCTFloat, nil
+	}
+
+	return 0, fmt.Errorf("image: invalid dct method %q", s)
+}
@@ -0,0 +1,641 @@
+// Package mdns implements DNS-SD service advertisement and browsing over multicast DNS (RFC 6762, RFC 6763).
+package mdns
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mDNS multicast group.
+var groupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	// servicesName is used to enumerate service types.
+	servicesName = "_services._dns-sd._udp.local."
+
+	// ttl is TTL of records in multicast responses, legacyTTL of records in unicast responses to one-shot queries.
+	ttl       = 120
+	legacyTTL = 10
+
+	// cacheFlush is set in class of unique records, unicastResponse in class of questions.
+	cacheFlush      = 1 << 15
+	unicastResponse = 1 << 15
+)
+
+// Service is an advertised service instance.
+type Service struct {
+	// Instance is the user visible name, e.g. "cam2ip on pi1".
+	Instance string
+	// Type is the service type, e.g. "_http._tcp".
+	Type string
+	Port int
+	// Text is TXT record with key=value pairs.
+	Text map[string]string
+}
+
+// name returns instance name, dots would split it into labels so they are replaced and it is limited to one label.
+func (s *Service) name() string {
+	instance := strings.ReplaceAll(s.Instance, ".", "-")
+	if len(instance) > 63 {
+		instance = instance[:63]
+	}
+
+	return instance + "." + s.typeName()
+}
+
+func (s *Service) typeName() string {
+	return s.Type + ".local."
+}
+
+// Responder answers mDNS queries for services of this host.
+type Responder struct {
+	host     string
+	services []Service
+
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	closing chan struct{}
+}
+
+// NewResponder returns new Responder, host is the name of this host without domain, e.g. "pi1".
+func NewResponder(host string, services []Service) *Responder {
+	return &Responder{
+		host:     HostLabel(host) + ".local.",
+		services: services,
+		closing:  make(chan struct{}),
+	}
+}
+
+// ListenAndServe joins the multicast group, announces services and answers queries.
+func (r *Responder) ListenAndServe() error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+
+	// Announcement is repeated, the first packet may be lost (RFC 6762, section 8.3)
+	go func() {
+		for i := 0; i < 3; i++ {
+			r.announce(ttl)
+
+			select {
+			case <-r.closing:
+				return
+			case <-time.After(time.Second << i):
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		local, err := localIP(addr)
+		if err != nil {
+			continue
+		}
+
+		resp, unicast := r.answer(buf[:n], addr, []net.IP{local})
+		if resp == nil {
+			continue
+		}
+
+		to := groupAddr
+		if unicast {
+			to = addr
+		}
+
+		if _, err := conn.WriteToUDP(resp, to); err != nil {
+			log.Printf("mdns: %v", err)
+		}
+	}
+}
+
+// Close sends goodbye packet and leaves the multicast group.
+func (r *Responder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+
+	close(r.closing)
+
+	// Records with zero TTL are removed from caches at once
+	if msg, err := r.records(0, interfaceIPs()); err == nil {
+		_, _ = r.conn.WriteToUDP(msg, groupAddr)
+	}
+
+	err := r.conn.Close()
+	r.conn = nil
+
+	return err
+}
+
+// announce sends unsolicited response with all records.
+func (r *Responder) announce(ttl uint32) {
+	msg, err := r.records(ttl, interfaceIPs())
+	if err != nil {
+		log.Printf("mdns: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return
+	}
+
+	if _, err := r.conn.WriteToUDP(msg, groupAddr); err != nil {
+		log.Printf("mdns: %v", err)
+	}
+}
+
+// answer returns response to query and whether it is sent to addr instead of the group, nil if there is nothing to answer.
+func (r *Responder) answer(query []byte, addr *net.UDPAddr, ips []net.IP) ([]byte, bool) {
+	var p dnsmessage.Parser
+
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return nil, false
+	}
+
+	questions, err := p.AllQuestions()
+	if err != nil || len(questions) == 0 {
+		return nil, false
+	}
+
+	// Query from other port than 5353 is one-shot query of simple resolver (RFC 6762, section 6.7)
+	legacy := addr.Port != groupAddr.Port
+
+	var answers, extra []dnsmessage.Resource
+	unicast, host := legacy, false
+
+	for _, q := range questions {
+		if q.Class&unicastResponse != 0 {
+			unicast = true
+		}
+
+		a, e, ok := r.match(q, ips)
+		answers = append(answers, a...)
+		extra = append(extra, e...)
+		host = host || ok
+	}
+
+	if len(answers) == 0 {
+		return nil, false
+	}
+
+	t := uint32(ttl)
+	if legacy {
+		t = legacyTTL
+	}
+
+	if host {
+		extra = append(extra, r.hostRecords(ips)...)
+	}
+
+	hdr := dnsmessage.Header{Response: true, Authoritative: true}
+	if legacy {
+		hdr.ID = h.ID
+	} else {
+		questions = nil
+	}
+
+	msg, err := build(hdr, questions, answers, extra, t, !legacy)
+	if err != nil {
+		log.Printf("mdns: %v", err)
+		return nil, false
+	}
+
+	return msg, unicast
+}
+
+// match returns answers and additional records for question, and whether host addresses should be added.
+func (r *Responder) match(q dnsmessage.Question, ips []net.IP) (answers, extra []dnsmessage.Resource, host bool) {
+	name := strings.ToLower(q.Name.String())
+	all := q.Type == dnsmessage.TypeALL
+
+	if name == servicesName && (all || q.Type == dnsmessage.TypePTR) {
+		seen := make(map[string]bool)
+		for _, s := range r.services {
+			if !seen[s.typeName()] {
+				seen[s.typeName()] = true
+				answers = append(answers, ptr(servicesName, s.typeName()))
+			}
+		}
+
+		return answers, nil, false
+	}
+
+	for _, s := range r.services {
+		switch name {
+		case strings.ToLower(s.typeName()):
+			if all || q.Type == dnsmessage.TypePTR {
+				answers = append(answers, ptr(s.typeName(), s.name()))
+				extra = append(extra, r.serviceRecords(s)...)
+				host = true
+			}
+		case strings.ToLower(s.name()):
+			if all || q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeTXT {
+				answers = append(answers, r.serviceRecords(s)...)
+				host = true
+			}
+		}
+	}
+
+	if name == strings.ToLower(r.host) && (all || q.Type == dnsmessage.TypeA) {
+		answers = append(answers, r.hostRecords(ips)...)
+	}
+
+	return answers, extra, host
+}
+
+// records returns message with records of all services and host, as announcement or goodbye.
+func (r *Responder) records(ttl uint32, ips []net.IP) ([]byte, error) {
+	var answers []dnsmessage.Resource
+	for _, s := range r.services {
+		answers = append(answers, ptr(s.typeName(), s.name()))
+		answers = append(answers, r.serviceRecords(s)...)
+	}
+
+	answers = append(answers, r.hostRecords(ips)...)
+
+	return build(dnsmessage.Header{Response: true, Authoritative: true}, nil, answers, nil, ttl, true)
+}
+
+// serviceRecords returns SRV and TXT records of service.
+func (r *Responder) serviceRecords(s Service) []dnsmessage.Resource {
+	name := mustName(s.name())
+
+	keys := make([]string, 0, len(s.Text))
+	for k := range s.Text {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	txt := make([]string, 0, len(keys))
+	for _, k := range keys {
+		txt = append(txt, k+"="+s.Text[k])
+	}
+
+	if len(txt) == 0 {
+		txt = []string{""}
+	}
+
+	return []dnsmessage.Resource{
+		{Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeSRV}, Body: &dnsmessage.SRVResource{Target: mustName(r.host), Port: uint16(s.Port)}},
+		{Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeTXT}, Body: &dnsmessage.TXTResource{TXT: txt}},
+	}
+}
+
+// hostRecords returns A records of host.
+func (r *Responder) hostRecords(ips []net.IP) []dnsmessage.Resource {
+	var ret []dnsmessage.Resource
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			ret = append(ret, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: mustName(r.host), Type: dnsmessage.TypeA},
+				Body:   &dnsmessage.AResource{A: [4]byte(ip4)},
+			})
+		}
+	}
+
+	return ret
+}
+
+// build returns message, TTL and class are set on all records and records without body are dropped.
+func build(hdr dnsmessage.Header, questions []dnsmessage.Question, answers, extra []dnsmessage.Resource, ttl uint32, flush bool) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 1500), hdr)
+	b.EnableCompression()
+
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+
+	for _, q := range questions {
+		q.Class &^= unicastResponse
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+
+	add := func(list []dnsmessage.Resource) error {
+		for _, res := range list {
+			if res.Body == nil {
+				continue
+			}
+
+			res.Header.TTL = ttl
+			res.Header.Class = dnsmessage.ClassINET
+			if flush && res.Header.Type != dnsmessage.TypePTR {
+				res.Header.Class |= cacheFlush
+			}
+
+			var err error
+			switch body := res.Body.(type) {
+			case *dnsmessage.PTRResource:
+				err = b.PTRResource(res.Header, *body)
+			case *dnsmessage.SRVResource:
+				err = b.SRVResource(res.Header, *body)
+			case *dnsmessage.TXTResource:
+				err = b.TXTResource(res.Header, *body)
+			case *dnsmessage.AResource:
+				err = b.AResource(res.Header, *body)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	if err := add(answers); err != nil {
+		return nil, err
+	}
+
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+
+	if err := add(extra); err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+// Entry is a service instance found by Browse.
+type Entry struct {
+	Instance string
+	Host     string
+	Port     int
+	Addrs    []net.IP
+	Text     map[string]string
+}
+
+// Browse sends query for service type, e.g. "_cam2ip._tcp", and returns instances that answered within timeout.
+func Browse(service string, timeout time.Duration) ([]Entry, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	typeName := strings.TrimSuffix(service, ".") + ".local."
+
+	query, err := build(dnsmessage.Header{}, []dnsmessage.Question{{
+		Name:  mustName(typeName),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	}}, nil, nil, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.WriteToUDP(query, groupAddr); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	c := newCollector()
+	buf := make([]byte, 9000)
+
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				break
+			}
+
+			return nil, err
+		}
+
+		c.add(buf[:n])
+	}
+
+	return c.entries(typeName), nil
+}
+
+// collector collects records from responses.
+type collector struct {
+	instances map[string]string
+	srv       map[string]dnsmessage.SRVResource
+	txt       map[string][]string
+	addrs     map[string][]net.IP
+}
+
+func newCollector() *collector {
+	return &collector{
+		instances: make(map[string]string),
+		srv:       make(map[string]dnsmessage.SRVResource),
+		txt:       make(map[string][]string),
+		addrs:     make(map[string][]net.IP),
+	}
+}
+
+// add adds records of response msg.
+func (c *collector) add(msg []byte) {
+	var p dnsmessage.Parser
+
+	h, err := p.Start(msg)
+	if err != nil || !h.Response {
+		return
+	}
+
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+
+	var list []dnsmessage.Resource
+
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return
+	}
+	list = append(list, answers...)
+
+	if err := p.SkipAllAuthorities(); err != nil {
+		return
+	}
+
+	// Additional records are optional, answers are useful without them
+	extra, _ := p.AllAdditionals()
+	list = append(list, extra...)
+
+	for _, res := range list {
+		name := strings.ToLower(res.Header.Name.String())
+
+		switch body := res.Body.(type) {
+		case *dnsmessage.PTRResource:
+			c.instances[strings.ToLower(body.PTR.String())] = name
+		case *dnsmessage.SRVResource:
+			c.srv[name] = *body
+		case *dnsmessage.TXTResource:
+			c.txt[name] = body.TXT
+		case *dnsmessage.AResource:
+			ip := net.IP(body.A[:])
+			if !containsIP(c.addrs[name], ip) {
+				c.addrs[name] = append(c.addrs[name], ip)
+			}
+		}
+	}
+}
+
+// entries returns complete instances of service type, sorted by name.
+func (c *collector) entries(typeName string) []Entry {
+	typeName = strings.ToLower(typeName)
+
+	var ret []Entry
+	for name, t := range c.instances {
+		srv, ok := c.srv[name]
+		if t != typeName || !ok {
+			continue
+		}
+
+		host := strings.ToLower(srv.Target.String())
+
+		e := Entry{
+			Instance: strings.TrimSuffix(name, "."+typeName),
+			Host:     host,
+			Port:     int(srv.Port),
+			Addrs:    c.addrs[host],
+			Text:     make(map[string]string),
+		}
+
+		for _, s := range c.txt[name] {
+			if k, v, ok := strings.Cut(s, "="); ok && k != "" {
+				e.Text[k] = v
+			}
+		}
+
+		ret = append(ret, e)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Instance < ret[j].Instance
+	})
+
+	return ret
+}
+
+// HostLabel returns host name usable as a single DNS label, e.g. "pi1" for "pi1.lan".
+func HostLabel(host string) string {
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	host, _, _ = strings.Cut(host, ".")
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(host) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+
+	if b.Len() == 0 {
+		return "cam2ip"
+	}
+
+	return b.String()
+}
+
+func ptr(name, target string) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: mustName(name), Type: dnsmessage.TypePTR},
+		Body:   &dnsmessage.PTRResource{PTR: mustName(target)},
+	}
+}
+
+// mustName returns DNS name, names are built from labels of limited length so they fit.
+func mustName(s string) dnsmessage.Name {
+	n, _ := dnsmessage.NewName(s)
+
+	return n
+}
+
+// interfaceIPs returns IPv4 addresses of multicast interfaces that are up.
+func interfaceIPs() []net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var ret []net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.To4() != nil {
+				ret = append(ret, ipn.IP.To4())
+			}
+		}
+	}
+
+	return ret
+}
+
+// localIP returns address of the interface used to reach addr.
+func localIP(addr *net.UDPAddr) (net.IP, error) {
+	c, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.Close()
+
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func containsIP(list []net.IP, ip net.IP) bool {
+	for _, l := range list {
+		if l.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
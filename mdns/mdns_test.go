@@ -0,0 +1,97 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func query(t *testing.T, name string, typ dnsmessage.Type) []byte {
+	t.Helper()
+
+	msg, err := build(dnsmessage.Header{ID: 7}, []dnsmessage.Question{{Name: mustName(name), Type: typ, Class: dnsmessage.ClassINET}}, nil, nil, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func TestAnswer(t *testing.T) {
+	r := NewResponder("Pi1.lan", []Service{
+		{Instance: "cam2ip on pi1", Type: "_cam2ip._tcp", Port: 56000, Text: map[string]string{"mjpeg": "/mjpeg", "main": "640x480"}},
+		{Instance: "cam2ip on pi1", Type: "_http._tcp", Port: 56000, Text: map[string]string{"path": "/"}},
+	})
+
+	ips := []net.IP{net.IPv4(192, 168, 1, 10)}
+	legacy := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 40000}
+
+	resp, unicast := r.answer(query(t, "_cam2ip._tcp.local.", dnsmessage.TypePTR), legacy, ips)
+	if resp == nil || !unicast {
+		t.Fatalf("no unicast answer for one-shot query")
+	}
+
+	var p dnsmessage.Parser
+	if h, err := p.Start(resp); err != nil || h.ID != 7 || !h.Response {
+		t.Fatalf("invalid header %+v: %v", h, err)
+	}
+
+	c := newCollector()
+	c.add(resp)
+
+	entries := c.entries("_cam2ip._tcp.local.")
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+
+	e := entries[0]
+	if e.Instance != "cam2ip on pi1" || e.Host != "pi1.local." || e.Port != 56000 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+
+	if len(e.Addrs) != 1 || !e.Addrs[0].Equal(ips[0]) {
+		t.Errorf("unexpected addrs %v", e.Addrs)
+	}
+
+	if e.Text["mjpeg"] != "/mjpeg" || e.Text["main"] != "640x480" {
+		t.Errorf("unexpected text %v", e.Text)
+	}
+
+	if len(c.entries("_http._tcp.local.")) != 0 {
+		t.Errorf("http service was not asked for")
+	}
+
+	multicast := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20), Port: 5353}
+
+	resp, unicast = r.answer(query(t, servicesName, dnsmessage.TypePTR), multicast, ips)
+	if resp == nil || unicast {
+		t.Fatalf("no multicast answer for service enumeration")
+	}
+
+	p = dnsmessage.Parser{}
+	_, _ = p.Start(resp)
+	_ = p.SkipAllQuestions()
+
+	answers, err := p.AllAnswers()
+	if err != nil || len(answers) != 2 {
+		t.Fatalf("got %d service types: %v", len(answers), err)
+	}
+
+	resp, _ = r.answer(query(t, "PI1.local.", dnsmessage.TypeA), multicast, ips)
+	if resp == nil {
+		t.Fatalf("no answer for host")
+	}
+
+	if resp, _ = r.answer(query(t, "_ipp._tcp.local.", dnsmessage.TypePTR), multicast, ips); resp != nil {
+		t.Errorf("answered query for other service")
+	}
+}
+
+func TestHostLabel(t *testing.T) {
+	for host, want := range map[string]string{"Pi1.lan": "pi1", "my_cam": "my-cam", "cam2ip-3": "cam2ip-3"} {
+		if got := HostLabel(host); got != want {
+			t.Errorf("HostLabel(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package metrics
+
+// Metrics of cam2ip, handler label is the endpoint, e.g. mjpeg, socket, y4m or rtsp.
+var (
+	CaptureFPS      = Default.NewGauge("cam2ip_capture_fps", "Frames per second captured from camera.")
+	CaptureFrames   = Default.NewCounter("cam2ip_capture_frames_total", "Frames captured from camera.")
+	CaptureDuration = Default.NewHistogram("cam2ip_capture_duration_seconds", "Time to read a frame from camera.", LatencyBuckets)
+	DecodeDuration  = Default.NewHistogram("cam2ip_decode_duration_seconds", "Time to decode a JPEG image.", LatencyBuckets)
+	EncodeDuration  = Default.NewHistogram("cam2ip_encode_duration_seconds", "Time to encode a JPEG image.", LatencyBuckets)
+
+	FramesDropped = Default.NewCounterVec("cam2ip_frames_dropped_total", "Frames not delivered to viewers because they were too slow.", "handler")
+	Viewers       = Default.NewGaugeVec("cam2ip_viewers", "Active viewers.", "handler")
+	BytesSent     = Default.NewCounterVec("cam2ip_sent_bytes_total", "Bytes of frames sent to viewers.", "handler")
+
+	CameraReconnects = Default.NewCounter("cam2ip_camera_reconnects_total", "Times camera came back after being offline.")
+	Logins           = Default.NewCounterVec("cam2ip_logins_total", "Login attempts by result, success or failure.", "result")
+)
+
+// Dropped counts frames skipped between last delivered sequence number and seq, and sets last to seq.
+func Dropped(handler string, last *uint64, seq uint64) {
+	if *last != 0 && seq > *last+1 {
+		FramesDropped.With(handler).Add(float64(seq - *last - 1))
+	}
+
+	*last = seq
+}
+
+func init() {
+	// Series exist from start, so rates and alerts work before the first viewer or login.
+	for _, handler := range []string{"jpeg", "mjpeg", "socket", "y4m", "rtsp"} {
+		Viewers.With(handler)
+		BytesSent.With(handler)
+	}
+
+	for _, handler := range []string{"mjpeg", "socket", "rtsp"} {
+		FramesDropped.With(handler)
+	}
+
+	Logins.With("success")
+	Logins.With("failure")
+}
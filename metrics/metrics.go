@@ -0,0 +1,349 @@
+// Package metrics implements counters, gauges and histograms exposed in Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// LatencyBuckets are histogram buckets for durations in seconds, from 1ms to 1s.
+var LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// value is float64 updated atomically.
+type value struct {
+	bits atomic.Uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	v.bits.Store(math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(v.bits.Load())
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v value
+}
+
+// Inc increments counter by one.
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add adds non-negative delta to counter.
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.v.add(delta)
+	}
+}
+
+// Value returns counter value.
+func (c *Counter) Value() float64 {
+	return c.v.get()
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v value
+}
+
+// Set sets gauge value.
+func (g *Gauge) Set(f float64) {
+	g.v.set(f)
+}
+
+// Inc increments gauge by one.
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+// Dec decrements gauge by one.
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+// Value returns gauge value.
+func (g *Gauge) Value() float64 {
+	return g.v.get()
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sum     value
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets))}
+}
+
+// Observe adds observation.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		h.counts[i].Add(1)
+	}
+
+	h.count.Add(1)
+	h.sum.add(v)
+}
+
+// Count returns number of observations.
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+// metric is a named metric with help and type, children are keyed by label values.
+type metric struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	buckets []float64
+
+	mu       sync.Mutex
+	children map[string]any
+}
+
+// child returns metric for label values, creating it on first use.
+func (m *metric) child(values []string) any {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.children[key]
+	if !ok {
+		switch m.typ {
+		case "counter":
+			c = &Counter{}
+		case "gauge":
+			c = &Gauge{}
+		case "histogram":
+			c = newHistogram(m.buckets)
+		}
+
+		m.children[key] = c
+	}
+
+	return c
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	m *metric
+}
+
+// With returns counter for label values.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.m.child(values).(*Counter)
+}
+
+// GaugeVec is a gauge with labels.
+type GaugeVec struct {
+	m *metric
+}
+
+// With returns gauge for label values.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.m.child(values).(*Gauge)
+}
+
+// Registry is a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// NewRegistry returns new Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry used by package level functions.
+var Default = NewRegistry()
+
+func (r *Registry) register(name, help, typ string, buckets []float64, labels []string) *metric {
+	m := &metric{name: name, help: help, typ: typ, labels: labels, buckets: buckets, children: make(map[string]any)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, old := range r.metrics {
+		if old.name == name {
+			panic("metrics: duplicate metric " + name)
+		}
+	}
+
+	r.metrics = append(r.metrics, m)
+
+	return m
+}
+
+// NewCounter registers counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.register(name, help, "counter", nil, nil).child(nil).(*Counter)
+}
+
+// NewCounterVec registers counter with labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", nil, labels)}
+}
+
+// NewGauge registers gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.register(name, help, "gauge", nil, nil).child(nil).(*Gauge)
+}
+
+// NewGaugeVec registers gauge with labels.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", nil, labels)}
+}
+
+// NewHistogram registers histogram with buckets, upper bounds in increasing order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.register(name, help, "histogram", buckets, nil).child(nil).(*Histogram)
+}
+
+// WriteTo writes metrics in Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for _, m := range metrics {
+		m.mu.Lock()
+
+		keys := make([]string, 0, len(m.children))
+		for k := range m.children {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, escapeHelp(m.help), m.name, m.typ)
+
+		leNames := append(append([]string(nil), m.labels...), "le")
+
+		for _, k := range keys {
+			var values []string
+			if len(m.labels) > 0 {
+				values = strings.Split(k, "\xff")
+			}
+
+			labels := formatLabels(m.labels, values)
+
+			switch c := m.children[k].(type) {
+			case *Counter:
+				fmt.Fprintf(bw, "%s%s %s\n", m.name, labels, formatFloat(c.Value()))
+			case *Gauge:
+				fmt.Fprintf(bw, "%s%s %s\n", m.name, labels, formatFloat(c.Value()))
+			case *Histogram:
+				var cumulative uint64
+				for i, b := range c.buckets {
+					cumulative += c.counts[i].Load()
+					fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, formatLabels(leNames, append(values[:len(values):len(values)], formatFloat(b))), cumulative)
+				}
+
+				count := c.count.Load()
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, formatLabels(leNames, append(values[:len(values):len(values)], "+Inf")), count)
+				fmt.Fprintf(bw, "%s_sum%s %s\n", m.name, labels, formatFloat(c.sum.get()))
+				fmt.Fprintf(bw, "%s_count%s %d\n", m.name, labels, count)
+			}
+		}
+
+		m.mu.Unlock()
+	}
+
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+
+	b.WriteByte('{')
+
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounterVec("test_requests_total", "Requests.", "handler")
+	g := r.NewGauge("test_viewers", "Viewers.")
+	h := r.NewHistogram("test_duration_seconds", "Duration.", []float64{0.1, 1})
+
+	c.With("mjpeg").Add(3)
+	c.With(`a"b`).Inc()
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var sb strings.Builder
+
+	_, err := r.WriteTo(&sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{handler="a\"b"} 1
+test_requests_total{handler="mjpeg"} 3
+# HELP test_viewers Viewers.
+# TYPE test_viewers gauge
+test_viewers 1
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5.55
+test_duration_seconds_count 3
+`
+
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestDropped(t *testing.T) {
+	var last uint64
+
+	for _, seq := range []uint64{5, 6, 9, 10} {
+		Dropped("test", &last, seq)
+	}
+
+	if v := FramesDropped.With("test").Value(); v != 2 {
+		t.Errorf("dropped %v frames, want 2", v)
+	}
+}
@@ -0,0 +1,228 @@
+// Package motion implements motion detection by comparing frames with a running average background.
+package motion
+
+import (
+	"image"
+	"log"
+	"math"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	im "github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+const (
+	// analysisWidth is the width frames are scaled to before comparison.
+	analysisWidth = 64
+	// learnRate is how fast background follows the scene, e.g. lighting changes.
+	learnRate = 0.05
+	// coveredDeviation is the luma standard deviation below which camera is covered.
+	coveredDeviation = 6
+	// sceneChange is the percentage of changed pixels at which camera is moved or blinded.
+	sceneChange = 80
+	// tamperDuration is how long tampering has to last before it is reported.
+	tamperDuration = 2 * time.Second
+)
+
+// Tamper reasons.
+const (
+	TamperCovered     = "covered"
+	TamperSceneChange = "scene_change"
+)
+
+// Result is the result of frame analysis.
+type Result struct {
+	// Score is percentage of changed pixels.
+	Score float64
+	// Start is true when motion starts and End is true when it ends.
+	Start bool
+	End   bool
+	// Tamper is the reason when tampering is detected, it is reported once until scene is normal again.
+	Tamper string
+}
+
+// Options are motion detection options.
+type Options struct {
+	// Threshold is the percentage of changed pixels that is motion.
+	Threshold float64
+	// PixelDelta is the luma difference at which pixel is changed.
+	PixelDelta float64
+	// Cooldown is the time without motion after which motion ends.
+	Cooldown time.Duration
+	// FPS is the analysis rate.
+	FPS float64
+}
+
+// DefaultOptions are default motion detection options.
+var DefaultOptions = Options{
+	Threshold:  1.5,
+	PixelDelta: 25,
+	Cooldown:   5 * time.Second,
+	FPS:        5,
+}
+
+// Detector detects motion.
+type Detector struct {
+	opts Options
+
+	background []float64
+	width      int
+	height     int
+	active     bool
+	lastMotion time.Time
+
+	tamperSince time.Time
+	tampered    bool
+}
+
+// NewDetector returns new Detector.
+func NewDetector(opts Options) *Detector {
+	if opts.PixelDelta <= 0 {
+		opts.PixelDelta = DefaultOptions.PixelDelta
+	}
+
+	if opts.FPS <= 0 {
+		opts.FPS = DefaultOptions.FPS
+	}
+
+	return &Detector{opts: opts}
+}
+
+// Active reports whether motion is in progress.
+func (d *Detector) Active() bool {
+	return d.active
+}
+
+// Detect compares image captured at t with background.
+func (d *Detector) Detect(img image.Image, t time.Time) (r Result) {
+	small := im.ResizeTo(img, analysisWidth, 0)
+	b := small.Bounds()
+
+	if d.background == nil || b.Dx() != d.width || b.Dy() != d.height {
+		d.width, d.height = b.Dx(), b.Dy()
+		d.background = make([]float64, d.width*d.height)
+
+		i := 0
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				d.background[i] = luma(small, x, y)
+				i++
+			}
+		}
+
+		return
+	}
+
+	changed := 0
+	var sum, sumSq float64
+
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			l := luma(small, x, y)
+			if math.Abs(l-d.background[i]) > d.opts.PixelDelta {
+				changed++
+			}
+
+			sum += l
+			sumSq += l * l
+
+			d.background[i] += learnRate * (l - d.background[i])
+			i++
+		}
+	}
+
+	n := float64(len(d.background))
+	mean := sum / n
+	deviation := math.Sqrt(max(sumSq/n-mean*mean, 0))
+
+	r.Score = float64(changed) * 100 / n
+
+	if r.Score >= d.opts.Threshold {
+		d.lastMotion = t
+
+		if !d.active {
+			d.active = true
+			r.Start = true
+		}
+	} else if d.active && t.Sub(d.lastMotion) >= d.opts.Cooldown {
+		d.active = false
+		r.End = true
+	}
+
+	reason := ""
+	switch {
+	case deviation < coveredDeviation:
+		reason = TamperCovered
+	case r.Score >= sceneChange:
+		reason = TamperSceneChange
+	}
+
+	switch {
+	case reason == "":
+		d.tamperSince = time.Time{}
+		d.tampered = false
+	case d.tamperSince.IsZero():
+		d.tamperSince = t
+	case !d.tampered && t.Sub(d.tamperSince) >= tamperDuration:
+		d.tampered = true
+		r.Tamper = reason
+	}
+
+	return
+}
+
+// Run analyzes frames until channel is closed and publishes motion events to bus.
+func (d *Detector) Run(frames <-chan *stream.Frame, bus *events.Bus) {
+	interval := time.Duration(float64(time.Second) / d.opts.FPS)
+
+	var last time.Time
+	var started time.Time
+
+	for f := range frames {
+		if f.Time.Sub(last) < interval*9/10 {
+			continue
+		}
+
+		last = f.Time
+
+		// Blank frames in privacy mode would look like covered camera, background is learned again afterwards.
+		if f.Privacy {
+			d.background = nil
+
+			continue
+		}
+
+		img, err := f.Image()
+		if err != nil {
+			log.Printf("motion: decode: %v", err)
+			continue
+		}
+
+		r := d.Detect(img, f.Time)
+
+		switch {
+		case r.Start:
+			started = f.Time
+			bus.Publish(events.MotionStart, map[string]any{"score": math.Round(r.Score*100) / 100})
+		case r.End:
+			bus.Publish(events.MotionEnd, map[string]any{"duration": f.Time.Sub(started).Seconds()})
+		}
+
+		if r.Tamper != "" {
+			bus.Publish(events.Tamper, map[string]any{"reason": r.Tamper})
+		}
+	}
+
+	if d.active {
+		d.active = false
+		bus.Publish(events.MotionEnd, map[string]any{"duration": time.Since(started).Seconds()})
+	}
+}
+
+func luma(img image.Image, x, y int) float64 {
+	r, g, b, _ := img.At(x, y).RGBA()
+
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+}
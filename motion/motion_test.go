@@ -0,0 +1,97 @@
+package motion
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+)
+
+func frame(box bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 64}), image.Point{}, draw.Src)
+
+	// Texture, so the scene does not look like covered camera.
+	for x := 0; x < 320; x += 20 {
+		draw.Draw(img, image.Rect(x, 0, x+10, 240), image.NewUniform(color.Gray{Y: 128}), image.Point{}, draw.Src)
+	}
+
+	if box {
+		draw.Draw(img, image.Rect(100, 80, 180, 160), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+
+	return img
+}
+
+func TestDetect(t *testing.T) {
+	d := NewDetector(Options{Threshold: 1, Cooldown: time.Second})
+
+	now := time.Now()
+	step := 200 * time.Millisecond
+
+	for i := 0; i < 5; i++ {
+		r := d.Detect(frame(false), now)
+		if r.Score != 0 || r.Start || r.End || r.Tamper != "" {
+			t.Fatalf("motion in static scene: %+v", r)
+		}
+
+		now = now.Add(step)
+	}
+
+	r := d.Detect(frame(true), now)
+	if !r.Start || r.Score < 5 {
+		t.Fatalf("motion not detected: %+v", r)
+	}
+
+	if !d.Active() {
+		t.Error("detector not active")
+	}
+
+	ended := false
+	for i := 0; i < 10 && !ended; i++ {
+		now = now.Add(step)
+		r = d.Detect(frame(false), now)
+
+		if r.Start {
+			t.Fatal("motion started twice")
+		}
+
+		ended = r.End
+	}
+
+	if !ended || d.Active() {
+		t.Error("motion did not end")
+	}
+}
+
+func TestTamper(t *testing.T) {
+	d := NewDetector(Options{Threshold: 1, Cooldown: time.Second})
+
+	now := time.Now()
+	step := 200 * time.Millisecond
+
+	d.Detect(frame(false), now)
+
+	covered := image.NewUniform(color.Black)
+	black := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	draw.Draw(black, black.Bounds(), covered, image.Point{}, draw.Src)
+
+	reported := 0
+	for i := 0; i < 20; i++ {
+		now = now.Add(step)
+
+		r := d.Detect(black, now)
+		if r.Tamper != "" {
+			reported++
+
+			if r.Tamper != TamperCovered {
+				t.Errorf("unexpected tamper reason %s", r.Tamper)
+			}
+		}
+	}
+
+	if reported != 1 {
+		t.Errorf("tamper reported %d times", reported)
+	}
+}
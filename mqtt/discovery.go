@@ -0,0 +1,94 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+var nonID = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// discovery returns Home Assistant discovery configs keyed by topic.
+func (c *Client) discovery() map[string][]byte {
+	id := nonID.ReplaceAllString(c.opts.ClientID, "_")
+
+	device := map[string]any{
+		"identifiers":  []string{id},
+		"name":         c.opts.Name,
+		"manufacturer": c.opts.Name,
+		"sw_version":   c.opts.Version,
+	}
+
+	availability := c.topic("status")
+
+	configs := map[string]map[string]any{
+		"camera/" + id: {
+			"name":  "Camera",
+			"topic": c.topic("snapshot"),
+		},
+		"binary_sensor/" + id + "_motion": {
+			"name":         "Motion",
+			"device_class": "motion",
+			"state_topic":  c.topic("motion"),
+		},
+		"binary_sensor/" + id + "_tamper": {
+			"name":         "Tamper",
+			"device_class": "tamper",
+			"state_topic":  c.topic("tamper"),
+			// Tamper events carry no end, sensor turns off by itself.
+			"value_template": "ON",
+			"off_delay":      60,
+		},
+		"switch/" + id + "_privacy": {
+			"name":          "Privacy",
+			"icon":          "mdi:eye-off",
+			"state_topic":   c.topic("privacy"),
+			"command_topic": c.topic("command/privacy"),
+		},
+		"button/" + id + "_snapshot": {
+			"name":          "Snapshot",
+			"icon":          "mdi:camera",
+			"command_topic": c.topic("command/snapshot"),
+		},
+		"sensor/" + id + "_fps": {
+			"name":                "FPS",
+			"state_topic":         c.topic("stats"),
+			"value_template":      "{{ value_json.fps }}",
+			"unit_of_measurement": "fps",
+			"state_class":         "measurement",
+		},
+		"sensor/" + id + "_viewers": {
+			"name":           "Viewers",
+			"icon":           "mdi:account-eye",
+			"state_topic":    c.topic("stats"),
+			"value_template": "{{ value_json.viewers }}",
+			"state_class":    "measurement",
+		},
+	}
+
+	if c.recorder != nil {
+		configs["switch/"+id+"_recording"] = map[string]any{
+			"name":          "Recording",
+			"icon":          "mdi:record-rec",
+			"state_topic":   c.topic("recording"),
+			"command_topic": c.topic("command/recording"),
+		}
+	}
+
+	ret := make(map[string][]byte, len(configs))
+
+	for key, config := range configs {
+		config["unique_id"] = nonID.ReplaceAllString(key[strings.IndexByte(key, '/')+1:], "_")
+		config["device"] = device
+		config["availability_topic"] = availability
+
+		data, err := json.Marshal(config)
+		if err != nil {
+			continue
+		}
+
+		ret[c.opts.DiscoveryPrefix+"/"+key+"/config"] = data
+	}
+
+	return ret
+}
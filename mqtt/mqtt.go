@@ -0,0 +1,404 @@
+// Package mqtt publishes camera state, events and snapshots to MQTT broker and handles remote commands.
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+const (
+	qos            = 1
+	connectTimeout = 10 * time.Second
+	publishTimeout = 5 * time.Second
+)
+
+// Camera provides frames and privacy control.
+type Camera interface {
+	// Stream returns stream by name, empty name is the main stream.
+	Stream(name string) *stream.Stream
+
+	// SetPrivacy turns privacy mode on or off.
+	SetPrivacy(on bool)
+
+	// Privacy reports whether privacy mode is on.
+	Privacy() bool
+}
+
+// Recorder controls recording.
+type Recorder interface {
+	// StartRecording starts recording.
+	StartRecording() error
+
+	// StopRecording stops recording.
+	StopRecording() error
+
+	// Recording reports whether recording is in progress.
+	Recording() bool
+}
+
+// Options are MQTT options.
+type Options struct {
+	// Broker is the broker URL, e.g. tcp://localhost:1883.
+	Broker   string
+	Username string
+	Password string
+	// ClientID is the client identifier, it is also used as unique id in discovery.
+	ClientID string
+	// Topic is the base topic.
+	Topic string
+	// SnapshotInterval is the period of snapshots, zero disables periodic snapshots.
+	SnapshotInterval time.Duration
+	// StatsInterval is the period of statistics.
+	StatsInterval time.Duration
+	// Discovery enables Home Assistant MQTT discovery.
+	Discovery bool
+	// DiscoveryPrefix is the Home Assistant discovery prefix.
+	DiscoveryPrefix string
+
+	Name    string
+	Version string
+}
+
+// stats is the statistics message.
+type stats struct {
+	FPS       float64 `json:"fps"`
+	Viewers   int     `json:"viewers"`
+	Camera    string  `json:"camera"`
+	Motion    bool    `json:"motion"`
+	Privacy   bool    `json:"privacy"`
+	Recording bool    `json:"recording"`
+}
+
+// Client is MQTT client.
+type Client struct {
+	opts     Options
+	camera   Camera
+	recorder Recorder
+	bus      *events.Bus
+
+	client paho.Client
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New returns new Client, recorder can be nil when recording is not available.
+func New(opts Options, camera Camera, recorder Recorder, bus *events.Bus) *Client {
+	if opts.ClientID == "" {
+		host, _ := os.Hostname()
+		opts.ClientID = "cam2ip-" + host
+	}
+
+	if opts.Topic == "" {
+		opts.Topic = "cam2ip"
+	}
+
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = 30 * time.Second
+	}
+
+	if opts.DiscoveryPrefix == "" {
+		opts.DiscoveryPrefix = "homeassistant"
+	}
+
+	opts.Topic = strings.TrimSuffix(opts.Topic, "/")
+
+	return &Client{
+		opts:     opts,
+		camera:   camera,
+		recorder: recorder,
+		bus:      bus,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start connects to broker and starts publishing, broker that is not reachable is retried in background.
+func (c *Client) Start() error {
+	if c.opts.Broker == "" {
+		return errors.New("mqtt: broker is not set")
+	}
+
+	po := paho.NewClientOptions().
+		AddBroker(c.opts.Broker).
+		SetClientID(c.opts.ClientID).
+		SetUsername(c.opts.Username).
+		SetPassword(c.opts.Password).
+		SetWill(c.topic("status"), "offline", qos, true).
+		SetConnectTimeout(connectTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("mqtt: connection lost: %v", err)
+		})
+
+	c.client = paho.NewClient(po)
+
+	// Connection is retried until broker is reachable, state is published on connect.
+	token := c.client.Connect()
+	go func() {
+		<-token.Done()
+
+		if token.Error() != nil {
+			log.Printf("mqtt: connect: %v", token.Error())
+		}
+	}()
+
+	ch, cancel := c.bus.Subscribe(64)
+
+	c.wg.Add(2)
+	go c.forward(ch, cancel)
+	go c.periodic()
+
+	return nil
+}
+
+// Close publishes offline state and disconnects.
+func (c *Client) Close() error {
+	if c.client == nil {
+		return nil
+	}
+
+	close(c.done)
+	c.wg.Wait()
+
+	if c.client.IsConnected() {
+		c.publish("status", []byte("offline"), true)
+	}
+
+	c.client.Disconnect(250)
+
+	return nil
+}
+
+// onConnect publishes state and subscribes to commands, it is called on every (re)connect.
+func (c *Client) onConnect(client paho.Client) {
+	c.publish("status", []byte("online"), true)
+
+	if c.opts.Discovery {
+		for topic, payload := range c.discovery() {
+			client.Publish(topic, qos, true, payload)
+		}
+	}
+
+	c.publishState()
+
+	handlers := map[string]paho.MessageHandler{
+		c.topic("command/snapshot"):  func(_ paho.Client, _ paho.Message) { c.snapshot() },
+		c.topic("command/privacy"):   c.onPrivacy,
+		c.topic("command/recording"): c.onRecording,
+	}
+
+	for topic, handler := range handlers {
+		token := client.Subscribe(topic, qos, handler)
+		if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+			log.Printf("mqtt: subscribe %s: %v", topic, token.Error())
+		}
+	}
+}
+
+func (c *Client) onPrivacy(_ paho.Client, msg paho.Message) {
+	on, ok := parseSwitch(msg.Payload())
+	if !ok {
+		log.Printf("mqtt: invalid privacy command %q", msg.Payload())
+
+		return
+	}
+
+	// Privacy events are forwarded to state topic.
+	c.camera.SetPrivacy(on)
+}
+
+func (c *Client) onRecording(_ paho.Client, msg paho.Message) {
+	on, ok := parseSwitch(msg.Payload())
+	if !ok {
+		log.Printf("mqtt: invalid recording command %q", msg.Payload())
+
+		return
+	}
+
+	if c.recorder == nil {
+		log.Printf("mqtt: recording is not available")
+
+		return
+	}
+
+	var err error
+	if on {
+		err = c.recorder.StartRecording()
+	} else {
+		err = c.recorder.StopRecording()
+	}
+
+	if err != nil {
+		log.Printf("mqtt: recording: %v", err)
+	}
+
+	c.publish("recording", onOff(c.recorder.Recording()), true)
+}
+
+// forward publishes bus events until client is closed.
+func (c *Client) forward(ch <-chan events.Event, cancel func()) {
+	defer c.wg.Done()
+	defer cancel()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+
+			c.publish("events/"+string(e.Type), data, false)
+
+			switch e.Type {
+			case events.MotionStart, events.MotionEnd:
+				c.publish("motion", onOff(e.Type == events.MotionStart), true)
+			case events.Tamper:
+				c.publish("tamper", data, false)
+			case events.PrivacyOn, events.PrivacyOff:
+				c.publish("privacy", onOff(e.Type == events.PrivacyOn), true)
+			case events.RecordingStarted:
+				c.publish("recording", onOff(true), true)
+			}
+		}
+	}
+}
+
+// periodic publishes snapshots and statistics until client is closed.
+func (c *Client) periodic() {
+	defer c.wg.Done()
+
+	statsTicker := time.NewTicker(c.opts.StatsInterval)
+	defer statsTicker.Stop()
+
+	var snapshot <-chan time.Time
+	if c.opts.SnapshotInterval > 0 {
+		t := time.NewTicker(c.opts.SnapshotInterval)
+		defer t.Stop()
+
+		snapshot = t.C
+	}
+
+	var lastSeq uint64
+	var lastTime time.Time
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-snapshot:
+			c.snapshot()
+		case now := <-statsTicker.C:
+			fps := 0.0
+
+			if f := c.camera.Stream(stream.MainStream).Latest(); f != nil {
+				if !lastTime.IsZero() && f.Seq >= lastSeq {
+					fps = float64(f.Seq-lastSeq) / now.Sub(lastTime).Seconds()
+				}
+
+				lastSeq, lastTime = f.Seq, now
+			}
+
+			data, err := json.Marshal(c.stats(fps))
+			if err == nil {
+				c.publish("stats", data, false)
+			}
+		}
+	}
+}
+
+func (c *Client) stats(fps float64) stats {
+	status := c.bus.Status()
+
+	s := stats{
+		FPS:     float64(int(fps*10)) / 10,
+		Viewers: status.Viewers,
+		Camera:  status.Camera,
+		Motion:  status.Motion,
+		Privacy: c.camera.Privacy(),
+	}
+
+	if c.recorder != nil {
+		s.Recording = c.recorder.Recording()
+	}
+
+	return s
+}
+
+// publishState publishes retained state topics.
+func (c *Client) publishState() {
+	status := c.bus.Status()
+
+	c.publish("motion", onOff(status.Motion), true)
+	c.publish("privacy", onOff(c.camera.Privacy()), true)
+
+	if c.recorder != nil {
+		c.publish("recording", onOff(c.recorder.Recording()), true)
+	}
+}
+
+// snapshot publishes latest frame of the main stream.
+func (c *Client) snapshot() {
+	f := c.camera.Stream(stream.MainStream).Latest()
+	if f == nil {
+		return
+	}
+
+	data, err := f.JPEG()
+	if err != nil {
+		log.Printf("mqtt: snapshot: %v", err)
+
+		return
+	}
+
+	c.publish("snapshot", data, false)
+}
+
+func (c *Client) publish(topic string, payload []byte, retained bool) {
+	if !c.client.IsConnectionOpen() {
+		return
+	}
+
+	token := c.client.Publish(c.topic(topic), qos, retained, payload)
+	if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+		log.Printf("mqtt: publish %s: %v", topic, token.Error())
+	}
+}
+
+func (c *Client) topic(name string) string {
+	return c.opts.Topic + "/" + name
+}
+
+func parseSwitch(payload []byte) (on, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(string(payload))) {
+	case "on", "true", "1", "start":
+		return true, true
+	case "off", "false", "0", "stop":
+		return false, true
+	}
+
+	return false, false
+}
+
+func onOff(on bool) []byte {
+	if on {
+		return []byte("ON")
+	}
+
+	return []byte("OFF")
+}
@@ -0,0 +1,147 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"image"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/gen2brain/cam2ip/events"
+	im "github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+type reader struct{}
+
+func (r *reader) Read() (image.Image, error) {
+	time.Sleep(20 * time.Millisecond)
+
+	return image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio420), nil
+}
+
+func (r *reader) Close() error { return nil }
+
+func TestDiscovery(t *testing.T) {
+	c := New(Options{ClientID: "cam2ip-test.host", Topic: "home/cam/", Discovery: true, Name: "cam2ip"}, nil, nil, events.NewBus())
+
+	configs := c.discovery()
+
+	data, ok := configs["homeassistant/switch/cam2ip-test_host_privacy/config"]
+	if !ok {
+		t.Fatalf("missing privacy switch config: %v", configs)
+	}
+
+	var config map[string]any
+
+	err := json.Unmarshal(data, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config["command_topic"] != "home/cam/command/privacy" || config["availability_topic"] != "home/cam/status" {
+		t.Errorf("unexpected config %s", data)
+	}
+
+	if _, ok := configs["homeassistant/switch/cam2ip-test_host_recording/config"]; ok {
+		t.Error("recording switch without recorder")
+	}
+}
+
+// TestBroker runs against local broker, e.g. mosquitto, at CAM2IP_MQTT_BROKER or tcp://localhost:1883.
+func TestBroker(t *testing.T) {
+	broker := os.Getenv("CAM2IP_MQTT_BROKER")
+	if broker == "" {
+		broker = "tcp://localhost:1883"
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		t.Skipf("no broker at %s", broker)
+	}
+
+	_ = conn.Close()
+
+	pipeline := stream.New(&reader{}, stream.Options{Encoder: im.DefaultEncoderOptions})
+	defer pipeline.Close()
+
+	topic := "cam2ip-test/" + strings.ReplaceAll(t.Name(), "/", "_")
+
+	var mu sync.Mutex
+	received := make(map[string][]byte)
+
+	sub := paho.NewClient(paho.NewClientOptions().AddBroker(broker).SetClientID("cam2ip-test-sub"))
+	if token := sub.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	defer sub.Disconnect(100)
+
+	token := sub.Subscribe(topic+"/#", 1, func(_ paho.Client, msg paho.Message) {
+		mu.Lock()
+		received[msg.Topic()] = msg.Payload()
+		mu.Unlock()
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	c := New(Options{Broker: broker, ClientID: "cam2ip-test", Topic: topic}, pipeline, nil, events.Default)
+
+	err = c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func(name, want string) {
+		t.Helper()
+
+		for i := 0; i < 50; i++ {
+			mu.Lock()
+			got, ok := received[topic+"/"+name]
+			mu.Unlock()
+
+			if ok && (want == "" || string(got) == want) {
+				return
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		t.Errorf("no %q on %s", want, name)
+	}
+
+	wait("status", "online")
+
+	sub.Publish(topic+"/command/privacy", 1, false, "ON").Wait()
+	wait("privacy", "ON")
+
+	if !pipeline.Privacy() {
+		t.Error("privacy command not applied")
+	}
+
+	pipeline.SetPrivacy(false)
+	wait("privacy", "OFF")
+
+	sub.Publish(topic+"/command/snapshot", 1, false, "").Wait()
+	wait("snapshot", "")
+
+	_ = c.Close()
+	wait("status", "offline")
+
+	// Clear retained messages.
+	for _, name := range []string{"status", "privacy", "motion"} {
+		sub.Publish(topic+"/"+name, 1, true, "").Wait()
+	}
+}
@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// sendEmail sends notification with snapshot attached, STARTTLS is used when server supports it.
+func sendEmail(ctx context.Context, t Target, n Notification) error {
+	host, port, err := splitAddress(t.Address)
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(t.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddressList(t.To)
+	if err != nil {
+		return err
+	}
+
+	msg, err := emailMessage(from, to, n)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.Address)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	// Port 465 is SMTP over TLS, other ports start in plain text.
+	if port == 465 {
+		conn = tls.Client(conn, &tls.Config{ServerName: host})
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+
+		return err
+	}
+
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && port != 465 {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if t.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", t.Username, t.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// emailMessage returns MIME message with text body and snapshot attachment.
+func emailMessage(from *mail.Address, to []*mail.Address, n Notification) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	rcpts := ""
+	for i, a := range to {
+		if i > 0 {
+			rcpts += ", "
+		}
+
+		rcpts += a.String()
+	}
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", rcpts)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "["+n.Name+"] "+n.Message))
+	fmt.Fprintf(&buf, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("Content-Transfer-Encoding", "8bit")
+
+	pw, err := mw.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(pw, "%s\r\n\r\nTime: %s\r\nEvent: %s\r\n", n.Message, n.Time.Format(time.RFC1123), n.Type)
+	for k, v := range n.Data {
+		fmt.Fprintf(pw, "%s: %v\r\n", k, v)
+	}
+
+	if len(n.Snapshot) > 0 {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "image/jpeg")
+		h.Set("Content-Transfer-Encoding", "base64")
+		h.Set("Content-Disposition", `attachment; filename="snapshot.jpg"`)
+
+		aw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+
+		// Base64 lines are limited to 76 characters.
+		enc := base64.StdEncoding.EncodeToString(n.Snapshot)
+		for len(enc) > 76 {
+			_, _ = aw.Write([]byte(enc[:76] + "\r\n"))
+			enc = enc[76:]
+		}
+
+		_, _ = aw.Write([]byte(enc + "\r\n"))
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// splitAddress returns host and port of SMTP server address.
+func splitAddress(addr string) (string, int, error) {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.Atoi(p)
+	if err != nil || host == "" {
+		return "", 0, fmt.Errorf("invalid address %q", addr)
+	}
+
+	return host, port, nil
+}
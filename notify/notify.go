@@ -0,0 +1,404 @@
+// Package notify delivers event notifications to webhooks and email recipients.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+// Target kinds.
+const (
+	KindWebhook = "webhook"
+	KindEmail   = "email"
+)
+
+const (
+	sendTimeout = 15 * time.Second
+)
+
+// DefaultEvents are events a target is notified about when it has no filter.
+var DefaultEvents = []events.Type{events.MotionStart, events.Tamper, events.CameraOffline, events.AuthFailed}
+
+// Target is a notification target with its rule.
+type Target struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Enabled bool   `json:"enabled"`
+
+	// URL is the webhook URL.
+	URL string `json:"url,omitempty"`
+
+	// Address is the SMTP server address, host:port, port 465 uses implicit TLS.
+	Address  string `json:"address,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	From     string `json:"from,omitempty"`
+	// To is comma separated list of recipients.
+	To string `json:"to,omitempty"`
+
+	// Events are event types target is notified about, empty is DefaultEvents.
+	Events []events.Type `json:"events"`
+	// Cooldown is the minimum number of seconds between notifications of the same event type.
+	Cooldown int `json:"cooldown"`
+	// QuietStart and QuietEnd are local times, HH:MM, between which notifications are not sent.
+	QuietStart string `json:"quiet_start,omitempty"`
+	QuietEnd   string `json:"quiet_end,omitempty"`
+}
+
+// Validate checks target configuration.
+func (t *Target) Validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return errors.New("name is required")
+	}
+
+	switch t.Kind {
+	case KindWebhook:
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url %q", t.URL)
+		}
+	case KindEmail:
+		if _, _, err := splitAddress(t.Address); err != nil {
+			return fmt.Errorf("invalid smtp address %q", t.Address)
+		}
+
+		if _, err := mail.ParseAddress(t.From); err != nil {
+			return fmt.Errorf("invalid from address %q", t.From)
+		}
+
+		if _, err := mail.ParseAddressList(t.To); err != nil {
+			return fmt.Errorf("invalid recipients %q", t.To)
+		}
+	default:
+		return fmt.Errorf("invalid kind %q", t.Kind)
+	}
+
+	if t.Cooldown < 0 {
+		return errors.New("cooldown must not be negative")
+	}
+
+	if (t.QuietStart == "") != (t.QuietEnd == "") {
+		return errors.New("quiet hours need both start and end")
+	}
+
+	for _, v := range []string{t.QuietStart, t.QuietEnd} {
+		if _, err := parseClock(v); v != "" && err != nil {
+			return fmt.Errorf("invalid quiet hours time %q", v)
+		}
+	}
+
+	return nil
+}
+
+// Wants reports whether target is notified about event type.
+func (t *Target) Wants(typ events.Type) bool {
+	list := t.Events
+	if len(list) == 0 {
+		list = DefaultEvents
+	}
+
+	for _, e := range list {
+		if e == typ {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Quiet reports whether now is within target quiet hours.
+func (t *Target) Quiet(now time.Time) bool {
+	start, err := parseClock(t.QuietStart)
+	if err != nil {
+		return false
+	}
+
+	end, err := parseClock(t.QuietEnd)
+	if err != nil || start == end {
+		return false
+	}
+
+	m := now.Hour()*60 + now.Minute()
+
+	// Quiet hours can span midnight, e.g. 22:00-07:00.
+	if start < end {
+		return m >= start && m < end
+	}
+
+	return m >= start || m < end
+}
+
+// Notification is a message sent to targets.
+type Notification struct {
+	Name    string         `json:"name"`
+	Type    events.Type    `json:"type"`
+	Time    time.Time      `json:"time"`
+	Message string         `json:"message"`
+	Data    map[string]any `json:"data,omitempty"`
+	Test    bool           `json:"test,omitempty"`
+
+	// Snapshot is JPEG image attached to notification, it can be empty.
+	Snapshot []byte `json:"-"`
+}
+
+// Store provides notification targets.
+type Store interface {
+	// GetNotificationTargets returns all targets.
+	GetNotificationTargets() ([]Target, error)
+}
+
+// Camera provides snapshots.
+type Camera interface {
+	// Stream returns stream by name, empty name is the main stream.
+	Stream(name string) *stream.Stream
+}
+
+// Options are notifier options.
+type Options struct {
+	// Name is the camera name used in messages.
+	Name string
+	// LoginFailures is the number of failed logins within LoginWindow that is notified.
+	LoginFailures int
+	LoginWindow   time.Duration
+	// AllowLocal allows webhooks to loopback and link-local addresses, they are refused by default
+	// so that users of web UI can not reach services of the host, e.g. cloud metadata.
+	AllowLocal bool
+}
+
+// Notifier sends notifications about bus events to targets from store.
+type Notifier struct {
+	opts   Options
+	store  Store
+	camera Camera
+	bus    *events.Bus
+	client *http.Client
+
+	mu       sync.Mutex
+	last     map[string]time.Time
+	failures []time.Time
+
+	now  func() time.Time
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New returns new Notifier, camera can be nil when snapshots are not attached.
+func New(opts Options, store Store, camera Camera, bus *events.Bus) *Notifier {
+	if opts.Name == "" {
+		opts.Name = "cam2ip"
+	}
+
+	if opts.LoginFailures <= 0 {
+		opts.LoginFailures = 5
+	}
+
+	if opts.LoginWindow <= 0 {
+		opts.LoginWindow = 5 * time.Minute
+	}
+
+	return &Notifier{
+		opts:   opts,
+		store:  store,
+		camera: camera,
+		bus:    bus,
+		client: webhookClient(opts.AllowLocal),
+		last:   make(map[string]time.Time),
+		now:    time.Now,
+		done:   make(chan struct{}),
+	}
+}
+
+// Start starts handling bus events.
+func (n *Notifier) Start() {
+	ch, cancel := n.bus.Subscribe(64)
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		defer cancel()
+
+		for {
+			select {
+			case <-n.done:
+				return
+			case e := <-ch:
+				n.handle(e)
+			}
+		}
+	}()
+}
+
+// Close stops handling events and waits for pending notifications.
+func (n *Notifier) Close() error {
+	close(n.done)
+	n.wg.Wait()
+
+	return nil
+}
+
+// Test sends test notification to target, filters, cooldown and quiet hours are ignored.
+func (n *Notifier) Test(t Target) error {
+	notification := n.notification(events.Event{Type: "test", Time: n.now()})
+	notification.Test = true
+	notification.Message = fmt.Sprintf("Test notification from %s", n.opts.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	return send(ctx, n.client, t, notification)
+}
+
+// handle sends notification about event to targets whose rules allow it.
+func (n *Notifier) handle(e events.Event) {
+	if e.Type == events.AuthFailed && !n.loginFailure(e.Time) {
+		return
+	}
+
+	targets, err := n.store.GetNotificationTargets()
+	if err != nil {
+		log.Printf("notify: %v", err)
+
+		return
+	}
+
+	var notification *Notification
+
+	for _, t := range targets {
+		if !t.Enabled || !t.Wants(e.Type) || t.Quiet(e.Time) || !n.allow(t, e) {
+			continue
+		}
+
+		// Snapshot is taken once, for the first target that is notified.
+		if notification == nil {
+			nt := n.notification(e)
+			notification = &nt
+		}
+
+		n.wg.Add(1)
+		go func(t Target, nt Notification) {
+			defer n.wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+			defer cancel()
+
+			if err := send(ctx, n.client, t, nt); err != nil {
+				log.Printf("notify: %s: %v", t.Name, err)
+			}
+		}(t, *notification)
+	}
+}
+
+// loginFailure records failed login and reports whether failures within window reached the limit.
+func (n *Notifier) loginFailure(t time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	kept := n.failures[:0]
+	for _, f := range n.failures {
+		if t.Sub(f) < n.opts.LoginWindow {
+			kept = append(kept, f)
+		}
+	}
+
+	n.failures = append(kept, t)
+
+	if len(n.failures) < n.opts.LoginFailures {
+		return false
+	}
+
+	n.failures = n.failures[:0]
+
+	return true
+}
+
+// allow reports whether target cooldown for event type has passed and records notification.
+func (n *Notifier) allow(t Target, e events.Event) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	key := strconv.FormatInt(t.ID, 10) + "/" + string(e.Type)
+
+	if last, ok := n.last[key]; ok && e.Time.Sub(last) < time.Duration(t.Cooldown)*time.Second {
+		return false
+	}
+
+	n.last[key] = e.Time
+
+	return true
+}
+
+func (n *Notifier) notification(e events.Event) Notification {
+	nt := Notification{
+		Name:    n.opts.Name,
+		Type:    e.Type,
+		Time:    e.Time,
+		Message: message(n.opts.Name, e, n.opts.LoginFailures),
+		Data:    e.Data,
+	}
+
+	if n.camera != nil {
+		if f := n.camera.Stream(stream.MainStream).Latest(); f != nil {
+			data, err := f.JPEG()
+			if err == nil {
+				nt.Snapshot = data
+			}
+		}
+	}
+
+	return nt
+}
+
+func message(name string, e events.Event, failures int) string {
+	switch e.Type {
+	case events.MotionStart:
+		return fmt.Sprintf("Motion detected on %s", name)
+	case events.MotionEnd:
+		return fmt.Sprintf("Motion ended on %s", name)
+	case events.Tamper:
+		return fmt.Sprintf("Tampering detected on %s: %v", name, e.Data["reason"])
+	case events.Trigger:
+		return fmt.Sprintf("Recording triggered on %s", name)
+	case events.CameraOffline:
+		return fmt.Sprintf("Camera %s is offline", name)
+	case events.CameraOnline:
+		return fmt.Sprintf("Camera %s is online", name)
+	case events.AuthFailed:
+		return fmt.Sprintf("%d failed logins on %s, last from %v", failures, name, e.Data["ip"])
+	}
+
+	return fmt.Sprintf("%s on %s", e.Type, name)
+}
+
+func send(ctx context.Context, client *http.Client, t Target, n Notification) error {
+	switch t.Kind {
+	case KindWebhook:
+		return sendWebhook(ctx, client, t, n)
+	case KindEmail:
+		return sendEmail(ctx, t, n)
+	}
+
+	return fmt.Errorf("invalid kind %q", t.Kind)
+}
+
+// parseClock returns minutes since midnight of HH:MM time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+)
+
+type store []Target
+
+func (s store) GetNotificationTargets() ([]Target, error) {
+	return s, nil
+}
+
+func TestQuiet(t *testing.T) {
+	tests := []struct {
+		start, end, now string
+		quiet           bool
+	}{
+		{"22:00", "07:00", "23:30", true},
+		{"22:00", "07:00", "06:59", true},
+		{"22:00", "07:00", "07:00", false},
+		{"09:00", "17:00", "12:00", true},
+		{"09:00", "17:00", "18:00", false},
+		{"", "", "12:00", false},
+	}
+
+	for _, tt := range tests {
+		now, _ := time.Parse("15:04", tt.now)
+
+		target := Target{QuietStart: tt.start, QuietEnd: tt.end}
+		if got := target.Quiet(now); got != tt.quiet {
+			t.Errorf("%s-%s at %s: got %v", tt.start, tt.end, tt.now, got)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	received := make(chan Notification, 10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+
+		err := json.Unmarshal([]byte(r.FormValue("payload")), &n)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if f, _, err := r.FormFile("snapshot"); err == nil {
+			n.Snapshot, _ = io.ReadAll(f)
+		}
+
+		received <- n
+	}))
+	defer ts.Close()
+
+	targets := store{
+		{ID: 1, Name: "hook", Kind: KindWebhook, Enabled: true, URL: ts.URL, Cooldown: 60},
+		{ID: 2, Name: "disabled", Kind: KindWebhook, URL: ts.URL},
+	}
+
+	bus := events.NewBus()
+
+	n := New(Options{Name: "test", LoginFailures: 3, AllowLocal: true}, targets, nil, bus)
+	n.Start()
+
+	bus.Publish(events.MotionStart, nil)
+	bus.Publish(events.MotionStart, nil) // cooldown
+	bus.Publish(events.ViewerConnected, nil)
+
+	for i := 0; i < 3; i++ {
+		bus.Publish(events.AuthFailed, map[string]any{"ip": "10.0.0.1"})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	_ = n.Close()
+	close(received)
+
+	var types []string
+	for nt := range received {
+		types = append(types, string(nt.Type))
+	}
+
+	if len(types) != 2 || !strings.Contains(strings.Join(types, ","), "motion_start") || !strings.Contains(strings.Join(types, ","), "auth_failed") {
+		t.Errorf("unexpected notifications %v", types)
+	}
+}
+
+func TestWebhookLocal(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	n := New(Options{Name: "test"}, store{}, nil, events.NewBus())
+
+	for _, u := range []string{ts.URL, "http://localhost:1/", "http://[::1]:1/", "http://169.254.169.254/latest/meta-data/", "http://0.0.0.0:1/"} {
+		if err := n.Test(Target{Name: "hook", Kind: KindWebhook, URL: u}); !errors.Is(err, errLocalAddress) {
+			t.Errorf("%s: expected local address error, got %v", u, err)
+		}
+	}
+
+	n = New(Options{Name: "test", AllowLocal: true}, store{}, nil, events.NewBus())
+
+	if err := n.Test(Target{Name: "hook", Kind: KindWebhook, URL: ts.URL}); err != nil {
+		t.Errorf("expected local webhook to be allowed, got %v", err)
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	from := &mail.Address{Address: "cam@example.com"}
+	to := []*mail.Address{{Name: "Admin", Address: "admin@example.com"}}
+
+	msg, err := emailMessage(from, to, Notification{Name: "cam2ip", Type: events.Tamper, Time: time.Now(), Message: "Tampering detected", Snapshot: []byte{0xff, 0xd8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Header.Get("Subject") != "[cam2ip] Tampering detected" {
+		t.Errorf("unexpected subject %q", m.Header.Get("Subject"))
+	}
+
+	body, _ := io.ReadAll(m.Body)
+	if !strings.Contains(string(body), `filename="snapshot.jpg"`) {
+		t.Error("snapshot not attached")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []Target{
+		{Name: "hook", Kind: KindWebhook, URL: "https://example.com/hook"},
+		{Name: "mail", Kind: KindEmail, Address: "smtp.example.com:587", From: "cam@example.com", To: "a@example.com, b@example.com", QuietStart: "22:00", QuietEnd: "06:00"},
+	}
+
+	for _, target := range valid {
+		if err := target.Validate(); err != nil {
+			t.Errorf("%s: %v", target.Name, err)
+		}
+	}
+
+	invalid := []Target{
+		{Name: "hook", Kind: KindWebhook, URL: "ftp://example.com"},
+		{Name: "mail", Kind: KindEmail, Address: "smtp.example.com", From: "cam@example.com", To: "a@example.com"},
+		{Name: "quiet", Kind: KindWebhook, URL: "http://example.com", QuietStart: "25:00", QuietEnd: "06:00"},
+		{Name: "", Kind: KindWebhook, URL: "http://example.com"},
+	}
+
+	for _, target := range invalid {
+		if err := target.Validate(); err == nil {
+			t.Errorf("%+v: expected error", target)
+		}
+	}
+}
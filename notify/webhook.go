@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"syscall"
+)
+
+// errLocalAddress is returned when webhook connects to loopback or link-local address.
+var errLocalAddress = errors.New("webhook: loopback and link-local addresses are not allowed")
+
+// webhookClient returns HTTP client of webhooks. Unless allowLocal is set, it refuses to connect to loopback,
+// link-local and unspecified addresses, addresses are checked on connect, so also after redirects and DNS changes.
+func webhookClient(allowLocal bool) *http.Client {
+	if allowLocal {
+		return &http.Client{Timeout: sendTimeout}
+	}
+
+	dialer := &net.Dialer{
+		Timeout: sendTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || localIP(ip) {
+				return fmt.Errorf("%w: %s", errLocalAddress, host)
+			}
+
+			return nil
+		},
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = dialer.DialContext
+	// Proxy would be the only address checked
+	tr.Proxy = nil
+
+	return &http.Client{Timeout: sendTimeout, Transport: tr}
+}
+
+// localIP reports whether ip is loopback, link-local, e.g. cloud metadata 169.254.169.254, unspecified or multicast.
+func localIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// sendWebhook posts notification as multipart form with JSON "payload" part and "snapshot" JPEG file.
+func sendWebhook(ctx context.Context, client *http.Client, t Target, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", `form-data; name="payload"`)
+	h.Set("Content-Type", "application/json")
+
+	pw, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+
+	_, _ = pw.Write(payload)
+
+	if len(n.Snapshot) > 0 {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", `form-data; name="snapshot"; filename="snapshot.jpg"`)
+		h.Set("Content-Type", "image/jpeg")
+
+		fw, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+
+		_, _ = fw.Write(n.Snapshot)
+	}
+
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("User-Agent", n.Name)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s", res.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package onvif
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WS-Discovery multicast group.
+var discoveryAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 3702}
+
+const (
+	nsAddressing = "http://schemas.xmlsoap.org/ws/2004/08/addressing"
+	nsDiscovery  = "http://schemas.xmlsoap.org/ws/2005/04/discovery"
+	nsNetwork    = "http://www.onvif.org/ver10/network/wsdl"
+)
+
+// probe is WS-Discovery Probe message.
+type probe struct {
+	MessageID string `xml:"Header>MessageID"`
+	Probe     *struct {
+		Types string `xml:"Types"`
+	} `xml:"Body>Probe"`
+}
+
+// Discovery answers WS-Discovery probes for the device service.
+type Discovery struct {
+	// Port is the HTTP port of device service.
+	Port int
+	// Name is announced in scopes.
+	Name string
+
+	uuid string
+
+	mu   sync.Mutex
+	conn *net.UDPConn
+}
+
+// NewDiscovery returns new Discovery, endpoint UUID is derived from host name and port so it is stable across restarts.
+func NewDiscovery(name string, port int) *Discovery {
+	h := sha1.Sum([]byte(hostname() + ":" + strconv.Itoa(port)))
+	h[6] = h[6]&0x0F | 0x50
+	h[8] = h[8]&0x3F | 0x80
+
+	return &Discovery{
+		Port: port,
+		Name: name,
+		uuid: formatUUID(h[:16]),
+	}
+}
+
+// ListenAndServe joins the multicast group and answers probes.
+func (d *Discovery) ListenAndServe() error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, discoveryAddr)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.conn = conn
+	d.mu.Unlock()
+
+	buf := make([]byte, 65536)
+
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		local, err := localIP(addr)
+		if err != nil {
+			continue
+		}
+
+		resp := d.probeMatch(buf[:n], local)
+		if resp == nil {
+			continue
+		}
+
+		_, err = conn.WriteToUDP(resp, addr)
+		if err != nil {
+			log.Printf("onvif: discovery: %v", err)
+		}
+	}
+}
+
+// Close leaves the multicast group.
+func (d *Discovery) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn == nil {
+		return nil
+	}
+
+	return d.conn.Close()
+}
+
+// probeMatch returns ProbeMatches response for a probe matching our types, nil otherwise.
+func (d *Discovery) probeMatch(msg []byte, local net.IP) []byte {
+	var p probe
+
+	err := xml.Unmarshal(msg, &p)
+	if err != nil || p.Probe == nil || !matchTypes(p.Probe.Types) {
+		return nil
+	}
+
+	scopes := []string{
+		"onvif://www.onvif.org/type/video_encoder",
+		"onvif://www.onvif.org/Profile/Streaming",
+		"onvif://www.onvif.org/name/" + strings.ReplaceAll(d.Name, " ", "_"),
+		"onvif://www.onvif.org/hardware/" + strings.ReplaceAll(d.Name, " ", "_"),
+	}
+
+	xaddr := fmt.Sprintf("http://%s%s", net.JoinHostPort(local.String(), strconv.Itoa(d.Port)), DevicePath)
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<env:Envelope xmlns:env="%s" xmlns:wsa="%s" xmlns:d="%s" xmlns:dn="%s" xmlns:tds="%s">`+
+		`<env:Header><wsa:MessageID>urn:uuid:%s</wsa:MessageID><wsa:RelatesTo>%s</wsa:RelatesTo>`+
+		`<wsa:To>%s/role/anonymous</wsa:To><wsa:Action>%s/ProbeMatches</wsa:Action></env:Header>`+
+		`<env:Body><d:ProbeMatches><d:ProbeMatch>`+
+		`<wsa:EndpointReference><wsa:Address>urn:uuid:%s</wsa:Address></wsa:EndpointReference>`+
+		`<d:Types>dn:NetworkVideoTransmitter tds:Device</d:Types><d:Scopes>%s</d:Scopes>`+
+		`<d:XAddrs>%s</d:XAddrs><d:MetadataVersion>1</d:MetadataVersion>`+
+		`</d:ProbeMatch></d:ProbeMatches></env:Body></env:Envelope>`,
+		nsSOAP, nsAddressing, nsDiscovery, nsNetwork, nsDevice,
+		randomUUID(), escape(p.MessageID), nsAddressing, nsDiscovery,
+		d.uuid, escape(strings.Join(scopes, " ")), escape(xaddr))
+
+	return b.Bytes()
+}
+
+// matchTypes reports whether probe types, QNames separated by spaces, are empty or name our types.
+func matchTypes(types string) bool {
+	fields := strings.Fields(types)
+	if len(fields) == 0 {
+		return true
+	}
+
+	for _, t := range fields {
+		if i := strings.LastIndexByte(t, ':'); i >= 0 {
+			t = t[i+1:]
+		}
+
+		if t == "NetworkVideoTransmitter" || t == "Device" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// localIP returns address of the interface used to reach addr.
+func localIP(addr *net.UDPAddr) (net.IP, error) {
+	c, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	defer c.Close()
+
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func randomUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	b[6] = b[6]&0x0F | 0x40
+	b[8] = b[8]&0x3F | 0x80
+
+	return formatUUID(b)
+}
+
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,371 @@
+// Package onvif implements minimal ONVIF Profile S device and media services and WS-Discovery.
+package onvif
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Service paths.
+const (
+	DevicePath = "/onvif/device_service"
+	MediaPath  = "/onvif/media_service"
+)
+
+// Namespaces.
+const (
+	nsSOAP   = "http://www.w3.org/2003/05/soap-envelope"
+	nsDevice = "http://www.onvif.org/ver10/device/wsdl"
+	nsMedia  = "http://www.onvif.org/ver10/media/wsdl"
+	nsSchema = "http://www.onvif.org/ver10/schema"
+	nsError  = "http://www.onvif.org/ver10/error"
+
+	passwordText = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText"
+)
+
+const maxRequestSize = 64 << 10
+
+// AuthFunc checks credentials of a client.
+type AuthFunc func(username, password, remoteAddr string) bool
+
+// Profile is a media profile backed by a stream.
+type Profile struct {
+	// Stream is the stream name used in stream URI.
+	Stream  string
+	Width   int
+	Height  int
+	FPS     float64
+	Quality int
+}
+
+// Options are service options.
+type Options struct {
+	Name     string
+	Version  string
+	Profiles []Profile
+	// RTSPPort is the RTSP server port, zero when RTSP is disabled.
+	RTSPPort int
+	// Auth checks WS-UsernameToken credentials, nil disables authentication.
+	Auth AuthFunc
+}
+
+// Service handles ONVIF device and media SOAP requests.
+type Service struct {
+	opts Options
+}
+
+// NewService returns new ONVIF service.
+func NewService(opts Options) *Service {
+	return &Service{opts}
+}
+
+// envelope is SOAP request.
+type envelope struct {
+	Header struct {
+		Security struct {
+			UsernameToken struct {
+				Username string `xml:"Username"`
+				Password struct {
+					Type  string `xml:"Type,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"Password"`
+			} `xml:"UsernameToken"`
+		} `xml:"Security"`
+	} `xml:"Header"`
+	Body struct {
+		Inner []byte `xml:",innerxml"`
+	} `xml:"Body"`
+}
+
+// getStreamUri is GetStreamUri request.
+type getStreamUri struct {
+	Protocol     string `xml:"StreamSetup>Transport>Protocol"`
+	ProfileToken string `xml:"ProfileToken"`
+}
+
+// getSnapshotUri is GetSnapshotUri request.
+type getSnapshotUri struct {
+	ProfileToken string `xml:"ProfileToken"`
+}
+
+// preAuth are operations allowed without credentials, clients call them before authentication.
+var preAuth = map[string]bool{
+	"GetSystemDateAndTime": true,
+	"GetCapabilities":      true,
+	"GetServices":          true,
+}
+
+// ServeHTTP handles requests on incoming connections.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		return
+	}
+
+	var env envelope
+
+	err = xml.Unmarshal(data, &env)
+	if err != nil {
+		fault(w, "env:Sender", "ter:WellFormed", "Malformed SOAP envelope", http.StatusBadRequest)
+
+		return
+	}
+
+	op, body := operation(env.Body.Inner)
+
+	if s.opts.Auth != nil && !preAuth[op] {
+		token := env.Header.Security.UsernameToken
+
+		// Passwords are stored hashed, so PasswordDigest can not be verified.
+		if typ := token.Password.Type; typ != "" && typ != passwordText {
+			name := typ[strings.LastIndex(typ, "#")+1:]
+			fault(w, "env:Sender", "ter:NotAuthorized", fmt.Sprintf("Unsupported password type %s, only PasswordText is supported", name), http.StatusBadRequest)
+
+			return
+		}
+
+		ok := token.Username != ""
+		if ok {
+			ok = s.opts.Auth(token.Username, strings.TrimSpace(token.Password.Value), remoteIP(r))
+		}
+
+		if !ok {
+			fault(w, "env:Sender", "ter:NotAuthorized", "Sender not Authorized", http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	var resp string
+
+	switch op {
+	case "GetSystemDateAndTime":
+		resp = s.systemDateAndTime()
+	case "GetDeviceInformation":
+		resp = s.deviceInformation()
+	case "GetCapabilities":
+		resp = s.capabilities(r)
+	case "GetServices":
+		resp = s.services(r)
+	case "GetProfiles":
+		resp = s.profiles()
+	case "GetStreamUri":
+		var req getStreamUri
+		_ = xml.Unmarshal(body, &req)
+
+		p, ok := s.profile(req.ProfileToken)
+		if !ok {
+			fault(w, "env:Sender", "ter:NoProfile", "Profile does not exist", http.StatusBadRequest)
+
+			return
+		}
+
+		resp = s.streamUri(r, p, req.Protocol)
+	case "GetSnapshotUri":
+		var req getSnapshotUri
+		_ = xml.Unmarshal(body, &req)
+
+		if _, ok := s.profile(req.ProfileToken); !ok {
+			fault(w, "env:Sender", "ter:NoProfile", "Profile does not exist", http.StatusBadRequest)
+
+			return
+		}
+
+		resp = s.snapshotUri(r)
+	default:
+		fault(w, "env:Receiver", "ter:ActionNotSupported", "Optional Action Not Implemented", http.StatusBadRequest)
+
+		return
+	}
+
+	write(w, resp, http.StatusOK)
+}
+
+func (s *Service) systemDateAndTime() string {
+	now := time.Now().UTC()
+
+	return fmt.Sprintf(`<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime>`+
+		`<tt:DateTimeType>NTP</tt:DateTimeType><tt:DaylightSavings>false</tt:DaylightSavings>`+
+		`<tt:TimeZone><tt:TZ>UTC</tt:TZ></tt:TimeZone><tt:UTCDateTime>`+
+		`<tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>`+
+		`<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date>`+
+		`</tt:UTCDateTime></tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>`,
+		now.Hour(), now.Minute(), now.Second(), now.Year(), now.Month(), now.Day())
+}
+
+func (s *Service) deviceInformation() string {
+	return fmt.Sprintf(`<tds:GetDeviceInformationResponse>`+
+		`<tds:Manufacturer>%s</tds:Manufacturer><tds:Model>%s</tds:Model>`+
+		`<tds:FirmwareVersion>%s</tds:FirmwareVersion><tds:SerialNumber>%s</tds:SerialNumber>`+
+		`<tds:HardwareId>%s</tds:HardwareId></tds:GetDeviceInformationResponse>`,
+		escape(s.opts.Name), escape(s.opts.Name), escape(s.opts.Version), escape(hostname()), escape(s.opts.Name))
+}
+
+func (s *Service) capabilities(r *http.Request) string {
+	rtsp := strconv.FormatBool(s.opts.RTSPPort != 0)
+
+	return fmt.Sprintf(`<tds:GetCapabilitiesResponse><tds:Capabilities>`+
+		`<tt:Device><tt:XAddr>%s</tt:XAddr></tt:Device>`+
+		`<tt:Media><tt:XAddr>%s</tt:XAddr><tt:StreamingCapabilities>`+
+		`<tt:RTPMulticast>false</tt:RTPMulticast><tt:RTP_TCP>%s</tt:RTP_TCP><tt:RTP_RTSP_TCP>%s</tt:RTP_RTSP_TCP>`+
+		`</tt:StreamingCapabilities></tt:Media>`+
+		`</tds:Capabilities></tds:GetCapabilitiesResponse>`,
+		escape(xaddr(r, DevicePath)), escape(xaddr(r, MediaPath)), rtsp, rtsp)
+}
+
+func (s *Service) services(r *http.Request) string {
+	service := func(ns, addr string) string {
+		return fmt.Sprintf(`<tds:Service><tds:Namespace>%s</tds:Namespace><tds:XAddr>%s</tds:XAddr>`+
+			`<tds:Version><tt:Major>2</tt:Major><tt:Minor>0</tt:Minor></tds:Version></tds:Service>`, ns, escape(addr))
+	}
+
+	return `<tds:GetServicesResponse>` + service(nsDevice, xaddr(r, DevicePath)) +
+		service(nsMedia, xaddr(r, MediaPath)) + `</tds:GetServicesResponse>`
+}
+
+func (s *Service) profiles() string {
+	var b strings.Builder
+
+	b.WriteString(`<trt:GetProfilesResponse>`)
+
+	// All profiles share the camera as video source.
+	var source Profile
+	if len(s.opts.Profiles) > 0 {
+		source = s.opts.Profiles[0]
+	}
+
+	for _, p := range s.opts.Profiles {
+		fmt.Fprintf(&b, `<trt:Profiles token="%[1]s" fixed="true"><tt:Name>%[1]s</tt:Name>`+
+			`<tt:VideoSourceConfiguration token="source"><tt:Name>source</tt:Name><tt:UseCount>%[2]d</tt:UseCount>`+
+			`<tt:SourceToken>source</tt:SourceToken><tt:Bounds x="0" y="0" width="%[7]d" height="%[8]d"/>`+
+			`</tt:VideoSourceConfiguration>`+
+			`<tt:VideoEncoderConfiguration token="%[1]s"><tt:Name>%[1]s</tt:Name><tt:UseCount>1</tt:UseCount>`+
+			`<tt:Encoding>JPEG</tt:Encoding><tt:Resolution><tt:Width>%[3]d</tt:Width><tt:Height>%[4]d</tt:Height></tt:Resolution>`+
+			`<tt:Quality>%[5]d</tt:Quality><tt:RateControl><tt:FrameRateLimit>%[6]d</tt:FrameRateLimit>`+
+			`<tt:EncodingInterval>1</tt:EncodingInterval><tt:BitrateLimit>0</tt:BitrateLimit></tt:RateControl>`+
+			`<tt:SessionTimeout>PT60S</tt:SessionTimeout></tt:VideoEncoderConfiguration></trt:Profiles>`,
+			escape(p.Stream), len(s.opts.Profiles), p.Width, p.Height, p.Quality, int(p.FPS+0.5), source.Width, source.Height)
+	}
+
+	b.WriteString(`</trt:GetProfilesResponse>`)
+
+	return b.String()
+}
+
+func (s *Service) profile(token string) (Profile, bool) {
+	for _, p := range s.opts.Profiles {
+		if p.Stream == token {
+			return p, true
+		}
+	}
+
+	return Profile{}, false
+}
+
+// streamUri returns RTSP URI when client asks for RTSP and it is enabled, MJPEG URI otherwise.
+func (s *Service) streamUri(r *http.Request, p Profile, protocol string) string {
+	uri := xaddr(r, "/mjpeg?stream="+p.Stream)
+
+	if s.opts.RTSPPort != 0 && (protocol == "RTSP" || protocol == "UDP" || protocol == "TCP") {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		uri = fmt.Sprintf("rtsp://%s/%s", net.JoinHostPort(host, strconv.Itoa(s.opts.RTSPPort)), p.Stream)
+	}
+
+	return fmt.Sprintf(`<trt:GetStreamUriResponse><trt:MediaUri><tt:Uri>%s</tt:Uri>`+
+		`<tt:InvalidAfterConnect>false</tt:InvalidAfterConnect><tt:InvalidAfterReboot>false</tt:InvalidAfterReboot>`+
+		`<tt:Timeout>PT0S</tt:Timeout></trt:MediaUri></trt:GetStreamUriResponse>`, escape(uri))
+}
+
+func (s *Service) snapshotUri(r *http.Request) string {
+	return fmt.Sprintf(`<trt:GetSnapshotUriResponse><trt:MediaUri><tt:Uri>%s</tt:Uri>`+
+		`<tt:InvalidAfterConnect>false</tt:InvalidAfterConnect><tt:InvalidAfterReboot>false</tt:InvalidAfterReboot>`+
+		`<tt:Timeout>PT0S</tt:Timeout></trt:MediaUri></trt:GetSnapshotUriResponse>`, escape(xaddr(r, "/jpeg")))
+}
+
+// operation returns name and XML of the first element in SOAP body.
+func operation(body []byte) (string, []byte) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+
+	for {
+		start := d.InputOffset()
+
+		t, err := d.Token()
+		if err != nil {
+			return "", nil
+		}
+
+		if se, ok := t.(xml.StartElement); ok {
+			return se.Name.Local, body[start:]
+		}
+	}
+}
+
+func fault(w http.ResponseWriter, code, subcode, reason string, status int) {
+	write(w, fmt.Sprintf(`<env:Fault><env:Code><env:Value>%s</env:Value><env:Subcode><env:Value>%s</env:Value></env:Subcode></env:Code>`+
+		`<env:Reason><env:Text xml:lang="en">%s</env:Text></env:Reason></env:Fault>`, code, subcode, reason), status)
+}
+
+func write(w http.ResponseWriter, body string, status int) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(status)
+
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<env:Envelope xmlns:env="%s" xmlns:tds="%s" xmlns:trt="%s" xmlns:tt="%s" xmlns:ter="%s">`+
+		`<env:Body>%s</env:Body></env:Envelope>`, nsSOAP, nsDevice, nsMedia, nsSchema, nsError, body)
+	if err != nil {
+		log.Printf("onvif: write: %v", err)
+	}
+}
+
+func xaddr(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host + path
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+
+	return name
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
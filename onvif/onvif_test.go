@@ -0,0 +1,166 @@
+package onvif
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const request = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">
+<s:Header>%s</s:Header>
+<s:Body xmlns:trt="http://www.onvif.org/ver10/media/wsdl" xmlns:tt="http://www.onvif.org/ver10/schema">%s</s:Body>
+</s:Envelope>`
+
+func security(username, password, typ string) string {
+	return `<Security xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<UsernameToken><Username>` + username + `</Username><Password Type="` + typ + `">` + password + `</Password>` +
+		`</UsernameToken></Security>`
+}
+
+func call(t *testing.T, url, header, body string) (int, string) {
+	t.Helper()
+
+	data := strings.Replace(strings.Replace(request, "%s", header, 1), "%s", body, 1)
+
+	resp, err := http.Post(url, "application/soap+xml", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, string(b)
+}
+
+func TestService(t *testing.T) {
+	svc := NewService(Options{
+		Name:    "cam2ip",
+		Version: "1.6",
+		Profiles: []Profile{
+			{Stream: "main", Width: 640, Height: 480, FPS: 25, Quality: 75},
+			{Stream: "sub", Width: 320, Height: 240, FPS: 5, Quality: 50},
+		},
+		RTSPPort: 8554,
+		Auth: func(username, password, remoteAddr string) bool {
+			return username == "admin" && password == "secret"
+		},
+	})
+
+	srv := httptest.NewServer(svc)
+	defer srv.Close()
+
+	auth := security("admin", "secret", passwordText)
+
+	status, body := call(t, srv.URL, "", `<tds:GetSystemDateAndTime xmlns:tds="http://www.onvif.org/ver10/device/wsdl"/>`)
+	if status != http.StatusOK || !strings.Contains(body, "UTCDateTime") {
+		t.Errorf("GetSystemDateAndTime: %d %s", status, body)
+	}
+
+	status, body = call(t, srv.URL, "", `<tds:GetDeviceInformation xmlns:tds="http://www.onvif.org/ver10/device/wsdl"/>`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "NotAuthorized") {
+		t.Errorf("GetDeviceInformation without credentials: %d %s", status, body)
+	}
+
+	status, body = call(t, srv.URL, security("admin", "wrong", passwordText), `<tds:GetDeviceInformation xmlns:tds="http://www.onvif.org/ver10/device/wsdl"/>`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "NotAuthorized") {
+		t.Errorf("GetDeviceInformation with wrong password: %d %s", status, body)
+	}
+
+	digest := security("admin", "secret", "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest")
+
+	status, body = call(t, srv.URL, digest, `<tds:GetDeviceInformation xmlns:tds="http://www.onvif.org/ver10/device/wsdl"/>`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "Unsupported password type PasswordDigest") {
+		t.Errorf("GetDeviceInformation with PasswordDigest: %d %s", status, body)
+	}
+
+	status, body = call(t, srv.URL, auth, `<tds:GetDeviceInformation xmlns:tds="http://www.onvif.org/ver10/device/wsdl"/>`)
+	if status != http.StatusOK || !strings.Contains(body, "<tds:FirmwareVersion>1.6</tds:FirmwareVersion>") {
+		t.Errorf("GetDeviceInformation: %d %s", status, body)
+	}
+
+	status, body = call(t, srv.URL, auth, `<trt:GetProfiles/>`)
+	if status != http.StatusOK || !strings.Contains(body, `token="sub"`) || !strings.Contains(body, "<tt:Width>320</tt:Width>") {
+		t.Errorf("GetProfiles: %d %s", status, body)
+	}
+
+	var profiles struct {
+		Profiles []struct {
+			Token string `xml:"token,attr"`
+		} `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+
+	err := xml.Unmarshal([]byte(body), &profiles)
+	if err != nil || len(profiles.Profiles) != 2 {
+		t.Errorf("GetProfiles: invalid response %v: %s", err, body)
+	}
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	hostname, _, _ := net.SplitHostPort(host)
+
+	streamUri := `<trt:GetStreamUri><trt:StreamSetup><tt:Stream>RTP-Unicast</tt:Stream><tt:Transport><tt:Protocol>%s</tt:Protocol></tt:Transport></trt:StreamSetup><trt:ProfileToken>sub</trt:ProfileToken></trt:GetStreamUri>`
+
+	_, body = call(t, srv.URL, auth, strings.Replace(streamUri, "%s", "HTTP", 1))
+	if !strings.Contains(body, "<tt:Uri>http://"+host+"/mjpeg?stream=sub</tt:Uri>") {
+		t.Errorf("GetStreamUri HTTP: %s", body)
+	}
+
+	_, body = call(t, srv.URL, auth, strings.Replace(streamUri, "%s", "RTSP", 1))
+	if !strings.Contains(body, "<tt:Uri>rtsp://"+hostname+":8554/sub</tt:Uri>") {
+		t.Errorf("GetStreamUri RTSP: %s", body)
+	}
+
+	_, body = call(t, srv.URL, auth, `<trt:GetSnapshotUri><trt:ProfileToken>main</trt:ProfileToken></trt:GetSnapshotUri>`)
+	if !strings.Contains(body, "<tt:Uri>http://"+host+"/jpeg</tt:Uri>") {
+		t.Errorf("GetSnapshotUri: %s", body)
+	}
+
+	status, body = call(t, srv.URL, auth, `<trt:GetSnapshotUri><trt:ProfileToken>none</trt:ProfileToken></trt:GetSnapshotUri>`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "NoProfile") {
+		t.Errorf("GetSnapshotUri unknown profile: %d %s", status, body)
+	}
+}
+
+func TestProbeMatch(t *testing.T) {
+	d := NewDiscovery("cam2ip", 56000)
+
+	msg := `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing">
+<s:Header><a:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</a:Action>
+<a:MessageID>uuid:0a6dc791-2be6-4991-9af1-454778a1917a</a:MessageID></s:Header>
+<s:Body><Probe xmlns="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+<Types xmlns:dn="http://www.onvif.org/ver10/network/wsdl">dn:%s</Types></Probe></s:Body></s:Envelope>`
+
+	resp := d.probeMatch([]byte(strings.Replace(msg, "%s", "NetworkVideoTransmitter", 1)), net.IPv4(192, 168, 1, 10))
+	if resp == nil {
+		t.Fatal("no response to probe")
+	}
+
+	for _, s := range []string{
+		"<wsa:RelatesTo>uuid:0a6dc791-2be6-4991-9af1-454778a1917a</wsa:RelatesTo>",
+		"<d:XAddrs>http://192.168.1.10:56000/onvif/device_service</d:XAddrs>",
+		"urn:uuid:" + d.uuid,
+	} {
+		if !bytes.Contains(resp, []byte(s)) {
+			t.Errorf("response does not contain %s: %s", s, resp)
+		}
+	}
+
+	if d.uuid != NewDiscovery("cam2ip", 56000).uuid {
+		t.Error("endpoint uuid is not stable")
+	}
+
+	if d.probeMatch([]byte(strings.Replace(msg, "%s", "Printer", 1)), net.IPv4(192, 168, 1, 10)) != nil {
+		t.Error("response to probe for other type")
+	}
+}
@@ -0,0 +1,595 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	// aviHeaderSize is the size of everything before the first frame chunk.
+	aviHeaderSize = 12 + 12 + 8 + 56 + 12 + 8 + 56 + 8 + 40 + 12
+
+	// moviOffset is the offset of movi fourcc, idx1 offsets are relative to it.
+	moviOffset = aviHeaderSize - 4
+
+	// MaxAVISize is the size after which segment must be closed, AVI 1.0 offsets are 32-bit
+	// and many players stop at 1 GiB.
+	MaxAVISize = 1 << 30
+
+	aviHasIndex    = 0x10
+	aviMustUseIdx  = 0x20
+	aviIndexKey    = 0x10
+	frameChunkID   = "00dc"
+	aviEntrySize   = 16
+	bitmapInfoSize = 40
+)
+
+// ErrAVITooLarge is returned by WriteFrame when frame would not fit in AVI file.
+var ErrAVITooLarge = errors.New("record: avi file too large")
+
+// AVIWriter writes MJPEG frames to AVI file with constant frame rate, frame timing is kept
+// by writing empty chunks, which players treat as repeated frames.
+type AVIWriter struct {
+	w      io.WriteSeeker
+	width  int
+	height int
+	fps    float64
+
+	index   []aviEntry
+	size    int64
+	maxSize int
+	closed  bool
+}
+
+type aviEntry struct {
+	offset uint32
+	size   uint32
+}
+
+// NewAVIWriter writes AVI header to w and returns new AVIWriter.
+func NewAVIWriter(w io.WriteSeeker, width, height int, fps float64) (*AVIWriter, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("record: invalid frame size")
+	}
+
+	if fps <= 0 {
+		return nil, errors.New("record: invalid frame rate")
+	}
+
+	a := &AVIWriter{w: w, width: width, height: height, fps: fps, size: aviHeaderSize}
+
+	if _, err := w.Write(a.header()); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Frames returns the number of written frames, including empty ones.
+func (a *AVIWriter) Frames() int {
+	return len(a.index)
+}
+
+// Size returns the current file size.
+func (a *AVIWriter) Size() int64 {
+	return a.size
+}
+
+// WriteFrame writes JPEG frame.
+func (a *AVIWriter) WriteFrame(jpeg []byte) error {
+	// Chunk, padding and its index entry must fit
+	if a.size+int64(8+len(jpeg)+1)+int64(len(a.index)+1)*aviEntrySize+8 > MaxAVISize {
+		return ErrAVITooLarge
+	}
+
+	a.index = append(a.index, aviEntry{offset: uint32(a.size - moviOffset), size: uint32(len(jpeg))})
+	if len(jpeg) > a.maxSize {
+		a.maxSize = len(jpeg)
+	}
+
+	buf := make([]byte, 8, 8+len(jpeg)+1)
+	copy(buf, frameChunkID)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(jpeg)))
+	buf = append(buf, jpeg...)
+
+	if len(jpeg)%2 == 1 {
+		buf = append(buf, 0)
+	}
+
+	n, err := a.w.Write(buf)
+	a.size += int64(n)
+
+	return err
+}
+
+// Skip writes empty frame, the previous frame is shown for its duration.
+func (a *AVIWriter) Skip() error {
+	return a.WriteFrame(nil)
+}
+
+// Checkpoint updates header to the current sizes and number of frames. File that was not closed, e.g. after
+// power loss, then has valid header up to the checkpoint and can be repaired with RepairAVI.
+func (a *AVIWriter) Checkpoint() error {
+	if a.closed {
+		return nil
+	}
+
+	if _, err := a.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := a.w.Write(a.header()); err != nil {
+		return err
+	}
+
+	_, err := a.w.Seek(a.size, io.SeekStart)
+
+	return err
+}
+
+// Close writes index and updates header, it does not close underlying writer.
+func (a *AVIWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+
+	a.closed = true
+
+	idx := make([]byte, 8+len(a.index)*aviEntrySize)
+	copy(idx, "idx1")
+	binary.LittleEndian.PutUint32(idx[4:], uint32(len(a.index)*aviEntrySize))
+
+	for i, e := range a.index {
+		b := idx[8+i*aviEntrySize:]
+		copy(b, frameChunkID)
+
+		// Empty chunks are not key frames, so seeking never lands on them
+		if e.size > 0 {
+			binary.LittleEndian.PutUint32(b[4:], aviIndexKey)
+		}
+
+		binary.LittleEndian.PutUint32(b[8:], e.offset)
+		binary.LittleEndian.PutUint32(b[12:], e.size)
+	}
+
+	if _, err := a.w.Write(idx); err != nil {
+		return err
+	}
+
+	moviSize := a.size - moviOffset
+	a.size += int64(len(idx))
+
+	if _, err := a.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	h := a.header()
+	binary.LittleEndian.PutUint32(h[aviHeaderSize-8:], uint32(moviSize))
+
+	if _, err := a.w.Write(h); err != nil {
+		return err
+	}
+
+	_, err := a.w.Seek(a.size, io.SeekStart)
+
+	return err
+}
+
+// header returns RIFF header with hdrl list and start of movi list, sizes are those of the current state.
+func (a *AVIWriter) header() []byte {
+	b := make([]byte, 0, aviHeaderSize)
+
+	u32 := func(v uint32) { b = binary.LittleEndian.AppendUint32(b, v) }
+	u16 := func(v uint16) { b = binary.LittleEndian.AppendUint16(b, v) }
+	fcc := func(s string) { b = append(b, s...) }
+
+	frames := uint32(len(a.index))
+	usec := uint32(math.Round(1e6 / a.fps))
+	scale, rate := uint32(1000), uint32(math.Round(a.fps*1000))
+	bufSize := uint32(a.maxSize)
+
+	maxBytes := uint32(0)
+	if a.maxSize > 0 {
+		maxBytes = uint32(math.Min(float64(a.maxSize)*a.fps, math.MaxUint32))
+	}
+
+	fcc("RIFF")
+	u32(uint32(a.size - 8))
+	fcc("AVI ")
+
+	fcc("LIST")
+	u32(4 + 8 + 56 + 12 + 8 + 56 + 8 + bitmapInfoSize)
+	fcc("hdrl")
+
+	// Main AVI header
+	fcc("avih")
+	u32(56)
+	u32(usec)
+	u32(maxBytes)
+	u32(0)
+	u32(aviHasIndex | aviMustUseIdx)
+	u32(frames)
+	u32(0)
+	u32(1)
+	u32(bufSize)
+	u32(uint32(a.width))
+	u32(uint32(a.height))
+	u32(0)
+	u32(0)
+	u32(0)
+	u32(0)
+
+	fcc("LIST")
+	u32(4 + 8 + 56 + 8 + bitmapInfoSize)
+	fcc("strl")
+
+	// Stream header
+	fcc("strh")
+	u32(56)
+	fcc("vids")
+	fcc("MJPG")
+	u32(0)
+	u16(0)
+	u16(0)
+	u32(0)
+	u32(scale)
+	u32(rate)
+	u32(0)
+	u32(frames)
+	u32(bufSize)
+	u32(math.MaxUint32)
+	u32(0)
+	u16(0)
+	u16(0)
+	u16(uint16(a.width))
+	u16(uint16(a.height))
+
+	// Stream format, BITMAPINFOHEADER
+	fcc("strf")
+	u32(bitmapInfoSize)
+	u32(bitmapInfoSize)
+	u32(uint32(a.width))
+	u32(uint32(a.height))
+	u16(1)
+	u16(24)
+	fcc("MJPG")
+	u32(uint32(a.width * a.height * 3))
+	u32(0)
+	u32(0)
+	u32(0)
+	u32(0)
+
+	fcc("LIST")
+	u32(uint32(a.size - moviOffset))
+	fcc("movi")
+
+	return b
+}
+
+// AVIReader reads frames of MJPEG AVI file. File without index, e.g. of interrupted recording,
+// is read by scanning chunks of movi list.
+type AVIReader struct {
+	r      io.ReaderAt
+	closer io.Closer
+
+	width  int
+	height int
+	fps    float64
+
+	// movi is the offset of movi fourcc.
+	movi  int64
+	index []aviEntry
+	// indexed is false when file has no idx1 and frames were found by scanning.
+	indexed bool
+}
+
+// OpenAVI opens AVI file name.
+func OpenAVI(name string) (*AVIReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	a, err := NewAVIReader(f, fi.Size())
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	a.closer = f
+
+	return a, nil
+}
+
+// NewAVIReader reads header and index of AVI file of given size from r.
+func NewAVIReader(r io.ReaderAt, size int64) (*AVIReader, error) {
+	a := &AVIReader{r: r, movi: -1}
+
+	b := make([]byte, 12)
+	if _, err := r.ReadAt(b, 0); err != nil || string(b[0:4]) != "RIFF" || string(b[8:12]) != "AVI " {
+		return nil, errors.New("record: not an avi file")
+	}
+
+	var usec uint32
+	var idx1 []byte
+
+	// Header of unfinished file has sizes of empty file, so chunks are walked up to the file size
+	err := walkChunks(r, 12, size, func(id string, pos int64, n uint32) error {
+		switch id {
+		case "LIST":
+			if _, err := r.ReadAt(b[:4], pos+8); err != nil {
+				return err
+			}
+
+			switch string(b[:4]) {
+			case "hdrl":
+				return walkChunks(r, pos+12, pos+8+int64(n), func(id string, pos int64, n uint32) error {
+					switch id {
+					case "avih":
+						h, err := readChunk(r, pos, n, 40)
+						if err != nil {
+							return err
+						}
+
+						usec = binary.LittleEndian.Uint32(h)
+						a.width = int(binary.LittleEndian.Uint32(h[32:]))
+						a.height = int(binary.LittleEndian.Uint32(h[36:]))
+					case "LIST":
+						h, err := readChunk(r, pos, n, 4+8+32)
+						if err != nil || string(h[0:4]) != "strl" || string(h[4:8]) != "strh" || string(h[12:16]) != "vids" || a.fps > 0 {
+							return err
+						}
+
+						scale, rate := binary.LittleEndian.Uint32(h[32:]), binary.LittleEndian.Uint32(h[36:])
+						if scale > 0 && rate > 0 {
+							a.fps = float64(rate) / float64(scale)
+						}
+					}
+
+					return nil
+				})
+			case "movi":
+				a.movi = pos + 8
+			}
+		case "idx1":
+			// Index that does not fit in the file is not complete, frames are found by scanning instead
+			if pos+8+int64(n) > size {
+				return io.EOF
+			}
+
+			var err error
+			if idx1, err = readChunk(r, pos, n, int(n)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if a.movi < 0 {
+		return nil, errors.New("record: movi list not found")
+	}
+
+	if a.fps <= 0 && usec > 0 {
+		a.fps = 1e6 / float64(usec)
+	}
+
+	if a.fps <= 0 || a.width <= 0 || a.height <= 0 {
+		return nil, errors.New("record: invalid avi header")
+	}
+
+	if len(idx1) > 0 {
+		a.indexed = true
+		a.readIndex(idx1)
+	} else {
+		a.scan(size)
+	}
+
+	return a, nil
+}
+
+// readIndex reads video frame entries of idx1, offsets are relative to movi or absolute.
+func (a *AVIReader) readIndex(idx1 []byte) {
+	base := a.movi
+	b := make([]byte, 4)
+
+	for i := 0; i+aviEntrySize <= len(idx1); i += aviEntrySize {
+		e := idx1[i:]
+		if !isFrameChunk(string(e[0:4])) {
+			continue
+		}
+
+		offset, size := binary.LittleEndian.Uint32(e[8:]), binary.LittleEndian.Uint32(e[12:])
+
+		if len(a.index) == 0 {
+			if _, err := a.r.ReadAt(b, base+int64(offset)); err != nil || string(b) != string(e[0:4]) {
+				base = 0
+			}
+		}
+
+		a.index = append(a.index, aviEntry{offset: uint32(base + int64(offset) - a.movi), size: size})
+	}
+}
+
+// scan builds index from frame chunks following movi fourcc, it stops at the first truncated chunk.
+func (a *AVIReader) scan(size int64) {
+	_ = walkChunks(a.r, a.movi+4, size, func(id string, pos int64, n uint32) error {
+		if pos+8+int64(n) > size {
+			return io.EOF
+		}
+
+		if isFrameChunk(id) {
+			a.index = append(a.index, aviEntry{offset: uint32(pos - a.movi), size: n})
+		}
+
+		return nil
+	})
+}
+
+// Width returns frame width.
+func (a *AVIReader) Width() int {
+	return a.width
+}
+
+// Height returns frame height.
+func (a *AVIReader) Height() int {
+	return a.height
+}
+
+// FPS returns frame rate.
+func (a *AVIReader) FPS() float64 {
+	return a.fps
+}
+
+// Frames returns the number of frames, including empty ones.
+func (a *AVIReader) Frames() int {
+	return len(a.index)
+}
+
+// Frame returns JPEG data of frame i, it is nil for empty frame that repeats the previous one.
+func (a *AVIReader) Frame(i int) ([]byte, error) {
+	if i < 0 || i >= len(a.index) {
+		return nil, io.EOF
+	}
+
+	e := a.index[i]
+	if e.size == 0 {
+		return nil, nil
+	}
+
+	data := make([]byte, e.size)
+	if _, err := a.r.ReadAt(data, a.movi+int64(e.offset)+8); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Close closes file opened by OpenAVI.
+func (a *AVIReader) Close() error {
+	if a.closer != nil {
+		return a.closer.Close()
+	}
+
+	return nil
+}
+
+// RepairAVI writes index and header of file written by AVIWriter that was not closed, e.g. after power loss.
+// Chunk that was not written completely is cut off, as are the last frames that are not complete JPEG from SOI
+// to EOI marker, since their data may not have reached the disk. It reports whether file needed repair.
+func RepairAVI(name string) (bool, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	r, err := NewAVIReader(f, fi.Size())
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if r.indexed {
+		return false, nil
+	}
+
+	if r.movi != moviOffset {
+		return false, fmt.Errorf("%s: record: avi file not written by recorder", name)
+	}
+
+	for n := len(r.index); n > 0 && r.index[n-1].size > 0; n-- {
+		data, err := r.Frame(n - 1)
+		if err == nil && bytes.HasPrefix(data, []byte{0xFF, 0xD8}) && bytes.HasSuffix(data, []byte{0xFF, 0xD9}) {
+			break
+		}
+
+		r.index = r.index[:n-1]
+	}
+
+	a := &AVIWriter{w: f, width: r.width, height: r.height, fps: r.fps, index: r.index, size: aviHeaderSize}
+
+	for _, e := range a.index {
+		a.maxSize = max(a.maxSize, int(e.size))
+	}
+
+	if n := len(a.index); n > 0 {
+		e := a.index[n-1]
+		a.size = moviOffset + int64(e.offset) + 8 + int64(e.size) + int64(e.size&1)
+	}
+
+	if err := f.Truncate(a.size); err != nil {
+		return false, err
+	}
+
+	if _, err := f.Seek(a.size, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	if err := a.Close(); err != nil {
+		return false, err
+	}
+
+	return true, f.Sync()
+}
+
+// walkChunks calls fn with id, position and size of each chunk from start to end, it stops at error or truncated header.
+func walkChunks(r io.ReaderAt, start, end int64, fn func(id string, pos int64, size uint32) error) error {
+	b := make([]byte, 8)
+
+	for pos := start; pos+8 <= end; {
+		if _, err := r.ReadAt(b, pos); err != nil {
+			return nil
+		}
+
+		size := binary.LittleEndian.Uint32(b[4:])
+		if err := fn(string(b[0:4]), pos, size); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		pos += 8 + int64(size) + int64(size&1)
+	}
+
+	return nil
+}
+
+// readChunk returns the first n bytes of data of chunk at pos with size, it fails if chunk is smaller.
+func readChunk(r io.ReaderAt, pos int64, size uint32, n int) ([]byte, error) {
+	if int(size) < n {
+		return nil, errors.New("record: invalid avi chunk")
+	}
+
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, pos+8); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// isFrameChunk reports whether chunk id is compressed or uncompressed video frame of any stream.
+func isFrameChunk(id string) bool {
+	return len(id) == 4 && (id[2:] == "dc" || id[2:] == "db")
+}
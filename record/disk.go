@@ -0,0 +1,15 @@
+//go:build !windows
+
+package record
+
+import "syscall"
+
+// diskFree returns free space in bytes available to user on disk of dir.
+func diskFree(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
@@ -0,0 +1,330 @@
+package record
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+// ClipsDir is the subdirectory of recordings directory where clips are kept.
+const ClipsDir = "clips"
+
+// Events that start clips, kept in the index.
+const (
+	EventMotion  = "motion"
+	EventTamper  = "tamper"
+	EventTrigger = "trigger"
+)
+
+// EventOptions are event recorder options.
+type EventOptions struct {
+	// Dir is the directory of recordings, clips are kept in its clips subdirectory.
+	Dir string
+	// FPS is the frame rate of clips.
+	FPS float64
+	// PreRoll is the time recorded before the event, frames are kept in memory.
+	PreRoll time.Duration
+	// PostRoll is the time recorded after the event ends.
+	PostRoll time.Duration
+	// MaxLength is the longest clip, clip of longer event is continued in a new file.
+	MaxLength time.Duration
+	// Upload copies finished clips to storage, nil disables uploads.
+	Upload *Uploader
+}
+
+// EventRecorder records clips around motion, tamper and trigger events.
+type EventRecorder struct {
+	opts   EventOptions
+	source Source
+	bus    *events.Bus
+	index  Index
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewEventRecorder returns new EventRecorder, finished clips are added to index unless it is nil.
+func NewEventRecorder(opts EventOptions, source Source, bus *events.Bus, index Index) *EventRecorder {
+	if opts.FPS <= 0 {
+		opts.FPS = 10
+	}
+
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = 10 * time.Minute
+	}
+
+	return &EventRecorder{opts: opts, source: source, bus: bus, index: index}
+}
+
+// Start starts waiting for events.
+func (r *EventRecorder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Join(r.opts.Dir, ClipsDir), 0755); err != nil {
+		return err
+	}
+
+	// Events are subscribed first, so that frame captured after event is never handled before it
+	ech, ecancel := r.bus.Subscribe(16)
+	fch, fcancel := r.source.Subscribe()
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	r.stop, r.done = stop, done
+
+	go func() {
+		defer close(done)
+		defer ecancel()
+		defer fcancel()
+
+		newClipper(r.opts, r.index).run(fch, ech, stop)
+	}()
+
+	return nil
+}
+
+// Close stops recorder and finishes the current clip.
+func (r *EventRecorder) Close() error {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+
+	close(stop)
+	<-done
+
+	return nil
+}
+
+// ringFrame is frame kept for pre-roll.
+type ringFrame struct {
+	time time.Time
+	data []byte
+}
+
+// clipper keeps state of event recording.
+type clipper struct {
+	opts  EventOptions
+	root  string
+	index Index
+
+	// ring holds frames of pre-roll while clip is not recorded.
+	ring []ringFrame
+	clip *segment
+	// event is the event of clip being recorded or pending to start with the next frame.
+	event  string
+	motion bool
+	// until is the time when clip ends if there is no motion.
+	until time.Time
+	// last is the time of the last frame.
+	last time.Time
+}
+
+func newClipper(opts EventOptions, index Index) *clipper {
+	return &clipper{opts: opts, root: filepath.Join(opts.Dir, ClipsDir), index: index}
+}
+
+func (c *clipper) run(frames <-chan *stream.Frame, evs <-chan events.Event, stop chan struct{}) {
+	defer c.finish()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case e, ok := <-evs:
+			if !ok {
+				return
+			}
+
+			c.handleEvent(e)
+		case f, ok := <-frames:
+			if !ok {
+				return
+			}
+
+			// Events published before the frame are handled first
+			for drained := false; !drained; {
+				select {
+				case e, ok := <-evs:
+					if !ok {
+						return
+					}
+
+					c.handleEvent(e)
+				default:
+					drained = true
+				}
+			}
+
+			c.handleFrame(f)
+		}
+	}
+}
+
+// handleEvent starts or extends clip. Time of the last frame is used, so that clip timing follows frames.
+func (c *clipper) handleEvent(e events.Event) {
+	switch e.Type {
+	case events.MotionStart:
+		c.motion = true
+		c.trigger(EventMotion, c.last)
+	case events.MotionEnd:
+		c.motion = false
+		c.extend(c.last.Add(c.opts.PostRoll))
+	case events.Tamper:
+		c.trigger(EventTamper, c.last.Add(c.opts.PostRoll))
+	case events.Trigger:
+		c.trigger(EventTrigger, c.last.Add(duration(e.Data["duration"])+c.opts.PostRoll))
+	}
+}
+
+// trigger sets event of the next clip unless clip is already recorded, and extends clip until t.
+func (c *clipper) trigger(event string, t time.Time) {
+	if c.event == "" {
+		c.event = event
+	}
+
+	c.extend(t)
+}
+
+func (c *clipper) extend(t time.Time) {
+	if t.After(c.until) {
+		c.until = t
+	}
+}
+
+func (c *clipper) handleFrame(f *stream.Frame) {
+	// Blank frames of privacy mode are not recorded, neither are frames before it
+	if f.Privacy {
+		c.finish()
+		c.ring = nil
+		c.event = ""
+
+		return
+	}
+
+	data, err := f.JPEG()
+	if err != nil {
+		return
+	}
+
+	c.last = f.Time
+
+	if c.clip != nil && !c.motion && f.Time.After(c.until) {
+		c.finish()
+	}
+
+	if c.clip != nil && f.Time.Sub(c.clip.start) >= c.opts.MaxLength {
+		event := c.event
+		c.finish()
+		c.event = event
+	}
+
+	if c.clip == nil && c.event != "" {
+		if err := c.start(f.Time, data); err != nil {
+			log.Printf("record: clip: %v", err)
+			c.finish()
+		}
+
+		return
+	}
+
+	if c.clip != nil {
+		err := c.clip.write(f.Time, data)
+		if errors.Is(err, ErrAVITooLarge) {
+			event := c.event
+			c.finish()
+			c.event = event
+
+			err = c.start(f.Time, data)
+		}
+
+		if err != nil {
+			log.Printf("record: clip: %v", err)
+			c.finish()
+		}
+
+		return
+	}
+
+	c.keep(f.Time, data)
+}
+
+// start starts clip with frames of pre-roll followed by the event frame.
+func (c *clipper) start(t time.Time, data []byte) error {
+	ring := append(c.ring, ringFrame{t, data})
+	c.ring = nil
+
+	seg, err := createSegment(c.root, ring[0].time, "-"+c.event, ring[0].data, c.opts.FPS)
+	if err != nil {
+		return err
+	}
+
+	c.clip = seg
+	c.clip.thumb = data
+
+	for _, rf := range ring {
+		if err := c.clip.write(rf.time, rf.data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keep adds frame to pre-roll, frames are kept at about the clip frame rate.
+func (c *clipper) keep(t time.Time, data []byte) {
+	if c.opts.PreRoll <= 0 {
+		return
+	}
+
+	interval := time.Duration(float64(time.Second) / c.opts.FPS)
+	if n := len(c.ring); n > 0 && t.Sub(c.ring[n-1].time) < interval/2 {
+		return
+	}
+
+	c.ring = append(c.ring, ringFrame{t, data})
+
+	i := 0
+	for i < len(c.ring) && t.Sub(c.ring[i].time) > c.opts.PreRoll {
+		i++
+	}
+
+	c.ring = c.ring[i:]
+}
+
+// finish finishes the current clip.
+func (c *clipper) finish() {
+	if c.clip != nil {
+		finishSegment(c.clip, c.opts.Dir, c.index, c.opts.Upload, KindClip, c.event)
+		c.clip = nil
+	}
+
+	c.event = ""
+}
+
+// duration returns duration of trigger in seconds from event data.
+func duration(v any) time.Duration {
+	switch d := v.(type) {
+	case float64:
+		return time.Duration(d * float64(time.Second))
+	case int:
+		return time.Duration(d) * time.Second
+	}
+
+	return 0
+}
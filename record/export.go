@@ -0,0 +1,367 @@
+package record
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	im "github.com/gen2brain/cam2ip/image"
+)
+
+// States of exports.
+const (
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+const (
+	// MaxExportLength is the longest time range that can be exported.
+	MaxExportLength = 24 * time.Hour
+
+	// maxRunningExports is the number of exports that can run at the same time.
+	maxRunningExports = 2
+
+	// exportTTL is how long finished exports and their files are kept.
+	exportTTL = 24 * time.Hour
+)
+
+// ErrExportBusy is returned when too many exports are running.
+var ErrExportBusy = errors.New("record: too many running exports")
+
+// RangeCatalog lists indexed recordings in time range, e.g. in database.
+type RangeCatalog interface {
+	// RecordingsBetween returns recordings that overlap time range ordered by start time, thumbnails are not needed.
+	RecordingsBetween(from, to time.Time) ([]*Recording, error)
+}
+
+// ExportOptions are options of export.
+type ExportOptions struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Overlay burns time of each frame and Label into the video, frames are re-encoded.
+	Overlay bool   `json:"overlay"`
+	Label   string `json:"label,omitempty"`
+}
+
+// Export is export of time range of recordings to MP4 file.
+type Export struct {
+	ExportOptions
+
+	ID    string `json:"id"`
+	State string `json:"state"`
+	// Progress is the exported part of time range, from 0 to 1.
+	Progress float64    `json:"progress"`
+	Frames   int        `json:"frames"`
+	Size     int64      `json:"size"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	name string
+}
+
+// Exporter exports time ranges of recordings in dir to MP4 files in out, exports are kept in memory.
+type Exporter struct {
+	dir     string
+	out     string
+	catalog RangeCatalog
+
+	mu   sync.Mutex
+	jobs map[string]*Export
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewExporter returns new Exporter.
+func NewExporter(dir, out string, catalog RangeCatalog) *Exporter {
+	return &Exporter{dir: dir, out: out, catalog: catalog, jobs: make(map[string]*Export), stop: make(chan struct{})}
+}
+
+// Start starts export in background and returns it.
+func (e *Exporter) Start(opts ExportOptions) (*Export, error) {
+	if !opts.To.After(opts.From) {
+		return nil, errors.New("record: end of export must be after start")
+	}
+
+	if opts.To.Sub(opts.From) > MaxExportLength {
+		return nil, fmt.Errorf("record: export must be shorter than %v", MaxExportLength)
+	}
+
+	if err := os.MkdirAll(e.out, 0755); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	select {
+	case <-e.stop:
+		return nil, errors.New("record: exporter is closed")
+	default:
+	}
+
+	e.clean()
+
+	running := 0
+	for _, job := range e.jobs {
+		if job.State == ExportRunning {
+			running++
+		}
+	}
+
+	if running >= maxRunningExports {
+		return nil, ErrExportBusy
+	}
+
+	job := &Export{
+		ExportOptions: opts,
+		ID:            hex.EncodeToString(id),
+		State:         ExportRunning,
+		Created:       time.Now(),
+	}
+
+	job.name = filepath.Join(e.out, job.ID+".mp4")
+	e.jobs[job.ID] = job
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+
+		err := e.run(job)
+
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		now := time.Now()
+		job.Finished = &now
+
+		if err != nil {
+			job.State = ExportFailed
+			job.Error = err.Error()
+			log.Printf("record: export %s: %v", job.ID, err)
+
+			return
+		}
+
+		job.State = ExportDone
+		job.Progress = 1
+	}()
+
+	ret := *job
+
+	return &ret, nil
+}
+
+// Get returns copy of export with id.
+func (e *Exporter) Get(id string) (*Export, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job, ok := e.jobs[id]
+	if !ok {
+		return nil, false
+	}
+
+	ret := *job
+
+	return &ret, true
+}
+
+// File returns name of MP4 file of finished export with id.
+func (e *Exporter) File(id string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	job, ok := e.jobs[id]
+	if !ok || job.State != ExportDone {
+		return "", false
+	}
+
+	return job.name, true
+}
+
+// Close stops running exports and waits for them.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	e.mu.Unlock()
+
+	e.wg.Wait()
+
+	return nil
+}
+
+// clean removes finished exports and files older than exportTTL, files are also left from previous runs.
+func (e *Exporter) clean() {
+	now := time.Now()
+
+	for id, job := range e.jobs {
+		if job.Finished != nil && now.Sub(*job.Finished) > exportTTL {
+			_ = os.Remove(job.name)
+			delete(e.jobs, id)
+		}
+	}
+
+	entries, err := os.ReadDir(e.out)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() && now.Sub(info.ModTime()) > exportTTL {
+			_ = os.Remove(filepath.Join(e.out, entry.Name()))
+		}
+	}
+}
+
+// run writes frames of time range to temporary file and renames it when done.
+func (e *Exporter) run(job *Export) error {
+	recs, err := e.catalog.RecordingsBetween(job.From, job.To)
+	if err != nil {
+		return err
+	}
+
+	tmp := job.name + ".part"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = e.write(job, f, recs)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, job.name)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+
+	return err
+}
+
+func (e *Exporter) write(job *Export, w io.WriteSeeker, recs []*Recording) error {
+	p := NewPlayback(e.dir, recs, job.From)
+	defer p.Close()
+
+	var m *MP4Writer
+	var first, last time.Time
+	var size int64
+
+	length := job.To.Sub(job.From)
+
+	for {
+		select {
+		case <-e.stop:
+			return errors.New("record: export canceled")
+		default:
+		}
+
+		data, t, err := p.Next()
+		if errors.Is(err, io.EOF) || (err == nil && !t.Before(job.To)) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// Recordings may overlap by a frame
+		if m != nil && !t.After(last) {
+			continue
+		}
+
+		if job.Overlay {
+			if data, err = overlay(data, job.Label, t); err != nil {
+				return err
+			}
+		}
+
+		if m == nil {
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+
+			if m, err = NewMP4Writer(w, cfg.Width, cfg.Height, t); err != nil {
+				return err
+			}
+
+			first = t
+		}
+
+		if err := m.WriteFrame(data, t.Sub(first)); err != nil {
+			return err
+		}
+
+		last = t
+		size += int64(len(data))
+
+		e.mu.Lock()
+		job.Frames = m.Frames()
+		job.Size = size
+		job.Progress = min(float64(t.Sub(job.From))/float64(length), 0.99)
+		e.mu.Unlock()
+	}
+
+	if m == nil {
+		return errors.New("record: no recorded frames in time range")
+	}
+
+	if err := m.Close(); err != nil {
+		return err
+	}
+
+	end, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	job.Size = end
+	e.mu.Unlock()
+
+	return nil
+}
+
+// overlay draws label and local time of frame t over JPEG frame.
+func overlay(data []byte, label string, t time.Time) ([]byte, error) {
+	img, err := im.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	text := t.Local().Format("2006-01-02 15:04:05")
+	if label != "" {
+		text = label + " " + text
+	}
+
+	var buf bytes.Buffer
+	if err := im.NewEncoder(&buf, im.DefaultEncoderOptions).Encode(im.Text(img, text)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,61 @@
+package record
+
+import (
+	"bytes"
+	"time"
+
+	im "github.com/gen2brain/cam2ip/image"
+)
+
+// thumbnailWidth is the width of recording thumbnails.
+const thumbnailWidth = 160
+
+// Kinds of recordings.
+const (
+	// KindSegment is a segment of continuous recording.
+	KindSegment = "segment"
+	// KindClip is a clip recorded around an event.
+	KindClip = "clip"
+)
+
+// Recording is a recorded file.
+type Recording struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+	// Event is the event that started clip, e.g. motion, tamper or trigger.
+	Event string `json:"event,omitempty"`
+	// Path is slash separated path relative to the recordings directory.
+	Path   string    `json:"path"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Frames int       `json:"frames"`
+	Size   int64     `json:"size"`
+	// Locked recording is never deleted by retention.
+	Locked bool `json:"locked"`
+	// Thumbnail is small JPEG of the first frame of segment or of the event frame of clip.
+	Thumbnail []byte `json:"-"`
+}
+
+// Index keeps recordings, e.g. in database.
+type Index interface {
+	// AddRecording adds finished recording and sets its id.
+	AddRecording(rec *Recording) error
+}
+
+// thumbnail returns JPEG frame scaled down to thumbnail width, nil on error.
+func thumbnail(data []byte) []byte {
+	img, err := im.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return nil
+	}
+
+	opts := im.DefaultEncoderOptions
+	opts.Quality = 70
+
+	var buf bytes.Buffer
+	if err := im.NewEncoder(&buf, opts).Encode(im.ResizeTo(img, thumbnailWidth, 0)); err != nil {
+		return nil
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,409 @@
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// mp4Timescale is the media timescale, units per second.
+	mp4Timescale = 90000
+
+	// mp4MovieTimescale is the timescale of movie and track durations.
+	mp4MovieTimescale = 1000
+
+	// objectTypeJPEG is objectTypeIndication of JPEG in ES descriptor.
+	objectTypeJPEG = 0x6C
+)
+
+// mp4Epoch is the start of MP4 time, creation times are seconds since it.
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// MP4Writer writes JPEG frames to MP4 file as MJPEG video track, frames have their own timestamps.
+// Frames are written to mdat as they come, moov with sample tables is written by Close.
+type MP4Writer struct {
+	w       io.WriteSeeker
+	width   int
+	height  int
+	created time.Time
+
+	mdat    int64
+	offset  int64
+	sizes   []uint32
+	offsets []uint64
+	times   []time.Duration
+	closed  bool
+}
+
+// NewMP4Writer writes file header to w and returns new MP4Writer, created is the time of the first frame.
+func NewMP4Writer(w io.WriteSeeker, width, height int, created time.Time) (*MP4Writer, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("record: invalid frame size")
+	}
+
+	m := &MP4Writer{w: w, width: width, height: height, created: created}
+
+	b := box("ftyp", func(b []byte) []byte {
+		b = append(b, "isom"...)
+		b = binary.BigEndian.AppendUint32(b, 512)
+
+		return append(b, "isomiso2mp41"...)
+	})
+
+	m.mdat = int64(len(b))
+
+	// mdat has 64-bit size, it is set by Close
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = append(b, "mdat"...)
+	b = binary.BigEndian.AppendUint64(b, 0)
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	m.offset = int64(len(b))
+
+	return m, nil
+}
+
+// Frames returns the number of written frames.
+func (m *MP4Writer) Frames() int {
+	return len(m.sizes)
+}
+
+// WriteFrame writes JPEG frame at time t from the start of the first frame, times must increase.
+func (m *MP4Writer) WriteFrame(jpeg []byte, t time.Duration) error {
+	if n := len(m.times); n > 0 && t <= m.times[n-1] {
+		return errors.New("record: frame time does not increase")
+	}
+
+	if _, err := m.w.Write(jpeg); err != nil {
+		return err
+	}
+
+	m.sizes = append(m.sizes, uint32(len(jpeg)))
+	m.offsets = append(m.offsets, uint64(m.offset))
+	m.times = append(m.times, t)
+	m.offset += int64(len(jpeg))
+
+	return nil
+}
+
+// Close writes moov box and updates size of mdat, it does not close underlying writer.
+func (m *MP4Writer) Close() error {
+	if m.closed {
+		return nil
+	}
+
+	m.closed = true
+
+	moov := m.moov()
+	if _, err := m.w.Write(moov); err != nil {
+		return err
+	}
+
+	end := m.offset + int64(len(moov))
+
+	if _, err := m.w.Seek(m.mdat+8, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := binary.Write(m.w, binary.BigEndian, uint64(m.offset-m.mdat)); err != nil {
+		return err
+	}
+
+	_, err := m.w.Seek(end, io.SeekStart)
+
+	return err
+}
+
+// deltas returns durations of samples in media timescale, rounded from timestamps so that they do not drift.
+// The last frame lasts as long as the previous one. A sample lasts at most math.MaxUint32 units, about 13 hours,
+// longer gaps between frames are shortened.
+func (m *MP4Writer) deltas() []uint32 {
+	ts := func(d time.Duration) int64 {
+		return int64(math.Round(d.Seconds() * mp4Timescale))
+	}
+
+	deltas := make([]uint32, len(m.times))
+	for i := 0; i+1 < len(m.times); i++ {
+		deltas[i] = uint32(min(max(ts(m.times[i+1])-ts(m.times[i]), 1), math.MaxUint32))
+	}
+
+	if n := len(deltas); n > 1 {
+		deltas[n-1] = deltas[n-2]
+	} else if n == 1 {
+		deltas[0] = mp4Timescale / 10
+	}
+
+	return deltas
+}
+
+func (m *MP4Writer) moov() []byte {
+	deltas := m.deltas()
+
+	var duration uint64
+	for _, d := range deltas {
+		duration += uint64(d)
+	}
+
+	movieDuration := duration * mp4MovieTimescale / mp4Timescale
+	created := uint64(max(m.created.Sub(mp4Epoch)/time.Second, 0))
+
+	matrix := func(b []byte) []byte {
+		for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+			b = binary.BigEndian.AppendUint32(b, v)
+		}
+
+		return b
+	}
+
+	u64 := binary.BigEndian.AppendUint64
+	u32 := binary.BigEndian.AppendUint32
+	u16 := binary.BigEndian.AppendUint16
+
+	// Version 1 boxes have 64-bit times and durations, media duration overflows 32 bits after about 13 hours
+	var version byte
+	if duration > math.MaxUint32 {
+		version = 1
+	}
+
+	long := func(b []byte, v uint64) []byte {
+		if version == 1 {
+			return u64(b, v)
+		}
+
+		return u32(b, uint32(v))
+	}
+
+	mvhd := fullBox("mvhd", version, 0, func(b []byte) []byte {
+		b = long(b, created)
+		b = long(b, created)
+		b = u32(b, mp4MovieTimescale)
+		b = long(b, movieDuration)
+		b = u32(b, 0x00010000)
+		b = u16(b, 0x0100)
+		b = append(b, make([]byte, 10)...)
+		b = matrix(b)
+		b = append(b, make([]byte, 24)...)
+
+		return u32(b, 2)
+	})
+
+	tkhd := fullBox("tkhd", version, 3, func(b []byte) []byte {
+		b = long(b, created)
+		b = long(b, created)
+		b = u32(b, 1)
+		b = u32(b, 0)
+		b = long(b, movieDuration)
+		b = append(b, make([]byte, 8)...)
+		b = u16(b, 0)
+		b = u16(b, 0)
+		b = u16(b, 0)
+		b = u16(b, 0)
+		b = matrix(b)
+		b = u32(b, uint32(m.width)<<16)
+
+		return u32(b, uint32(m.height)<<16)
+	})
+
+	mdhd := fullBox("mdhd", version, 0, func(b []byte) []byte {
+		b = long(b, created)
+		b = long(b, created)
+		b = u32(b, mp4Timescale)
+		b = long(b, duration)
+		b = u16(b, 0x55C4)
+
+		return u16(b, 0)
+	})
+
+	hdlr := fullBox("hdlr", 0, 0, func(b []byte) []byte {
+		b = u32(b, 0)
+		b = append(b, "vide"...)
+		b = append(b, make([]byte, 12)...)
+
+		return append(b, "VideoHandler\x00"...)
+	})
+
+	vmhd := fullBox("vmhd", 0, 1, func(b []byte) []byte {
+		return append(b, make([]byte, 8)...)
+	})
+
+	dinf := box("dinf", func(b []byte) []byte {
+		return append(b, fullBox("dref", 0, 0, func(b []byte) []byte {
+			b = u32(b, 1)
+
+			return append(b, fullBox("url ", 0, 1, nil)...)
+		})...)
+	})
+
+	stsd := fullBox("stsd", 0, 0, func(b []byte) []byte {
+		b = u32(b, 1)
+
+		return append(b, box("mp4v", func(b []byte) []byte {
+			b = append(b, make([]byte, 6)...)
+			b = u16(b, 1)
+			b = append(b, make([]byte, 16)...)
+			b = u16(b, uint16(m.width))
+			b = u16(b, uint16(m.height))
+			b = u32(b, 0x00480000)
+			b = u32(b, 0x00480000)
+			b = u32(b, 0)
+			b = u16(b, 1)
+
+			name := make([]byte, 32)
+			name[0] = byte(copy(name[1:], "MJPEG"))
+			b = append(b, name...)
+
+			b = u16(b, 0x0018)
+			b = u16(b, 0xFFFF)
+
+			return append(b, m.esds()...)
+		})...)
+	})
+
+	stts := fullBox("stts", 0, 0, func(b []byte) []byte {
+		var runs [][2]uint32
+		for _, d := range deltas {
+			if n := len(runs); n > 0 && runs[n-1][1] == d {
+				runs[n-1][0]++
+			} else {
+				runs = append(runs, [2]uint32{1, d})
+			}
+		}
+
+		b = u32(b, uint32(len(runs)))
+		for _, r := range runs {
+			b = u32(b, r[0])
+			b = u32(b, r[1])
+		}
+
+		return b
+	})
+
+	// Each frame is a chunk of its own
+	stsc := fullBox("stsc", 0, 0, func(b []byte) []byte {
+		if len(m.sizes) == 0 {
+			return u32(b, 0)
+		}
+
+		b = u32(b, 1)
+		b = u32(b, 1)
+		b = u32(b, 1)
+
+		return u32(b, 1)
+	})
+
+	stsz := fullBox("stsz", 0, 0, func(b []byte) []byte {
+		b = u32(b, 0)
+		b = u32(b, uint32(len(m.sizes)))
+		for _, s := range m.sizes {
+			b = u32(b, s)
+		}
+
+		return b
+	})
+
+	co64 := fullBox("co64", 0, 0, func(b []byte) []byte {
+		b = u32(b, uint32(len(m.offsets)))
+		for _, o := range m.offsets {
+			b = binary.BigEndian.AppendUint64(b, o)
+		}
+
+		return b
+	})
+
+	stbl := box("stbl", func(b []byte) []byte {
+		return concat(b, stsd, stts, stsc, stsz, co64)
+	})
+
+	minf := box("minf", func(b []byte) []byte {
+		return concat(b, vmhd, dinf, stbl)
+	})
+
+	mdia := box("mdia", func(b []byte) []byte {
+		return concat(b, mdhd, hdlr, minf)
+	})
+
+	trak := box("trak", func(b []byte) []byte {
+		return concat(b, tkhd, mdia)
+	})
+
+	return box("moov", func(b []byte) []byte {
+		return concat(b, mvhd, trak)
+	})
+}
+
+// esds returns ES descriptor box of JPEG video stream.
+func (m *MP4Writer) esds() []byte {
+	var maxSize uint32
+	var total uint64
+	for _, s := range m.sizes {
+		maxSize = max(maxSize, s)
+		total += uint64(s)
+	}
+
+	var bitrate uint32
+	if n := len(m.times); n > 1 {
+		if d := (m.times[n-1] - m.times[0]).Seconds(); d > 0 {
+			bitrate = uint32(min(float64(total)*8/d, math.MaxUint32))
+		}
+	}
+
+	dcd := []byte{objectTypeJPEG, 0x04<<2 | 1, byte(maxSize >> 16), byte(maxSize >> 8), byte(maxSize)}
+	dcd = binary.BigEndian.AppendUint32(dcd, bitrate)
+	dcd = binary.BigEndian.AppendUint32(dcd, bitrate)
+
+	es := []byte{0, 1, 0}
+	es = append(es, descriptor(0x04, dcd)...)
+	es = append(es, descriptor(0x06, []byte{0x02})...)
+
+	return fullBox("esds", 0, 0, func(b []byte) []byte {
+		return append(b, descriptor(0x03, es)...)
+	})
+}
+
+// box returns box of type typ with content appended by fn.
+func box(typ string, fn func(b []byte) []byte) []byte {
+	b := make([]byte, 8, 64)
+	copy(b[4:], typ)
+
+	if fn != nil {
+		b = fn(b)
+	}
+
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+
+	return b
+}
+
+// fullBox returns box with version and flags.
+func fullBox(typ string, version byte, flags uint32, fn func(b []byte) []byte) []byte {
+	return box(typ, func(b []byte) []byte {
+		b = binary.BigEndian.AppendUint32(b, uint32(version)<<24|flags)
+		if fn != nil {
+			b = fn(b)
+		}
+
+		return b
+	})
+}
+
+// descriptor returns MPEG-4 descriptor with length in four bytes.
+func descriptor(tag byte, data []byte) []byte {
+	n := len(data)
+	b := []byte{tag, byte(n>>21)&0x7F | 0x80, byte(n>>14)&0x7F | 0x80, byte(n>>7)&0x7F | 0x80, byte(n) & 0x7F}
+
+	return append(b, data...)
+}
+
+func concat(b []byte, parts ...[]byte) []byte {
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+
+	return b
+}
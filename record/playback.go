@@ -0,0 +1,166 @@
+package record
+
+import (
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"time"
+
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+// Playback reads frames of recordings in order of time from a position, recordings that overlap
+// already played time are played from where the previous one ended.
+type Playback struct {
+	dir  string
+	recs []*Recording
+	pos  time.Time
+
+	avi   *AVIReader
+	rec   *Recording
+	frame int
+}
+
+// NewPlayback returns new Playback of recordings in dir ordered by start time, starting at from.
+func NewPlayback(dir string, recs []*Recording, from time.Time) *Playback {
+	return &Playback{dir: dir, recs: recs, pos: from}
+}
+
+// Next returns the next frame and its time, empty frames are skipped. It returns io.EOF after the last recording.
+func (p *Playback) Next() ([]byte, time.Time, error) {
+	for {
+		if p.avi == nil {
+			if err := p.open(); err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+
+		if p.frame >= p.avi.Frames() {
+			if t := p.frameTime(p.frame); t.After(p.pos) {
+				p.pos = t
+			}
+
+			p.close()
+
+			continue
+		}
+
+		i := p.frame
+		p.frame++
+
+		data, err := p.avi.Frame(i)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if data != nil {
+			t := p.frameTime(i)
+			p.pos = t
+
+			return data, t, nil
+		}
+	}
+}
+
+// Seek moves forward to t, frames before t are skipped without reading them.
+func (p *Playback) Seek(t time.Time) {
+	if !t.After(p.pos) {
+		return
+	}
+
+	p.pos = t
+
+	if p.avi != nil {
+		p.frame = max(p.frame, int(math.Ceil(t.Sub(p.rec.Start).Seconds()*p.avi.FPS())))
+	}
+}
+
+// open opens the next recording that ends after the current position.
+func (p *Playback) open() error {
+	for len(p.recs) > 0 {
+		rec := p.recs[0]
+		p.recs = p.recs[1:]
+
+		if !rec.End.After(p.pos) {
+			continue
+		}
+
+		avi, err := OpenAVI(filepath.Join(p.dir, filepath.FromSlash(rec.Path)))
+		if err != nil {
+			// File may have been deleted by retention in the meantime
+			continue
+		}
+
+		p.avi, p.rec = avi, rec
+		p.frame = 0
+
+		if d := p.pos.Sub(rec.Start); d > 0 {
+			p.frame = int(math.Ceil(d.Seconds() * avi.FPS()))
+		}
+
+		return nil
+	}
+
+	return io.EOF
+}
+
+// frameTime returns time of frame i of the current recording.
+func (p *Playback) frameTime(i int) time.Time {
+	return p.rec.Start.Add(time.Duration(math.Round(float64(i) * float64(time.Second) / p.avi.FPS())))
+}
+
+func (p *Playback) close() {
+	if p.avi != nil {
+		_ = p.avi.Close()
+		p.avi, p.rec = nil, nil
+	}
+}
+
+// Close closes the current recording.
+func (p *Playback) Close() error {
+	p.close()
+
+	return nil
+}
+
+// FrameStore reads recorded frames of time ranges, e.g. for exports longer than in-memory history.
+type FrameStore struct {
+	dir     string
+	catalog RangeCatalog
+}
+
+// NewFrameStore returns new FrameStore of recordings in dir.
+func NewFrameStore(dir string, catalog RangeCatalog) *FrameStore {
+	return &FrameStore{dir: dir, catalog: catalog}
+}
+
+// Frames calls fn with recorded frames in range, at most fps frames per second, oldest first. Frames are read one
+// at a time, so that long ranges are not held in memory, error of fn stops reading and is returned.
+func (s *FrameStore) Frames(from, to time.Time, fps float64, fn func(f *stream.Frame) error) error {
+	recs, err := s.catalog.RecordingsBetween(from, to)
+	if err != nil {
+		return err
+	}
+
+	p := NewPlayback(s.dir, recs, from)
+	defer p.Close()
+
+	interval := time.Duration(float64(time.Second) / fps)
+
+	for seq := uint64(0); ; seq++ {
+		data, t, err := p.Next()
+		if errors.Is(err, io.EOF) || (err == nil && !t.Before(to)) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := fn(stream.NewFrameJPEG(seq, t, data)); err != nil {
+			return err
+		}
+
+		// Frames between samples are not read
+		p.Seek(t.Add(interval))
+	}
+}
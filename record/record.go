@@ -0,0 +1,370 @@
+// Package record records camera frames to time-segmented MJPEG AVI files and clips around events.
+package record
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+const (
+	// maxGap is the longest pause between frames within segment, e.g. camera offline, a new segment is started after it.
+	maxGap = 5 * time.Second
+
+	// retryInterval is the pause after segment file could not be created.
+	retryInterval = 10 * time.Second
+
+	// checkpointInterval is the time of recording between header checkpoints, at most that much is lost on power loss.
+	checkpointInterval = 10 * time.Second
+)
+
+// Source provides frames to record, e.g. *stream.Stream.
+type Source interface {
+	// Subscribe returns channel that receives frames, returned function unsubscribes.
+	Subscribe() (<-chan *stream.Frame, func())
+}
+
+// Options are recorder options.
+type Options struct {
+	// Dir is the directory of recordings, segments are kept in subdirectory of each day.
+	Dir string
+	// Segment is the segment length, segments are aligned to multiples of it, e.g. 10:00, 10:10.
+	Segment time.Duration
+	// FPS is the frame rate of recording, frames are dropped or repeated to keep timing.
+	FPS float64
+	// Upload copies finished segments to storage, nil disables uploads.
+	Upload *Uploader
+}
+
+// Recorder records frames of source to segments.
+type Recorder struct {
+	opts   Options
+	source Source
+	index  Index
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRecorder returns new Recorder, finished segments are added to index unless it is nil.
+func NewRecorder(opts Options, source Source, index Index) *Recorder {
+	if opts.Segment <= 0 {
+		opts.Segment = 10 * time.Minute
+	}
+
+	if opts.FPS <= 0 {
+		opts.FPS = 10
+	}
+
+	return &Recorder{opts: opts, source: source, index: index}
+}
+
+// StartRecording starts continuous recording.
+func (r *Recorder) StartRecording() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(r.opts.Dir, 0755); err != nil {
+		return err
+	}
+
+	ch, cancel := r.source.Subscribe()
+
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go r.run(ch, cancel, r.stop, r.done)
+
+	events.Publish(events.RecordingStarted, map[string]any{"mode": "continuous"})
+
+	return nil
+}
+
+// StopRecording stops recording and finishes the current segment.
+func (r *Recorder) StopRecording() error {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+
+	close(stop)
+	<-done
+
+	return nil
+}
+
+// FPS returns the frame rate of recording.
+func (r *Recorder) FPS() float64 {
+	return r.opts.FPS
+}
+
+// Recording reports whether recording is in progress.
+func (r *Recorder) Recording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.stop != nil
+}
+
+// Close stops recording.
+func (r *Recorder) Close() error {
+	return r.StopRecording()
+}
+
+func (r *Recorder) run(ch <-chan *stream.Frame, cancel func(), stop, done chan struct{}) {
+	defer close(done)
+	defer cancel()
+
+	var seg *segment
+	var failed time.Time
+
+	finish := func() {
+		if seg != nil {
+			finishSegment(seg, r.opts.Dir, r.index, r.opts.Upload, KindSegment, "")
+			seg = nil
+		}
+	}
+
+	defer finish()
+
+	for {
+		var f *stream.Frame
+		var ok bool
+
+		select {
+		case <-stop:
+			return
+		case f, ok = <-ch:
+			if !ok {
+				return
+			}
+		}
+
+		// Blank frames of privacy mode are not recorded
+		if f.Privacy {
+			finish()
+			continue
+		}
+
+		data, err := f.JPEG()
+		if err != nil {
+			continue
+		}
+
+		if seg != nil && (!f.Time.Before(seg.end) || f.Time.Sub(seg.last) > maxGap) {
+			finish()
+		}
+
+		if seg == nil {
+			if f.Time.Sub(failed) < retryInterval {
+				continue
+			}
+
+			seg, err = createSegment(r.opts.Dir, f.Time, "", data, r.opts.FPS)
+			if err != nil {
+				log.Printf("record: %v", err)
+				failed = f.Time
+
+				continue
+			}
+
+			seg.end = f.Time.Truncate(r.opts.Segment).Add(r.opts.Segment)
+		}
+
+		err = seg.write(f.Time, data)
+		if errors.Is(err, ErrAVITooLarge) {
+			finish()
+
+			seg, err = createSegment(r.opts.Dir, f.Time, "", data, r.opts.FPS)
+			if err == nil {
+				seg.end = f.Time.Truncate(r.opts.Segment).Add(r.opts.Segment)
+				err = seg.write(f.Time, data)
+			}
+		}
+
+		if err != nil {
+			log.Printf("record: %v", err)
+			finish()
+			failed = f.Time
+		}
+	}
+}
+
+// createSegment creates file for segment starting with frame at t in subdirectory of root for the day,
+// suffix is added to file name. Frame size is taken from the frame.
+func createSegment(root string, t time.Time, suffix string, data []byte, fps float64) (*segment, error) {
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(root, t.Format("2006-01-02"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// Segment started in the same second as the previous one gets a suffix
+	var name string
+	var file *os.File
+
+	for i := 0; ; i++ {
+		name = filepath.Join(dir, t.Format("20060102-150405")+suffix+".avi")
+		if i > 0 {
+			name = filepath.Join(dir, fmt.Sprintf("%s%s-%d.avi", t.Format("20060102-150405"), suffix, i))
+		}
+
+		file, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+		if err == nil {
+			break
+		}
+
+		if !os.IsExist(err) || i == 9 {
+			return nil, err
+		}
+	}
+
+	avi, err := NewAVIWriter(file, cfg.Width, cfg.Height, fps)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(name)
+
+		return nil, err
+	}
+
+	return &segment{
+		name:  name,
+		file:  file,
+		avi:   avi,
+		fps:   fps,
+		start: t,
+		saved: t,
+		thumb: data,
+	}, nil
+}
+
+// finishSegment closes segment, adds it to index and uploads it, errors are logged.
+func finishSegment(seg *segment, dir string, index Index, upload *Uploader, kind, event string) {
+	if err := seg.close(); err != nil {
+		log.Printf("record: %s: %v", seg.name, err)
+		return
+	}
+
+	if index == nil || seg.avi.Frames() == 0 {
+		return
+	}
+
+	rec := seg.recording(dir)
+	rec.Kind = kind
+	rec.Event = event
+
+	if err := index.AddRecording(rec); err != nil {
+		log.Printf("record: %s: %v", seg.name, err)
+		return
+	}
+
+	upload.Upload(rec)
+}
+
+// segment is AVI file being recorded.
+type segment struct {
+	name  string
+	file  *os.File
+	avi   *AVIWriter
+	fps   float64
+	start time.Time
+	end   time.Time
+	last  time.Time
+	// saved is time of frame at the last checkpoint.
+	saved time.Time
+	// thumb is JPEG frame for thumbnail.
+	thumb []byte
+}
+
+// recording returns recording of closed segment with path relative to dir.
+func (s *segment) recording(dir string) *Recording {
+	rel, err := filepath.Rel(dir, s.name)
+	if err != nil {
+		rel = s.name
+	}
+
+	return &Recording{
+		Path:      filepath.ToSlash(rel),
+		Start:     s.start,
+		End:       s.start.Add(time.Duration(math.Round(float64(s.avi.Frames()) * float64(time.Second) / s.fps))),
+		Frames:    s.avi.Frames(),
+		Size:      s.avi.Size(),
+		Thumbnail: thumbnail(s.thumb),
+	}
+}
+
+// write writes frame at its position in time, empty frames fill the gap after the previous frame
+// and frame that comes before its time is dropped.
+func (s *segment) write(t time.Time, data []byte) error {
+	n := int(math.Round(t.Sub(s.start).Seconds() * s.fps))
+	if n < s.avi.Frames() {
+		return nil
+	}
+
+	for s.avi.Frames() < n {
+		if err := s.avi.Skip(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.avi.WriteFrame(data); err != nil {
+		return err
+	}
+
+	s.last = t
+
+	// Header and frames are flushed to disk, so file can be recovered
+	if t.Sub(s.saved) >= checkpointInterval {
+		s.saved = t
+
+		if err := s.avi.Checkpoint(); err != nil {
+			return err
+		}
+
+		return s.file.Sync()
+	}
+
+	return nil
+}
+
+func (s *segment) close() error {
+	err := s.avi.Close()
+
+	if serr := s.file.Sync(); err == nil {
+		err = serr
+	}
+
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+
+	// Segment without frames is not useful
+	if s.avi.Frames() == 0 {
+		_ = os.Remove(s.name)
+	}
+
+	return err
+}
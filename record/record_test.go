@@ -0,0 +1,957 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/storage"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// checkAVI checks RIFF structure of file and returns sizes of frames in index.
+func checkAVI(t *testing.T, name string) []uint32 {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "AVI " || int(le.Uint32(data[4:])) != len(data)-8 {
+		t.Fatalf("%s: invalid RIFF header", name)
+	}
+
+	if string(data[moviOffset:moviOffset+4]) != "movi" {
+		t.Fatalf("%s: movi not found", name)
+	}
+
+	moviEnd := moviOffset + int(le.Uint32(data[moviOffset-4:]))
+	if string(data[moviEnd:moviEnd+4]) != "idx1" {
+		t.Fatalf("%s: idx1 not found after movi", name)
+	}
+
+	n := int(le.Uint32(data[moviEnd+4:])) / aviEntrySize
+	if frames := int(le.Uint32(data[48:])); frames != n {
+		t.Errorf("%s: avih has %d frames, index %d", name, frames, n)
+	}
+
+	var sizes []uint32
+	for i := 0; i < n; i++ {
+		e := data[moviEnd+8+i*aviEntrySize:]
+		off := moviOffset + int(le.Uint32(e[8:]))
+		size := le.Uint32(e[12:])
+
+		if string(data[off:off+4]) != frameChunkID || le.Uint32(data[off+4:]) != size {
+			t.Fatalf("%s: index entry %d does not point to its chunk", name, i)
+		}
+
+		if size > 0 && !bytes.HasPrefix(data[off+8:], []byte{0xFF, 0xD8}) {
+			t.Errorf("%s: chunk %d is not JPEG", name, i)
+		}
+
+		sizes = append(sizes, size)
+	}
+
+	return sizes
+}
+
+func TestAVIWriter(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.avi")
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAVIWriter(f, 64, 48, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := testJPEG(t, 64, 48)
+
+	for _, skip := range []bool{false, true, false, false} {
+		if skip {
+			err = a.Skip()
+		} else {
+			err = a.WriteFrame(frame)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := checkAVI(t, name)
+	if len(sizes) != 4 || sizes[1] != 0 || sizes[2] != uint32(len(frame)) {
+		t.Errorf("unexpected index %v", sizes)
+	}
+}
+
+type source chan *stream.Frame
+
+func (s source) Subscribe() (<-chan *stream.Frame, func()) {
+	return s, func() {}
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	src := make(source)
+
+	r := NewRecorder(Options{Dir: dir, Segment: 10 * time.Second, FPS: 10}, src, nil)
+	if err := r.StartRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Recording() {
+		t.Fatal("not recording")
+	}
+
+	data := testJPEG(t, 32, 24)
+	start := time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local)
+
+	// 10 fps for 10s with a gap of one second, segment ends at 15:04:10
+	var seq uint64
+	for d := time.Duration(0); d < 10*time.Second; d += 100 * time.Millisecond {
+		if d >= 2*time.Second && d < 3*time.Second {
+			continue
+		}
+
+		seq++
+		src <- stream.NewFrameJPEG(seq, start.Add(d), data)
+	}
+
+	if err := r.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := filepath.Join(dir, "2025-01-02", "20250102-150405.avi")
+	second := filepath.Join(dir, "2025-01-02", "20250102-150410.avi")
+
+	if sizes := checkAVI(t, first); len(sizes) != 50 || sizes[25] != 0 || sizes[30] == 0 {
+		t.Errorf("first segment has %d frames", len(sizes))
+	}
+
+	if sizes := checkAVI(t, second); len(sizes) != 50 {
+		t.Errorf("second segment has %d frames", len(sizes))
+	}
+}
+
+type index []*Recording
+
+func (i *index) AddRecording(rec *Recording) error {
+	rec.ID = int64(len(*i) + 1)
+	*i = append(*i, rec)
+
+	return nil
+}
+
+func TestEventRecorder(t *testing.T) {
+	dir := t.TempDir()
+	src := make(source)
+	bus := events.NewBus()
+
+	var idx index
+
+	r := NewEventRecorder(EventOptions{Dir: dir, FPS: 10, PreRoll: time.Second, PostRoll: time.Second}, src, bus, &idx)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := testJPEG(t, 32, 24)
+	start := time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local)
+
+	var seq uint64
+	send := func(from, to int) {
+		for i := from; i < to; i++ {
+			seq++
+			src <- stream.NewFrameJPEG(seq, start.Add(time.Duration(i)*100*time.Millisecond), data)
+		}
+	}
+
+	// Motion from 3s to 5s, clip has pre-roll from 1.9s and post-roll until 5.9s,
+	// event may be handled one frame earlier since it races with the last sent frame
+	send(0, 30)
+	bus.Publish(events.MotionStart, nil)
+	send(30, 50)
+	bus.Publish(events.MotionEnd, nil)
+	send(50, 80)
+
+	// Trigger for 0.5s at 8s, clip is from 6.9s until 9.4s
+	bus.Publish(events.Trigger, map[string]any{"duration": 0.5})
+	send(80, 110)
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(idx) != 2 {
+		t.Fatalf("got %d clips, want 2", len(idx))
+	}
+
+	for i, want := range []struct {
+		event      string
+		start, end time.Duration
+	}{
+		{EventMotion, 1900 * time.Millisecond, 6 * time.Second},
+		{EventTrigger, 6900 * time.Millisecond, 9500 * time.Millisecond},
+	} {
+		rec := idx[i]
+		from, to := rec.Start.Sub(start), rec.End.Sub(start)
+
+		if rec.Kind != KindClip || rec.Event != want.event {
+			t.Errorf("clip %d: got %s %s", i, rec.Kind, rec.Event)
+		}
+
+		if from < want.start-100*time.Millisecond || from > want.start || to < want.end-100*time.Millisecond || to > want.end {
+			t.Errorf("clip %d: got %v-%v, want %v-%v", i, from, to, want.start, want.end)
+		}
+
+		if len(rec.Thumbnail) == 0 {
+			t.Errorf("clip %d: no thumbnail", i)
+		}
+
+		if sizes := checkAVI(t, filepath.Join(dir, filepath.FromSlash(rec.Path))); len(sizes) != rec.Frames {
+			t.Errorf("clip %d: file has %d frames, index %d", i, len(sizes), rec.Frames)
+		}
+	}
+}
+
+func TestRetentionPlan(t *testing.T) {
+	now := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	rec := func(id int64, kind string, age time.Duration, size int64, locked bool) *Recording {
+		return &Recording{ID: id, Kind: kind, Start: now.Add(-age - time.Minute), End: now.Add(-age), Size: size, Locked: locked}
+	}
+
+	recs := []*Recording{
+		rec(1, KindClip, 9*day, 10, true),
+		rec(2, KindSegment, 8*day, 100, false),
+		rec(3, KindClip, 7*day, 10, false),
+		rec(4, KindSegment, 2*day, 100, false),
+		rec(5, KindClip, 2*day, 10, false),
+		rec(6, KindSegment, day, 100, false),
+	}
+
+	ids := func(rep *Report) (ret []int64) {
+		for _, d := range rep.Deleted {
+			ret = append(ret, d.Recording.ID)
+		}
+
+		return ret
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts RetentionOptions
+		free int64
+		want []int64
+	}{
+		{"none", RetentionOptions{}, 1000, nil},
+		{"age", RetentionOptions{MaxAge: 3 * day, ClipMaxAge: 8 * day}, 1000, []int64{2}},
+		{"clip age", RetentionOptions{MaxAge: 3 * day, ClipMaxAge: 5 * day}, 1000, []int64{2, 3}},
+		{"size", RetentionOptions{MaxSize: 150}, 1000, []int64{2, 4}},
+		{"size clips", RetentionOptions{MaxSize: 15}, 1000, []int64{2, 4, 6, 3, 5}},
+		{"free", RetentionOptions{MinFree: 150}, 0, []int64{2, 4}},
+		{"free unknown", RetentionOptions{MinFree: 150}, -1, nil},
+	} {
+		rep := plan(tt.opts, recs, tt.free, now)
+
+		if got := ids(rep); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: deleted %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	rep := plan(RetentionOptions{}, recs, 1000, now)
+	if rep.Segments.Count != 3 || rep.Clips.Size != 30 || rep.Locked.Count != 1 || !rep.Oldest.Equal(recs[0].Start) {
+		t.Errorf("unexpected usage %+v", rep)
+	}
+}
+
+type catalog struct {
+	recs []*Recording
+}
+
+func (c *catalog) Recordings() ([]*Recording, error) {
+	return c.recs, nil
+}
+
+func (c *catalog) DeleteRecording(id int64) error {
+	for i, rec := range c.recs {
+		if rec.ID == id {
+			c.recs = append(c.recs[:i], c.recs[i+1:]...)
+		}
+	}
+
+	return nil
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	var recs []*Recording
+	for i, path := range []string{"2025-01-01/20250101-100000.avi", "2025-01-02/20250102-100000.avi"} {
+		name := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(name, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		recs = append(recs, &Recording{ID: int64(i + 1), Kind: KindSegment, Path: path, Start: now, End: now, Size: 100})
+	}
+
+	c := &catalog{recs: recs}
+	r := NewRetention(RetentionOptions{MaxSize: 100}, dir, c)
+
+	rep, err := r.Run(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rep.Deleted) != 1 || len(c.recs) != 2 || r.Last() != nil {
+		t.Fatalf("dry run deleted recordings")
+	}
+
+	rep, err = r.Run(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rep.Deleted) != 1 || rep.Freed != 100 || len(c.recs) != 1 || r.Last() != rep {
+		t.Fatalf("unexpected report %+v", rep)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "2025-01-01")); !os.IsNotExist(err) {
+		t.Errorf("empty directory of the day is not deleted")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "2025-01-02", "20250102-100000.avi")); err != nil {
+		t.Error(err)
+	}
+}
+
+// writeAVI writes file with frames at 10 fps, false frames are skipped.
+func writeAVI(t *testing.T, name string, frames []bool, closed bool) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	a, err := NewAVIWriter(f, 32, 24, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := testJPEG(t, 32, 24)
+
+	for _, ok := range frames {
+		if ok {
+			err = a.WriteFrame(data)
+		} else {
+			err = a.Skip()
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if closed {
+		if err := a.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAVIReader(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, closed := range []bool{true, false} {
+		name := filepath.Join(dir, fmt.Sprintf("%v.avi", closed))
+		writeAVI(t, name, []bool{true, false, true, true}, closed)
+
+		if !closed {
+			// Interrupted write of the last chunk
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _ = f.Write([]byte("00dc\x00\x10\x00\x00\xff\xd8"))
+			_ = f.Close()
+		}
+
+		a, err := OpenAVI(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.Frames() != 4 || a.FPS() != 10 || a.Width() != 32 || a.Height() != 24 {
+			t.Errorf("closed %v: got %d frames at %v fps, %dx%d", closed, a.Frames(), a.FPS(), a.Width(), a.Height())
+		}
+
+		for i := 0; i < a.Frames(); i++ {
+			data, err := a.Frame(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (i == 1) != (data == nil) || (data != nil && !bytes.HasPrefix(data, []byte{0xFF, 0xD8})) {
+				t.Errorf("closed %v: unexpected frame %d", closed, i)
+			}
+		}
+
+		_ = a.Close()
+	}
+
+	// Index chunk of interrupted close is larger than the file, frames are scanned
+	name := filepath.Join(dir, "idx1.avi")
+	writeAVI(t, name, []bool{true, true}, false)
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = f.Write([]byte("idx1\xf0\xff\xff\x7f00dc\x10\x00\x00\x00"))
+	_ = f.Close()
+
+	a, err := OpenAVI(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	if a.Frames() != 2 || a.indexed {
+		t.Errorf("expected 2 scanned frames, got %d, indexed %v", a.Frames(), a.indexed)
+	}
+}
+
+func TestPlayback(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	frames := func(n, skip int) []bool {
+		ret := make([]bool, n)
+		for i := range ret {
+			ret[i] = i != skip
+		}
+
+		return ret
+	}
+
+	recs := []*Recording{
+		{Path: "a.avi", Start: start, End: start.Add(2 * time.Second)},
+		{Path: "b.avi", Start: start.Add(1500 * time.Millisecond), End: start.Add(2500 * time.Millisecond)},
+		{Path: "missing.avi", Start: start.Add(5 * time.Second), End: start.Add(6 * time.Second)},
+		{Path: "c.avi", Start: start.Add(10 * time.Second), End: start.Add(11 * time.Second)},
+	}
+
+	writeAVI(t, filepath.Join(dir, "a.avi"), frames(20, 10), true)
+	writeAVI(t, filepath.Join(dir, "b.avi"), frames(10, -1), true)
+	writeAVI(t, filepath.Join(dir, "c.avi"), frames(10, -1), true)
+
+	p := NewPlayback(dir, recs, start.Add(550*time.Millisecond))
+	defer p.Close()
+
+	var times []time.Duration
+	for {
+		_, ft, err := p.Next()
+		if err != nil {
+			break
+		}
+
+		times = append(times, ft.Sub(start))
+	}
+
+	// 0.6s-1.9s without 1s from a, 2.0s-2.4s from b and 10s-10.9s from c
+	if len(times) != 13+5+10 || times[0] != 600*time.Millisecond || times[13] != 2*time.Second || times[18] != 10*time.Second {
+		t.Errorf("unexpected frame times %v", times)
+	}
+}
+
+// mp4Boxes returns boxes of data by path of types, e.g. moov/trak, and content of each box.
+func mp4Boxes(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	boxes := make(map[string][]byte)
+
+	var walk func(data []byte, prefix string)
+	walk = func(data []byte, prefix string) {
+		for len(data) > 0 {
+			if len(data) < 8 {
+				t.Fatalf("truncated box in %q", prefix)
+			}
+
+			size := uint64(binary.BigEndian.Uint32(data))
+			typ := string(data[4:8])
+			hdr := uint64(8)
+
+			if size == 1 {
+				size = binary.BigEndian.Uint64(data[8:])
+				hdr = 16
+			}
+
+			if size < hdr || size > uint64(len(data)) {
+				t.Fatalf("invalid size %d of box %s%s", size, prefix, typ)
+			}
+
+			path := prefix + typ
+			boxes[path] = data[hdr:size]
+
+			switch typ {
+			case "moov", "trak", "mdia", "minf", "stbl":
+				walk(data[hdr:size], path+"/")
+			}
+
+			data = data[size:]
+		}
+	}
+
+	walk(data, "")
+
+	return boxes
+}
+
+func TestMP4Writer(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mp4")
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMP4Writer(f, 32, 24, time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 fps with a gap of one second after the third frame
+	times := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 1200 * time.Millisecond, 1300 * time.Millisecond}
+	for _, ft := range times {
+		if err := m.WriteFrame(testJPEG(t, 32, 24), ft); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.WriteFrame(testJPEG(t, 32, 24), time.Second); err == nil {
+		t.Error("expected error for decreasing time")
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = f.Close()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boxes := mp4Boxes(t, data)
+	be := binary.BigEndian
+
+	stbl := "moov/trak/mdia/minf/stbl/"
+	for _, typ := range []string{"ftyp", "mdat", "moov/mvhd", "moov/trak/tkhd", "moov/trak/mdia/mdhd", stbl + "stsd", stbl + "stts", stbl + "stsz", stbl + "co64"} {
+		if _, ok := boxes[typ]; !ok {
+			t.Fatalf("missing box %s", typ)
+		}
+	}
+
+	// Runs of 2x100ms, 1x1s and 2x100ms
+	stts := boxes[stbl+"stts"]
+	if n := be.Uint32(stts[4:]); n != 3 || be.Uint32(stts[8:]) != 2 || be.Uint32(stts[12:]) != 9000 || be.Uint32(stts[20:]) != 90000 {
+		t.Errorf("unexpected stts % x", stts)
+	}
+
+	// Duration of 1.4s in movie timescale
+	if d := be.Uint32(boxes["moov/mvhd"][16:]); d != 1400 {
+		t.Errorf("expected duration 1400, got %d", d)
+	}
+
+	stsz := boxes[stbl+"stsz"]
+	co64 := boxes[stbl+"co64"]
+
+	if be.Uint32(stsz[8:]) != uint32(len(times)) || be.Uint32(co64[4:]) != uint32(len(times)) {
+		t.Fatalf("expected %d samples", len(times))
+	}
+
+	for i := range times {
+		size := be.Uint32(stsz[12+4*i:])
+		offset := be.Uint64(co64[8+8*i:])
+
+		frame := data[offset : offset+uint64(size)]
+		if !bytes.HasPrefix(frame, []byte{0xFF, 0xD8}) || !bytes.HasSuffix(frame, []byte{0xFF, 0xD9}) {
+			t.Errorf("sample %d is not JPEG", i)
+		}
+	}
+}
+
+func TestMP4WriterLong(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.mp4")
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMP4Writer(f, 32, 24, time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 21 hours in three samples of 7 hours, the gap of 14 hours is shortened to the longest sample
+	for _, ft := range []time.Duration{0, 7 * time.Hour, 14 * time.Hour, 28 * time.Hour} {
+		if err := m.WriteFrame(testJPEG(t, 32, 24), ft); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = f.Close()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boxes := mp4Boxes(t, data)
+	be := binary.BigEndian
+
+	media := uint64(2*7*3600*mp4Timescale) + 2*math.MaxUint32
+	movie := media * mp4MovieTimescale / mp4Timescale
+
+	mvhd, tkhd, mdhd := boxes["moov/mvhd"], boxes["moov/trak/tkhd"], boxes["moov/trak/mdia/mdhd"]
+	if mvhd[0] != 1 || tkhd[0] != 1 || mdhd[0] != 1 {
+		t.Fatalf("expected version 1 boxes, got %d, %d, %d", mvhd[0], tkhd[0], mdhd[0])
+	}
+
+	if d := be.Uint64(mdhd[24:]); d != media {
+		t.Errorf("expected media duration %d, got %d", media, d)
+	}
+
+	if d := be.Uint64(mvhd[24:]); d != movie {
+		t.Errorf("expected movie duration %d, got %d", movie, d)
+	}
+
+	if d := be.Uint64(tkhd[28:]); d != movie {
+		t.Errorf("expected track duration %d, got %d", movie, d)
+	}
+
+	// Runs of 2x7h and 2 shortened samples
+	stts := boxes["moov/trak/mdia/minf/stbl/stts"]
+	if n := be.Uint32(stts[4:]); n != 2 || be.Uint32(stts[12:]) != 7*3600*mp4Timescale || be.Uint32(stts[20:]) != math.MaxUint32 {
+		t.Errorf("unexpected stts % x", stts)
+	}
+}
+
+type rangeCatalog []*Recording
+
+func (c rangeCatalog) RecordingsBetween(from, to time.Time) ([]*Recording, error) {
+	var ret []*Recording
+	for _, rec := range c {
+		if rec.End.After(from) && rec.Start.Before(to) {
+			ret = append(ret, rec)
+		}
+	}
+
+	return ret, nil
+}
+
+func TestExporter(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	writeAVI(t, filepath.Join(dir, "a.avi"), []bool{true, true, true, true, true, true, true, true, true, true}, true)
+	writeAVI(t, filepath.Join(dir, "b.avi"), []bool{true, true, true, true, true, true, true, true, true, true}, true)
+
+	recs := rangeCatalog{
+		{Path: "a.avi", Start: start, End: start.Add(time.Second)},
+		{Path: "b.avi", Start: start.Add(5 * time.Second), End: start.Add(6 * time.Second)},
+	}
+
+	e := NewExporter(dir, filepath.Join(dir, "exports"), recs)
+	defer e.Close()
+
+	if _, err := e.Start(ExportOptions{From: start, To: start}); err == nil {
+		t.Error("expected error for empty range")
+	}
+
+	for _, overlay := range []bool{false, true} {
+		job, err := e.Start(ExportOptions{From: start.Add(500 * time.Millisecond), To: start.Add(5500 * time.Millisecond), Overlay: overlay, Label: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for job.State == ExportRunning && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			job, _ = e.Get(job.ID)
+		}
+
+		if job.State != ExportDone || job.Progress != 1 {
+			t.Fatalf("overlay %v: export %s: %s", overlay, job.State, job.Error)
+		}
+
+		// 0.5s-0.9s from a and 5.0s-5.4s from b
+		if job.Frames != 10 {
+			t.Errorf("overlay %v: expected 10 frames, got %d", overlay, job.Frames)
+		}
+
+		name, ok := e.File(job.ID)
+		if !ok {
+			t.Fatal("missing file")
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if int64(len(data)) != job.Size {
+			t.Errorf("overlay %v: expected size %d, got %d", overlay, job.Size, len(data))
+		}
+
+		boxes := mp4Boxes(t, data)
+
+		// Gap between recordings is kept, duration is 5s including the last frame
+		if d := binary.BigEndian.Uint32(boxes["moov/mvhd"][16:]); d != 5000 {
+			t.Errorf("overlay %v: expected duration 5000, got %d", overlay, d)
+		}
+	}
+
+	if job, err := e.Start(ExportOptions{From: start.Add(2 * time.Second), To: start.Add(3 * time.Second)}); err != nil {
+		t.Fatal(err)
+	} else {
+		_ = e.Close()
+
+		if job, _ = e.Get(job.ID); job.State != ExportFailed {
+			t.Errorf("expected failed export without frames, got %s", job.State)
+		}
+	}
+}
+
+func TestFrameStore(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	all := make([]bool, 20)
+	for i := range all {
+		all[i] = true
+	}
+
+	writeAVI(t, filepath.Join(dir, "a.avi"), all, true)
+	writeAVI(t, filepath.Join(dir, "c.avi"), all[:10], true)
+
+	s := NewFrameStore(dir, rangeCatalog{
+		{Path: "a.avi", Start: start, End: start.Add(2 * time.Second)},
+		{Path: "c.avi", Start: start.Add(10 * time.Second), End: start.Add(11 * time.Second)},
+	})
+
+	var times []time.Duration
+
+	err := s.Frames(start, start.Add(10500*time.Millisecond), 2, func(f *stream.Frame) error {
+		if _, err := f.Image(); err != nil {
+			return err
+		}
+
+		times = append(times, f.Time.Sub(start))
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every fifth frame of a at 10 fps, and the first frame of c before end of range
+	want := []time.Duration{0, 500 * time.Millisecond, time.Second, 1500 * time.Millisecond, 10 * time.Second}
+	if fmt.Sprint(times) != fmt.Sprint(want) {
+		t.Errorf("expected frame times %v, got %v", want, times)
+	}
+
+	// Error of fn stops reading
+	stop := errors.New("stop")
+
+	n := 0
+	err = s.Frames(start, start.Add(10500*time.Millisecond), 2, func(f *stream.Frame) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Errorf("expected reading to stop after first frame, got %d frames, %v", n, err)
+	}
+}
+
+func TestUploader(t *testing.T) {
+	dir := t.TempDir()
+	out := t.TempDir()
+
+	writeAVI(t, filepath.Join(dir, "clips", "2025-01-02", "20250102-150405-motion.avi"), []bool{true, true}, true)
+
+	// Nil uploader does nothing
+	(*Uploader)(nil).Upload(&Recording{Path: "missing.avi"})
+
+	u := NewUploader(dir, storage.NewLocal(out))
+	u.Upload(&Recording{Path: "clips/2025-01-02/20250102-150405-motion.avi"})
+	u.Upload(&Recording{Path: "clips/2025-01-02/missing.avi"})
+	_ = u.Close()
+
+	want, err := os.ReadFile(filepath.Join(dir, "clips", "2025-01-02", "20250102-150405-motion.avi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(out, "recordings", "clips", "2025-01-02", "20250102-150405-motion.avi"))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("expected uploaded clip, %v", err)
+	}
+}
+
+type recoveryCatalog struct {
+	index
+}
+
+func (c *recoveryCatalog) Recordings() ([]*Recording, error) {
+	return c.index, nil
+}
+
+func TestRecover(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2025, 1, 2, 15, 4, 5, 0, time.Local)
+
+	// Segment interrupted after a checkpoint, in the middle of writing a frame
+	name := filepath.Join(dir, "2025-01-02", "20250102-150405.avi")
+	writeAVI(t, name, []bool{true, false, true, true}, false)
+
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &AVIWriter{w: f, width: 32, height: 24, fps: 10, size: aviHeaderSize}
+	if err := a.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Frame whose data did not reach the disk, frame without EOI and truncated chunk
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = f.Write([]byte("00dc\x04\x00\x00\x00\x00\x00\x00\x00"))
+	_, _ = f.Write([]byte("00dc\x06\x00\x00\x00\xff\xd8\xff\xdb\x00\x00"))
+	_, _ = f.Write([]byte("00dc\x00\x10\x00\x00\xff\xd8"))
+	_ = f.Close()
+
+	// Clip finished but not indexed, empty file and file that is indexed
+	writeAVI(t, filepath.Join(dir, ClipsDir, "2025-01-02", "20250102-150410-motion-1.avi"), []bool{true, true}, true)
+	writeAVI(t, filepath.Join(dir, "2025-01-02", "20250102-150500.avi"), nil, false)
+	writeAVI(t, filepath.Join(dir, "2025-01-02", "20250102-150600.avi"), []bool{true}, true)
+
+	c := &recoveryCatalog{index{{Path: "2025-01-02/20250102-150600.avi"}}}
+
+	out := t.TempDir()
+	upload := NewUploader(dir, storage.NewLocal(out))
+
+	added, err := Recover(dir, c, upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := upload.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovered recordings are uploaded, the indexed one is not
+	for _, rel := range []string{"2025-01-02/20250102-150405.avi", ClipsDir + "/2025-01-02/20250102-150410-motion-1.avi", "2025-01-02/20250102-150600.avi"} {
+		_, err := os.Stat(filepath.Join(out, RecordingsKey, filepath.FromSlash(rel)))
+		if uploaded := err == nil; uploaded != !strings.HasSuffix(rel, "150600.avi") {
+			t.Errorf("%s: unexpected upload state %v", rel, uploaded)
+		}
+	}
+
+	if len(added) != 2 || len(c.index) != 3 {
+		t.Fatalf("expected 2 recovered recordings, got %d", len(added))
+	}
+
+	seg, clip := added[0], added[1]
+	if seg.Path != "2025-01-02/20250102-150405.avi" || seg.Kind != KindSegment || seg.Frames != 4 || !seg.Start.Equal(start) ||
+		seg.End.Sub(seg.Start) != 400*time.Millisecond || seg.Thumbnail == nil {
+		t.Errorf("unexpected segment %+v", seg)
+	}
+
+	if clip.Kind != KindClip || clip.Event != EventMotion || clip.Frames != 2 {
+		t.Errorf("unexpected clip %+v", clip)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "2025-01-02", "20250102-150500.avi")); !os.IsNotExist(err) {
+		t.Error("expected empty file to be removed")
+	}
+
+	// Repaired file has index, so it is not repaired again
+	sizes := checkAVI(t, name)
+	if len(sizes) != 4 || sizes[1] != 0 {
+		t.Errorf("unexpected index %v", sizes)
+	}
+
+	if fi, _ := os.Stat(name); fi.Size() != seg.Size {
+		t.Errorf("expected size %d, got %d", seg.Size, fi.Size())
+	}
+
+	if repaired, err := RepairAVI(name); err != nil || repaired {
+		t.Errorf("expected repaired file to be valid, got %v, %v", repaired, err)
+	}
+
+	if added, err := Recover(dir, c, nil); err != nil || len(added) != 0 {
+		t.Errorf("expected nothing to recover, got %d, %v", len(added), err)
+	}
+}
@@ -0,0 +1,162 @@
+package record
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RecoveryCatalog lists indexed recordings and adds recovered ones, e.g. database.
+type RecoveryCatalog interface {
+	Index
+
+	// Recordings returns all recordings, thumbnails are not needed.
+	Recordings() ([]*Recording, error)
+}
+
+// Recover repairs files in dir that are missing from catalog, e.g. segment that was being recorded on power loss,
+// and adds them to catalog. Files without frames are removed. Added recordings are uploaded with upload, which can be
+// nil, as finished ones are. It must run before recording starts and returns added recordings.
+func Recover(dir string, catalog RecoveryCatalog, upload *Uploader) ([]*Recording, error) {
+	recs, err := catalog.Recordings()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(recs))
+	for _, rec := range recs {
+		known[rec.Path] = true
+	}
+
+	var added []*Recording
+
+	err = filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directory that does not exist has nothing to recover
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if d.IsDir() || filepath.Ext(name) != ".avi" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if known[rel] {
+			return nil
+		}
+
+		rec, err := recoverFile(name, rel)
+		if err != nil {
+			log.Printf("record: recovery: %v", err)
+			return nil
+		}
+
+		if rec == nil {
+			return nil
+		}
+
+		if err := catalog.AddRecording(rec); err != nil {
+			return err
+		}
+
+		added = append(added, rec)
+		upload.Upload(rec)
+
+		return nil
+	})
+
+	return added, err
+}
+
+// recoverFile repairs file and returns its recording with path rel, it returns nil if file was removed.
+func recoverFile(name, rel string) (*Recording, error) {
+	kind, event, start, err := parseName(rel)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", rel, err)
+	}
+
+	repaired, err := RepairAVI(name)
+	if err != nil {
+		// File created just before power loss has no complete header
+		if fi, serr := os.Stat(name); serr == nil && fi.Size() < aviHeaderSize {
+			return nil, os.Remove(name)
+		}
+
+		return nil, err
+	}
+
+	a, err := OpenAVI(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var thumb []byte
+	for i := 0; i < a.Frames() && thumb == nil; i++ {
+		if data, err := a.Frame(i); err == nil && data != nil {
+			thumb = thumbnail(data)
+		}
+	}
+
+	frames, fps := a.Frames(), a.FPS()
+	_ = a.Close()
+
+	if frames == 0 {
+		return nil, os.Remove(name)
+	}
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if repaired {
+		log.Printf("record: recovery: repaired %s, %d frames", rel, frames)
+	}
+
+	return &Recording{
+		Kind:      kind,
+		Event:     event,
+		Path:      rel,
+		Start:     start,
+		End:       start.Add(time.Duration(math.Round(float64(frames) * float64(time.Second) / fps))),
+		Frames:    frames,
+		Size:      fi.Size(),
+		Thumbnail: thumb,
+	}, nil
+}
+
+// parseName returns kind, event and start time of recording from its path, e.g. clips/2025-01-02/20250102-150405-motion.avi.
+// Start time has only seconds, it is in local time as names are.
+func parseName(rel string) (kind, event string, start time.Time, err error) {
+	base := strings.TrimSuffix(path.Base(rel), ".avi")
+
+	start, err = time.ParseInLocation("20060102-150405", base[:min(len(base), 15)], time.Local)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	kind = KindSegment
+	if strings.HasPrefix(rel, ClipsDir+"/") {
+		kind = KindClip
+
+		// Suffix is event, optionally followed by number of file started in the same second
+		event, _, _ = strings.Cut(strings.TrimPrefix(base[15:], "-"), "-")
+	}
+
+	return kind, event, start, nil
+}
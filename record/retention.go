@@ -0,0 +1,274 @@
+package record
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Reasons of deletion.
+const (
+	ReasonAge  = "age"
+	ReasonSize = "size"
+	ReasonFree = "free"
+)
+
+// RetentionOptions are retention policies, policy with zero value is disabled.
+type RetentionOptions struct {
+	// MaxAge is the age after which segments are deleted.
+	MaxAge time.Duration
+	// ClipMaxAge is the age after which clips are deleted, clips are usually kept longer than segments.
+	ClipMaxAge time.Duration
+	// MaxSize is the maximum total size of recordings in bytes, above it the oldest segments and then the oldest clips are deleted.
+	MaxSize int64
+	// MinFree is the free space in bytes kept on disk of recordings, below it recordings are deleted as above.
+	MinFree int64
+	// Interval is the pause between runs.
+	Interval time.Duration
+}
+
+// Enabled reports whether any policy is set.
+func (o RetentionOptions) Enabled() bool {
+	return o.MaxAge > 0 || o.ClipMaxAge > 0 || o.MaxSize > 0 || o.MinFree > 0
+}
+
+// Catalog lists and deletes indexed recordings, e.g. in database.
+type Catalog interface {
+	// Recordings returns all recordings ordered by start time, thumbnails are not needed.
+	Recordings() ([]*Recording, error)
+	// DeleteRecording deletes recording with id from index.
+	DeleteRecording(id int64) error
+}
+
+// Usage is number and size of recordings.
+type Usage struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+func (u *Usage) add(rec *Recording) {
+	u.Count++
+	u.Size += rec.Size
+}
+
+// Deletion is recording deleted by retention.
+type Deletion struct {
+	Recording *Recording `json:"recording"`
+	Reason    string     `json:"reason"`
+}
+
+// Report is result of retention run.
+type Report struct {
+	Time   time.Time `json:"time"`
+	DryRun bool      `json:"dry_run"`
+
+	// Usage of recordings before deletion, locked clips are counted in clips too.
+	Segments Usage `json:"segments"`
+	Clips    Usage `json:"clips"`
+	Locked   Usage `json:"locked"`
+	// Free is free disk space in bytes, -1 if unknown.
+	Free   int64      `json:"free"`
+	Oldest *time.Time `json:"oldest,omitempty"`
+
+	Deleted []Deletion `json:"deleted"`
+	// Freed is the total size of deleted recordings.
+	Freed int64 `json:"freed"`
+}
+
+// Retention deletes recordings according to policies.
+type Retention struct {
+	opts    RetentionOptions
+	dir     string
+	catalog Catalog
+
+	mu   sync.Mutex
+	last *Report
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRetention returns new Retention of recordings in dir.
+func NewRetention(opts RetentionOptions, dir string, catalog Catalog) *Retention {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+
+	return &Retention{opts: opts, dir: dir, catalog: catalog}
+}
+
+// Start starts applying policies periodically, it does nothing if no policy is set.
+func (r *Retention) Start() {
+	if !r.opts.Enabled() || r.stop != nil {
+		return
+	}
+
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			rep, err := r.Run(false)
+			if err != nil {
+				log.Printf("record: retention: %v", err)
+			} else if len(rep.Deleted) > 0 {
+				log.Printf("record: retention: deleted %d recordings, %d bytes", len(rep.Deleted), rep.Freed)
+			}
+
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops retention.
+func (r *Retention) Close() error {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+		r.stop = nil
+	}
+
+	return nil
+}
+
+// Last returns report of the last run that was not a dry run, nil if there was none.
+func (r *Retention) Last() *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.last
+}
+
+// Run applies policies, with dryRun recordings are only reported and not deleted.
+func (r *Retention) Run(dryRun bool) (*Report, error) {
+	recs, err := r.catalog.Recordings()
+	if err != nil {
+		return nil, err
+	}
+
+	free, err := diskFree(r.dir)
+	if err != nil {
+		free = -1
+	}
+
+	rep := plan(r.opts, recs, free, time.Now())
+	rep.DryRun = dryRun
+
+	if dryRun {
+		return rep, nil
+	}
+
+	deleted := rep.Deleted[:0]
+	rep.Freed = 0
+
+	for _, d := range rep.Deleted {
+		if err := r.delete(d.Recording); err != nil {
+			log.Printf("record: retention: %s: %v", d.Recording.Path, err)
+			continue
+		}
+
+		deleted = append(deleted, d)
+		rep.Freed += d.Recording.Size
+	}
+
+	rep.Deleted = deleted
+
+	r.mu.Lock()
+	r.last = rep
+	r.mu.Unlock()
+
+	return rep, nil
+}
+
+// delete deletes file of recording and its directory of the day if it is empty, then removes it from index.
+func (r *Retention) delete(rec *Recording) error {
+	name := filepath.Join(r.dir, filepath.FromSlash(rec.Path))
+
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	_ = os.Remove(filepath.Dir(name))
+
+	return r.catalog.DeleteRecording(rec.ID)
+}
+
+// plan returns report with recordings that should be deleted at now, recs are ordered by start time.
+func plan(opts RetentionOptions, recs []*Recording, free int64, now time.Time) *Report {
+	rep := &Report{Time: now, Free: free, Deleted: make([]Deletion, 0)}
+
+	deleted := make(map[*Recording]bool)
+	remove := func(rec *Recording, reason string) {
+		deleted[rec] = true
+		rep.Deleted = append(rep.Deleted, Deletion{Recording: rec, Reason: reason})
+		rep.Freed += rec.Size
+	}
+
+	var total int64
+	for _, rec := range recs {
+		total += rec.Size
+
+		if rec.Kind == KindClip {
+			rep.Clips.add(rec)
+		} else {
+			rep.Segments.add(rec)
+		}
+
+		if rec.Locked {
+			rep.Locked.add(rec)
+		}
+
+		if rep.Oldest == nil || rec.Start.Before(*rep.Oldest) {
+			start := rec.Start
+			rep.Oldest = &start
+		}
+	}
+
+	for _, rec := range recs {
+		maxAge := opts.MaxAge
+		if rec.Kind == KindClip {
+			maxAge = opts.ClipMaxAge
+		}
+
+		if !rec.Locked && maxAge > 0 && now.Sub(rec.End) > maxAge {
+			remove(rec, ReasonAge)
+		}
+	}
+
+	// Segments go first, clips only when deleting all segments is not enough
+	var candidates []*Recording
+	for _, kind := range []string{KindSegment, KindClip} {
+		for _, rec := range recs {
+			if rec.Kind == kind && !rec.Locked && !deleted[rec] {
+				candidates = append(candidates, rec)
+			}
+		}
+	}
+
+	if opts.MaxSize > 0 {
+		for len(candidates) > 0 && total-rep.Freed > opts.MaxSize {
+			remove(candidates[0], ReasonSize)
+			candidates = candidates[1:]
+		}
+	}
+
+	if opts.MinFree > 0 && free >= 0 {
+		for len(candidates) > 0 && free+rep.Freed < opts.MinFree {
+			remove(candidates[0], ReasonFree)
+			candidates = candidates[1:]
+		}
+	}
+
+	return rep
+}
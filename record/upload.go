@@ -0,0 +1,63 @@
+package record
+
+import (
+	"context"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/gen2brain/cam2ip/storage"
+)
+
+// RecordingsKey is the key prefix of uploaded recordings, followed by path of recording, e.g. recordings/clips/2025-01-02/20250102-150405-motion.avi.
+const RecordingsKey = "recordings"
+
+// Uploader copies finished recordings in dir to storage, e.g. upload queue to S3, the same way as exports and snapshots.
+type Uploader struct {
+	dir string
+	st  storage.Storage
+
+	wg sync.WaitGroup
+}
+
+// NewUploader returns new Uploader.
+func NewUploader(dir string, st storage.Storage) *Uploader {
+	return &Uploader{dir: dir, st: st}
+}
+
+// Upload copies file of recording in background, so that recording continues meanwhile. Errors are logged,
+// nil Uploader does nothing.
+func (u *Uploader) Upload(rec *Recording) {
+	if u == nil {
+		return
+	}
+
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+
+		if err := u.upload(rec); err != nil {
+			log.Printf("record: upload %s: %v", rec.Path, err)
+		}
+	}()
+}
+
+// Close waits for running uploads.
+func (u *Uploader) Close() error {
+	u.wg.Wait()
+
+	return nil
+}
+
+func (u *Uploader) upload(rec *Recording) error {
+	f, err := os.Open(filepath.Join(u.dir, filepath.FromSlash(rec.Path)))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return u.st.Put(context.Background(), path.Join(RecordingsKey, rec.Path), f, "video/x-msvideo")
+}
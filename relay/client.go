@@ -0,0 +1,160 @@
+package relay
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/hashicorp/yamux"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// ClientOptions are options of Client.
+type ClientOptions struct {
+	// URL of relay, e.g. https://relay.example.com, ws and wss schemes are accepted too.
+	URL string
+	// ID and Key of the device, as registered on relay.
+	ID  string
+	Key string
+}
+
+// Client keeps tunnel to relay open and serves requests that come through it.
+type Client struct {
+	opts    ClientOptions
+	handler http.Handler
+	url     string
+
+	mu      sync.Mutex
+	session *yamux.Session
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewClient returns new Client, requests from relay are served with handler.
+func NewClient(opts ClientOptions, handler http.Handler) (*Client, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("relay: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("relay: invalid URL %q", opts.URL)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + TunnelPath
+
+	if !ValidID(opts.ID) || opts.Key == "" {
+		return nil, fmt.Errorf("relay: device id and key are required")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Client{opts: opts, handler: handler, url: u.String(), ctx: ctx, cancel: cancel, done: make(chan struct{})}, nil
+}
+
+// Start connects to relay in background and reconnects with backoff when tunnel breaks.
+func (c *Client) Start() {
+	go func() {
+		defer close(c.done)
+
+		backoff := minBackoff
+
+		for {
+			start := time.Now()
+
+			err := c.serve()
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			// Tunnel that worked for a while starts backoff again
+			if time.Since(start) > maxBackoff {
+				backoff = minBackoff
+			}
+
+			log.Printf("relay: %v, reconnecting in %v", err, backoff)
+
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff = min(backoff*2, maxBackoff)
+		}
+	}()
+}
+
+// Close closes tunnel and stops reconnecting.
+func (c *Client) Close() error {
+	c.cancel()
+
+	c.mu.Lock()
+	if c.session != nil {
+		_ = c.session.Close()
+	}
+	c.mu.Unlock()
+
+	<-c.done
+
+	return nil
+}
+
+// serve opens tunnel and serves requests until it is closed.
+func (c *Client) serve() error {
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.opts.ID+":"+c.opts.Key)))
+
+	ctx, cancel := context.WithTimeout(c.ctx, 30*time.Second)
+	ws, resp, err := websocket.Dial(ctx, c.url, &websocket.DialOptions{HTTPHeader: header})
+	cancel()
+
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("relay rejected device %s key", c.opts.ID)
+		}
+
+		return err
+	}
+
+	session, err := yamux.Server(websocket.NetConn(c.ctx, ws, websocket.MessageBinary), nil)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+
+	log.Printf("relay: connected to %s as %s", c.opts.URL, c.opts.ID)
+
+	// Same timeouts as direct connections, streaming handlers extend them
+	srv := &http.Server{
+		Handler:      c.handler,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+
+	err = srv.Serve(session)
+	_ = session.Close()
+
+	return fmt.Errorf("tunnel closed: %w", err)
+}
@@ -0,0 +1,259 @@
+// Package relay tunnels HTTP requests to cameras behind NAT over WebSocket connections that cameras open to relay.
+package relay
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/hashicorp/yamux"
+)
+
+const (
+	// TunnelPath is the path where cameras open tunnels.
+	TunnelPath = "/tunnel"
+	// CameraPrefix is the path prefix of requests proxied to cameras, followed by device id.
+	CameraPrefix = "/cam/"
+)
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Device is a connected camera.
+type Device struct {
+	ID          string    `json:"id"`
+	Addr        string    `json:"addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+type device struct {
+	Device
+	session *yamux.Session
+	proxy   *httputil.ReverseProxy
+}
+
+// Server accepts tunnels from cameras and proxies requests to them.
+type Server struct {
+	// keys are SHA-256 hashes of device keys, by device id.
+	keys map[string]string
+
+	mu      sync.Mutex
+	devices map[string]*device
+}
+
+// NewServer returns new Server, keys are hex encoded SHA-256 hashes of device keys by device id.
+func NewServer(keys map[string]string) *Server {
+	return &Server{keys: keys, devices: make(map[string]*device)}
+}
+
+// ServeTunnel accepts tunnel from camera, device id and key are sent as Basic credentials.
+func (s *Server) ServeTunnel(w http.ResponseWriter, r *http.Request) {
+	id, key, ok := r.BasicAuth()
+	if !ok || !s.authenticate(id, key) {
+		log.Printf("relay: camera %q from %s: invalid key", id, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Basic realm="cam2ip-relay"`)
+		http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+
+		return
+	}
+
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	// Tunnel outlives the request, so its context is not used
+	conn := websocket.NetConn(context.Background(), c, websocket.MessageBinary)
+
+	session, err := yamux.Client(conn, nil)
+	if err != nil {
+		_ = conn.Close()
+
+		return
+	}
+
+	d := &device{
+		Device:  Device{ID: id, Addr: r.RemoteAddr, ConnectedAt: time.Now()},
+		session: session,
+		proxy:   newProxy(id, session),
+	}
+
+	// Camera that reconnects replaces its stale tunnel
+	s.mu.Lock()
+	old := s.devices[id]
+	s.devices[id] = d
+	s.mu.Unlock()
+
+	if old != nil {
+		_ = old.session.Close()
+	}
+
+	log.Printf("relay: camera %s connected from %s", id, r.RemoteAddr)
+
+	<-session.CloseChan()
+
+	s.mu.Lock()
+	if s.devices[id] == d {
+		delete(s.devices, id)
+	}
+	s.mu.Unlock()
+
+	log.Printf("relay: camera %s disconnected", id)
+}
+
+// ServeHTTP proxies requests under CameraPrefix to connected camera.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, CameraPrefix), "/")
+
+	if _, ok := s.keys[id]; !ok {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+
+		return
+	}
+
+	s.mu.Lock()
+	d := s.devices[id]
+	s.mu.Unlock()
+
+	if d == nil {
+		http.Error(w, "503 Service Unavailable: camera is not connected", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	d.proxy.ServeHTTP(w, r)
+}
+
+// Devices returns connected cameras sorted by id.
+func (s *Server) Devices() []Device {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make([]Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		ret = append(ret, d.Device)
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+
+	return ret
+}
+
+// Close closes all tunnels.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.devices {
+		_ = d.session.Close()
+	}
+
+	return nil
+}
+
+func (s *Server) authenticate(id, key string) bool {
+	want, ok := s.keys[id]
+	if !ok {
+		// Unknown devices are compared too, so timing does not tell which devices exist
+		want = strings.Repeat("0", sha256.Size*2)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(HashKey(key)), []byte(want)) == 1 && ok
+}
+
+// newProxy returns proxy that sends requests through tunnel session, CameraPrefix and device id are removed from path.
+func newProxy(id string, session *yamux.Session) *httputil.ReverseProxy {
+	prefix := CameraPrefix + id
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return session.Open()
+		},
+		IdleConnTimeout: 90 * time.Second,
+	}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetXForwarded()
+
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = id
+			pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.In.URL.Path, prefix), "/")
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = ""
+
+			// Credentials of relay users are not for camera
+			pr.Out.Header.Del("Authorization")
+			pr.Out.Header.Del("Cookie")
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("relay: camera %s: %v", id, err)
+			http.Error(w, "502 Bad Gateway", http.StatusBadGateway)
+		},
+	}
+}
+
+// HashKey returns hex encoded SHA-256 hash of device key.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKey returns new random device key.
+func NewKey() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// ValidID reports whether id can be used as device id.
+func ValidID(id string) bool {
+	return validID.MatchString(id)
+}
+
+// LoadKeys reads device keys file, each line is device id and hex encoded SHA-256 hash of key separated by colon.
+// Empty lines and lines starting with # are skipped.
+func LoadKeys(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, hash, ok := strings.Cut(line, ":")
+		if _, err := hex.DecodeString(hash); !ok || !ValidID(id) || err != nil || len(hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s:%d: invalid device line", name, n)
+		}
+
+		keys[id] = strings.ToLower(hash)
+	}
+
+	return keys, scanner.Err()
+}
@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTunnel(t *testing.T) {
+	s := NewServer(map[string]string{"cam1": HashKey("secret"), "cam2": HashKey("other")})
+	defer s.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(TunnelPath, s.ServeTunnel)
+	mux.Handle(CameraPrefix, s)
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	camera := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s auth=%q cookie=%q", r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), r.Header.Get("Cookie"))
+	})
+
+	c, err := NewClient(ClientOptions{URL: ts.URL, ID: "cam1", Key: "secret"}, camera)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Start()
+	defer c.Close()
+
+	for i := 0; i < 100 && len(s.Devices()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if d := s.Devices(); len(d) != 1 || d[0].ID != "cam1" {
+		t.Fatalf("unexpected devices %+v", d)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/cam/cam1/mjpeg?stream=sub", nil)
+	req.SetBasicAuth("admin", "admin")
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: "x"})
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if want := `/mjpeg stream=sub auth="" cookie=""`; string(body) != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+
+	for path, code := range map[string]int{"/cam/cam2/jpeg": http.StatusServiceUnavailable, "/cam/cam3/jpeg": http.StatusNotFound} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != code {
+			t.Errorf("%s: got %d, want %d", path, resp.StatusCode, code)
+		}
+	}
+}
+
+func TestTunnelInvalidKey(t *testing.T) {
+	s := NewServer(map[string]string{"cam1": HashKey("secret")})
+
+	ts := httptest.NewServer(http.HandlerFunc(s.ServeTunnel))
+	defer ts.Close()
+
+	c, err := NewClient(ClientOptions{URL: ts.URL, ID: "cam1", Key: "wrong"}, http.NotFoundHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.serve(); err == nil || len(s.Devices()) != 0 {
+		t.Errorf("device connected with invalid key: %v", err)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "devices")
+
+	data := "# cameras\n\ncam1:" + HashKey("secret") + "\n"
+	if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadKeys(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != 1 || keys["cam1"] != HashKey("secret") {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	if err := os.WriteFile(name, []byte("cam1:secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeys(name); err == nil {
+		t.Error("plain key accepted")
+	}
+}
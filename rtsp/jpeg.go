@@ -0,0 +1,267 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+// JPEG markers.
+const (
+	markerSOF0 = 0xC0
+	markerSOF1 = 0xC1
+	markerSOF2 = 0xC2
+	markerDHT  = 0xC4
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+	markerDRI  = 0xDD
+)
+
+var errUnsupported = errors.New("rtsp: unsupported jpeg")
+
+// jpegFrame is a baseline JPEG split into parts carried by RFC 2435 payload.
+type jpegFrame struct {
+	// Type is 0 for 4:2:2 and 1 for 4:2:0, 64 is added when restart markers are used.
+	Type   byte
+	Width  int
+	Height int
+	// Quant holds luma and chroma 8-bit quantization tables in zigzag order.
+	Quant []byte
+	// Restart is the restart interval in MCUs.
+	Restart uint16
+	// Data is the entropy-coded scan data.
+	Data []byte
+}
+
+// parseJPEG parses baseline YCbCr JPEG with 2x1 or 2x2 luma sampling.
+// RFC 2435 carries no Huffman tables, receivers use the standard ones, so other tables are unsupported.
+func parseJPEG(b []byte) (*jpegFrame, error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != markerSOI {
+		return nil, fmt.Errorf("rtsp: missing SOI marker")
+	}
+
+	f := &jpegFrame{}
+	quant := make(map[byte][]byte)
+	var quantIDs []byte
+
+	i := 2
+	for i+4 <= len(b) {
+		if b[i] != 0xFF {
+			return nil, fmt.Errorf("rtsp: invalid marker at %d", i)
+		}
+
+		marker := b[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		length := int(binary.BigEndian.Uint16(b[i+2:]))
+		if length < 2 || i+2+length > len(b) {
+			return nil, fmt.Errorf("rtsp: invalid segment length at %d", i)
+		}
+
+		seg := b[i+4 : i+2+length]
+
+		switch marker {
+		case markerDQT:
+			for len(seg) >= 65 {
+				if seg[0]>>4 != 0 {
+					return nil, fmt.Errorf("%w: 16-bit quantization table", errUnsupported)
+				}
+
+				quant[seg[0]&0x0F] = seg[1:65]
+				seg = seg[65:]
+			}
+		case markerSOF0, markerSOF1:
+			if len(seg) < 6 || seg[5] != 3 || len(seg) < 6+3*3 {
+				return nil, fmt.Errorf("%w: not a 3 component image", errUnsupported)
+			}
+
+			f.Height = int(binary.BigEndian.Uint16(seg[1:]))
+			f.Width = int(binary.BigEndian.Uint16(seg[3:]))
+
+			switch seg[7] {
+			case 0x21:
+				f.Type = 0
+			case 0x22:
+				f.Type = 1
+			default:
+				return nil, fmt.Errorf("%w: luma sampling %#x", errUnsupported, seg[7])
+			}
+
+			if seg[10] != 0x11 || seg[13] != 0x11 {
+				return nil, fmt.Errorf("%w: chroma sampling", errUnsupported)
+			}
+
+			quantIDs = []byte{seg[8], seg[11]}
+			if seg[14] != seg[11] {
+				return nil, fmt.Errorf("%w: chroma components use different tables", errUnsupported)
+			}
+		case markerSOF2:
+			return nil, fmt.Errorf("%w: progressive", errUnsupported)
+		case markerDHT:
+			if !standardHuffman(seg) {
+				return nil, fmt.Errorf("%w: optimized Huffman tables", errUnsupported)
+			}
+		case markerDRI:
+			if len(seg) >= 2 {
+				f.Restart = binary.BigEndian.Uint16(seg)
+			}
+		case markerSOS:
+			// Luma uses tables 0 and chroma tables 1, as receivers assume
+			if len(seg) < 7 || seg[0] != 3 || seg[2] != 0x00 || seg[4] != 0x11 || seg[6] != 0x11 {
+				return nil, fmt.Errorf("%w: Huffman table selectors", errUnsupported)
+			}
+
+			data := b[i+2+length:]
+			if n := len(data); n >= 2 && data[n-2] == 0xFF && data[n-1] == markerEOI {
+				data = data[:n-2]
+			}
+
+			f.Data = data
+
+			for _, id := range quantIDs {
+				q, ok := quant[id]
+				if !ok {
+					return nil, fmt.Errorf("rtsp: missing quantization table %d", id)
+				}
+
+				f.Quant = append(f.Quant, q...)
+			}
+
+			if f.Width == 0 || f.Height == 0 {
+				return nil, fmt.Errorf("rtsp: missing SOF marker")
+			}
+
+			if f.Width > 2040 || f.Height > 2040 {
+				return nil, fmt.Errorf("%w: %dx%d exceeds 2040x2040", errUnsupported, f.Width, f.Height)
+			}
+
+			// Size is carried in 8 pixel units, receivers would decode a smaller picture from misaligned MCUs
+			if f.Width%8 != 0 || f.Height%8 != 0 {
+				return nil, fmt.Errorf("%w: %dx%d is not a multiple of 8", errUnsupported, f.Width, f.Height)
+			}
+
+			if f.Restart > 0 {
+				f.Type += 64
+			}
+
+			return f, nil
+		}
+
+		i += 2 + length
+	}
+
+	return nil, fmt.Errorf("rtsp: missing SOS marker")
+}
+
+// standardHuffman reports whether all tables of DHT segment are the standard ones.
+func standardHuffman(seg []byte) bool {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return false
+		}
+
+		n := 0
+		for _, v := range seg[1:17] {
+			n += int(v)
+		}
+
+		if len(seg) < 17+n {
+			return false
+		}
+
+		found := false
+		for _, h := range stdHuffman {
+			if h.class == seg[0] {
+				found = bytes.Equal(h.bits, seg[1:17]) && bytes.Equal(h.vals, seg[17:17+n])
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+
+		seg = seg[17+n:]
+	}
+
+	return true
+}
+
+// baseline encodes image as baseline 4:2:0 JPEG with standard Huffman tables, as RFC 2435 receivers expect.
+// Image is cropped to a multiple of 8 pixels, the unit of RFC 2435 size fields.
+func baseline(img image.Image, quality int) ([]byte, error) {
+	b := img.Bounds()
+	crop := image.Rect(b.Min.X, b.Min.Y, b.Min.X+b.Dx()&^7, b.Min.Y+b.Dy()&^7)
+	if crop.Empty() {
+		return nil, fmt.Errorf("%w: %dx%d is smaller than 8x8", errUnsupported, b.Dx(), b.Dy())
+	}
+
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+
+	// Standard library encodes gray images with one component
+	if _, gray := img.(*image.Gray); gray || !ok {
+		rgba := image.NewRGBA(crop)
+		draw.Draw(rgba, crop, img, crop.Min, draw.Src)
+		img = rgba
+	} else if crop != b {
+		img = sub.SubImage(crop)
+	}
+
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// stdHuffman are the tables from JPEG standard section K.3.
+var stdHuffman = []struct {
+	class byte
+	bits  []byte
+	vals  []byte
+}{
+	{0x00, []byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0}, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{0x10, []byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d}, []byte{
+		0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+		0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+		0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+		0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+		0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+		0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+		0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+		0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+		0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+		0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	}},
+	{0x01, []byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0}, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{0x11, []byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77}, []byte{
+		0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+		0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+		0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+		0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+		0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+		0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+		0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+		0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+		0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+		0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	}},
+}
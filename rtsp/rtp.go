@@ -0,0 +1,72 @@
+package rtsp
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// payloadType is the static RTP payload type for JPEG.
+	payloadType = 26
+	// clockRate is the RTP clock rate for JPEG.
+	clockRate = 90000
+	// maxPayload keeps packets under common MTU.
+	maxPayload = 1400
+)
+
+// packetizer splits JPEG frames into RTP packets with RFC 2435 payload.
+type packetizer struct {
+	ssrc uint32
+	seq  uint16
+}
+
+// packetize returns RTP packets for frame with given RTP timestamp.
+func (p *packetizer) packetize(f *jpegFrame, ts uint32) [][]byte {
+	packets := make([][]byte, 0, len(f.Data)/maxPayload+1)
+
+	offset := 0
+
+	for {
+		hdr := make([]byte, 0, 12+8+4+4+len(f.Quant))
+
+		// RTP header, marker is set below for the last packet.
+		hdr = append(hdr, 0x80, payloadType)
+		hdr = binary.BigEndian.AppendUint16(hdr, p.seq)
+		hdr = binary.BigEndian.AppendUint32(hdr, ts)
+		hdr = binary.BigEndian.AppendUint32(hdr, p.ssrc)
+
+		// JPEG header, Q=255 means quantization tables are sent in-band.
+		hdr = append(hdr, 0, byte(offset>>16), byte(offset>>8), byte(offset))
+		hdr = append(hdr, f.Type, 255, byte(f.Width/8), byte(f.Height/8))
+
+		if f.Type >= 64 {
+			// Restart marker header, F and L bits set, count 0x3FFF.
+			hdr = binary.BigEndian.AppendUint16(hdr, f.Restart)
+			hdr = append(hdr, 0xFF, 0xFF)
+		}
+
+		if offset == 0 {
+			hdr = append(hdr, 0, 0)
+			hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(f.Quant)))
+			hdr = append(hdr, f.Quant...)
+		}
+
+		n := min(maxPayload-(len(hdr)-12), len(f.Data)-offset)
+
+		pkt := append(hdr, f.Data[offset:offset+n]...)
+		offset += n
+
+		last := offset >= len(f.Data)
+		if last {
+			pkt[1] |= 0x80
+		}
+
+		packets = append(packets, pkt)
+		p.seq++
+
+		if last {
+			break
+		}
+	}
+
+	return packets
+}
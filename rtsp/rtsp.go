@@ -0,0 +1,717 @@
+// Package rtsp implements RTSP server publishing MJPEG over RTP (RFC 2435).
+package rtsp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	"github.com/gen2brain/cam2ip/metrics"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+const (
+	// sessionTimeout is the number of seconds without requests or RTCP packets after which connection is closed.
+	sessionTimeout = 60
+	writeTimeout   = 5 * time.Second
+)
+
+// StreamSource interface
+type StreamSource interface {
+	// Stream returns stream by name, empty name is the main stream, nil if there is no such stream.
+	Stream(name string) *stream.Stream
+}
+
+// AuthFunc checks credentials of a client.
+type AuthFunc func(username, password, remoteAddr string) bool
+
+// Server is RTSP server.
+type Server struct {
+	Addr    string
+	Streams StreamSource
+	// Auth checks Basic credentials, nil disables authentication.
+	Auth AuthFunc
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+}
+
+// NewServer returns new RTSP server.
+func NewServer(addr string, streams StreamSource, auth AuthFunc) *Server {
+	return &Server{
+		Addr:    addr,
+		Streams: streams,
+		Auth:    auth,
+		conns:   make(map[*conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves RTSP requests.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on the listener.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		c := &conn{
+			s:  s,
+			nc: nc,
+			br: bufio.NewReader(nc),
+		}
+
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go c.serve()
+	}
+}
+
+// Close closes listener and all connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+
+	for c := range s.conns {
+		_ = c.nc.Close()
+	}
+
+	return err
+}
+
+// request is RTSP request.
+type request struct {
+	Method string
+	URL    *url.URL
+	Header textproto.MIMEHeader
+	Body   []byte
+}
+
+// transport is negotiated RTP transport.
+type transport struct {
+	tcp     bool
+	channel byte
+
+	rtp  *net.UDPConn
+	rtcp *net.UDPConn
+	dst  *net.UDPAddr
+
+	clientPorts [2]int
+}
+
+func (t *transport) close() {
+	if t.rtp != nil {
+		_ = t.rtp.Close()
+	}
+
+	if t.rtcp != nil {
+		_ = t.rtcp.Close()
+	}
+}
+
+// conn is RTSP client connection.
+type conn struct {
+	s  *Server
+	nc net.Conn
+	br *bufio.Reader
+
+	wmu sync.Mutex
+
+	authorized bool
+	session    string
+	stream     *stream.Stream
+	transport  *transport
+	packetizer packetizer
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.teardown()
+		_ = c.nc.Close()
+
+		c.s.mu.Lock()
+		delete(c.s.conns, c)
+		c.s.mu.Unlock()
+	}()
+
+	for {
+		req, err := c.readRequest()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Printf("rtsp: %s: session timed out", c.nc.RemoteAddr())
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("rtsp: %s: %v", c.nc.RemoteAddr(), err)
+			}
+
+			return
+		}
+
+		err = c.handle(req)
+		if err != nil {
+			log.Printf("rtsp: %s: %s: %v", c.nc.RemoteAddr(), req.Method, err)
+
+			return
+		}
+	}
+}
+
+// readRequest reads next request, interleaved packets sent by client (RTCP receiver reports) are skipped.
+// Requests and packets keep the session alive.
+func (c *conn) readRequest() (*request, error) {
+	for {
+		c.keepalive()
+
+		b, err := c.br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if b[0] != '$' {
+			break
+		}
+
+		hdr := make([]byte, 4)
+		if _, err := io.ReadFull(c.br, hdr); err != nil {
+			return nil, err
+		}
+
+		if _, err := c.br.Discard(int(binary.BigEndian.Uint16(hdr[2:]))); err != nil {
+			return nil, err
+		}
+	}
+
+	tp := textproto.NewReader(c.br)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "RTSP/") {
+		return nil, fmt.Errorf("malformed request line %q", line)
+	}
+
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed url %q: %w", parts[1], err)
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{Method: parts[0], URL: u, Header: header}
+
+	if v := header.Get("Content-Length"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 64*1024 {
+			return nil, fmt.Errorf("invalid content length %q", v)
+		}
+
+		req.Body = make([]byte, n)
+		if _, err := io.ReadFull(c.br, req.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+// keepalive extends session by sessionTimeout.
+func (c *conn) keepalive() {
+	_ = c.nc.SetReadDeadline(time.Now().Add(sessionTimeout * time.Second))
+}
+
+// receiveRTCP keeps session of UDP transport alive while client sends RTCP receiver reports.
+func (c *conn) receiveRTCP(t *transport) {
+	buf := make([]byte, 1500)
+
+	for {
+		if _, _, err := t.rtcp.ReadFromUDP(buf); err != nil {
+			return
+		}
+
+		c.keepalive()
+	}
+}
+
+func (c *conn) handle(req *request) error {
+	header := make(map[string]string)
+	header["CSeq"] = req.Header.Get("CSeq")
+
+	if req.Method != "OPTIONS" && !c.authorize(req) {
+		header["WWW-Authenticate"] = `Basic realm="cam2ip"`
+
+		return c.respond(401, "Unauthorized", header, nil)
+	}
+
+	switch req.Method {
+	case "OPTIONS":
+		header["Public"] = "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER, SET_PARAMETER"
+
+		return c.respond(200, "OK", header, nil)
+	case "DESCRIBE":
+		name, _ := streamName(req.URL)
+		if c.s.Streams.Stream(name) == nil {
+			return c.respond(404, "Not Found", header, nil)
+		}
+
+		base := *req.URL
+		base.User = nil
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/"
+
+		header["Content-Base"] = base.String()
+		header["Content-Type"] = "application/sdp"
+
+		return c.respond(200, "OK", header, c.sdp())
+	case "SETUP":
+		return c.setup(req, header)
+	case "PLAY":
+		return c.play(req, header)
+	case "TEARDOWN":
+		c.teardown()
+
+		return c.respond(200, "OK", header, nil)
+	case "GET_PARAMETER", "SET_PARAMETER":
+		if c.session != "" {
+			header["Session"] = c.sessionHeader()
+		}
+
+		return c.respond(200, "OK", header, nil)
+	}
+
+	return c.respond(501, "Not Implemented", header, nil)
+}
+
+// authorize checks Basic credentials once per connection.
+func (c *conn) authorize(req *request) bool {
+	if c.s.Auth == nil || c.authorized {
+		return true
+	}
+
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return false
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return false
+	}
+
+	username, password, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return false
+	}
+
+	host, _, _ := net.SplitHostPort(c.nc.RemoteAddr().String())
+	c.authorized = c.s.Auth(username, password, host)
+
+	return c.authorized
+}
+
+func (c *conn) setup(req *request, header map[string]string) error {
+	if c.transport != nil {
+		return c.respond(459, "Aggregate Operation Not Allowed", header, nil)
+	}
+
+	name, _ := streamName(req.URL)
+
+	st := c.s.Streams.Stream(name)
+	if st == nil {
+		return c.respond(404, "Not Found", header, nil)
+	}
+
+	t, err := c.parseTransport(req.Header.Get("Transport"))
+	if err != nil {
+		return c.respond(461, "Unsupported Transport", header, nil)
+	}
+
+	c.stream = st
+	c.transport = t
+	c.session = newID(8)
+	c.packetizer = packetizer{ssrc: randUint32(), seq: uint16(randUint32())}
+
+	if !t.tcp {
+		go c.receiveRTCP(t)
+	}
+
+	if t.tcp {
+		header["Transport"] = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d;ssrc=%08X", t.channel, t.channel+1, c.packetizer.ssrc)
+	} else {
+		header["Transport"] = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d;ssrc=%08X",
+			t.clientPorts[0], t.clientPorts[1], t.rtp.LocalAddr().(*net.UDPAddr).Port, t.rtcp.LocalAddr().(*net.UDPAddr).Port,
+			c.packetizer.ssrc)
+	}
+
+	header["Session"] = c.sessionHeader()
+
+	return c.respond(200, "OK", header, nil)
+}
+
+func (c *conn) play(req *request, header map[string]string) error {
+	if c.transport == nil || req.Header.Get("Session") == "" || strings.Split(req.Header.Get("Session"), ";")[0] != c.session {
+		return c.respond(454, "Session Not Found", header, nil)
+	}
+
+	header["Session"] = c.sessionHeader()
+	header["Range"] = "npt=0.000-"
+
+	if c.stop != nil {
+		return c.respond(200, "OK", header, nil)
+	}
+
+	u := *req.URL
+	u.User = nil
+	header["RTP-Info"] = fmt.Sprintf("url=%s;seq=%d;rtptime=0", strings.TrimSuffix(u.String(), "/"), c.packetizer.seq)
+
+	err := c.respond(200, "OK", header, nil)
+	if err != nil {
+		return err
+	}
+
+	c.stop = make(chan struct{})
+
+	c.wg.Add(1)
+	go c.send()
+
+	return nil
+}
+
+// teardown stops sending and releases transport.
+func (c *conn) teardown() {
+	if c.stop != nil {
+		close(c.stop)
+		c.wg.Wait()
+		c.stop = nil
+	}
+
+	if c.transport != nil {
+		c.transport.close()
+		c.transport = nil
+	}
+
+	c.session = ""
+	c.stream = nil
+}
+
+// send writes stream frames as RTP packets until stopped.
+func (c *conn) send() {
+	defer c.wg.Done()
+
+	ch, unsubscribe := c.stream.Subscribe()
+	defer unsubscribe()
+
+	host, _, _ := net.SplitHostPort(c.nc.RemoteAddr().String())
+	disconnect := events.Connect(map[string]any{"stream": c.stream.Name(), "handler": "rtsp", "ip": host})
+	defer disconnect()
+
+	metrics.Viewers.With("rtsp").Inc()
+	defer metrics.Viewers.With("rtsp").Dec()
+
+	var start time.Time
+	var warned bool
+	var lastSeq uint64
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case f, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			metrics.Dropped("rtsp", &lastSeq, f.Seq)
+
+			data, err := f.JPEG()
+			if err != nil {
+				continue
+			}
+
+			jf, err := parseJPEG(data)
+			if errors.Is(err, errUnsupported) {
+				// Frames that RFC 2435 cannot carry are re-encoded for each client
+				if !warned {
+					warned = true
+					log.Printf("%v, frames are re-encoded, use baseline encoding with standard Huffman tables and 4:2:0 or 4:2:2 subsampling", err)
+				}
+
+				jf, err = c.reencode(f)
+			}
+
+			if err != nil {
+				continue
+			}
+
+			if start.IsZero() {
+				start = f.Time
+			}
+
+			ts := uint32(f.Time.Sub(start).Seconds() * clockRate)
+
+			for _, pkt := range c.packetizer.packetize(jf, ts) {
+				err = c.writePacket(pkt)
+				if err != nil {
+					_ = c.nc.Close()
+
+					return
+				}
+			}
+		}
+	}
+}
+
+// reencode returns frame encoded as baseline 4:2:0 JPEG with standard Huffman tables.
+func (c *conn) reencode(f *stream.Frame) (*jpegFrame, error) {
+	img, err := f.Image()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := baseline(img, c.stream.EncoderOptions().Quality)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJPEG(data)
+}
+
+func (c *conn) writePacket(pkt []byte) error {
+	if !c.transport.tcp {
+		n, err := c.transport.rtp.WriteToUDP(pkt, c.transport.dst)
+		metrics.BytesSent.With("rtsp").Add(float64(n))
+
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	buf := make([]byte, 4, 4+len(pkt))
+	buf[0] = '$'
+	buf[1] = c.transport.channel
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(pkt)))
+
+	_ = c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	n, err := c.nc.Write(append(buf, pkt...))
+	metrics.BytesSent.With("rtsp").Add(float64(n))
+
+	return err
+}
+
+func (c *conn) respond(code int, status string, header map[string]string, body []byte) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "RTSP/1.0 %d %s\r\n", code, status)
+	fmt.Fprintf(&sb, "CSeq: %s\r\n", header["CSeq"])
+	fmt.Fprintf(&sb, "Server: cam2ip\r\n")
+	fmt.Fprintf(&sb, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123))
+
+	for k, v := range header {
+		if k != "CSeq" {
+			fmt.Fprintf(&sb, "%s: %s\r\n", k, v)
+		}
+	}
+
+	if len(body) > 0 {
+		fmt.Fprintf(&sb, "Content-Length: %d\r\n", len(body))
+	}
+
+	sb.WriteString("\r\n")
+	sb.Write(body)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	_ = c.nc.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := io.WriteString(c.nc, sb.String())
+
+	return err
+}
+
+func (c *conn) sessionHeader() string {
+	return fmt.Sprintf("%s;timeout=%d", c.session, sessionTimeout)
+}
+
+func (c *conn) sdp() []byte {
+	host, _, _ := net.SplitHostPort(c.nc.LocalAddr().String())
+
+	ipv, addr := "IP4", "0.0.0.0"
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		ipv, addr = "IP6", "::"
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("v=0\r\n")
+	fmt.Fprintf(&sb, "o=- %d 1 IN %s %s\r\n", time.Now().Unix(), ipv, host)
+	sb.WriteString("s=cam2ip\r\n")
+	fmt.Fprintf(&sb, "c=IN %s %s\r\n", ipv, addr)
+	sb.WriteString("t=0 0\r\n")
+	fmt.Fprintf(&sb, "m=video 0 RTP/AVP %d\r\n", payloadType)
+	fmt.Fprintf(&sb, "a=rtpmap:%d JPEG/%d\r\n", payloadType, clockRate)
+	sb.WriteString("a=control:trackID=0\r\n")
+
+	return []byte(sb.String())
+}
+
+// parseTransport picks the first supported transport from client Transport header.
+func (c *conn) parseTransport(v string) (*transport, error) {
+	for _, spec := range strings.Split(v, ",") {
+		params := strings.Split(strings.TrimSpace(spec), ";")
+		if len(params) == 0 {
+			continue
+		}
+
+		proto := strings.ToUpper(params[0])
+		if proto != "RTP/AVP" && proto != "RTP/AVP/UDP" && proto != "RTP/AVP/TCP" {
+			continue
+		}
+
+		t := &transport{tcp: proto == "RTP/AVP/TCP"}
+		multicast := false
+
+		for _, p := range params[1:] {
+			k, val, _ := strings.Cut(p, "=")
+
+			switch strings.ToLower(k) {
+			case "multicast":
+				multicast = true
+			case "interleaved":
+				a, _, _ := strings.Cut(val, "-")
+				n, err := strconv.Atoi(a)
+				if err == nil && n >= 0 && n < 255 {
+					t.channel = byte(n)
+				}
+			case "client_port":
+				a, b, _ := strings.Cut(val, "-")
+				t.clientPorts[0], _ = strconv.Atoi(a)
+				t.clientPorts[1], _ = strconv.Atoi(b)
+				if t.clientPorts[1] == 0 {
+					t.clientPorts[1] = t.clientPorts[0] + 1
+				}
+			}
+		}
+
+		if multicast {
+			continue
+		}
+
+		if t.tcp {
+			return t, nil
+		}
+
+		if t.clientPorts[0] == 0 {
+			continue
+		}
+
+		err := t.listenUDP(c.nc)
+		if err != nil {
+			return nil, err
+		}
+
+		return t, nil
+	}
+
+	return nil, fmt.Errorf("no supported transport in %q", v)
+}
+
+// listenUDP opens RTP and RTCP sockets on consecutive ports and sets client address.
+func (t *transport) listenUDP(nc net.Conn) error {
+	local := nc.LocalAddr().(*net.TCPAddr)
+	remote := nc.RemoteAddr().(*net.TCPAddr)
+
+	t.dst = &net.UDPAddr{IP: remote.IP, Port: t.clientPorts[0], Zone: remote.Zone}
+
+	var err error
+
+	for i := 0; i < 10; i++ {
+		t.rtp, err = net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+		if err != nil {
+			return err
+		}
+
+		port := t.rtp.LocalAddr().(*net.UDPAddr).Port
+
+		t.rtcp, err = net.ListenUDP("udp", &net.UDPAddr{IP: local.IP, Port: port + 1})
+		if err == nil {
+			return nil
+		}
+
+		_ = t.rtp.Close()
+		t.rtp = nil
+	}
+
+	return err
+}
+
+// streamName returns stream name and track from URL path, e.g. /sub/trackID=0.
+func streamName(u *url.URL) (name, track string) {
+	path := strings.Trim(u.Path, "/")
+
+	name, track, _ = strings.Cut(path, "/")
+	if strings.HasPrefix(name, "trackID=") {
+		return "", name
+	}
+
+	return name, track
+}
+
+func randUint32() uint32 {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
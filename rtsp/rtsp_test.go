@@ -0,0 +1,372 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	im "github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+type testReader struct{}
+
+func (testReader) Read() (image.Image, error) {
+	time.Sleep(20 * time.Millisecond)
+
+	img := image.NewYCbCr(image.Rect(0, 0, 160, 120), image.YCbCrSubsampleRatio420)
+	for i := range img.Y {
+		img.Y[i] = uint8(i)
+	}
+
+	return img, nil
+}
+
+func (testReader) Close() error {
+	return nil
+}
+
+func TestPacketize(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	img := image.NewYCbCr(image.Rect(0, 0, 640, 480), image.YCbCrSubsampleRatio420)
+	for i := range img.Y {
+		img.Y[i] = uint8(i * 7)
+	}
+
+	err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := parseJPEG(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Type != 1 || f.Width != 640 || f.Height != 480 || len(f.Quant) != 128 {
+		t.Fatalf("unexpected frame type %d, size %dx%d, quant %d", f.Type, f.Width, f.Height, len(f.Quant))
+	}
+
+	p := &packetizer{ssrc: 1}
+	packets := p.packetize(f, 1234)
+
+	if len(packets) < 2 {
+		t.Fatalf("expected fragmented frame, got %d packets", len(packets))
+	}
+
+	decoded, err := depacketize(packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(decoded)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseJPEG(progressive()); err == nil {
+		t.Error("expected error for progressive jpeg")
+	}
+}
+
+func TestUnsupportedJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	codec, err := im.Lookup("jpegli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	optimized := im.DefaultEncoderOptions
+	optimized.OptimizeCoding = true
+
+	full := im.DefaultEncoderOptions
+	full.Subsampling = image.YCbCrSubsampleRatio444
+
+	// jpegli uses separate quantization tables for Cb and Cr
+	tests := map[string]im.EncoderOptions{"default": im.DefaultEncoderOptions, "optimized": optimized, "444": full}
+
+	for name, opts := range tests {
+		buf := new(bytes.Buffer)
+		if err := codec.Encode(buf, img, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := parseJPEG(buf.Bytes()); !errors.Is(err, errUnsupported) {
+			t.Errorf("%s: expected unsupported jpeg, got %v", name, err)
+		}
+	}
+
+	// Re-encoded frames are carried as is
+	for _, src := range []image.Image{img, image.NewGray(img.Bounds())} {
+		data, err := baseline(src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := parseJPEG(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := depacketize((&packetizer{ssrc: 1}).packetize(f, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := jpeg.Decode(bytes.NewReader(decoded)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sizes that are not a multiple of 8 are re-encoded cropped, e.g. 16:9 substream
+	odd := image.NewRGBA(image.Rect(0, 0, 320, 180))
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, odd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseJPEG(buf.Bytes()); !errors.Is(err, errUnsupported) {
+		t.Errorf("320x180: expected unsupported jpeg, got %v", err)
+	}
+
+	data, err := baseline(odd, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := parseJPEG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := depacketize((&packetizer{ssrc: 1}).packetize(f, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Width != 320 || cfg.Height != 176 {
+		t.Errorf("expected 320x176, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestServer(t *testing.T) {
+	p := stream.New(testReader{}, stream.Options{Encoder: im.DefaultEncoderOptions})
+	defer p.Close()
+
+	auth := func(username, password, remoteAddr string) bool {
+		return username == "admin" && password == "secret"
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("", p, auth)
+	go s.Serve(l)
+	defer s.Close()
+
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	_ = nc.SetDeadline(time.Now().Add(10 * time.Second))
+
+	br := bufio.NewReader(nc)
+	u := "rtsp://" + l.Addr().String() + "/main"
+	cseq := 0
+
+	do := func(method, url string, header ...string) (int, textproto.MIMEHeader) {
+		cseq++
+		req := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\n%s\r\n", method, url, cseq, strings.Join(header, ""))
+		if _, err := io.WriteString(nc, req); err != nil {
+			t.Fatal(err)
+		}
+
+		tp := textproto.NewReader(br)
+
+		line, err := tp.ReadLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := tp.ReadMIMEHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var code int
+		_, _ = fmt.Sscanf(line, "RTSP/1.0 %d", &code)
+
+		var n int
+		_, _ = fmt.Sscanf(h.Get("Content-Length"), "%d", &n)
+		_, _ = br.Discard(n)
+
+		return code, h
+	}
+
+	if code, _ := do("DESCRIBE", u); code != 401 {
+		t.Fatalf("expected 401, got %d", code)
+	}
+
+	authorization := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")) + "\r\n"
+
+	if code, _ := do("DESCRIBE", u, authorization); code != 200 {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	code, h := do("SETUP", u+"/trackID=0", "Transport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n")
+	if code != 200 {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	session := strings.Split(h.Get("Session"), ";")[0]
+
+	if code, _ := do("PLAY", u, "Session: "+session+"\r\n"); code != 200 {
+		t.Fatalf("expected 200, got %d", code)
+	}
+
+	var packets [][]byte
+
+	for {
+		hdr := make([]byte, 4)
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		if hdr[0] != '$' || hdr[1] != 0 {
+			t.Fatalf("unexpected interleaved header %v", hdr)
+		}
+
+		pkt := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+		if _, err := io.ReadFull(br, pkt); err != nil {
+			t.Fatal(err)
+		}
+
+		// Wait for frame start.
+		if len(packets) == 0 && binary.BigEndian.Uint32(pkt[12:])&0xFFFFFF != 0 {
+			continue
+		}
+
+		packets = append(packets, pkt)
+
+		if pkt[1]&0x80 != 0 {
+			break
+		}
+	}
+
+	decoded, err := depacketize(packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dx() != 160 || b.Dy() != 120 {
+		t.Errorf("expected 160x120, got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+// depacketize rebuilds JPEG from RFC 2435 packets the way clients do, using standard Huffman tables.
+func depacketize(packets [][]byte) ([]byte, error) {
+	var typ, width, height byte
+	var quant, data []byte
+
+	for i, pkt := range packets {
+		if pkt[1]&0x7F != payloadType {
+			return nil, fmt.Errorf("invalid payload type %d", pkt[1]&0x7F)
+		}
+
+		p := pkt[12:]
+
+		offset := int(p[1])<<16 | int(p[2])<<8 | int(p[3])
+		if offset != len(data) {
+			return nil, fmt.Errorf("packet %d: offset %d, expected %d", i, offset, len(data))
+		}
+
+		typ, width, height = p[4], p[6], p[7]
+		q := p[5]
+		p = p[8:]
+
+		if typ >= 64 {
+			p = p[4:]
+		}
+
+		if offset == 0 && q >= 128 {
+			n := int(binary.BigEndian.Uint16(p[2:]))
+			quant = p[4 : 4+n]
+			p = p[4+n:]
+		}
+
+		data = append(data, p...)
+	}
+
+	if len(quant) != 128 {
+		return nil, fmt.Errorf("invalid quantization tables length %d", len(quant))
+	}
+
+	sampling := byte(0x21)
+	if typ&0x3F == 1 {
+		sampling = 0x22
+	}
+
+	b := []byte{0xFF, markerSOI}
+
+	b = append(b, 0xFF, markerDQT, 0, 132, 0)
+	b = append(b, quant[:64]...)
+	b = append(b, 1)
+	b = append(b, quant[64:]...)
+
+	b = append(b, 0xFF, markerSOF0, 0, 17, 8)
+	b = binary.BigEndian.AppendUint16(b, uint16(height)*8)
+	b = binary.BigEndian.AppendUint16(b, uint16(width)*8)
+	b = append(b, 3, 1, sampling, 0, 2, 0x11, 1, 3, 0x11, 1)
+
+	for _, h := range stdHuffman {
+		b = append(b, 0xFF, 0xC4)
+		b = binary.BigEndian.AppendUint16(b, uint16(2+1+16+len(h.vals)))
+		b = append(b, h.class)
+		b = append(b, h.bits...)
+		b = append(b, h.vals...)
+	}
+
+	b = append(b, 0xFF, markerSOS, 0, 12, 3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0)
+	b = append(b, data...)
+	b = append(b, 0xFF, markerEOI)
+
+	return b, nil
+}
+
+func progressive() []byte {
+	// SOI, SOF2 with 3 components, SOS.
+	b := []byte{0xFF, markerSOI, 0xFF, markerSOF2, 0, 17, 8, 0, 8, 0, 8, 3, 1, 0x22, 0, 2, 0x11, 1, 3, 0x11, 1}
+	b = append(b, 0xFF, markerSOS, 0, 2)
+
+	return b
+}
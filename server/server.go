@@ -2,14 +2,58 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/gen2brain/cam2ip/events"
 	"github.com/gen2brain/cam2ip/handlers"
+	"github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/mdns"
+	"github.com/gen2brain/cam2ip/metrics"
+	"github.com/gen2brain/cam2ip/motion"
+	"github.com/gen2brain/cam2ip/mqtt"
+	"github.com/gen2brain/cam2ip/notify"
+	"github.com/gen2brain/cam2ip/onvif"
+	"github.com/gen2brain/cam2ip/record"
+	"github.com/gen2brain/cam2ip/relay"
+	"github.com/gen2brain/cam2ip/rtsp"
+	"github.com/gen2brain/cam2ip/storage"
+	"github.com/gen2brain/cam2ip/stream"
+	"github.com/gen2brain/cam2ip/timelapse"
 )
 
+// DataDir is the directory where database and stored files are kept.
+const DataDir = "data"
+
+// GalleryDir is the directory where exported images are saved.
+const GalleryDir = DataDir + "/gallery"
+
+// UploadQueueDir is the directory where uploads wait until storage is reachable.
+const UploadQueueDir = DataDir + "/upload-queue"
+
+// RecordingsDir is the default directory of recordings.
+const RecordingsDir = DataDir + "/recordings"
+
+// TimelapseDir is the directory where frames of timelapse jobs are kept.
+const TimelapseDir = DataDir + "/timelapse"
+
+// CompileDir is the directory where timelapse videos are compiled before download.
+const CompileDir = DataDir + "/compile"
+
+// ExportsDir is the directory where recordings exported to MP4 are kept for a day.
+const ExportsDir = DataDir + "/exports"
+
 // Server struct.
 type Server struct {
 	Name    string
@@ -25,13 +69,81 @@ type Server struct {
 	Rotate  int
 	Flip    string
 
+	Codec                string
+	Subsampling          string
+	Progressive          bool
+	OptimizeCoding       bool
+	AdaptiveQuantization bool
+	DCTMethod            string
+
 	NoWebGL bool
 
+	ABR           bool
+	ABRBitrate    int
+	ABRLatency    int
+	ABRMinQuality int
+
 	Timestamp  bool
 	TimeFormat string
 
+	History    int
+	HistoryFPS float64
+
+	Motion          bool
+	MotionThreshold float64
+
+	SubWidth   int
+	SubHeight  int
+	SubFPS     float64
+	SubQuality int
+
 	Bind     string
 	Htpasswd string
+	Debug    bool
+
+	MetricsToken string
+	MetricsAllow string
+
+	RTSPBind string
+	ONVIF    bool
+	MDNS     bool
+	MDNSName string
+
+	RelayURL string
+	RelayID  string
+	RelayKey string
+
+	Record        bool
+	RecordDir     string
+	RecordSegment int
+	RecordFPS     float64
+	RecordStream  string
+
+	RecordMaxAge     int
+	RecordClipMaxAge int
+	RecordMaxSize    int
+	RecordMinFree    int
+
+	EventRecord   bool
+	EventPreRoll  int
+	EventPostRoll int
+
+	MQTTBroker           string
+	MQTTUsername         string
+	MQTTPassword         string
+	MQTTTopic            string
+	MQTTSnapshotInterval int
+	MQTTDiscovery        bool
+
+	NotifyAllowLocal bool
+
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3Prefix    string
+	S3Retention int
 
 	Reader handlers.ImageReader
 }
@@ -43,8 +155,170 @@ func NewServer() *Server {
 	return s
 }
 
+// EncoderOptions returns JPEG encoder options from server configuration.
+func (s *Server) EncoderOptions() (opts image.EncoderOptions, err error) {
+	opts = image.DefaultEncoderOptions
+	opts.Quality = s.Quality
+	opts.Progressive = s.Progressive
+	opts.OptimizeCoding = s.OptimizeCoding
+	opts.AdaptiveQuantization = s.AdaptiveQuantization
+
+	if s.Subsampling != "" {
+		opts.Subsampling, err = image.ParseSubsampling(s.Subsampling)
+		if err != nil {
+			return
+		}
+	}
+
+	if s.DCTMethod != "" {
+		opts.DCTMethod, err = image.ParseDCTMethod(s.DCTMethod)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// ABROptions returns adaptive bitrate options from server configuration.
+func (s *Server) ABROptions() handlers.ABROptions {
+	return handlers.ABROptions{
+		Enabled:       s.ABR,
+		TargetBitrate: s.ABRBitrate,
+		TargetLatency: time.Duration(s.ABRLatency) * time.Millisecond,
+		MinQuality:    s.ABRMinQuality,
+	}
+}
+
+// streamOptions returns pipeline options, substream is disabled with zero width.
+func (s *Server) streamOptions(opts image.EncoderOptions) stream.Options {
+	so := stream.Options{
+		History:    time.Duration(s.History) * time.Second,
+		HistoryFPS: s.HistoryFPS,
+		Encoder:    opts,
+	}
+
+	if s.SubWidth > 0 {
+		sub := opts
+		if s.SubQuality > 0 {
+			sub.Quality = s.SubQuality
+		}
+
+		so.Sub = &stream.SubOptions{
+			Width:   s.SubWidth,
+			Height:  s.SubHeight,
+			FPS:     s.SubFPS,
+			Encoder: sub,
+		}
+	}
+
+	return so
+}
+
+// profiles returns size, rate and quality of each stream.
+func (s *Server) profiles(opts image.EncoderOptions) []onvif.Profile {
+	width, height := int(s.Width), int(s.Height)
+	if s.Rotate == 90 || s.Rotate == 270 {
+		width, height = height, width
+	}
+
+	fps := 30.0
+	if s.Delay > 0 {
+		fps = min(fps, 1000/float64(s.Delay))
+	}
+
+	profiles := []onvif.Profile{{Stream: stream.MainStream, Width: width, Height: height, FPS: fps, Quality: opts.Quality}}
+
+	if s.SubWidth > 0 {
+		subHeight := s.SubHeight
+		if subHeight == 0 && width > 0 {
+			subHeight = s.SubWidth * height / width
+		}
+
+		quality := opts.Quality
+		if s.SubQuality > 0 {
+			quality = s.SubQuality
+		}
+
+		profiles = append(profiles, onvif.Profile{Stream: stream.SubStream, Width: s.SubWidth, Height: subHeight, FPS: min(fps, s.SubFPS), Quality: quality})
+	}
+
+	return profiles
+}
+
+// onvifOptions returns ONVIF service options with a profile for each stream.
+func (s *Server) onvifOptions(opts image.EncoderOptions) onvif.Options {
+	return onvif.Options{
+		Name:     s.Name,
+		Version:  s.Version,
+		Profiles: s.profiles(opts),
+		RTSPPort: port(s.RTSPBind),
+		Auth: func(username, password, remoteAddr string) bool {
+			return handlers.CheckCredentials(username, password, remoteAddr, "onvif")
+		},
+	}
+}
+
+// apiOptions returns API options with configuration of the camera.
+func (s *Server) apiOptions(opts image.EncoderOptions) handlers.APIOptions {
+	camera := handlers.CameraConfig{
+		Index:     s.Index,
+		Rotate:    s.Rotate,
+		Flip:      s.Flip,
+		Codec:     s.Codec,
+		Timestamp: s.Timestamp,
+		Motion:    s.Motion,
+		History:   s.History,
+	}
+
+	for _, p := range s.profiles(opts) {
+		camera.Streams = append(camera.Streams, handlers.StreamConfig{Name: p.Stream, Width: p.Width, Height: p.Height, FPS: p.FPS, Quality: p.Quality})
+	}
+
+	return handlers.APIOptions{Name: s.Name, Version: s.Version, Camera: camera}
+}
+
+// mdnsServices returns advertised services, TXT records carry camera name, streams and paths.
+func (s *Server) mdnsServices(opts image.EncoderOptions) []mdns.Service {
+	instance := s.MDNSName
+	if instance == "" {
+		instance = s.Name + " on " + mdns.HostLabel("")
+	}
+
+	text := map[string]string{
+		"txtvers": "1",
+		"name":    instance,
+		"version": s.Version,
+		"path":    "/",
+		"mjpeg":   "/mjpeg",
+		"jpeg":    "/jpeg",
+		"api":     "/api/v1",
+	}
+
+	var names []string
+	for _, p := range s.profiles(opts) {
+		names = append(names, p.Stream)
+		text[p.Stream] = fmt.Sprintf("%dx%d", p.Width, p.Height)
+	}
+	text["streams"] = strings.Join(names, ",")
+
+	if s.RTSPBind != "" {
+		text["rtsp"] = strconv.Itoa(port(s.RTSPBind))
+	}
+
+	return []mdns.Service{
+		{Instance: instance, Type: "_cam2ip._tcp", Port: port(s.Bind), Text: text},
+		{Instance: instance, Type: "_http._tcp", Port: port(s.Bind), Text: map[string]string{"path": "/"}},
+	}
+}
+
 // ListenAndServe listens on the TCP address and serves requests.
 func (s *Server) ListenAndServe() error {
+	opts, err := s.EncoderOptions()
+	if err != nil {
+		return err
+	}
+
 	// Инициализируем базу данных
 	if err := handlers.InitDatabase(); err != nil {
 		return fmt.Errorf("failed to initialize database: %v", err)
@@ -56,7 +330,96 @@ func (s *Server) ListenAndServe() error {
 		return fmt.Errorf("failed to initialize logger: %v", err)
 	}
 
-	// Note: Basic auth is disabled in favor of custom session-based authentication
+	// Камера читается один раз, кадры раздаются всем обработчикам
+	pipeline := stream.New(s.Reader, s.streamOptions(opts))
+	defer pipeline.Close()
+
+	// Детектор движения анализирует субпоток, если он включен
+	if s.Motion {
+		mopts := motion.DefaultOptions
+		mopts.Threshold = s.MotionThreshold
+
+		st := pipeline.Stream(stream.SubStream)
+		if st == nil {
+			st = pipeline.Stream(stream.MainStream)
+		}
+
+		frames, unsubscribe := st.Subscribe()
+		defer unsubscribe()
+
+		go motion.NewDetector(mopts).Run(frames, events.Default)
+	}
+
+	// Очередь загрузок в S3 создается до записи, чтобы сегменты и клипы тоже загружались
+	var queue *storage.Queue
+	var upload *record.Uploader
+	if s.S3Endpoint != "" {
+		queue, err = s.uploadQueue()
+		if err != nil {
+			return err
+		}
+
+		queue.Start()
+		defer queue.Close()
+
+		upload = record.NewUploader(s.RecordDir, queue)
+		defer upload.Close()
+	}
+
+	// Сегменты, прерванные сбоем питания, восстанавливаются до начала записи и загружаются в S3
+	if recs, err := record.Recover(s.RecordDir, handlers.GetDatabase(), upload); err != nil {
+		log.Printf("record: recovery: %v", err)
+	} else if len(recs) > 0 {
+		log.Printf("record: recovered %d recordings", len(recs))
+	}
+
+	// Запись можно включить и выключить через MQTT, с --record она начинается сразу
+	recorder, err := s.recorder(pipeline, opts, upload)
+	if err != nil {
+		return err
+	}
+	defer recorder.Close()
+
+	if s.Record {
+		if err := recorder.StartRecording(); err != nil {
+			return err
+		}
+	}
+
+	// Клипы записываются вокруг событий движения, вмешательства и внешних триггеров
+	if s.EventRecord {
+		er := s.eventRecorder(pipeline, recorder, upload)
+		if err := er.Start(); err != nil {
+			return err
+		}
+		defer er.Close()
+	}
+
+	// Старые записи удаляются по возрасту, общему размеру и свободному месту на диске
+	retention := record.NewRetention(record.RetentionOptions{
+		MaxAge:     time.Duration(s.RecordMaxAge) * 24 * time.Hour,
+		ClipMaxAge: time.Duration(s.RecordClipMaxAge) * 24 * time.Hour,
+		MaxSize:    int64(s.RecordMaxSize) << 20,
+		MinFree:    int64(s.RecordMinFree) << 20,
+	}, s.RecordDir, handlers.GetDatabase())
+	retention.Start()
+	defer retention.Close()
+
+	exporter := record.NewExporter(s.RecordDir, ExportsDir, handlers.GetDatabase())
+	defer exporter.Close()
+
+	// Задания таймлапса хранятся в базе и меняются через API без перезапуска
+	scheduler := timelapse.New(TimelapseDir, handlers.GetDatabase(), pipeline)
+
+	// Недособранные видео остаются после аварийного завершения
+	if err := os.RemoveAll(CompileDir); err != nil {
+		log.Printf("timelapse: %v", err)
+	}
+
+	scheduler.Start()
+	defer scheduler.Close()
+
+	// Защищенные маршруты принимают cookie сессии, а клиенты без cookie (NVR, ONVIF, плееры) - Basic авторизацию
 
 	// Публичные маршруты (не требуют авторизации)
 	http.Handle("/", handlers.NewAuth()) // Страница авторизации теперь на корневом маршруте
@@ -65,18 +428,142 @@ func (s *Server) ListenAndServe() error {
 	http.HandleFunc("/debug/headers", handlers.DebugHeaders)
 	http.HandleFunc("/debug/ip", handlers.DebugIP)
 
+	// Замер кодеков нагружает процессор, он доступен только в режиме отладки
+	if s.Debug {
+		http.Handle("/debug/codec-bench", handlers.AuthMiddleware(handlers.NewCodecBench(pipeline, opts)))
+	}
+
 	// Защищенные маршруты (требуют авторизации)
-	http.Handle("/dashboard", handlers.AuthMiddleware(handlers.NewDashboard()))
+	http.Handle("/dashboard", handlers.AuthMiddleware(handlers.NewDashboard(s.SubWidth > 0)))
 	http.Handle("/logout", handlers.NewLogout())
-	http.Handle("/html", handlers.AuthMiddleware(handlers.NewHTML(s.Width, s.Height, s.NoWebGL)))
-	http.Handle("/jpeg", handlers.AuthMiddleware(handlers.NewJPEG(s.Reader, s.Quality)))
-	http.Handle("/mjpeg", handlers.AuthMiddleware(handlers.NewMJPEG(s.Reader, s.Delay, s.Quality)))
-	http.Handle("/socket", handlers.AuthMiddleware(handlers.NewSocket(s.Reader, s.Delay, s.Quality)))
+	http.Handle("/html", handlers.AuthMiddleware(handlers.NewHTML(s.Width, s.Height, s.NoWebGL, s.SubWidth > 0)))
+	http.Handle("/jpeg", handlers.AuthMiddleware(handlers.NewJPEG(pipeline, opts)))
+	http.Handle("/mjpeg", handlers.AuthMiddleware(handlers.NewMJPEG(pipeline, s.Delay, s.ABROptions())))
+	http.Handle("/y4m", handlers.AuthMiddleware(handlers.NewY4M(pipeline)))
+	http.Handle("/socket", handlers.AuthMiddleware(handlers.NewSocket(pipeline, s.Delay, s.ABROptions())))
+
+	http.Handle("/events", handlers.AuthMiddleware(handlers.NewEvents(events.Default)))
+
+	// API сам проверяет авторизацию, чтобы отвечать ошибками в JSON
+	apiOpts := s.apiOptions(opts)
+	apiOpts.Retention = retention
+	apiOpts.Exporter = exporter
+	apiOpts.Timelapse = scheduler
+	apiOpts.CompileDir = CompileDir
+	http.Handle("/api/v1/", handlers.NewAPI(apiOpts, pipeline, handlers.GetDatabase(), events.Default))
+
+	// Метрики защищены токеном или списком разрешенных адресов, а не сессией
+	mh, err := handlers.NewMetrics(metrics.Default, s.MetricsToken, s.MetricsAllow)
+	if err != nil {
+		return fmt.Errorf("metrics: %v", err)
+	}
+	http.Handle("/metrics", mh)
+
+	// Уведомления отправляются получателям из базы данных, изменения применяются сразу
+	notifier := notify.New(notify.Options{Name: s.Name, AllowLocal: s.NotifyAllowLocal}, handlers.GetDatabase(), pipeline, events.Default)
+	notifier.Start()
+	defer notifier.Close()
+
+	notifications := handlers.AuthMiddleware(handlers.NewNotifications(handlers.GetDatabase(), notifier))
+	http.Handle("/notifications", notifications)
+	http.Handle("/notifications/", notifications)
+
+	// Файлы сохраняются локально и, если задано, копируются в S3
+	var st storage.Storage = storage.NewLocal(DataDir)
+	if queue != nil {
+		st = storage.Multi{st, queue}
+
+		ch, cancel := events.Subscribe(16)
+		defer cancel()
+
+		go uploadSnapshots(ch, pipeline, queue)
+	}
+
+	// Записи просматриваются и скачиваются только после авторизации
+	recordings := handlers.AuthMiddleware(handlers.NewRecordings(s.RecordDir, handlers.GetDatabase()))
+	http.Handle("/recordings", recordings)
+	http.Handle("/recordings/", recordings)
+
+	// Периоды длиннее истории в памяти собираются из записей, запись можно включить и во время работы
+	frameStore := record.NewFrameStore(s.RecordDir, handlers.GetDatabase())
+	http.Handle("/export/gif", handlers.AuthMiddleware(handlers.NewExport(pipeline, frameStore, st)))
+	http.Handle("/gallery/", handlers.AuthMiddleware(http.StripPrefix("/gallery/", http.FileServer(http.Dir(GalleryDir)))))
+
+	// ONVIF сервисы проверяют WS-UsernameToken сами
+	if s.ONVIF {
+		svc := onvif.NewService(s.onvifOptions(opts))
+		http.Handle(onvif.DevicePath, svc)
+		http.Handle(onvif.MediaPath, svc)
+
+		discovery := onvif.NewDiscovery(s.Name, port(s.Bind))
+		defer discovery.Close()
+
+		go func() {
+			if err := discovery.ListenAndServe(); err != nil {
+				handlers.GetLogger().LogError("WS-Discovery failed", err)
+			}
+		}()
+	}
+
+	// Камера объявляет себя в локальной сети, чтобы ее можно было найти без IP адреса
+	if s.MDNS {
+		responder := mdns.NewResponder("", s.mdnsServices(opts))
+		defer responder.Close()
+
+		go func() {
+			if err := responder.ListenAndServe(); err != nil {
+				handlers.GetLogger().LogError("mDNS failed", err)
+			}
+		}()
+	}
 
 	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
+	// RTSP сервер использует тех же пользователей, что и веб-интерфейс
+	if s.RTSPBind != "" {
+		rs := rtsp.NewServer(s.RTSPBind, pipeline, func(username, password, remoteAddr string) bool {
+			return handlers.CheckCredentials(username, password, remoteAddr, "rtsp")
+		})
+		defer rs.Close()
+
+		go func() {
+			if err := rs.ListenAndServe(); err != nil {
+				handlers.GetLogger().LogError("RTSP server failed", err)
+			}
+		}()
+	}
+
+	if s.MQTTBroker != "" {
+		mc := mqtt.New(mqtt.Options{
+			Broker:           s.MQTTBroker,
+			Username:         s.MQTTUsername,
+			Password:         s.MQTTPassword,
+			Topic:            s.MQTTTopic,
+			SnapshotInterval: time.Duration(s.MQTTSnapshotInterval) * time.Second,
+			Discovery:        s.MQTTDiscovery,
+			Name:             s.Name,
+			Version:          s.Version,
+		}, pipeline, recorder, events.Default)
+
+		if err := mc.Start(); err != nil {
+			return err
+		}
+		defer mc.Close()
+	}
+
+	// Камера сама подключается к relay, запросы из туннеля обслуживаются теми же обработчиками
+	if s.RelayURL != "" {
+		rc, err := relay.NewClient(relay.ClientOptions{URL: s.RelayURL, ID: s.RelayID, Key: s.RelayKey}, handlers.Trusted(http.DefaultServeMux))
+		if err != nil {
+			return err
+		}
+
+		rc.Start()
+		defer rc.Close()
+	}
+
 	srv := &http.Server{
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
@@ -87,5 +574,130 @@ func (s *Server) ListenAndServe() error {
 		return err
 	}
 
-	return srv.Serve(listener)
+	// По сигналу сервер останавливается штатно, чтобы отложенные Close успели дописать записи
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	err = srv.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// recorder returns recorder of configured stream, frame rate defaults to the stream rate.
+func (s *Server) recorder(pipeline *stream.Pipeline, opts image.EncoderOptions, upload *record.Uploader) (*record.Recorder, error) {
+	st := pipeline.Stream(s.RecordStream)
+	if st == nil {
+		return nil, fmt.Errorf("record: unknown or disabled stream %q", s.RecordStream)
+	}
+
+	fps := s.RecordFPS
+	if fps <= 0 {
+		for _, p := range s.profiles(opts) {
+			if p.Stream == st.Name() {
+				fps = p.FPS
+			}
+		}
+	}
+
+	return record.NewRecorder(record.Options{
+		Dir:     s.RecordDir,
+		Segment: time.Duration(s.RecordSegment) * time.Minute,
+		FPS:     fps,
+		Upload:  upload,
+	}, st, handlers.GetDatabase()), nil
+}
+
+// eventRecorder returns recorder of clips of the same stream and frame rate as continuous recorder.
+func (s *Server) eventRecorder(pipeline *stream.Pipeline, recorder *record.Recorder, upload *record.Uploader) *record.EventRecorder {
+	return record.NewEventRecorder(record.EventOptions{
+		Dir:      s.RecordDir,
+		FPS:      recorder.FPS(),
+		PreRoll:  time.Duration(s.EventPreRoll) * time.Second,
+		PostRoll: time.Duration(s.EventPostRoll) * time.Second,
+		Upload:   upload,
+	}, pipeline.Stream(s.RecordStream), events.Default, handlers.GetDatabase())
+}
+
+// uploadQueue returns queue of uploads to S3, retention is applied as bucket lifecycle rule.
+func (s *Server) uploadQueue() (*storage.Queue, error) {
+	s3, err := storage.NewS3(storage.S3Options{
+		Endpoint:  s.S3Endpoint,
+		Region:    s.S3Region,
+		Bucket:    s.S3Bucket,
+		AccessKey: s.S3AccessKey,
+		SecretKey: s.S3SecretKey,
+		Prefix:    s.S3Prefix,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.S3Retention > 0 {
+		// Хранилище может быть недоступно при запуске, правило устанавливается повторно до успеха
+		go func() {
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				err := s3.SetRetention(ctx, s.S3Retention)
+				cancel()
+
+				if err == nil {
+					return
+				}
+
+				log.Printf("storage: retention: %v", err)
+				time.Sleep(5 * time.Minute)
+			}
+		}()
+	}
+
+	return storage.NewQueue(UploadQueueDir, s3)
+}
+
+// uploadSnapshots stores snapshot of the main stream for motion and tamper events until channel is closed.
+func uploadSnapshots(ch <-chan events.Event, pipeline *stream.Pipeline, st storage.Storage) {
+	for e := range ch {
+		if e.Type != events.MotionStart && e.Type != events.Tamper {
+			continue
+		}
+
+		f := pipeline.Latest()
+		if f == nil {
+			continue
+		}
+
+		data, err := f.JPEG()
+		if err != nil {
+			continue
+		}
+
+		key := fmt.Sprintf("snapshots/%s-%s.jpg", e.Time.Format("2006/01/02/150405"), e.Type)
+
+		if err := st.Put(context.Background(), key, bytes.NewReader(data), "image/jpeg"); err != nil {
+			log.Printf("storage: snapshot: %v", err)
+		}
+	}
+}
+
+// port returns port number of address, zero if there is none.
+func port(addr string) int {
+	if addr == "" {
+		return 0
+	}
+
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0
+	}
+
+	n, _ := strconv.Atoi(p)
+
+	return n
 }
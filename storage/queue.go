@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	queueMinBackoff = 5 * time.Second
+	queueMaxBackoff = 5 * time.Minute
+	queueTimeout    = 10 * time.Minute
+)
+
+// queueItem is the metadata of queued upload, data is kept in file with the same name and .data extension.
+type queueItem struct {
+	Key         string    `json:"key"`
+	ContentType string    `json:"content_type"`
+	Created     time.Time `json:"created"`
+}
+
+// Queue keeps uploads on disk and uploads them to destination storage in order, failed uploads are
+// retried with backoff, so nothing is lost while destination is offline or camera restarts.
+// Uploads that destination rejects with ErrRejected are moved to failed subdirectory, so they do not block the queue.
+type Queue struct {
+	dir string
+	dst Storage
+
+	seq  atomic.Uint64
+	mu   sync.Mutex
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewQueue returns new Queue that spools uploads in dir.
+func NewQueue(dir string, dst Storage) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Queue{
+		dir:  dir,
+		dst:  dst,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Start starts uploading, uploads queued before restart are uploaded first.
+func (q *Queue) Start() {
+	q.wg.Add(1)
+	go q.run()
+}
+
+// Close stops uploading, queued uploads are kept on disk.
+func (q *Queue) Close() error {
+	close(q.done)
+	q.wg.Wait()
+
+	return nil
+}
+
+// Put copies data to queue, it returns when data is safely on disk.
+func (q *Queue) Put(_ context.Context, key string, r io.ReadSeeker, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	name := filepath.Join(q.dir, fmt.Sprintf("%019d-%06d", time.Now().UnixNano(), q.seq.Add(1)%1000000))
+
+	err := writeFile(name+".data", func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Metadata is written last, item without it is not complete and is ignored.
+	err = writeFile(name+".json", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(queueItem{Key: key, ContentType: contentType, Created: time.Now()})
+	})
+	if err != nil {
+		_ = os.Remove(name + ".data")
+
+		return err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Get returns object from destination.
+func (q *Queue) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return q.dst.Get(ctx, key)
+}
+
+// Delete removes queued uploads of key and deletes object from destination.
+func (q *Queue) Delete(ctx context.Context, key string) error {
+	q.mu.Lock()
+	for _, name := range q.items() {
+		if item, err := readItem(name); err == nil && item.Key == key {
+			removeItem(name)
+		}
+	}
+	q.mu.Unlock()
+
+	return q.dst.Delete(ctx, key)
+}
+
+// List lists objects in destination.
+func (q *Queue) List(ctx context.Context, prefix string) ([]Object, error) {
+	return q.dst.List(ctx, prefix)
+}
+
+// Pending returns number of queued uploads.
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items())
+}
+
+func (q *Queue) run() {
+	defer q.wg.Done()
+
+	backoff := queueMinBackoff
+
+	for {
+		err := q.upload()
+
+		wait := time.Minute
+		if err != nil {
+			log.Printf("storage: upload: %v, retrying in %v", err, backoff)
+
+			wait = backoff
+			backoff = min(backoff*2, queueMaxBackoff)
+		} else {
+			backoff = queueMinBackoff
+		}
+
+		select {
+		case <-q.done:
+			return
+		case <-q.wake:
+			if err != nil {
+				// Destination is still down, new uploads wait for the backoff.
+				select {
+				case <-q.done:
+					return
+				case <-time.After(wait):
+				}
+			}
+		case <-time.After(wait):
+		}
+	}
+}
+
+// upload uploads queued items oldest first until queue is empty or upload fails.
+func (q *Queue) upload() error {
+	for {
+		q.mu.Lock()
+		items := q.items()
+		q.mu.Unlock()
+
+		if len(items) == 0 {
+			return nil
+		}
+
+		name := items[0]
+
+		item, err := readItem(name)
+		if err != nil {
+			log.Printf("storage: dropping invalid queue item %s: %v", name, err)
+
+			q.mu.Lock()
+			removeItem(name)
+			q.mu.Unlock()
+
+			continue
+		}
+
+		err = q.put(name, item)
+		if errors.Is(err, ErrRejected) {
+			q.mu.Lock()
+			failed, ferr := q.fail(name)
+			q.mu.Unlock()
+
+			if ferr != nil {
+				return fmt.Errorf("%s: %w", item.Key, ferr)
+			}
+
+			log.Printf("storage: upload %s rejected, moved to %s: %v", item.Key, failed, err)
+
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", item.Key, err)
+		}
+
+		q.mu.Lock()
+		removeItem(name)
+		q.mu.Unlock()
+
+		select {
+		case <-q.done:
+			return nil
+		default:
+		}
+	}
+}
+
+func (q *Queue) put(name string, item queueItem) error {
+	f, err := os.Open(name + ".data")
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), queueTimeout)
+	defer cancel()
+
+	// Closing queue cancels upload in progress, it is retried after restart.
+	go func() {
+		select {
+		case <-q.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return q.dst.Put(ctx, item.Key, f, item.ContentType)
+}
+
+// fail moves queue item to failed subdirectory and returns its new name.
+func (q *Queue) fail(name string) (string, error) {
+	dir := filepath.Join(q.dir, "failed")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	failed := filepath.Join(dir, filepath.Base(name))
+
+	// Metadata is moved last, like in Put.
+	for _, ext := range []string{".data", ".json"} {
+		if err := os.Rename(name+ext, failed+ext); err != nil {
+			return "", err
+		}
+	}
+
+	return failed, nil
+}
+
+// items returns names of complete queue items without extension, oldest first.
+func (q *Queue) items() []string {
+	matches, _ := filepath.Glob(filepath.Join(q.dir, "*.json"))
+
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(m, ".json"))
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func readItem(name string) (item queueItem, err error) {
+	data, err := os.ReadFile(name + ".json")
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(data, &item)
+	if err == nil {
+		err = checkKey(item.Key)
+	}
+
+	return
+}
+
+func removeItem(name string) {
+	_ = os.Remove(name + ".json")
+	_ = os.Remove(name + ".data")
+}
+
+// writeFile writes file through temporary file, so it either exists complete or not at all.
+func writeFile(name string, write func(w io.Writer) error) error {
+	tmp := name + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+
+	return err
+}
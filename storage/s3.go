@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// S3Options are S3 storage options.
+type S3Options struct {
+	// Endpoint is the service URL, e.g. http://localhost:9000 for MinIO or https://s3.eu-central-1.amazonaws.com.
+	Endpoint string
+	// Region is the bucket region, MinIO accepts any.
+	Region string
+	Bucket string
+
+	AccessKey string
+	SecretKey string
+
+	// Prefix is prepended to all keys, e.g. cam2ip/.
+	Prefix string
+	// VirtualHost addresses bucket as subdomain of endpoint instead of path.
+	VirtualHost bool
+}
+
+// S3 stores objects in S3-compatible object storage, requests are signed with AWS Signature Version 4.
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 returns new S3 storage.
+func NewS3(opts S3Options) (*S3, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("storage: invalid s3 endpoint %q", opts.Endpoint)
+	}
+
+	if opts.Bucket == "" {
+		return nil, errors.New("storage: s3 bucket is not set")
+	}
+
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+
+	return &S3{
+		opts:     opts,
+		endpoint: u,
+		client:   &http.Client{},
+	}, nil
+}
+
+// Put uploads object, payload is hashed before upload, so reader is read twice.
+func (s *S3) Put(ctx context.Context, key string, r io.ReadSeeker, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+
+	res, err := s.do(ctx, http.MethodPut, key, nil, header, io.NopCloser(r), size, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+
+	return drain(res)
+}
+
+// Get downloads object.
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(ctx, http.MethodGet, key, nil, nil, nil, 0, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// Delete deletes object.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil, 0, emptySHA256)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return drain(res)
+}
+
+// listResult is ListObjectsV2 response.
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List lists objects with ListObjectsV2, following continuation tokens.
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	ret := make([]Object, 0)
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.opts.Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0, emptySHA256)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listResult
+
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		_ = res.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("storage: list: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			ret = append(ret, Object{Key: strings.TrimPrefix(c.Key, s.opts.Prefix), Size: c.Size, Modified: c.LastModified})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return ret, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+// SetRetention sets bucket lifecycle rule that expires objects under prefix after days, so storage
+// cleans up by itself even when camera is gone. It replaces existing lifecycle configuration of the bucket.
+func (s *S3) SetRetention(ctx context.Context, days int) error {
+	if days <= 0 {
+		return errors.New("storage: retention days must be positive")
+	}
+
+	body := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<LifecycleConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Rule>
+    <ID>cam2ip-retention</ID>
+    <Filter><Prefix>%s</Prefix></Filter>
+    <Status>Enabled</Status>
+    <Expiration><Days>%d</Days></Expiration>
+  </Rule>
+</LifecycleConfiguration>`, xmlEscape(s.opts.Prefix), days)
+
+	sum := md5.Sum([]byte(body))
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/xml")
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+
+	res, err := s.do(ctx, http.MethodPut, "", url.Values{"lifecycle": {""}}, header,
+		io.NopCloser(strings.NewReader(body)), int64(len(body)), sha256Hex([]byte(body)))
+	if err != nil {
+		return err
+	}
+
+	return drain(res)
+}
+
+// s3Error is S3 error response.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends signed request for key, empty key is the bucket itself.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.ReadCloser, size int64, payload string) (*http.Response, error) {
+	u := *s.endpoint
+
+	p := strings.TrimSuffix(u.Path, "/")
+	if s.opts.VirtualHost {
+		u.Host = s.opts.Bucket + "." + u.Host
+	} else {
+		p += "/" + s.opts.Bucket
+	}
+
+	if key != "" {
+		p += "/" + s.opts.Prefix + key
+	} else if !s.opts.VirtualHost {
+		p += "/"
+	}
+
+	if p == "" {
+		p = "/"
+	}
+
+	u.Path = p
+	u.RawPath = uriEncode(p, false)
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	// Empty body has to be nil, otherwise it is sent chunked.
+	if body != nil && size > 0 {
+		req.Body = body
+		req.ContentLength = size
+	}
+
+	sign(req, s.opts.AccessKey, s.opts.SecretKey, s.opts.Region, payload, time.Now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound && key != "" {
+		return nil, ErrNotFound
+	}
+
+	var e s3Error
+	if data, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10)); xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		err = fmt.Errorf("storage: s3 %s %s: %s: %s", method, key, e.Code, e.Message)
+	} else {
+		err = fmt.Errorf("storage: s3 %s %s: %s", method, key, res.Status)
+	}
+
+	// Credentials, clock skew and throttling affect all requests and can be fixed, other client errors are final.
+	switch res.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+	default:
+		if res.StatusCode >= 400 && res.StatusCode <= 499 {
+			err = rejectedError{err}
+		}
+	}
+
+	return nil, err
+}
+
+// rejectedError is error of request that is refused for good, it matches ErrRejected.
+type rejectedError struct {
+	error
+}
+
+func (e rejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+func (e rejectedError) Unwrap() error {
+	return e.error
+}
+
+func drain(res *http.Response) error {
+	defer res.Body.Close()
+
+	_, err := io.Copy(io.Discard, res.Body)
+
+	return err
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
@@ -0,0 +1,116 @@
+// Package storage stores snapshots, clips and recording segments on local disk or S3-compatible object storage.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when object does not exist.
+var ErrNotFound = errors.New("storage: object not found")
+
+// ErrRejected is returned when destination refuses request for good, e.g. invalid or too large object,
+// retrying it does not help.
+var ErrRejected = errors.New("storage: request rejected")
+
+// Object describes stored object.
+type Object struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Storage stores objects by slash separated keys, e.g. gallery/cam2ip-20250102-150405.gif.
+type Storage interface {
+	// Put stores data read from r under key, reader is read from the current offset.
+	Put(ctx context.Context, key string, r io.ReadSeeker, contentType string) error
+
+	// Get returns object data, ErrNotFound when it does not exist.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Delete deletes object, deleting object that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// List returns objects whose keys start with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// Multi writes to all storages and reads from the first one, e.g. local disk and upload queue.
+type Multi []Storage
+
+// Put stores data in all storages.
+func (m Multi) Put(ctx context.Context, key string, r io.ReadSeeker, contentType string) error {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, s := range m {
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := s.Put(ctx, key, r, contentType); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Get returns object from the first storage.
+func (m Multi) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return m[0].Get(ctx, key)
+}
+
+// Delete deletes object from all storages.
+func (m Multi) Delete(ctx context.Context, key string) error {
+	var errs []error
+
+	for _, s := range m {
+		if err := s.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// List lists objects in the first storage.
+func (m Multi) List(ctx context.Context, prefix string) ([]Object, error) {
+	return m[0].List(ctx, prefix)
+}
+
+// checkKey returns error for keys that are empty, absolute or not clean.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+
+	return nil
+}
+
+// ContentType returns content type of key by extension.
+func ContentType(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".gif":
+		return "image/gif"
+	case ".avi":
+		return "video/x-msvideo"
+	case ".mp4":
+		return "video/mp4"
+	case ".json":
+		return "application/json"
+	}
+
+	return "application/octet-stream"
+}
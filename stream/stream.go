@@ -0,0 +1,568 @@
+// Package stream.
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gen2brain/cam2ip/events"
+	im "github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/metrics"
+)
+
+// offlineFailures is the number of consecutive read errors after which camera is offline.
+const offlineFailures = 10
+
+// fpsSmoothing is the weight of the last frame interval in capture frame rate.
+const fpsSmoothing = 0.1
+
+// ErrClosed is returned by Read after pipeline is closed.
+var ErrClosed = errors.New("stream: pipeline closed")
+
+// Reader interface.
+type Reader interface {
+	// Read reads next frame from camera/video and returns image.
+	Read() (img image.Image, err error)
+
+	// Close closes camera/video.
+	Close() error
+}
+
+const (
+	// MainStream is the name of full resolution stream.
+	MainStream = "main"
+	// SubStream is the name of low resolution stream.
+	SubStream = "sub"
+)
+
+// SubOptions are substream options.
+type SubOptions struct {
+	// Width and Height of substream, zero height keeps aspect ratio.
+	Width  int
+	Height int
+	// FPS is the maximum substream frame rate, zero is the camera rate.
+	FPS float64
+	// Encoder are options used for encoding substream frames to JPEG.
+	Encoder im.EncoderOptions
+}
+
+// Options .
+type Options struct {
+	// History is the length of in-memory frame history.
+	History time.Duration
+	// HistoryFPS is the rate at which frames are kept in history.
+	HistoryFPS float64
+	// Encoder are options used for encoding frames to JPEG.
+	Encoder im.EncoderOptions
+	// Sub are substream options, nil disables substream.
+	Sub *SubOptions
+}
+
+// Frame is a captured frame, JPEG is encoded on first use and shared by all consumers.
+type Frame struct {
+	Seq  uint64
+	Time time.Time
+	// Privacy is set for blank frames published in privacy mode.
+	Privacy bool
+
+	img  image.Image
+	opts im.EncoderOptions
+
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// NewFrame returns new frame for image.
+func NewFrame(seq uint64, t time.Time, img image.Image, opts im.EncoderOptions) *Frame {
+	return &Frame{Seq: seq, Time: t, img: img, opts: opts}
+}
+
+// NewFrameJPEG returns new frame for already encoded JPEG data.
+func NewFrameJPEG(seq uint64, t time.Time, data []byte) *Frame {
+	f := &Frame{Seq: seq, Time: t, data: data}
+	f.once.Do(func() {})
+
+	return f
+}
+
+// Image returns frame image, decoding it from JPEG if frame holds only encoded data.
+func (f *Frame) Image() (image.Image, error) {
+	if f.img != nil {
+		return f.img, nil
+	}
+
+	data, err := f.JPEG()
+	if err != nil {
+		return nil, err
+	}
+
+	return im.NewDecoder(bytes.NewReader(data)).Decode()
+}
+
+// JPEG returns frame encoded to JPEG.
+func (f *Frame) JPEG() ([]byte, error) {
+	f.once.Do(func() {
+		buf := new(bytes.Buffer)
+		f.err = im.NewEncoder(buf, f.opts).Encode(f.img)
+		f.data = buf.Bytes()
+	})
+
+	return f.data, f.err
+}
+
+// Stream is a sequence of frames with its own size, rate and quality.
+type Stream struct {
+	name string
+	opts im.EncoderOptions
+
+	mu     sync.Mutex
+	latest *Frame
+	next   chan struct{}
+	subs   map[chan *Frame]struct{}
+	closed bool
+}
+
+func newStream(name string, opts im.EncoderOptions) *Stream {
+	return &Stream{
+		name: name,
+		opts: opts,
+		next: make(chan struct{}),
+		subs: make(map[chan *Frame]struct{}),
+	}
+}
+
+// Name returns stream name.
+func (s *Stream) Name() string {
+	return s.name
+}
+
+// EncoderOptions returns options used for encoding stream frames.
+func (s *Stream) EncoderOptions() im.EncoderOptions {
+	return s.opts
+}
+
+// Read waits for the next frame and returns image, it implements handlers.ImageReader.
+func (s *Stream) Read() (image.Image, error) {
+	f, err := s.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Image()
+}
+
+// Close is a no-op, streams are closed with pipeline.
+func (s *Stream) Close() error {
+	return nil
+}
+
+// Next waits for the next frame.
+func (s *Stream) Next() (*Frame, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+
+		return nil, ErrClosed
+	}
+
+	next := s.next
+	s.mu.Unlock()
+
+	<-next
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.latest == nil {
+		return nil, ErrClosed
+	}
+
+	return s.latest, nil
+}
+
+// Latest returns the last frame, or nil.
+func (s *Stream) Latest() *Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.latest
+}
+
+// Subscribe returns channel that receives every frame, frames are dropped if receiver is too slow.
+// Returned function must be called to unsubscribe.
+func (s *Stream) Subscribe() (<-chan *Frame, func()) {
+	ch := make(chan *Frame, 2)
+
+	s.mu.Lock()
+	if s.closed {
+		close(ch)
+	} else {
+		s.subs[ch] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	var once sync.Once
+
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			if _, ok := s.subs[ch]; ok {
+				delete(s.subs, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+func (s *Stream) publish(f *Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latest = f
+
+	close(s.next)
+	s.next = make(chan struct{})
+
+	for ch := range s.subs {
+		select {
+		case ch <- f:
+		default:
+		}
+	}
+}
+
+func (s *Stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+
+	for ch := range s.subs {
+		delete(s.subs, ch)
+		close(ch)
+	}
+
+	s.latest = nil
+	close(s.next)
+}
+
+// Pipeline reads frames from camera once and produces main stream, optional substream and frame history.
+type Pipeline struct {
+	reader Reader
+	opts   Options
+
+	main *Stream
+	sub  *Stream
+
+	mu      sync.Mutex
+	history []*Frame
+	closed  bool
+
+	privacy atomic.Bool
+	blank   image.Image
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New returns new Pipeline and starts capturing.
+func New(reader Reader, opts Options) *Pipeline {
+	p := &Pipeline{
+		reader: reader,
+		opts:   opts,
+		main:   newStream(MainStream, opts.Encoder),
+		done:   make(chan struct{}),
+	}
+
+	if opts.Sub != nil {
+		p.sub = newStream(SubStream, opts.Sub.Encoder)
+	}
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p
+}
+
+// Stream returns stream by name, empty name is the main stream. It returns nil for unknown or disabled stream.
+func (p *Pipeline) Stream(name string) *Stream {
+	switch name {
+	case "", MainStream:
+		return p.main
+	case SubStream:
+		return p.sub
+	}
+
+	return nil
+}
+
+// Streams returns all enabled streams.
+func (p *Pipeline) Streams() []*Stream {
+	if p.sub != nil {
+		return []*Stream{p.main, p.sub}
+	}
+
+	return []*Stream{p.main}
+}
+
+// Read waits for the next main stream frame and returns image, it implements handlers.ImageReader.
+func (p *Pipeline) Read() (image.Image, error) {
+	return p.main.Read()
+}
+
+// Next waits for the next main stream frame.
+func (p *Pipeline) Next() (*Frame, error) {
+	return p.main.Next()
+}
+
+// Latest returns the last main stream frame, or nil.
+func (p *Pipeline) Latest() *Frame {
+	return p.main.Latest()
+}
+
+// Subscribe subscribes to main stream frames.
+func (p *Pipeline) Subscribe() (<-chan *Frame, func()) {
+	return p.main.Subscribe()
+}
+
+// History returns frames kept in history captured after since, oldest first.
+func (p *Pipeline) History(since time.Time) []*Frame {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	frames := make([]*Frame, 0, len(p.history))
+	for _, f := range p.history {
+		if f.Time.After(since) {
+			frames = append(frames, f)
+		}
+	}
+
+	return frames
+}
+
+// SetPrivacy turns privacy mode on or off, in privacy mode streams get blank frames and history is not kept.
+func (p *Pipeline) SetPrivacy(on bool) {
+	if p.privacy.Swap(on) == on {
+		return
+	}
+
+	typ := events.PrivacyOff
+	if on {
+		typ = events.PrivacyOn
+	}
+
+	events.Publish(typ, nil)
+}
+
+// Privacy reports whether privacy mode is on.
+func (p *Pipeline) Privacy() bool {
+	return p.privacy.Load()
+}
+
+// HistoryLength returns configured history length.
+func (p *Pipeline) HistoryLength() time.Duration {
+	return p.opts.History
+}
+
+// Close stops capturing, it does not close underlying reader.
+func (p *Pipeline) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+
+		return nil
+	}
+
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.done)
+	p.wg.Wait()
+
+	for _, s := range p.Streams() {
+		s.close()
+	}
+
+	return nil
+}
+
+func (p *Pipeline) run() {
+	defer p.wg.Done()
+
+	var seq, subSeq uint64
+	var failures int
+	var lastHistory, lastSub, lastCapture time.Time
+	var avgInterval float64
+
+	historyInterval := interval(p.opts.HistoryFPS)
+
+	subInterval := time.Duration(0)
+	if p.opts.Sub != nil {
+		subInterval = interval(p.opts.Sub.FPS)
+	}
+
+	for {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		start := time.Now()
+
+		img, err := p.reader.Read()
+		if err != nil {
+			log.Printf("stream: read: %v", err)
+
+			failures++
+			if failures == offlineFailures {
+				events.Publish(events.CameraOffline, map[string]any{"error": err.Error()})
+				metrics.CaptureFPS.Set(0)
+				lastCapture = time.Time{}
+			}
+
+			select {
+			case <-p.done:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			continue
+		}
+
+		if failures >= offlineFailures {
+			events.Publish(events.CameraOnline, nil)
+			metrics.CameraReconnects.Inc()
+		}
+
+		failures = 0
+
+		seq++
+		now := time.Now()
+
+		metrics.CaptureDuration.Observe(now.Sub(start).Seconds())
+		metrics.CaptureFrames.Inc()
+
+		// Frame rate is smoothed over intervals between frames.
+		if !lastCapture.IsZero() {
+			d := now.Sub(lastCapture).Seconds()
+			if avgInterval == 0 {
+				avgInterval = d
+			} else {
+				avgInterval += fpsSmoothing * (d - avgInterval)
+			}
+
+			if avgInterval > 0 {
+				metrics.CaptureFPS.Set(math.Round(10/avgInterval) / 10)
+			}
+		}
+
+		lastCapture = now
+
+		privacy := p.privacy.Load()
+
+		var f *Frame
+		if privacy {
+			f = NewFrame(seq, now, p.blankImage(img.Bounds()), p.opts.Encoder)
+			f.Privacy = true
+		} else {
+			f = NewFrame(seq, now, clone(img), p.opts.Encoder)
+		}
+
+		if !privacy && p.opts.History > 0 && now.Sub(lastHistory) >= historyInterval {
+			lastHistory = now
+
+			data, err := f.JPEG()
+			if err != nil {
+				log.Printf("stream: encode: %v", err)
+			} else {
+				p.keep(NewFrameJPEG(seq, now, data))
+			}
+		}
+
+		p.main.publish(f)
+
+		if p.sub != nil && now.Sub(lastSub) >= subInterval {
+			lastSub = now
+			subSeq++
+
+			simg := im.ResizeTo(f.img, p.opts.Sub.Width, p.opts.Sub.Height)
+			sf := NewFrame(subSeq, now, simg, p.opts.Sub.Encoder)
+			sf.Privacy = privacy
+			p.sub.publish(sf)
+		}
+	}
+}
+
+// blankImage returns black image of given size, it is reused while size does not change.
+func (p *Pipeline) blankImage(r image.Rectangle) image.Image {
+	if p.blank == nil || p.blank.Bounds() != r {
+		img := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+		for i := range img.Cb {
+			img.Cb[i] = 128
+			img.Cr[i] = 128
+		}
+
+		p.blank = img
+	}
+
+	return p.blank
+}
+
+// keep appends frame to history and drops frames older than history length.
+func (p *Pipeline) keep(f *Frame) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.history = append(p.history, f)
+
+	n := 0
+	for n < len(p.history) && f.Time.Sub(p.history[n].Time) > p.opts.History {
+		n++
+	}
+
+	if n > 0 {
+		p.history = append(p.history[:0], p.history[n:]...)
+	}
+}
+
+func interval(fps float64) time.Duration {
+	if fps <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(time.Second) / fps)
+}
+
+// clone copies image buffers that cameras reuse between reads, since frames outlive the next read.
+func clone(img image.Image) image.Image {
+	switch i := img.(type) {
+	case *image.YCbCr:
+		c := *i
+		c.Y = append([]byte(nil), i.Y...)
+		c.Cb = append([]byte(nil), i.Cb...)
+		c.Cr = append([]byte(nil), i.Cr...)
+
+		return &c
+	case *image.RGBA:
+		c := *i
+		c.Pix = append([]byte(nil), i.Pix...)
+
+		return &c
+	}
+
+	return img
+}
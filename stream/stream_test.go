@@ -0,0 +1,106 @@
+package stream
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	im "github.com/gen2brain/cam2ip/image"
+)
+
+type testReader struct {
+	img *image.YCbCr
+}
+
+func (r *testReader) Read() (image.Image, error) {
+	time.Sleep(10 * time.Millisecond)
+
+	// Reuse the buffer like cameras do.
+	r.img.Y[0]++
+
+	return r.img, nil
+}
+
+func (r *testReader) Close() error {
+	return nil
+}
+
+func TestPipeline(t *testing.T) {
+	reader := &testReader{image.NewYCbCr(image.Rect(0, 0, 64, 48), image.YCbCrSubsampleRatio422)}
+
+	p := New(reader, Options{
+		History:    time.Second,
+		HistoryFPS: 20,
+		Encoder:    im.DefaultEncoderOptions,
+		Sub:        &SubOptions{Width: 32, FPS: 50, Encoder: im.DefaultEncoderOptions},
+	})
+
+	ch, unsubscribe := p.Subscribe()
+
+	f1, err := p.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f2, err := p.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f2.Seq <= f1.Seq {
+		t.Errorf("expected newer frame, got %d after %d", f2.Seq, f1.Seq)
+	}
+
+	img1, _ := f1.Image()
+	img2, _ := f2.Image()
+	if img1.(*image.YCbCr).Y[0] == img2.(*image.YCbCr).Y[0] {
+		t.Error("frames share camera buffer")
+	}
+
+	if _, ok := <-ch; !ok {
+		t.Error("subscriber channel closed")
+	}
+
+	unsubscribe()
+
+	sub := p.Stream(SubStream)
+	if sub == nil {
+		t.Fatal("substream is not enabled")
+	}
+
+	sf, err := sub.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	simg, _ := sf.Image()
+	if b := simg.Bounds(); b.Dx() != 32 || b.Dy() != 24 {
+		t.Errorf("expected substream size 32x24, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	if p.Stream("unknown") != nil {
+		t.Error("expected nil for unknown stream")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	history := p.History(time.Time{})
+	if len(history) == 0 {
+		t.Fatal("empty history")
+	}
+
+	for _, f := range history {
+		if _, err := f.Image(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Next(); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,530 @@
+// Package timelapse captures frames at long intervals within daily time windows and compiles them to AVI or GIF.
+package timelapse
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	im "github.com/gen2brain/cam2ip/image"
+	"github.com/gen2brain/cam2ip/record"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+// Formats of compiled timelapse.
+const (
+	FormatAVI = "avi"
+	FormatGIF = "gif"
+)
+
+const (
+	// MinInterval is the shortest interval between frames.
+	MinInterval = 1
+
+	// MaxGIFFrames is the maximum number of GIF frames, frames are skipped evenly above it.
+	MaxGIFFrames = 500
+
+	// MaxGIFPixels bounds memory of quantized GIF frames, e.g. 500 frames of 640x480.
+	MaxGIFPixels = 640 * 480 * MaxGIFFrames
+
+	// maxRunningCompiles is the number of videos that can be compiled at the same time.
+	maxRunningCompiles = 2
+
+	// cleanInterval is the pause between retention runs.
+	cleanInterval = 10 * time.Minute
+
+	dayFormat   = "2006-01-02"
+	frameFormat = "20060102-150405"
+)
+
+// ErrNoFrames is returned by Compile when job has no frames in time range.
+var ErrNoFrames = errors.New("timelapse: no frames")
+
+// ErrTooLarge is returned by Compile when GIF frames have more than MaxGIFPixels.
+var ErrTooLarge = errors.New("timelapse: gif frames are too large")
+
+// ErrBusy is returned by Compile when too many videos are compiled.
+var ErrBusy = errors.New("timelapse: too many running compiles")
+
+// Job is a timelapse job.
+type Job struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+
+	// Interval is the number of seconds between frames.
+	Interval int `json:"interval"`
+	// WindowStart and WindowEnd are local times, HH:MM, between which frames are captured, empty is all day.
+	WindowStart string `json:"window_start,omitempty"`
+	WindowEnd   string `json:"window_end,omitempty"`
+	// Retention is the number of days frames are kept, 0 keeps them forever.
+	Retention int `json:"retention"`
+}
+
+// Validate checks job configuration.
+func (j *Job) Validate() error {
+	if strings.TrimSpace(j.Name) == "" {
+		return errors.New("name is required")
+	}
+
+	if j.Interval < MinInterval {
+		return fmt.Errorf("interval must be at least %d second", MinInterval)
+	}
+
+	if j.Retention < 0 {
+		return errors.New("retention must not be negative")
+	}
+
+	if (j.WindowStart == "") != (j.WindowEnd == "") {
+		return errors.New("time window needs both start and end")
+	}
+
+	for _, v := range []string{j.WindowStart, j.WindowEnd} {
+		if _, err := parseClock(v); v != "" && err != nil {
+			return fmt.Errorf("invalid time window %q", v)
+		}
+	}
+
+	return nil
+}
+
+// Active reports whether now is within job time window.
+func (j *Job) Active(now time.Time) bool {
+	start, err := parseClock(j.WindowStart)
+	if err != nil {
+		return true
+	}
+
+	end, err := parseClock(j.WindowEnd)
+	if err != nil || start == end {
+		return true
+	}
+
+	m := now.Hour()*60 + now.Minute()
+
+	// Window can span midnight, e.g. 20:00-06:00.
+	if start < end {
+		return m >= start && m < end
+	}
+
+	return m >= start || m < end
+}
+
+// Frame is a captured frame.
+type Frame struct {
+	Time time.Time
+	Path string
+}
+
+// Stats are statistics of captured frames.
+type Stats struct {
+	Frames int        `json:"frames"`
+	Size   int64      `json:"size"`
+	First  *time.Time `json:"first,omitempty"`
+	Last   *time.Time `json:"last,omitempty"`
+}
+
+// CompileOptions are options of compiled timelapse.
+type CompileOptions struct {
+	// Format is FormatAVI or FormatGIF.
+	Format string
+	// FPS is the frame rate of playback.
+	FPS float64
+	// Width of GIF frames, 0 keeps original size, narrower frames are not scaled up. Frames of AVI are not scaled.
+	Width int
+	// From and To limit frames, zero values are not limits.
+	From time.Time
+	To   time.Time
+}
+
+// Store provides timelapse jobs.
+type Store interface {
+	// GetTimelapseJobs returns all jobs.
+	GetTimelapseJobs() ([]Job, error)
+}
+
+// Source provides the latest frame, e.g. *stream.Stream.
+type Source interface {
+	// Latest returns the latest frame, nil if there is none.
+	Latest() *stream.Frame
+}
+
+// Scheduler captures frames of enabled jobs from store to subdirectory of dir for each job and day.
+type Scheduler struct {
+	dir    string
+	store  Store
+	source Source
+
+	mu        sync.Mutex
+	last      map[int64]time.Time
+	cleaned   time.Time
+	compiling int
+
+	now  func() time.Time
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New returns new Scheduler.
+func New(dir string, store Store, source Source) *Scheduler {
+	return &Scheduler{
+		dir:    dir,
+		store:  store,
+		source: source,
+		last:   make(map[int64]time.Time),
+		now:    time.Now,
+		done:   make(chan struct{}),
+	}
+}
+
+// Start starts capturing, jobs are read from store every second, so changes apply without restart.
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.tick()
+			}
+		}
+	}()
+}
+
+// Close stops capturing.
+func (s *Scheduler) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	return nil
+}
+
+// tick captures frame for jobs whose interval has passed and applies retention.
+func (s *Scheduler) tick() {
+	jobs, err := s.store.GetTimelapseJobs()
+	if err != nil {
+		log.Printf("timelapse: %v", err)
+		return
+	}
+
+	now := s.now()
+
+	s.mu.Lock()
+	clean := now.Sub(s.cleaned) >= cleanInterval
+	if clean {
+		s.cleaned = now
+	}
+	s.mu.Unlock()
+
+	for _, job := range jobs {
+		if clean && job.Retention > 0 {
+			if err := s.clean(job, now); err != nil {
+				log.Printf("timelapse: %s: %v", job.Name, err)
+			}
+		}
+	}
+
+	var f *stream.Frame
+
+	for _, job := range jobs {
+		if !job.Enabled || !job.Active(now) {
+			continue
+		}
+
+		// Frames are aligned to interval from local midnight, e.g. every minute at :00 or every day at 00:00
+		y, m, d := now.Date()
+		midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+		interval := time.Duration(job.Interval) * time.Second
+		slot := midnight.Add(now.Sub(midnight) / interval * interval)
+
+		s.mu.Lock()
+		due := s.last[job.ID].Before(slot)
+		s.mu.Unlock()
+
+		if !due {
+			continue
+		}
+
+		if f == nil {
+			// Blank frames of privacy mode and stale frames of offline camera are not captured
+			if f = s.source.Latest(); f == nil || f.Privacy || now.Sub(f.Time) > 5*time.Second {
+				return
+			}
+		}
+
+		if err := s.capture(job, f); err != nil {
+			log.Printf("timelapse: %s: %v", job.Name, err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.last[job.ID] = slot
+		s.mu.Unlock()
+	}
+}
+
+// capture writes frame of job.
+func (s *Scheduler) capture(job Job, f *stream.Frame) error {
+	data, err := f.JPEG()
+	if err != nil {
+		return err
+	}
+
+	t := f.Time.Local()
+
+	dir := filepath.Join(s.jobDir(job.ID), t.Format(dayFormat))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, t.Format(frameFormat)+".jpg"), data, 0644)
+}
+
+// clean removes days of job older than its retention.
+func (s *Scheduler) clean(job Job, now time.Time) error {
+	entries, err := os.ReadDir(s.jobDir(job.ID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	today := now.Local()
+	oldest := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -job.Retention)
+
+	for _, e := range entries {
+		day, err := time.ParseInLocation(dayFormat, e.Name(), time.Local)
+		if err != nil || !e.IsDir() || !day.Before(oldest) {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(s.jobDir(job.ID), e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Scheduler) jobDir(id int64) string {
+	return filepath.Join(s.dir, strconv.FormatInt(id, 10))
+}
+
+// Frames returns frames of job between from and to, oldest first. Zero values are not limits.
+func (s *Scheduler) Frames(id int64, from, to time.Time) ([]Frame, error) {
+	dir := s.jobDir(id)
+
+	days, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var frames []Frame
+
+	for _, day := range days {
+		if !day.IsDir() {
+			continue
+		}
+
+		entries, err := os.ReadDir(filepath.Join(dir, day.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			t, err := time.ParseInLocation(frameFormat, strings.TrimSuffix(e.Name(), ".jpg"), time.Local)
+			if err != nil || filepath.Ext(e.Name()) != ".jpg" {
+				continue
+			}
+
+			if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && !t.Before(to)) {
+				continue
+			}
+
+			frames = append(frames, Frame{Time: t, Path: filepath.Join(dir, day.Name(), e.Name())})
+		}
+	}
+
+	sort.Slice(frames, func(i, j int) bool {
+		return frames[i].Time.Before(frames[j].Time)
+	})
+
+	return frames, nil
+}
+
+// Stats returns statistics of captured frames of job.
+func (s *Scheduler) Stats(id int64) (Stats, error) {
+	var st Stats
+
+	frames, err := s.Frames(id, time.Time{}, time.Time{})
+	if err != nil {
+		return st, err
+	}
+
+	for _, f := range frames {
+		if fi, err := os.Stat(f.Path); err == nil {
+			st.Size += fi.Size()
+		}
+	}
+
+	st.Frames = len(frames)
+	if len(frames) > 0 {
+		st.First, st.Last = &frames[0].Time, &frames[len(frames)-1].Time
+	}
+
+	return st, nil
+}
+
+// Delete removes all frames of job.
+func (s *Scheduler) Delete(id int64) error {
+	s.mu.Lock()
+	delete(s.last, id)
+	s.mu.Unlock()
+
+	return os.RemoveAll(s.jobDir(id))
+}
+
+// Compile writes frames of job to w as MJPEG AVI or animated GIF.
+func (s *Scheduler) Compile(w io.WriteSeeker, id int64, opts CompileOptions) error {
+	if opts.FPS <= 0 {
+		opts.FPS = 10
+	}
+
+	s.mu.Lock()
+	if s.compiling >= maxRunningCompiles {
+		s.mu.Unlock()
+
+		return ErrBusy
+	}
+	s.compiling++
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.compiling--
+		s.mu.Unlock()
+	}()
+
+	frames, err := s.Frames(id, opts.From, opts.To)
+	if err != nil {
+		return err
+	}
+
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+
+	switch opts.Format {
+	case FormatAVI:
+		return compileAVI(w, frames, opts.FPS)
+	case FormatGIF:
+		return compileGIF(w, frames, opts)
+	}
+
+	return fmt.Errorf("timelapse: invalid format %q", opts.Format)
+}
+
+// compileAVI writes JPEG files as they are, frame size of the first frame is in header.
+func compileAVI(w io.WriteSeeker, frames []Frame, fps float64) error {
+	var a *record.AVIWriter
+
+	for _, f := range frames {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return err
+		}
+
+		if a == nil {
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				return fmt.Errorf("%s: %w", f.Path, err)
+			}
+
+			if a, err = record.NewAVIWriter(w, cfg.Width, cfg.Height, fps); err != nil {
+				return err
+			}
+		}
+
+		if err := a.WriteFrame(data); err != nil {
+			return err
+		}
+	}
+
+	return a.Close()
+}
+
+func compileGIF(w io.Writer, frames []Frame, opts CompileOptions) error {
+	// Long timelapse is sampled evenly
+	if n := len(frames); n > MaxGIFFrames {
+		sampled := make([]Frame, MaxGIFFrames)
+		for i := range sampled {
+			sampled[i] = frames[i*n/MaxGIFFrames]
+		}
+
+		frames = sampled
+	}
+
+	g := im.NewGIF(opts.Width, opts.FPS)
+
+	for _, f := range frames {
+		file, err := os.Open(f.Path)
+		if err != nil {
+			return err
+		}
+
+		img, err := im.NewDecoder(file).Decode()
+		_ = file.Close()
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Path, err)
+		}
+
+		// Size of the first frame scaled to width is checked before frames are quantized
+		if g.Len() == 0 {
+			fw, fh := img.Bounds().Dx(), img.Bounds().Dy()
+			if opts.Width > 0 && opts.Width < fw {
+				fw, fh = opts.Width, max(fh*opts.Width/fw, 1)
+			}
+
+			if len(frames)*fw*fh > MaxGIFPixels {
+				return ErrTooLarge
+			}
+		}
+
+		g.Add(img)
+	}
+
+	return g.Encode(w)
+}
+
+// parseClock returns minutes since midnight of HH:MM.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,275 @@
+package timelapse
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gen2brain/cam2ip/record"
+	"github.com/gen2brain/cam2ip/stream"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		job Job
+		ok  bool
+	}{
+		{Job{Name: "site", Interval: 60}, true},
+		{Job{Name: "plant", Interval: 600, WindowStart: "07:00", WindowEnd: "19:00", Retention: 30}, true},
+		{Job{Name: "", Interval: 60}, false},
+		{Job{Name: "site", Interval: 0}, false},
+		{Job{Name: "site", Interval: 60, Retention: -1}, false},
+		{Job{Name: "site", Interval: 60, WindowStart: "07:00"}, false},
+		{Job{Name: "site", Interval: 60, WindowStart: "7am", WindowEnd: "19:00"}, false},
+	}
+
+	for i, tt := range tests {
+		if err := tt.job.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%d: expected ok %v, got %v", i, tt.ok, err)
+		}
+	}
+}
+
+func TestActive(t *testing.T) {
+	day := Job{WindowStart: "07:00", WindowEnd: "19:00"}
+	night := Job{WindowStart: "20:00", WindowEnd: "06:00"}
+
+	at := func(h, m int) time.Time {
+		return time.Date(2025, 1, 2, h, m, 0, 0, time.Local)
+	}
+
+	if !day.Active(at(7, 0)) || !day.Active(at(18, 59)) || day.Active(at(19, 0)) || day.Active(at(6, 59)) {
+		t.Error("unexpected day window")
+	}
+
+	if !night.Active(at(23, 0)) || !night.Active(at(5, 59)) || night.Active(at(12, 0)) {
+		t.Error("unexpected night window")
+	}
+
+	if !(&Job{}).Active(at(3, 0)) {
+		t.Error("expected job without window to be always active")
+	}
+}
+
+type store []Job
+
+func (s store) GetTimelapseJobs() ([]Job, error) {
+	return s, nil
+}
+
+type source struct {
+	frame *stream.Frame
+}
+
+func (s *source) Latest() *stream.Frame {
+	return s.frame
+}
+
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestScheduler(t *testing.T) {
+	dir := t.TempDir()
+	data := testJPEG(t, 32, 24)
+
+	jobs := store{
+		{ID: 1, Name: "site", Enabled: true, Interval: 60, Retention: 1},
+		{ID: 2, Name: "plant", Enabled: true, Interval: 10, WindowStart: "07:00", WindowEnd: "08:00"},
+		{ID: 3, Name: "disabled", Enabled: false, Interval: 1},
+	}
+
+	src := &source{}
+	s := New(dir, jobs, src)
+
+	// Old day of job 1 is removed by retention
+	old := filepath.Join(dir, "1", "2024-12-30")
+	if err := os.MkdirAll(old, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2025, 1, 2, 6, 59, 0, 0, time.Local)
+
+	// Two minutes ticked every second
+	for i := 0; i < 120; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		s.now = func() time.Time { return now }
+		src.frame = stream.NewFrameJPEG(uint64(i), now, data)
+		s.tick()
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("expected old day to be removed")
+	}
+
+	count := func(id int64) int {
+		frames, err := s.Frames(id, time.Time{}, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return len(frames)
+	}
+
+	// Job 1 at 06:59:00 and 07:00:00, job 2 every 10 seconds within window from 07:00:00
+	if n1, n2, n3 := count(1), count(2), count(3); n1 != 2 || n2 != 6 || n3 != 0 {
+		t.Errorf("unexpected number of frames %d, %d, %d", n1, n2, n3)
+	}
+
+	// Privacy frames are not captured
+	now := start.Add(time.Hour)
+	s.now = func() time.Time { return now }
+	src.frame = stream.NewFrameJPEG(0, now, data)
+	src.frame.Privacy = true
+	s.tick()
+
+	if n := count(1); n != 2 {
+		t.Errorf("expected privacy frame to be skipped, got %d frames", n)
+	}
+
+	st, err := s.Stats(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Frames != 6 || st.Size != int64(6*len(data)) || !st.First.Equal(start.Add(time.Minute)) {
+		t.Errorf("unexpected stats %+v", st)
+	}
+
+	frames, err := s.Frames(2, start.Add(time.Minute+10*time.Second), start.Add(time.Minute+30*time.Second))
+	if err != nil || len(frames) != 2 {
+		t.Errorf("expected 2 frames in range, got %d, %v", len(frames), err)
+	}
+
+	if err := s.Delete(2); err != nil || count(2) != 0 {
+		t.Errorf("expected frames to be deleted, %v", err)
+	}
+}
+
+func TestSchedulerLocal(t *testing.T) {
+	data := testJPEG(t, 32, 24)
+
+	src := &source{}
+	s := New(t.TempDir(), store{{ID: 1, Name: "daily", Enabled: true, Interval: 86400}}, src)
+
+	// Daily frame is captured at local midnight, which is not a multiple of a day since zero time in UTC
+	zone := time.FixedZone("IST", 5*3600+1800)
+	start := time.Date(2025, 1, 2, 23, 59, 58, 0, zone)
+
+	for i := 0; i < 4; i++ {
+		now := start.Add(time.Duration(i) * time.Second)
+		s.now = func() time.Time { return now }
+		src.frame = stream.NewFrameJPEG(uint64(i), now, data)
+		s.tick()
+	}
+
+	frames, err := s.Frames(1, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 2 || !frames[1].Time.Equal(time.Date(2025, 1, 3, 0, 0, 0, 0, zone)) {
+		t.Errorf("expected frames at start and at midnight, got %+v", frames)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	dir := t.TempDir()
+	data := testJPEG(t, 32, 24)
+
+	s := New(dir, store{}, &source{})
+
+	start := time.Date(2025, 1, 2, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 5; i++ {
+		if err := s.capture(Job{ID: 1}, stream.NewFrameJPEG(0, start.Add(time.Duration(i)*time.Hour), data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name := filepath.Join(dir, "out.avi")
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Compile(f, 1, CompileOptions{Format: FormatAVI, FPS: 5, To: start.Add(4 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = f.Close()
+
+	a, err := record.OpenAVI(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Frames() != 4 || a.FPS() != 5 || a.Width() != 32 {
+		t.Errorf("unexpected avi with %d frames at %v fps", a.Frames(), a.FPS())
+	}
+
+	_ = a.Close()
+
+	f, err = os.Create(filepath.Join(dir, "out.gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := s.Compile(f, 1, CompileOptions{Format: FormatGIF, FPS: 2, Width: 16}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Image) != 5 || g.Image[0].Bounds().Dx() != 16 || g.Delay[0] != 50 {
+		t.Errorf("unexpected gif with %d frames", len(g.Image))
+	}
+
+	if err := s.Compile(f, 2, CompileOptions{Format: FormatGIF}); err == nil {
+		t.Error("expected error for job without frames")
+	}
+
+	// Compiles above the limit are refused, the running ones are not affected
+	s.compiling = maxRunningCompiles
+	if err := s.Compile(f, 1, CompileOptions{Format: FormatGIF}); !errors.Is(err, ErrBusy) {
+		t.Errorf("expected ErrBusy, got %v", err)
+	}
+	s.compiling = 0
+
+	// Large frames are rejected before they are quantized
+	large := filepath.Join(dir, "large.jpg")
+	if err := os.WriteFile(large, testJPEG(t, 2000, 2000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := make([]Frame, 100)
+	for i := range frames {
+		frames[i] = Frame{Path: large}
+	}
+
+	if err := compileGIF(io.Discard, frames, CompileOptions{Width: 1920}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}